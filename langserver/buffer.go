@@ -2,22 +2,31 @@ package langserver
 
 import (
 	"context"
+	"io/ioutil"
 	"log"
+	"sort"
 	"sync"
+	"time"
 
 	"go.lsp.dev/jsonrpc2"
 	lsp "go.lsp.dev/protocol"
 	"go.lsp.dev/uri"
 )
 
+// Time to wait after the last change before publishing diagnostics.
+const diagnosticsDelay = 300 * time.Millisecond
+
 type Buffer struct {
 	Documents map[string]Document
 	mtx       sync.RWMutex
+	// Pending diagnostics of changed documents.
+	timers map[string]*time.Timer
 }
 
 func NewBuffer() *Buffer {
 	return &Buffer{
 		Documents: make(map[string]Document, 0),
+		timers:    make(map[string]*time.Timer, 0),
 	}
 }
 
@@ -30,17 +39,123 @@ func (b *Buffer) UpdateBufferDoc(documentURI string, buf string, ctx context.Con
 	}
 	b.Documents[documentURI] = *d
 
+	Indexer.UpdateDocument(documentURI, buf)
+	publishDiagnostics(ctx, r.Conn(), d, Indexer)
+}
+
+// Apply incremental changes to a document. The diagnostics are only
+// published once the changes stop, so typing doesn't parse the document
+// on every keystroke.
+func (b *Buffer) ApplyBufferChanges(documentURI string, changes []lsp.TextDocumentContentChangeEvent, r *jsonrpc2.Request, Indexer *Indexer) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	d, ok := b.Documents[documentURI]
+	if !ok {
+		d = Document{
+			URI: documentURI,
+		}
+	}
+
+	if err := d.ApplyChanges(changes); err != nil {
+		log.Printf("%s is out of sync: %s", documentURI, err)
+	}
+	b.Documents[documentURI] = d
+
+	if timer, ok := b.timers[documentURI]; ok {
+		timer.Stop()
+	}
+
+	conn := r.Conn()
+	var timer *time.Timer
+	timer = time.AfterFunc(diagnosticsDelay, func() {
+		b.mtx.Lock()
+		if b.timers[documentURI] == timer {
+			delete(b.timers, documentURI)
+		}
+		b.mtx.Unlock()
+
+		// The diagnostics of a document out of sync would be wrong.
+		doc := b.GetBufferDoc(documentURI)
+		if doc != nil && !doc.outOfSync {
+			Indexer.UpdateDocument(doc.URI, doc.Text)
+			publishDiagnostics(context.Background(), conn, doc, Indexer)
+		}
+	})
+	b.timers[documentURI] = timer
+}
+
+// Forget a closed document, the file on disk is used from now on.
+func (b *Buffer) CloseBufferDoc(documentURI string, Indexer *Indexer) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if timer, ok := b.timers[documentURI]; ok {
+		timer.Stop()
+		delete(b.timers, documentURI)
+	}
+	delete(b.Documents, documentURI)
+
+	// Unsaved changes are discarded.
+	if src, err := ioutil.ReadFile(documentURI); err == nil {
+		Indexer.UpdateDocument(documentURI, string(src))
+	}
+}
+
+// Publish the diagnostics of every open document, eg. once the
+// indexing is done.
+func (b *Buffer) PublishDiagnostics(ctx context.Context, conn *jsonrpc2.Conn, Indexer *Indexer) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	for _, doc := range b.Documents {
+		d := doc
+		publishDiagnostics(ctx, conn, &d, Indexer)
+	}
+}
+
+func publishDiagnostics(ctx context.Context, conn *jsonrpc2.Conn, d *Document, Indexer *Indexer) {
 	diagnostics, err := d.GetDiagnostics(Indexer)
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return
 	}
 
-	r.Conn().Notify(ctx, lsp.MethodTextDocumentPublishDiagnostics, lsp.PublishDiagnosticsParams{
-		URI:         lsp.DocumentURI(uri.File(documentURI)),
+	conn.Notify(ctx, lsp.MethodTextDocumentPublishDiagnostics, lsp.PublishDiagnosticsParams{
+		URI:         lsp.DocumentURI(uri.File(d.URI)),
 		Diagnostics: diagnostics,
 	})
 }
 
+// Get the text of a file, from its document if it's open, so unsaved
+// changes are used.
+func (b *Buffer) FileText(path string) (string, error) {
+	if doc := b.GetBufferDoc(path); doc != nil {
+		return doc.Text, nil
+	}
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return string(src), nil
+}
+
+// Get the paths of the open documents.
+func (b *Buffer) DocumentURIs() []string {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	result := make([]string, 0, len(b.Documents))
+	for path := range b.Documents {
+		result = append(result, path)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
 func (b *Buffer) GetBufferDoc(documentURI string) *Document {
 	b.mtx.RLock()
 	defer b.mtx.RUnlock()
@@ -0,0 +1,185 @@
+package langserver
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/nkoporec/drupal-lsp/langserver/parser"
+)
+
+// Bump this when a parser changes what it stores, so caches written by
+// an older version are discarded.
+const cacheVersion = 13
+
+// IndexCache is the index of a document root persisted between runs.
+type IndexCache struct {
+	Version      int
+	DocumentRoot string
+	Files        map[string]CachedFile
+	PhpClasses   []parser.PhpClass
+	PhpFunctions []parser.PhpFunction
+	// Encoded state of every parser, keyed by the parser name.
+	Parsers map[string][]byte
+}
+
+// CachedFile is the state of a file when it was last indexed.
+type CachedFile struct {
+	ModTime int64
+	Hash    string
+}
+
+func NewIndexCache(documentRoot string) *IndexCache {
+	return &IndexCache{
+		Version:      cacheVersion,
+		DocumentRoot: documentRoot,
+		Files:        make(map[string]CachedFile),
+		Parsers:      make(map[string][]byte),
+	}
+}
+
+// Get the cache file of a document root.
+func cacheFile(documentRoot string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha1.Sum([]byte(documentRoot))
+
+	return filepath.Join(dir, "drupal-lsp", hex.EncodeToString(hash[:])+".gob"), nil
+}
+
+// Load the cached index of a document root and restore the state of the
+// parsers from it. An empty cache is returned if there is none or if it
+// was written by another version.
+func LoadIndexCache(documentRoot string, parsers map[string]parser.Parser) *IndexCache {
+	path, err := cacheFile(documentRoot)
+	if err != nil {
+		log.Println(err)
+		return NewIndexCache(documentRoot)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return NewIndexCache(documentRoot)
+	}
+	defer f.Close()
+
+	cache := &IndexCache{}
+	if err := gob.NewDecoder(f).Decode(cache); err != nil {
+		log.Println(err)
+		return NewIndexCache(documentRoot)
+	}
+
+	if cache.Version != cacheVersion || cache.DocumentRoot != documentRoot {
+		log.Println("Index cache is outdated, rebuilding.")
+		return NewIndexCache(documentRoot)
+	}
+
+	// Decode into new parsers so a failure leaves the given ones empty.
+	restored := parser.InitParsers()
+	for name, item := range restored {
+		data, ok := cache.Parsers[name]
+		if !ok {
+			log.Printf("Index cache has no %s parser, rebuilding.", name)
+			return NewIndexCache(documentRoot)
+		}
+
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(item); err != nil {
+			log.Println(err)
+			return NewIndexCache(documentRoot)
+		}
+	}
+
+	for name, item := range restored {
+		parsers[name] = item
+	}
+
+	return cache
+}
+
+// Check if a file changed since it was cached. Files with a new
+// modification time are hashed, so touching a file doesn't parse it again.
+// New files aren't hashed, they're parsed anyway and an empty hash never
+// matches.
+func (c *IndexCache) Revalidate(path string, info os.FileInfo) (CachedFile, bool) {
+	modTime := info.ModTime().UnixNano()
+
+	cached, ok := c.Files[path]
+	if !ok {
+		return CachedFile{ModTime: modTime}, true
+	}
+
+	if cached.ModTime == modTime {
+		return cached, false
+	}
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return CachedFile{ModTime: modTime}, true
+	}
+
+	hash := sha1.Sum(src)
+	state := CachedFile{
+		ModTime: modTime,
+		Hash:    hex.EncodeToString(hash[:]),
+	}
+
+	return state, cached.Hash != state.Hash
+}
+
+// Encode the state of a parser once it's indexed. This must be done
+// before the parser is used by requests, which may update it meanwhile.
+func (c *IndexCache) AddParser(name string, item parser.Parser) error {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(item); err != nil {
+		return err
+	}
+
+	if c.Parsers == nil {
+		c.Parsers = make(map[string][]byte)
+	}
+	c.Parsers[name] = data.Bytes()
+
+	return nil
+}
+
+// Write the index to disk, with the parsers added to it.
+func (c *IndexCache) Save(phpClasses []parser.PhpClass, phpFunctions []parser.PhpFunction) error {
+	path, err := cacheFile(c.DocumentRoot)
+	if err != nil {
+		return err
+	}
+
+	c.PhpClasses = phpClasses
+	c.PhpFunctions = phpFunctions
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a crash can't leave a
+	// broken cache behind.
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := gob.NewEncoder(f).Encode(c); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
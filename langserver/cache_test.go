@@ -0,0 +1,167 @@
+package langserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nkoporec/drupal-lsp/langserver/parser"
+)
+
+// Write the caches of a test to a temporary directory.
+func tempCacheDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous, ok := os.LookupEnv("XDG_CACHE_HOME")
+	os.Setenv("XDG_CACHE_HOME", dir)
+
+	return func() {
+		if ok {
+			os.Setenv("XDG_CACHE_HOME", previous)
+		} else {
+			os.Unsetenv("XDG_CACHE_HOME")
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestIndexCache(t *testing.T) {
+	defer tempCacheDir(t)()
+
+	root, err := ioutil.TempDir("", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "foo.module")
+	ioutil.WriteFile(path, []byte("<?php\n"), 0644)
+
+	// Save the cache and load it again, like a new run does.
+	revalidate := func(expected bool) {
+		t.Helper()
+
+		cache := LoadIndexCache(root, parser.InitParsers())
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		state, changed := cache.Revalidate(path, info)
+		if changed != expected {
+			t.Errorf("Revalidate() = %v, want %v", changed, expected)
+		}
+
+		cache.Files[path] = state
+		for name, item := range parser.InitParsers() {
+			if err := cache.AddParser(name, item); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := cache.Save(nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	touch := func(offset time.Duration) {
+		modTime := time.Now().Add(offset)
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	// A new file.
+	revalidate(true)
+	revalidate(false)
+
+	// New files aren't hashed, so the first touch parses them again.
+	touch(time.Hour)
+	revalidate(true)
+	touch(2 * time.Hour)
+	revalidate(false)
+
+	ioutil.WriteFile(path, []byte("<?php\nfunction foo() {}\n"), 0644)
+	touch(3 * time.Hour)
+	revalidate(true)
+}
+
+func TestIndexerRemovedFile(t *testing.T) {
+	defer tempCacheDir(t)()
+
+	root, err := ioutil.TempDir("", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	module := filepath.Join(root, "foo.module")
+	api := filepath.Join(root, "foo.api.php")
+	ioutil.WriteFile(module, []byte("<?php\nfunction foo_help() {}\nclass Foo {}\n"), 0644)
+	ioutil.WriteFile(api, []byte("<?php\nfunction hook_foo($bar) {}\n"), 0644)
+
+	// Only the classes of the dependencies are indexed.
+	os.MkdirAll(filepath.Join(root, "vendor", "symfony"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "vendor", "symfony", "bar.php"), []byte("<?php\nnamespace Symfony;\nclass Bar {}\nfunction bar() {}\n"), 0644)
+	os.Mkdir(filepath.Join(root, "node_modules"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "node_modules", "baz.php"), []byte("<?php\nclass Baz {}\n"), 0644)
+
+	classNames := func(i *Indexer) string {
+		names := []string{}
+		for _, class := range i.GetPhpClasses() {
+			names = append(names, class.Namespace)
+		}
+		sort.Strings(names)
+
+		return strings.Join(names, " ")
+	}
+
+	hook := func(i *Indexer) *parser.Hook {
+		for _, item := range i.GetParsers() {
+			if hook, ok := item.(*parser.Hook); ok {
+				return hook
+			}
+		}
+
+		t.Fatal("No hook parser")
+		return nil
+	}
+
+	indexer := NewIndexer(root)
+	indexer.Run()
+
+	if names := classNames(indexer); names != "Foo Symfony\\Bar" {
+		t.Errorf("Invalid classes %s", names)
+	}
+
+	if functions := indexer.GetPhpFunctions(); len(functions) != 2 {
+		t.Errorf("Invalid functions %v", functions)
+	}
+
+	if params := hook(indexer).Params; params["hook_foo"] != "$bar" {
+		t.Errorf("Invalid hook parameters %v", params)
+	}
+
+	os.Remove(module)
+	os.Remove(api)
+
+	// The next run starts from the cache.
+	indexer = NewIndexer(root)
+	indexer.Run()
+
+	if names := classNames(indexer); names != "Symfony\\Bar" {
+		t.Errorf("Removed classes are indexed %s", names)
+	}
+
+	if functions := indexer.GetPhpFunctions(); len(functions) != 0 {
+		t.Errorf("Removed functions are indexed %v", functions)
+	}
+
+	if hook := hook(indexer); len(hook.Definitions) != 0 || len(hook.Params) != 0 {
+		t.Errorf("Removed hooks are indexed %v %v", hook.Definitions, hook.Params)
+	}
+}
@@ -0,0 +1,855 @@
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nkoporec/drupal-lsp/langserver/parser"
+	"github.com/nkoporec/drupal-lsp/php"
+	"github.com/nkoporec/drupal-lsp/utils"
+
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// The use statements of a php file.
+var useStatementRegex = regexp.MustCompile(`(?m)^use\s+([\w\\]+)(?:\s+as\s+\w+)?\s*;`)
+
+// The namespace statement of a php file.
+var namespaceStatementRegex = regexp.MustCompile(`(?m)^namespace\s+[\w\\]+\s*;`)
+
+// The instantiation of the class in its create() method, eg. new static(
+var createInstanceRegex = regexp.MustCompile(`new\s+(?:static|self|[\w\\]+)\s*\(`)
+
+// The first variable of a parameter list.
+var parameterVariableRegex = regexp.MustCompile(`\$(\w+)`)
+
+// The traits used by a class body.
+var traitUseStatementRegex = regexp.MustCompile(`(?m)^\s*use\s+[\w\\]+(?:\s*,\s*[\w\\]+)*\s*;`)
+
+// The property declarations of a class body.
+var propertyDeclarationRegex = regexp.MustCompile(`(?m)^\s*(?:public|protected|private|var)\s+(?:static\s+)?(?:\??[\w\\]+\s+)?\$\w+[^;]*;`)
+
+// Documentation of the parameters passed to the constructor of plugins.
+var pluginParameters = map[string][2]string{
+	"configuration":     {"array", "A configuration array containing information about the plugin instance."},
+	"plugin_id":         {"string", "The plugin_id for the plugin instance."},
+	"plugin_definition": {"mixed", "The plugin implementation definition."},
+}
+
+// Offer to replace the \Drupal::service() calls of a class by a service
+// injected in its constructor.
+func (h *LspHandler) handleCodeAction(ctx context.Context, params *lsp.CodeActionParams) ([]lsp.CodeAction, error) {
+	result := []lsp.CodeAction{}
+
+	doc := h.Buffer.GetBufferDoc(UriToFilename(params.TextDocument.URI))
+	if doc == nil || !utils.InSlice(phpFileExtensions, filepath.Ext(doc.URI)) {
+		return result, nil
+	}
+
+	var services *parser.Service
+	for _, item := range h.Indexer.GetParsers() {
+		if service, ok := item.(*parser.Service); ok {
+			services = service
+		}
+	}
+
+	if services == nil {
+		return result, nil
+	}
+
+	src := []byte(doc.Text)
+	parsedDoc, err := php.Parse(src)
+	if err != nil {
+		return result, nil
+	}
+
+	seen := map[string]bool{}
+	lines := php.NewLineOffsets(src)
+	for _, call := range parsedDoc.StaticCalls {
+		name, ok := serviceCallName(call)
+		if !ok || seen[name] || !rangesOverlap(parser.LinesRange(lines, call.Position), params.Range) {
+			continue
+		}
+		seen[name] = true
+
+		edit, ok := injectServiceEdit(h.Indexer, services, doc.URI, src, parsedDoc, call)
+		if !ok {
+			continue
+		}
+
+		result = append(result, lsp.CodeAction{
+			Title: fmt.Sprintf("Inject the '%s' service", name),
+			Kind:  lsp.QuickFix,
+			Edit:  edit,
+		})
+	}
+
+	return result, nil
+}
+
+// Build the edit injecting the service of a \Drupal::service() call in
+// the constructor of the class calling it. The class gets the service
+// either from its create() method or from its services.yml arguments.
+func injectServiceEdit(i *Indexer, services *parser.Service, path string, src []byte, parsedDoc *php.ParsedDoc, call *php.PhpStaticCall) (*lsp.WorkspaceEdit, bool) {
+	name, _ := serviceCallName(call)
+
+	defs := services.GetGoToDefinition(name)
+	if len(defs) == 0 {
+		return nil, false
+	}
+
+	class, methods := classAt(src, parsedDoc, call.Position.StartPos)
+	if class == nil {
+		return nil, false
+	}
+
+	scope := findMethod(methods, call.Scope)
+	if !canUseThis(scope) {
+		return nil, false
+	}
+
+	create := findMethod(methods, "create")
+	var serviceDef *parser.ParserDefinition
+	if create == nil || !create.Static || create.Body == nil {
+		create = nil
+		for _, def := range services.GetDefinitions() {
+			if strings.TrimPrefix(def.Class, "\\") == class.Name {
+				def := def
+				serviceDef = &def
+				break
+			}
+		}
+
+		// Without create() or a service definition the constructor
+		// arguments can't be passed.
+		if serviceDef == nil {
+			return nil, false
+		}
+	}
+
+	property := serviceProperty(name)
+	indent := lineIndent(src, scope.Position.StartPos)
+
+	fqcn := ""
+	if defs[0].Class != "" {
+		fqcn = serviceType(i, defs[0].Class)
+	}
+
+	edits := []lsp.TextEdit{}
+
+	typeName := ""
+	if fqcn != "" {
+		var importEdit *lsp.TextEdit
+		typeName, importEdit = importClass(src, parsedDoc, class, fqcn)
+		if importEdit != nil {
+			edits = append(edits, *importEdit)
+		}
+	}
+
+	classStart, classEnd := classBody(src, class, methods)
+
+	// A property of the class is already injected, the one of a parent
+	// class or trait is injected without declaring it again.
+	declared := utils.InSlice(class.Properties, property)
+	inherited, complete := inheritedProperty(i.GetPhpClassIndex(), class, property)
+
+	if !declared {
+		if !inherited {
+			// Typing the property of an unknown parent class could
+			// conflict with its declaration.
+			propertyType := typeName
+			if !complete {
+				propertyType = ""
+			}
+
+			edits = append(edits, propertyEdit(src, classStart, methods, indent, name, property, propertyType, fqcn))
+		}
+
+		constructor := findMethod(methods, "__construct")
+		if constructor != nil && constructor.Params != nil && constructor.Body != nil {
+			edits = append(edits, constructorEdits(src, constructor, indent, name, property, typeName, fqcn)...)
+		} else {
+			var arguments []string
+			if create != nil {
+				var ok bool
+				if arguments, ok = createArguments(src, create); !ok {
+					return nil, false
+				}
+			}
+
+			edits = append(edits, newConstructorEdit(src, class, methods, indent, name, property, typeName, fqcn, arguments))
+		}
+	}
+
+	result := &lsp.WorkspaceEdit{
+		Changes: make(map[uri.URI][]lsp.TextEdit),
+	}
+
+	if !declared {
+		if create != nil {
+			edit, ok := createEdit(src, create, name)
+			if !ok {
+				return nil, false
+			}
+			edits = append(edits, edit)
+		} else {
+			edit, ok := serviceArgumentEdit(*serviceDef, name)
+			if !ok {
+				return nil, false
+			}
+			result.Changes[uri.File(serviceDef.File)] = []lsp.TextEdit{edit}
+		}
+	}
+
+	// Replace every call to the service where $this is available.
+	for _, item := range parsedDoc.StaticCalls {
+		if itemName, ok := serviceCallName(item); !ok || itemName != name {
+			continue
+		}
+
+		if item.Position.StartPos < classStart || item.Position.StartPos > classEnd || !canUseThis(findMethod(methods, item.Scope)) {
+			continue
+		}
+
+		edits = append(edits, lsp.TextEdit{
+			Range:   parser.NodeRange(src, item.Position),
+			NewText: "$this->" + property,
+		})
+	}
+
+	result.Changes[uri.File(path)] = append(result.Changes[uri.File(path)], edits...)
+
+	return result, true
+}
+
+// Get the service name of a \Drupal::service('foo') call.
+func serviceCallName(call *php.PhpStaticCall) (string, bool) {
+	if call.Class.Name != "Drupal" || call.Method == nil || call.Method.Name != "service" || call.Position == nil {
+		return "", false
+	}
+
+	if len(call.Args) != 1 || call.Args[0].Name == "" || !strings.ContainsAny(call.Args[0].Name[:1], `'"`) {
+		return "", false
+	}
+
+	return strings.Trim(call.Args[0].Name, `'"`), true
+}
+
+// Check if $this is available in a method. The constructor is skipped, as
+// the service is only set at its end.
+func canUseThis(method *php.PhpClassMethod) bool {
+	return method != nil && !method.Static && method.Name != "__construct"
+}
+
+// Get the class declared around an offset and its methods.
+func classAt(src []byte, parsedDoc *php.ParsedDoc, offset int) (*php.PhpClassDeclaration, []*php.PhpClassMethod) {
+	var class *php.PhpClassDeclaration
+	end := len(src)
+
+	for _, item := range parsedDoc.Classes {
+		if item.Position == nil {
+			continue
+		}
+
+		if item.Position.StartPos <= offset && (class == nil || item.Position.StartPos > class.Position.StartPos) {
+			class = item
+		}
+	}
+
+	if class == nil || class.Kind != "class" {
+		return nil, nil
+	}
+
+	for _, item := range parsedDoc.Classes {
+		if item.Position != nil && item.Position.StartPos > class.Position.StartPos && item.Position.StartPos < end {
+			end = item.Position.StartPos
+		}
+	}
+
+	methods := []*php.PhpClassMethod{}
+	for _, method := range parsedDoc.ClassMethods {
+		if method.Position.StartPos > class.Position.StartPos && method.Position.StartPos < end {
+			methods = append(methods, method)
+		}
+	}
+
+	return class, methods
+}
+
+// Get the offsets of the body of a class, from its opening bracket to its
+// last method.
+func classBody(src []byte, class *php.PhpClassDeclaration, methods []*php.PhpClassMethod) (int, int) {
+	start := class.Position.EndPos
+	if index := strings.IndexByte(string(src[start:]), '{'); index != -1 {
+		start += index + 1
+	}
+
+	end := len(src)
+	if len(methods) > 0 {
+		last := methods[len(methods)-1]
+		if last.Body != nil {
+			end = last.Body.EndPos
+		}
+	}
+
+	return start, end
+}
+
+// Check if a parent class or a trait of a class declares a property. The
+// search is complete if every parent class and trait is indexed.
+func inheritedProperty(classes map[string]parser.PhpClass, class *php.PhpClassDeclaration, property string) (bool, bool) {
+	complete := true
+	seen := map[string]bool{}
+	queue := append([]string{class.Parent}, class.Traits...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		item, ok := classes[name]
+		if !ok {
+			complete = false
+			continue
+		}
+
+		if utils.InSlice(item.Properties, property) {
+			return true, true
+		}
+
+		queue = append(append(queue, item.Parent), item.Traits...)
+	}
+
+	return false, complete
+}
+
+func findMethod(methods []*php.PhpClassMethod, name string) *php.PhpClassMethod {
+	for _, method := range methods {
+		if method.Name == name {
+			return method
+		}
+	}
+
+	return nil
+}
+
+// Get the type a service class is injected as, preferably the interface
+// named after it, eg. MessengerInterface for Messenger.
+func serviceType(i *Indexer, class string) string {
+	class = strings.TrimPrefix(class, "\\")
+	short := class[strings.LastIndex(class, "\\")+1:]
+
+	if item, ok := i.GetPhpClassIndex()[class]; ok {
+		for _, name := range item.Interfaces {
+			if name == short+"Interface" || strings.HasSuffix(name, "\\"+short+"Interface") {
+				return name
+			}
+		}
+	}
+
+	return class
+}
+
+// Get the property a service is stored in, eg. entityTypeManager for
+// entity_type.manager.
+func serviceProperty(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return r == '.' || r == '_' || r == '-' || r == '\\'
+	})
+
+	result := ""
+	for i, part := range parts {
+		if i > 0 {
+			part = strings.ToUpper(part[:1]) + part[1:]
+		}
+		result += part
+	}
+
+	return result
+}
+
+// Get how a class is written in a file, and the edit importing it if it's
+// not imported yet.
+func importClass(src []byte, parsedDoc *php.ParsedDoc, class *php.PhpClassDeclaration, fqcn string) (string, *lsp.TextEdit) {
+	short := fqcn[strings.LastIndex(fqcn, "\\")+1:]
+
+	for alias, name := range parsedDoc.Uses {
+		if name == fqcn {
+			return alias, nil
+		}
+	}
+
+	if parsedDoc.Namespace != "" && parsedDoc.Namespace+"\\"+short == fqcn {
+		return short, nil
+	}
+
+	// The name is already used by another class.
+	if _, ok := parsedDoc.Uses[short]; ok || strings.HasSuffix(class.Name, "\\"+short) || class.Name == short {
+		return "\\" + fqcn, nil
+	}
+
+	statement := "use " + fqcn + ";"
+
+	// Keep the use statements sorted.
+	matches := useStatementRegex.FindAllSubmatchIndex(src, -1)
+	for _, match := range matches {
+		if strings.ToLower(string(src[match[2]:match[3]])) > strings.ToLower(fqcn) {
+			return short, &lsp.TextEdit{
+				Range:   emptyRange(src, match[0]),
+				NewText: statement + "\n",
+			}
+		}
+	}
+
+	if len(matches) > 0 {
+		return short, &lsp.TextEdit{
+			Range:   emptyRange(src, matches[len(matches)-1][1]),
+			NewText: "\n" + statement,
+		}
+	}
+
+	if match := namespaceStatementRegex.FindIndex(src); match != nil {
+		return short, &lsp.TextEdit{
+			Range:   emptyRange(src, match[1]),
+			NewText: "\n\n" + statement,
+		}
+	}
+
+	return "\\" + fqcn, nil
+}
+
+// Declare the property of a service, after the other properties of the
+// class.
+func propertyEdit(src []byte, classStart int, methods []*php.PhpClassMethod, indent string, name string, property string, typeName string, fqcn string) lsp.TextEdit {
+	end := len(src)
+	if len(methods) > 0 {
+		end = memberStart(src, methods[0].Position.StartPos)
+	}
+
+	// After the other properties, or the traits that come first.
+	offset := classStart
+	if end > classStart {
+		if matches := propertyDeclarationRegex.FindAllIndex(src[classStart:end], -1); len(matches) > 0 {
+			offset = classStart + matches[len(matches)-1][1]
+		} else if matches := traitUseStatementRegex.FindAllIndex(src[classStart:end], -1); len(matches) > 0 {
+			offset = classStart + matches[len(matches)-1][1]
+		}
+	}
+
+	lines := []string{
+		"/**",
+		fmt.Sprintf(" * The %s service.", name),
+	}
+	if fqcn != "" {
+		lines = append(lines, " *", " * @var \\"+fqcn)
+	}
+	lines = append(lines, " */", strings.TrimSpace(fmt.Sprintf("protected %s $%s;", typeName, property)))
+
+	if typeName == "" {
+		lines[len(lines)-1] = fmt.Sprintf("protected $%s;", property)
+	}
+
+	return lsp.TextEdit{
+		Range:   emptyRange(src, offset),
+		NewText: "\n\n" + indentLines(lines, indent),
+	}
+}
+
+// Add the service to an existing constructor: its docblock, parameters and
+// body.
+func constructorEdits(src []byte, constructor *php.PhpClassMethod, indent string, name string, property string, typeName string, fqcn string) []lsp.TextEdit {
+	result := []lsp.TextEdit{}
+
+	// Document the parameter.
+	start := memberStart(src, constructor.Position.StartPos)
+	if start < constructor.Position.StartPos {
+		docblock := string(src[start:constructor.Position.StartPos])
+		if end := strings.LastIndex(docblock, "*/"); end != -1 && strings.HasPrefix(strings.TrimSpace(docblock), "/**") {
+			lineStart := strings.LastIndex(docblock[:end], "\n") + 1
+
+			lines := []string{}
+			if !strings.Contains(docblock, "@param") {
+				lines = append(lines, " *")
+			}
+			lines = append(lines, paramDoc(fqcn, property, fmt.Sprintf("The %s service.", name))...)
+
+			result = append(result, lsp.TextEdit{
+				Range:   emptyRange(src, start+lineStart),
+				NewText: indentLines(lines, indent) + "\n",
+			})
+		}
+	}
+
+	parameter := strings.TrimSpace(typeName + " $" + property)
+	result = append(result, appendArgument(src, constructor.Params.StartPos, constructor.Params.EndPos, parameter))
+
+	assignment := fmt.Sprintf("$this->%s = $%s;", property, property)
+	lineStart := strings.LastIndex(string(src[:constructor.Body.EndPos]), "\n") + 1
+	if lineStart > constructor.Body.StartPos && strings.TrimSpace(string(src[lineStart:constructor.Body.EndPos])) == "" {
+		result = append(result, lsp.TextEdit{
+			Range:   emptyRange(src, lineStart),
+			NewText: indent + indent + assignment + "\n",
+		})
+	} else {
+		result = append(result, lsp.TextEdit{
+			Range:   emptyRange(src, constructor.Body.EndPos),
+			NewText: " " + assignment + " ",
+		})
+	}
+
+	return result
+}
+
+// Add a constructor receiving the service before the first method. The
+// arguments already passed by create() are passed to the parent
+// constructor, eg. the ones of plugins.
+func newConstructorEdit(src []byte, class *php.PhpClassDeclaration, methods []*php.PhpClassMethod, indent string, name string, property string, typeName string, fqcn string, arguments []string) lsp.TextEdit {
+	short := class.Name[strings.LastIndex(class.Name, "\\")+1:]
+
+	lines := []string{
+		"/**",
+		fmt.Sprintf(" * Constructs a new %s object.", short),
+		" *",
+	}
+
+	parameters := []string{}
+	for _, argument := range arguments {
+		parameterType, description := "mixed", fmt.Sprintf("The %s.", strings.Replace(argument, "_", " ", -1))
+		if doc, ok := pluginParameters[argument]; ok {
+			parameterType, description = doc[0], doc[1]
+		}
+
+		lines = append(lines, paramDoc(parameterType, argument, description)...)
+		if parameterType == "array" {
+			parameters = append(parameters, "array $"+argument)
+		} else {
+			parameters = append(parameters, "$"+argument)
+		}
+	}
+	lines = append(lines, paramDoc(fqcn, property, fmt.Sprintf("The %s service.", name))...)
+	parameters = append(parameters, strings.TrimSpace(typeName+" $"+property))
+
+	lines = append(lines,
+		" */",
+		fmt.Sprintf("public function __construct(%s) {", strings.Join(parameters, ", ")),
+	)
+
+	if len(arguments) > 0 {
+		variables := []string{}
+		for _, argument := range arguments {
+			variables = append(variables, "$"+argument)
+		}
+		lines = append(lines, indent+fmt.Sprintf("parent::__construct(%s);", strings.Join(variables, ", ")))
+	}
+
+	lines = append(lines,
+		indent+fmt.Sprintf("$this->%s = $%s;", property, property),
+		"}",
+	)
+
+	return lsp.TextEdit{
+		Range:   emptyRange(src, memberStart(src, methods[0].Position.StartPos)),
+		NewText: indentLines(lines, indent) + "\n\n",
+	}
+}
+
+// Pass the service to the class instantiated by create().
+func createEdit(src []byte, create *php.PhpClassMethod, name string) (lsp.TextEdit, bool) {
+	container := "container"
+	if create.Params != nil {
+		if match := parameterVariableRegex.FindSubmatch(src[create.Params.StartPos:create.Params.EndPos]); match != nil {
+			container = string(match[1])
+		}
+	}
+
+	match := createInstanceRegex.FindIndex(src[create.Body.StartPos:create.Body.EndPos])
+	if match == nil {
+		return lsp.TextEdit{}, false
+	}
+
+	open := create.Body.StartPos + match[1]
+	close := matchingParenthesis(src, open)
+	if close == -1 {
+		return lsp.TextEdit{}, false
+	}
+
+	return appendArgument(src, open, close, fmt.Sprintf("$%s->get('%s')", container, name)), true
+}
+
+// Get the variables passed to the class instantiated by create(), eg.
+// $configuration, $plugin_id and $plugin_definition for plugins. Other
+// arguments, eg. services, can't be passed on by a new constructor.
+func createArguments(src []byte, create *php.PhpClassMethod) ([]string, bool) {
+	result := []string{}
+
+	match := createInstanceRegex.FindIndex(src[create.Body.StartPos:create.Body.EndPos])
+	if match == nil {
+		return result, true
+	}
+
+	open := create.Body.StartPos + match[1]
+	close := matchingParenthesis(src, open)
+	if close == -1 {
+		return result, false
+	}
+
+	if strings.TrimSpace(string(src[open:close])) == "" {
+		return result, true
+	}
+
+	for _, argument := range strings.Split(string(src[open:close]), ",") {
+		argument = strings.TrimSpace(argument)
+		if argument == "" {
+			// A trailing comma.
+			continue
+		}
+
+		variable := parameterVariableRegex.FindStringSubmatch(argument)
+		if variable == nil || variable[0] != argument {
+			return nil, false
+		}
+
+		result = append(result, variable[1])
+	}
+
+	return result, true
+}
+
+// Add an argument at the end of the list between two offsets, following
+// its layout.
+func appendArgument(src []byte, start int, end int, argument string) lsp.TextEdit {
+	list := string(src[start:end])
+	trimmed := strings.TrimRight(list, " \t\r\n")
+	offset := start + len(trimmed)
+
+	switch {
+	case strings.TrimSpace(list) == "":
+		return lsp.TextEdit{
+			Range:   emptyRange(src, start),
+			NewText: argument,
+		}
+	case strings.Contains(trimmed, "\n"):
+		indent := lineIndent(src, offset-1)
+		if strings.HasSuffix(trimmed, ",") {
+			return lsp.TextEdit{
+				Range:   emptyRange(src, offset),
+				NewText: "\n" + indent + argument + ",",
+			}
+		}
+
+		return lsp.TextEdit{
+			Range:   emptyRange(src, offset),
+			NewText: ",\n" + indent + argument,
+		}
+	}
+
+	return lsp.TextEdit{
+		Range:   emptyRange(src, offset),
+		NewText: ", " + argument,
+	}
+}
+
+// Add an argument to a service in its services.yml file.
+func serviceArgumentEdit(def parser.ParserDefinition, name string) (lsp.TextEdit, bool) {
+	src, err := ioutil.ReadFile(def.File)
+	if err != nil {
+		return lsp.TextEdit{}, false
+	}
+
+	argument := "'@" + name + "'"
+	lines := strings.Split(string(src), "\n")
+
+	keyLine := int(def.Position.Line)
+	if keyLine >= len(lines) {
+		return lsp.TextEdit{}, false
+	}
+	keyIndent := len(lines[keyLine]) - len(strings.TrimLeft(lines[keyLine], " "))
+
+	position := func(line int, offset int) lsp.Range {
+		character := parser.LineCharacter(lines[line], offset)
+		return lsp.Range{
+			Start: lsp.Position{Line: float64(line), Character: character},
+			End:   lsp.Position{Line: float64(line), Character: character},
+		}
+	}
+
+	childIndent := strings.Repeat(" ", keyIndent*2)
+	if keyIndent == 0 {
+		childIndent = "  "
+	}
+	insertAfter := keyLine
+
+	for i := keyLine + 1; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		indent := len(line) - len(trimmed)
+		if indent <= keyIndent {
+			break
+		}
+
+		if i == keyLine+1 || strings.HasPrefix(trimmed, "class:") {
+			childIndent = line[:indent]
+		}
+
+		if strings.HasPrefix(trimmed, "class:") {
+			insertAfter = i
+		}
+
+		if !strings.HasPrefix(trimmed, "arguments:") {
+			continue
+		}
+
+		value := strings.TrimSpace(strings.TrimPrefix(trimmed, "arguments:"))
+		if strings.HasPrefix(value, "[") {
+			end := strings.LastIndex(line, "]")
+			if end == -1 {
+				return lsp.TextEdit{}, false
+			}
+
+			start := strings.Index(line, "[")
+			if strings.TrimSpace(line[start+1:end]) == "" {
+				return lsp.TextEdit{Range: position(i, end), NewText: argument}, true
+			}
+
+			content := strings.TrimRight(line[:end], " ")
+			return lsp.TextEdit{Range: position(i, len(content)), NewText: ", " + argument}, true
+		}
+
+		// A list of items, one per line.
+		last := -1
+		itemIndent := ""
+		for j := i + 1; j < len(lines); j++ {
+			item := strings.TrimRight(lines[j], "\r")
+			itemTrimmed := strings.TrimLeft(item, " ")
+			if itemTrimmed == "" || strings.HasPrefix(itemTrimmed, "#") {
+				continue
+			}
+
+			if !strings.HasPrefix(itemTrimmed, "-") || len(item)-len(itemTrimmed) < indent {
+				break
+			}
+
+			last = j
+			itemIndent = item[:len(item)-len(itemTrimmed)]
+		}
+
+		if last == -1 {
+			return lsp.TextEdit{Range: position(i, len(line)), NewText: " [" + argument + "]"}, true
+		}
+
+		end := len(strings.TrimRight(lines[last], "\r"))
+		return lsp.TextEdit{Range: position(last, end), NewText: "\n" + itemIndent + "- " + argument}, true
+	}
+
+	end := len(strings.TrimRight(lines[insertAfter], "\r"))
+	return lsp.TextEdit{Range: position(insertAfter, end), NewText: "\n" + childIndent + "arguments: [" + argument + "]"}, true
+}
+
+// Get the offset where a member starts, including its docblock.
+func memberStart(src []byte, offset int) int {
+	start := strings.LastIndex(string(src[:offset]), "\n") + 1
+
+	before := strings.TrimRight(string(src[:start]), " \t\r\n")
+	if strings.HasSuffix(before, "*/") {
+		if docblock := strings.LastIndex(before, "/**"); docblock != -1 {
+			start = strings.LastIndex(before[:docblock], "\n") + 1
+		}
+	}
+
+	return start
+}
+
+// Get the indentation of the line of an offset.
+func lineIndent(src []byte, offset int) string {
+	start := strings.LastIndex(string(src[:offset]), "\n") + 1
+	line := string(src[start:])
+
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// Find the parenthesis closing the one opened before an offset.
+func matchingParenthesis(src []byte, offset int) int {
+	depth := 0
+	var quote byte
+
+	for i := offset; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+
+	return -1
+}
+
+// Document a parameter in a docblock.
+func paramDoc(paramType string, name string, description string) []string {
+	if strings.Contains(paramType, "\\") {
+		paramType = "\\" + paramType
+	}
+
+	param := fmt.Sprintf(" * @param %s $%s", paramType, name)
+	if paramType == "" {
+		param = fmt.Sprintf(" * @param $%s", name)
+	}
+
+	return []string{
+		param,
+		" *   " + description,
+	}
+}
+
+func indentLines(lines []string, indent string) string {
+	for i, line := range lines {
+		lines[i] = indent + line
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func emptyRange(src []byte, offset int) lsp.Range {
+	line, character := php.LineColumn(src, offset)
+	position := lsp.Position{
+		Line:      float64(line),
+		Character: float64(character),
+	}
+
+	return lsp.Range{
+		Start: position,
+		End:   position,
+	}
+}
+
+func rangesOverlap(a lsp.Range, b lsp.Range) bool {
+	before := func(x lsp.Position, y lsp.Position) bool {
+		return x.Line < y.Line || (x.Line == y.Line && x.Character < y.Character)
+	}
+
+	return !before(a.End, b.Start) && !before(b.End, a.Start)
+}
@@ -0,0 +1,155 @@
+package langserver
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nkoporec/drupal-lsp/langserver/parser"
+	"github.com/nkoporec/drupal-lsp/php"
+
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+func TestInjectServiceEdit(t *testing.T) {
+	src := "<?php\n\nnamespace Drupal\\foo\\Controller;\n\nuse Symfony\\Component\\DependencyInjection\\ContainerInterface;\n\nclass FooController {\n\n  public static function create(ContainerInterface $container) {\n    return new static();\n  }\n\n  public function page() {\n    \\Drupal::service('messenger')->addMessage('hi');\n  }\n\n}\n"
+
+	services := &parser.Service{
+		Definitions: []parser.ParserDefinition{
+			{Name: "messenger", Class: "Drupal\\Core\\Messenger\\Messenger"},
+		},
+	}
+
+	text, ok := applyInjectServiceEdit(t, &Indexer{}, services, src)
+	if !ok {
+		t.Errorf("No edit for the service call")
+		return
+	}
+
+	expected := "<?php\n\nnamespace Drupal\\foo\\Controller;\n\nuse Drupal\\Core\\Messenger\\Messenger;\nuse Symfony\\Component\\DependencyInjection\\ContainerInterface;\n\nclass FooController {\n\n  /**\n   * The messenger service.\n   *\n   * @var \\Drupal\\Core\\Messenger\\Messenger\n   */\n  protected Messenger $messenger;\n\n  /**\n   * Constructs a new FooController object.\n   *\n   * @param \\Drupal\\Core\\Messenger\\Messenger $messenger\n   *   The messenger service.\n   */\n  public function __construct(Messenger $messenger) {\n    $this->messenger = $messenger;\n  }\n\n  public static function create(ContainerInterface $container) {\n    return new static($container->get('messenger'));\n  }\n\n  public function page() {\n    $this->messenger->addMessage('hi');\n  }\n\n}\n"
+	if text != expected {
+		t.Errorf("Invalid text %q", text)
+	}
+}
+
+func TestInjectServiceEditInheritedProperty(t *testing.T) {
+	src := "<?php\n\nnamespace Drupal\\foo\\Controller;\n\nuse Drupal\\Core\\Controller\\ControllerBase;\n\nclass FooController extends ControllerBase {\n\n  use FooTrait;\n\n  public static function create($container) {\n    return new static();\n  }\n\n  public function page() {\n    \\Drupal::service('%s')->get('hi');\n  }\n\n}\n"
+
+	services := &parser.Service{
+		Definitions: []parser.ParserDefinition{
+			{Name: "entity_type.manager", Class: "Drupal\\Core\\Entity\\EntityTypeManager"},
+			{Name: "messenger", Class: "Drupal\\Core\\Messenger\\Messenger"},
+			{Name: "config.factory", Class: "Drupal\\Core\\Config\\ConfigFactory"},
+		},
+	}
+
+	indexer := &Indexer{
+		PhpClasses: []parser.PhpClass{
+			{Namespace: "Drupal\\Core\\Controller\\ControllerBase", Properties: []string{"entityTypeManager"}, Traits: []string{"Drupal\\Core\\Messenger\\MessengerTrait"}},
+			{Namespace: "Drupal\\Core\\Messenger\\MessengerTrait", Kind: "trait", Properties: []string{"messenger"}},
+		},
+	}
+
+	tests := []struct {
+		service  string
+		expected string
+	}{
+		// Properties of the parent class and of its traits are assigned
+		// without being declared again.
+		{"entity_type.manager", "<?php\n\nnamespace Drupal\\foo\\Controller;\n\nuse Drupal\\Core\\Controller\\ControllerBase;\nuse Drupal\\Core\\Entity\\EntityTypeManager;\n\nclass FooController extends ControllerBase {\n\n  use FooTrait;\n\n  /**\n   * Constructs a new FooController object.\n   *\n   * @param \\Drupal\\Core\\Entity\\EntityTypeManager $entityTypeManager\n   *   The entity_type.manager service.\n   */\n  public function __construct(EntityTypeManager $entityTypeManager) {\n    $this->entityTypeManager = $entityTypeManager;\n  }\n\n  public static function create($container) {\n    return new static($container->get('entity_type.manager'));\n  }\n\n  public function page() {\n    $this->entityTypeManager->get('hi');\n  }\n\n}\n"},
+		{"messenger", "<?php\n\nnamespace Drupal\\foo\\Controller;\n\nuse Drupal\\Core\\Controller\\ControllerBase;\nuse Drupal\\Core\\Messenger\\Messenger;\n\nclass FooController extends ControllerBase {\n\n  use FooTrait;\n\n  /**\n   * Constructs a new FooController object.\n   *\n   * @param \\Drupal\\Core\\Messenger\\Messenger $messenger\n   *   The messenger service.\n   */\n  public function __construct(Messenger $messenger) {\n    $this->messenger = $messenger;\n  }\n\n  public static function create($container) {\n    return new static($container->get('messenger'));\n  }\n\n  public function page() {\n    $this->messenger->get('hi');\n  }\n\n}\n"},
+		// FooTrait isn't indexed, so the property is declared without a
+		// type.
+		{"config.factory", "<?php\n\nnamespace Drupal\\foo\\Controller;\n\nuse Drupal\\Core\\Config\\ConfigFactory;\nuse Drupal\\Core\\Controller\\ControllerBase;\n\nclass FooController extends ControllerBase {\n\n  use FooTrait;\n\n  /**\n   * The config.factory service.\n   *\n   * @var \\Drupal\\Core\\Config\\ConfigFactory\n   */\n  protected $configFactory;\n\n  /**\n   * Constructs a new FooController object.\n   *\n   * @param \\Drupal\\Core\\Config\\ConfigFactory $configFactory\n   *   The config.factory service.\n   */\n  public function __construct(ConfigFactory $configFactory) {\n    $this->configFactory = $configFactory;\n  }\n\n  public static function create($container) {\n    return new static($container->get('config.factory'));\n  }\n\n  public function page() {\n    $this->configFactory->get('hi');\n  }\n\n}\n"},
+	}
+
+	for _, test := range tests {
+		text, ok := applyInjectServiceEdit(t, indexer, services, fmt.Sprintf(src, test.service))
+		if !ok {
+			t.Errorf("No edit for the %s service", test.service)
+			continue
+		}
+
+		if text != test.expected {
+			t.Errorf("Invalid text for the %s service %q", test.service, text)
+		}
+	}
+}
+
+func TestInjectServiceEditCreateArguments(t *testing.T) {
+	services := &parser.Service{
+		Definitions: []parser.ParserDefinition{
+			{Name: "messenger"},
+		},
+	}
+
+	tests := []struct {
+		arguments string
+		expected  bool
+	}{
+		{"", true},
+		{"$configuration, $plugin_id, $plugin_definition", true},
+		{"$configuration,\n      $plugin_id,\n    ", true},
+		// A new constructor can't pass on other arguments.
+		{"$container->get('logger.factory')", false},
+		{"$configuration, $plugin_id, $plugin_definition, TRUE", false},
+	}
+
+	for _, test := range tests {
+		src := "<?php\n\nclass FooBlock extends BlockBase {\n\n  public static function create($container, $configuration, $plugin_id, $plugin_definition) {\n    return new static(" + test.arguments + ");\n  }\n\n  public function build() {\n    \\Drupal::service('messenger');\n  }\n\n}\n"
+
+		if _, ok := applyInjectServiceEdit(t, &Indexer{}, services, src); ok != test.expected {
+			t.Errorf("injectServiceEdit(new static(%s)) = %v, want %v", test.arguments, ok, test.expected)
+		}
+	}
+}
+
+// Apply the edit injecting the service of the \Drupal::service() call of a
+// document, and get the resulting text.
+func applyInjectServiceEdit(t *testing.T, i *Indexer, services *parser.Service, src string) (string, bool) {
+	parsedDoc, err := php.Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var call *php.PhpStaticCall
+	for _, item := range parsedDoc.StaticCalls {
+		if _, ok := serviceCallName(item); ok {
+			call = item
+		}
+	}
+
+	if call == nil {
+		t.Fatalf("Service call not found")
+	}
+
+	edit, ok := injectServiceEdit(i, services, "/foo/FooController.php", []byte(src), parsedDoc, call)
+	if !ok {
+		return "", false
+	}
+
+	// The edits follow the document, apply them from the end so their
+	// ranges stay valid.
+	doc := &Document{Text: src}
+	edits := edit.Changes[uri.File("/foo/FooController.php")]
+	for i := len(edits) - 1; i >= 0; i-- {
+		doc.ApplyChange(lsp.TextDocumentContentChangeEvent{
+			Range: &edits[i].Range,
+			Text:  edits[i].NewText,
+		})
+	}
+
+	return doc.Text, true
+}
+
+func TestServiceProperty(t *testing.T) {
+	for name, expected := range map[string]string{
+		"messenger":            "messenger",
+		"entity_type.manager":  "entityTypeManager",
+		"plugin.manager.block": "pluginManagerBlock",
+	} {
+		if property := serviceProperty(name); property != expected {
+			t.Errorf("Invalid property %s for %s", property, name)
+		}
+	}
+}
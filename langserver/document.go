@@ -2,7 +2,11 @@ package langserver
 
 import (
 	"errors"
+	"fmt"
 	"strings"
+	"unicode"
+
+	"github.com/nkoporec/drupal-lsp/langserver/parser"
 
 	lsp "go.lsp.dev/protocol"
 )
@@ -10,20 +14,34 @@ import (
 type Document struct {
 	URI  string
 	Text string
+	// Set once a change fails, the text no longer matches the client's.
+	outOfSync bool
 }
 
 func (d *Document) GetDiagnostics(indexer *Indexer) ([]lsp.Diagnostic, error) {
 	result := []lsp.Diagnostic{}
 
-	p := indexer.Parsers
+	p := indexer.GetParsers()
 	for _, par := range p {
-		diagnostic := par.Diagnostics(d.Text, par.GetDefinitions())
-		result = append(result, diagnostic...)
+		// The diagnostics of parsers are based on php code.
+		if !d.IsTwig() {
+			result = append(result, par.Diagnostics(d.Text, par.GetDefinitions())...)
+		}
+
+		if diagnoser, ok := par.(parser.FileDiagnoser); ok {
+			result = append(result, diagnoser.FileDiagnostics(d.URI, d.Text)...)
+		}
 	}
 
 	return result, nil
 }
 
+// Check if the document is a twig template, which only the parsers that
+// handle the document itself support.
+func (d *Document) IsTwig() bool {
+	return strings.HasSuffix(d.URI, ".twig")
+}
+
 func (d *Document) GetMethodCall(position lsp.Position) (string, error) {
 	doc := string(d.Text)
 	c := doc
@@ -45,38 +63,63 @@ func (d *Document) GetMethodCall(position lsp.Position) (string, error) {
 	// everything after it.
 	// We only care about the current line and the cursor position.
 	currentLineEnd := strings.IndexRune(c, '\n')
-	c = c[:currentLineEnd]
-
-	c = c[:int(position.Character)]
-	// Method delimeter is a char that starts a method call
-	// We can have it two ways.
-	// ->method()
-	// ::method()
-	methodStartDelimiter := strings.IndexRune(c, '>')
-	if methodStartDelimiter == -1 {
-		methodStartDelimiter = strings.IndexRune(c, ':')
+	if currentLineEnd != -1 {
+		c = c[:currentLineEnd]
 	}
 
-	if methodStartDelimiter <= 0 {
-		return "", errors.New("Method start delimiter not found")
+	if position.Character > parser.LineCharacter(c, len(c)) {
+		return "", errors.New("Position is out of range")
 	}
+	c = c[:parser.LineOffset(c, position.Character)]
 
-	// Get everything after the method delimiter.
-	// We add one to the position to get the cursor position so
-	// we remove the actual delimeter char (: or >)
-	c = c[methodStartDelimiter+1:]
+	// Find the call whose arguments contain the cursor, so chained calls
+	// are resolved to the last one, eg. getStorage in
+	// \Drupal::entityTypeManager()->getStorage('node').
+	calls := []int{}
+	var quote byte
+	for i := 0; i < len(c); i++ {
+		char := c[i]
+		if quote != 0 {
+			if char == '\\' {
+				i++
+			} else if char == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch char {
+		case '\'', '"':
+			quote = char
+		case '(':
+			calls = append(calls, i)
+		case ')':
+			if len(calls) > 0 {
+				calls = calls[:len(calls)-1]
+			}
+		}
+	}
 
-	// Method name is till the first (
-	methodEndDelimiter := strings.IndexRune(c, '(')
-	if methodEndDelimiter == -1 {
+	if len(calls) == 0 {
 		return "", errors.New("Method end delimeter not found")
 	}
 
-	// Get the method name
-	methodName := c[:methodEndDelimiter]
+	// Method name is before the (
+	c = c[:calls[len(calls)-1]]
+	methodName := strings.TrimRight(c, " \t")
+	methodStart := strings.LastIndexFunc(methodName, func(r rune) bool {
+		return !(r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r))
+	})
+	c = methodName[:methodStart+1]
+	methodName = methodName[methodStart+1:]
 
-	// Remove extra : as it could be \Drupal::method();
-	methodName = strings.Trim(methodName, ":")
+	// Method delimeter is a char that starts a method call
+	// We can have it two ways.
+	// ->method()
+	// ::method()
+	if methodName == "" || (!strings.HasSuffix(c, "->") && !strings.HasSuffix(c, "::")) {
+		return "", errors.New("Method start delimiter not found")
+	}
 
 	return methodName, nil
 }
@@ -102,22 +145,136 @@ func (d *Document) GetMethodParams(position lsp.Position) (string, error) {
 	// everything after it.
 	// We only care about the current line and the cursor position.
 	currentLineEnd := strings.IndexRune(c, '\n')
-	c = c[:currentLineEnd]
+	if currentLineEnd != -1 {
+		c = c[:currentLineEnd]
+	}
+
+	character := parser.LineOffset(c, position.Character)
+	paramBef := c[:character]
+	paramAft := c[character:]
 
-	paramBef := c[:int(position.Character)]
-	paramAft := c[int(position.Character):]
+	// The argument under the cursor starts after the opening
+	// parenthesis or the previous argument.
+	paramBefStartDelimiter := strings.LastIndexAny(paramBef, "(,")
+	paramBef = paramBef[paramBefStartDelimiter+1:]
 
-	paramBefStartDelimiter := strings.IndexRune(paramBef, '(')
-	paramBef = paramBef[paramBefStartDelimiter+2:]
+	// And ends before the next argument or the closing parenthesis.
+	paramAftEndDelimiter := strings.IndexAny(paramAft, "),")
+	if paramAftEndDelimiter != -1 {
+		paramAft = paramAft[:paramAftEndDelimiter]
+	}
+
+	param := strings.TrimSpace(paramBef + paramAft)
+	param = strings.Trim(param, "'")
+	param = strings.Trim(param, "\"")
+
+	return param, nil
+}
 
-	paramAftStartDelimiter := strings.IndexRune(paramAft, ')')
-	paramAft = paramAft[:paramAftStartDelimiter]
+// Get the name under the cursor, eg. a service or route name, and
+// its range.
+func (d *Document) GetWordAtPosition(position lsp.Position) (string, lsp.Range) {
+	lines := strings.Split(d.Text, "\n")
+	if int(position.Line) >= len(lines) {
+		return "", lsp.Range{}
+	}
+
+	line := lines[int(position.Line)]
+	character := parser.LineOffset(line, position.Character)
 
-	paramBef = strings.Trim(paramBef, "'")
-	paramBef = strings.Trim(paramBef, "\"")
+	isNameChar := func(c byte) bool {
+		return c == '_' || c == '.' || c == '\\' || c == '-' ||
+			(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+	}
 
-	paramAft = strings.Trim(paramAft, "'")
-	paramAft = strings.Trim(paramAft, "\"")
+	start := character
+	for start > 0 && isNameChar(line[start-1]) {
+		start--
+	}
+
+	end := character
+	for end < len(line) && isNameChar(line[end]) {
+		end++
+	}
+
+	return line[start:end], lsp.Range{
+		Start: lsp.Position{
+			Line:      position.Line,
+			Character: parser.LineCharacter(line, start),
+		},
+		End: lsp.Position{
+			Line:      position.Line,
+			Character: parser.LineCharacter(line, end),
+		},
+	}
+}
+
+// Apply the changes of a notification in order. The changes after one that
+// fails are relative to a text we don't have, so the document is out of
+// sync: the last good text is kept, and the changes are skipped until the
+// client sends the whole text.
+func (d *Document) ApplyChanges(changes []lsp.TextDocumentContentChangeEvent) error {
+	for _, change := range changes {
+		if d.outOfSync && change.Range != nil {
+			continue
+		}
+
+		if err := d.ApplyChange(change); err != nil {
+			d.outOfSync = true
+			return err
+		}
+
+		if change.Range == nil {
+			d.outOfSync = false
+		}
+	}
+
+	return nil
+}
+
+// Apply a change sent by the client. Changes without a range replace
+// the whole text.
+func (d *Document) ApplyChange(change lsp.TextDocumentContentChangeEvent) error {
+	if change.Range == nil {
+		d.Text = change.Text
+		return nil
+	}
+
+	start, err := d.Offset(change.Range.Start)
+	if err != nil {
+		return err
+	}
+
+	end, err := d.Offset(change.Range.End)
+	if err != nil {
+		return err
+	}
+
+	if end < start {
+		return fmt.Errorf("Invalid change range %v", change.Range)
+	}
+
+	d.Text = d.Text[:start] + change.Text + d.Text[end:]
+
+	return nil
+}
+
+// Convert a position to a byte offset in the text. The position
+// character is counted in UTF-16 code units, as the protocol requires.
+func (d *Document) Offset(position lsp.Position) (int, error) {
+	offset := 0
+	for line := 0; line < int(position.Line); line++ {
+		lineEnd := strings.IndexRune(d.Text[offset:], '\n')
+		if lineEnd == -1 {
+			return 0, fmt.Errorf("Line %d is out of range", int(position.Line))
+		}
+		offset += lineEnd + 1
+	}
+
+	line := d.Text[offset:]
+	if lineEnd := strings.IndexRune(line, '\n'); lineEnd != -1 {
+		line = line[:lineEnd]
+	}
 
-	return paramBef + "" + paramAft, nil
+	return offset + parser.LineOffset(line, position.Character), nil
 }
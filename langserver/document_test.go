@@ -0,0 +1,136 @@
+package langserver
+
+import (
+	"testing"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestApplyChange(t *testing.T) {
+	doc := &Document{
+		Text: "<?php\n// 😀 é\n\\Drupal::service('foo');\n",
+	}
+
+	// Replace 'é', the emoji takes two UTF-16 code units.
+	err := doc.ApplyChange(lsp.TextDocumentContentChangeEvent{
+		Range: &lsp.Range{
+			Start: lsp.Position{Line: 1, Character: 6},
+			End:   lsp.Position{Line: 1, Character: 7},
+		},
+		Text: "e",
+	})
+	if err != nil {
+		t.Errorf("ApplyChange() error = %v", err)
+		return
+	}
+
+	// Replace the service name.
+	err = doc.ApplyChange(lsp.TextDocumentContentChangeEvent{
+		Range: &lsp.Range{
+			Start: lsp.Position{Line: 2, Character: 18},
+			End:   lsp.Position{Line: 2, Character: 21},
+		},
+		Text: "bar.baz",
+	})
+	if err != nil {
+		t.Errorf("ApplyChange() error = %v", err)
+		return
+	}
+
+	expected := "<?php\n// 😀 e\n\\Drupal::service('bar.baz');\n"
+	if doc.Text != expected {
+		t.Errorf("Invalid text %q", doc.Text)
+		return
+	}
+
+	// A change without a range replaces the text.
+	doc.ApplyChange(lsp.TextDocumentContentChangeEvent{
+		Text: "<?php\n",
+	})
+
+	if doc.Text != "<?php\n" {
+		t.Errorf("Invalid text %q", doc.Text)
+		return
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	doc := &Document{
+		URI:  "/foo/foo.module",
+		Text: "<?php\n// Unsaved.\n",
+	}
+
+	change := func(line float64, text string) lsp.TextDocumentContentChangeEvent {
+		return lsp.TextDocumentContentChangeEvent{
+			Range: &lsp.Range{
+				Start: lsp.Position{Line: line, Character: 0},
+				End:   lsp.Position{Line: line, Character: 0},
+			},
+			Text: text,
+		}
+	}
+
+	if err := doc.ApplyChanges([]lsp.TextDocumentContentChangeEvent{change(1, "// A.\n"), change(2, "// B.\n")}); err != nil || doc.Text != "<?php\n// A.\n// B.\n// Unsaved.\n" {
+		t.Errorf("Invalid text %q, %v", doc.Text, err)
+	}
+
+	// The changes after a failed one aren't applied, the last good text
+	// is kept.
+	if err := doc.ApplyChanges([]lsp.TextDocumentContentChangeEvent{change(1, "// C.\n"), change(20, "// D.\n"), change(1, "// E.\n")}); err == nil || !doc.outOfSync {
+		t.Errorf("ApplyChanges() = %v, out of sync %v", err, doc.outOfSync)
+	}
+	if doc.Text != "<?php\n// C.\n// A.\n// B.\n// Unsaved.\n" {
+		t.Errorf("Invalid text %q", doc.Text)
+	}
+
+	// The next changes are skipped until the whole text is sent.
+	doc.ApplyChanges([]lsp.TextDocumentContentChangeEvent{change(1, "// F.\n")})
+	if doc.Text != "<?php\n// C.\n// A.\n// B.\n// Unsaved.\n" || !doc.outOfSync {
+		t.Errorf("Invalid text %q", doc.Text)
+	}
+
+	doc.ApplyChanges([]lsp.TextDocumentContentChangeEvent{{Text: "<?php\n"}, change(1, "// G.\n")})
+	if doc.Text != "<?php\n// G.\n" || doc.outOfSync {
+		t.Errorf("Invalid text %q", doc.Text)
+	}
+}
+
+func TestGetWordAtPosition(t *testing.T) {
+	doc := &Document{
+		Text: "<?php\n$a = ['é' => \\Drupal::service('foo.bar')];\n",
+	}
+
+	// The é takes two bytes but one UTF-16 code unit.
+	word, r := doc.GetWordAtPosition(lsp.Position{Line: 1, Character: 32})
+	if word != "foo.bar" || r.Start.Character != 31 || r.End.Character != 38 {
+		t.Errorf("GetWordAtPosition() = %s, %v", word, r)
+	}
+}
+
+func TestGetMethodCall(t *testing.T) {
+	doc := &Document{
+		Text: "<?php\n$storage = \\Drupal::entityTypeManager()->getStorage('node');\n\\Drupal::service('foo');\n$this->redirect(\n",
+	}
+
+	tests := []struct {
+		position lsp.Position
+		method   string
+	}{
+		// Inside the chained call.
+		{lsp.Position{Line: 1, Character: 56}, "getStorage"},
+		// Inside the first call of the chain.
+		{lsp.Position{Line: 1, Character: 38}, "entityTypeManager"},
+		{lsp.Position{Line: 2, Character: 19}, "service"},
+		// On the last line.
+		{lsp.Position{Line: 3, Character: 16}, "redirect"},
+		// Outside of a call.
+		{lsp.Position{Line: 2, Character: 23}, ""},
+	}
+
+	for _, test := range tests {
+		method, _ := doc.GetMethodCall(test.position)
+		if method != test.method {
+			t.Errorf("GetMethodCall(%v) = %q, expected %q", test.position, method, test.method)
+		}
+	}
+}
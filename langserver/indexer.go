@@ -5,119 +5,496 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/nkoporec/drupal-lsp/langserver/parser"
+	"github.com/nkoporec/drupal-lsp/php"
+	"github.com/nkoporec/drupal-lsp/utils"
 
 	"go.lsp.dev/uri"
 )
 
+// Files that can contain php code.
+var phpFileExtensions = parser.PhpFileExtensions
+
+// Directories of dependencies that aren't indexed.
+var indexSkipDirectories = []string{
+	".git",
+	"node_modules",
+}
+
+// The php dependencies, eg. Symfony. Only their class declarations are
+// indexed, they don't use the Drupal definitions.
+const vendorDirectory = "vendor"
+
+// Number of files parsed between progress reports.
+const indexBatchSize = 50
+
 type Indexer struct {
 	DocumentRoot string
 	Parsers      []parser.Parser
 	PhpClasses   []parser.PhpClass
+	PhpFunctions []parser.PhpFunction
+	// Called while indexing with the number of parsed files.
+	Progress func(message string, done int, total int)
+	mtx      sync.RWMutex
+	// The php classes by name, built again once they change.
+	phpClassIndex map[string]parser.PhpClass
+	// Closed once the indexing is done.
+	done chan struct{}
 }
 
 func NewIndexer(rootUri string) *Indexer {
 	return &Indexer{
 		DocumentRoot: rootUri,
+		done:         make(chan struct{}),
 	}
 }
 
-// @todo Implement caching.
 func (i *Indexer) Run() {
+	defer close(i.done)
+
 	i.DocumentRoot = FixDocumentRootUri(i.DocumentRoot)
 
 	// Check if the document root exists.
 	if _, err := os.Stat(i.DocumentRoot); os.IsNotExist(err) {
-		log.Fatal("Indexer: Directory does not exist")
+		log.Println("Indexer: Directory does not exist")
+		return
 	}
 
-	// Walk the document root and get all php files.
-	go func() {
-		log.Println("Indexing php files ...")
+	// Get available parsers.
+	p := parser.InitParsers()
 
-		phpClasses := []parser.PhpClass{}
-		filepath.Walk(i.DocumentRoot, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
+	// Restore the previous index, so only files that changed since
+	// then are parsed.
+	cache := LoadIndexCache(i.DocumentRoot, p)
+
+	for _, item := range p {
+		if user, ok := item.(parser.ClassIndexUser); ok {
+			user.SetClassIndex(i.GetPhpClassIndex)
+		}
+	}
+
+	items := make(map[string][]string)
+	phpFiles := []string{}
+	vendorFiles := make(map[string]bool)
+	files := make(map[string]CachedFile)
+	stale := []string{}
+
+	// Walk the document root and get all php and parser files.
+	walk := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if utils.InSlice(indexSkipDirectories, info.Name()) {
+				return filepath.SkipDir
 			}
+			return nil
+		}
+
+		ext := filepath.Ext(path)
+		isPhp := utils.InSlice(phpFileExtensions, ext)
 
-			if info.IsDir() {
-				return nil
+		// Get available custom parsers.
+		parserNames := []string{}
+		for name, item := range p {
+			matcher, ok := item.(parser.FileMatcher)
+			if (ok && matcher.MatchFile(path)) || (!ok && strings.Contains(path, item.FileExtension())) {
+				parserNames = append(parserNames, name)
 			}
+		}
+
+		if !isPhp && len(parserNames) == 0 {
+			return nil
+		}
+
+		state, changed := cache.Revalidate(path, info)
+		files[path] = state
+		if !changed {
+			return nil
+		}
 
-			// General PHP parser.
+		stale = append(stale, path)
 
-			ext := filepath.Ext(path)
-			if ext != ".php" {
-				return nil
+		if isPhp {
+			phpFiles = append(phpFiles, path)
+			if isVendorPath(i.DocumentRoot, path) {
+				vendorFiles[path] = true
 			}
+		}
+
+		for _, name := range parserNames {
+			items[name] = append(items[name], path)
+		}
+
+		return nil
+	}
+
+	syncDirectories := configSyncDirectories(i.DocumentRoot)
+	if config, ok := p["config"].(*parser.Config); ok {
+		config.SetSyncDirectories(syncDirectories)
+	}
 
-			className := filepath.Base(path)
-			className = strings.TrimSuffix(className, ext)
+	filepath.Walk(i.DocumentRoot, walk)
+	for _, dir := range syncDirectories {
+		// The ones in the document root are walked with it.
+		if rel, err := filepath.Rel(i.DocumentRoot, dir); err == nil && !strings.HasPrefix(rel, "..") {
+			continue
+		}
+
+		filepath.Walk(dir, walk)
+	}
+
+	// Files that were removed since the index was cached.
+	for path := range cache.Files {
+		if _, ok := files[path]; !ok {
+			stale = append(stale, path)
+		}
+	}
+	log.Printf("Indexer: %d of %d files changed", len(stale), len(files))
+
+	removed := make(map[string]bool, len(stale))
+	for _, path := range stale {
+		removed[path] = true
+	}
+
+	// Cached classes and functions are available right away.
+	phpClasses := []parser.PhpClass{}
+	for _, class := range cache.PhpClasses {
+		if !removed[class.Path] {
+			phpClasses = append(phpClasses, class)
+		}
+	}
+	phpFunctions := []parser.PhpFunction{}
+	for _, function := range cache.PhpFunctions {
+		if !removed[function.Path] {
+			phpFunctions = append(phpFunctions, function)
+		}
+	}
+	i.mtx.Lock()
+	i.PhpClasses = phpClasses
+	i.PhpFunctions = phpFunctions
+	i.phpClassIndex = nil
+	i.mtx.Unlock()
+
+	// The files the definitions may be used in.
+	referenceFiles := []string{}
+	for _, path := range phpFiles {
+		if !vendorFiles[path] {
+			referenceFiles = append(referenceFiles, path)
+		}
+	}
+
+	total := len(phpFiles)
+	for name, par := range p {
+		total += len(items[name])
+		if _, ok := par.(parser.ReferenceProvider); ok {
+			total += len(referenceFiles)
+		}
+	}
+	done := 0
 
+	// Parse the php classes and functions, eg. t() in bootstrap.inc. They
+	// come first, as some parsers check the classes they index.
+	done += i.batch(phpFiles, done, total, func(files []string) {
+		for _, path := range files {
 			src, err := ioutil.ReadFile(path)
 			if err != nil {
 				log.Println(err)
-				return nil
+				continue
 			}
 
-			// Split by line.
-			line := 1
-			temp := strings.Split(string(src), "\n")
-			for _, item := range temp {
-				if strings.HasPrefix(item, "namespace") {
-					// Remove the namespace.
-					item = strings.Replace(item, "namespace", "", 1)
-					item = strings.Replace(item, " ", "", 1)
-					item = strings.Replace(item, ";", "", 1)
-
-					// Add the namespace to the list.
-					phpClasses = append(phpClasses, parser.PhpClass{
-						Namespace:   item + "\\" + className,
-						Path:        path,
-						Description: "",
-					})
-				}
-				line++
+			var classes []parser.PhpClass
+			var functions []parser.PhpFunction
+			if vendorFiles[path] {
+				classes, functions = scannedPhpSymbols(path, src)
+			} else {
+				classes, functions = phpSymbols(path, src)
 			}
 
-			return nil
+			i.mtx.Lock()
+			i.PhpClasses = append(i.PhpClasses, classes...)
+			i.PhpFunctions = append(i.PhpFunctions, functions...)
+			i.phpClassIndex = nil
+			i.mtx.Unlock()
+		}
+	})
+
+	// Parse the files. A parser is only used once it's done, so
+	// requests are answered with the parsers that are ready.
+	cached := true
+	for name, par := range p {
+		par.RemoveDefinitions(stale)
+		done += i.batch(items[name], done, total, par.AddDefinitions)
+
+		// Find where the definitions are used.
+		if provider, ok := par.(parser.ReferenceProvider); ok {
+			done += i.batch(referenceFiles, done, total, provider.AddReferences)
+		}
+
+		// The edited documents update the references of the parser
+		// once it's used, so it's cached before.
+		if err := cache.AddParser(name, par); err != nil {
+			log.Println(err)
+			cached = false
+		}
+
+		i.mtx.Lock()
+		i.Parsers = append(i.Parsers, par)
+		i.mtx.Unlock()
+	}
+
+	if !cached {
+		return
+	}
+
+	cache.Files = files
+	if err := cache.Save(i.GetPhpClasses(), i.GetPhpFunctions()); err != nil {
+		log.Println(err)
+	}
+}
+
+// Get the classes and functions declared in a php file.
+func phpSymbols(path string, src []byte) ([]parser.PhpClass, []parser.PhpFunction) {
+	// The parser only supports php 7.4, the declarations it drops are
+	// scanned from the source.
+	parsedDoc, err := php.Parse(src)
+	if err != nil {
+		log.Printf("%s: %s", path, err)
+		parsedDoc = &php.ParsedDoc{Classes: php.ScanDeclarations(src)}
+	} else if len(parsedDoc.Errors) > 0 {
+		log.Printf("%s: %s", path, parsedDoc.Errors[0])
+	}
+
+	return parsedDocSymbols(path, src, parsedDoc)
+}
+
+// Get the classes declared in a php file without parsing it, which is
+// much faster for the many files of the dependencies.
+func scannedPhpSymbols(path string, src []byte) ([]parser.PhpClass, []parser.PhpFunction) {
+	return parsedDocSymbols(path, src, &php.ParsedDoc{Classes: php.ScanDeclarations(src)})
+}
+
+// Get the classes and functions of a parsed php file.
+func parsedDocSymbols(path string, src []byte, parsedDoc *php.ParsedDoc) ([]parser.PhpClass, []parser.PhpFunction) {
+	classes := []parser.PhpClass{}
+	functions := []parser.PhpFunction{}
+
+	lines := php.NewLineOffsets(src)
+	for _, class := range parsedDoc.Classes {
+		methods := []parser.PhpMethod{}
+		for _, method := range class.Methods {
+			parameters := []php.PhpParameter{}
+			for _, parameter := range method.Parameters {
+				parameters = append(parameters, *parameter)
+			}
+
+			methods = append(methods, parser.PhpMethod{
+				Name:       method.Name,
+				Parameters: parameters,
+				ReturnType: method.ReturnType,
+				Static:     method.Static,
+				Visibility: method.Visibility,
+				Range:      parser.LinesRange(lines, method.Position),
+				Doc:        docblockDoc(method.Docblock),
+			})
+		}
+
+		classes = append(classes, parser.PhpClass{
+			Namespace:   class.Name,
+			Path:        path,
+			Description: php.DocblockText(class.Docblock),
+			Range:       parser.LinesRange(lines, class.Position),
+			Kind:        class.Kind,
+			Parent:      class.Parent,
+			Interfaces:  class.Interfaces,
+			Methods:     methods,
+			Properties:  class.Properties,
+			Traits:      class.Traits,
+		})
+	}
+
+	for _, function := range parsedDoc.Functions {
+		parameters := []php.PhpParameter{}
+		for _, parameter := range function.Parameters {
+			parameters = append(parameters, *parameter)
+		}
+
+		functions = append(functions, parser.PhpFunction{
+			Name:       function.Name,
+			Namespace:  function.Namespace,
+			Path:       path,
+			Range:      parser.LinesRange(lines, function.Position),
+			Parameters: parameters,
+			Doc:        docblockDoc(function.Docblock),
 		})
+	}
 
-		i.PhpClasses = phpClasses
-		log.Println("Indexing php files completed.")
-	}()
+	return classes, functions
+}
 
-	// Get available parsers.
-	p := parser.InitParsers()
-	items := make(map[string][]string)
-	// Walk the document root and get all .services.yml files.
-	filepath.Walk(i.DocumentRoot, func(path string, info os.FileInfo, err error) error {
+// Check if a file is in a vendor directory of the document root.
+func isVendorPath(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+
+	return utils.InSlice(strings.Split(filepath.Dir(rel), string(filepath.Separator)), vendorDirectory)
+}
+
+// Wait until the indexing is done.
+func (i *Indexer) Wait() {
+	<-i.done
+}
+
+// $settings['config_sync_directory'] = '../config/sync';
+var configSyncRegex = regexp.MustCompile(`\$settings\[\s*['"]config_sync_directory['"]\s*\]\s*=\s*['"]([^'"]+)['"]`)
+
+// Get the config sync directories of the sites, which may be outside of
+// the document root.
+func configSyncDirectories(documentRoot string) []string {
+	result := []string{}
+
+	// The document root is either the Drupal root or the project root,
+	// with Drupal in a subdirectory like web.
+	settings, _ := filepath.Glob(filepath.Join(documentRoot, "sites", "*", "settings*.php"))
+	nested, _ := filepath.Glob(filepath.Join(documentRoot, "*", "sites", "*", "settings*.php"))
+
+	for _, path := range append(settings, nested...) {
+		src, err := ioutil.ReadFile(path)
 		if err != nil {
-			return err
+			continue
 		}
 
-		if info.IsDir() {
-			return nil
-		}
+		for _, match := range configSyncRegex.FindAllSubmatch(src, -1) {
+			dir := string(match[1])
 
-		// Get available custom parsers.
-		for name, item := range p {
-			if strings.Contains(path, item.FileExtension()) {
-				items[name] = append(items[name], path)
+			// Relative paths start at the Drupal root, which contains
+			// the sites directory.
+			if !filepath.IsAbs(dir) {
+				drupalRoot := filepath.Dir(filepath.Dir(filepath.Dir(path)))
+				dir = filepath.Join(drupalRoot, dir)
+			}
+			dir = filepath.Clean(dir)
+
+			if !utils.InSlice(result, dir) {
+				result = append(result, dir)
 			}
 		}
+	}
 
-		return nil
-	})
+	return result
+}
 
-	// Parse the files.
-	for name, par := range p {
-		par.AddDefinitions(items[name])
-		i.Parsers = append(i.Parsers, par)
+// Process the files in batches and report the progress after each one.
+func (i *Indexer) batch(files []string, done int, total int, process func(files []string)) int {
+	for start := 0; start < len(files); start += indexBatchSize {
+		end := start + indexBatchSize
+		if end > len(files) {
+			end = len(files)
+		}
+
+		process(files[start:end])
+
+		if i.Progress != nil {
+			i.Progress("Indexing", done+end, total)
+		}
+	}
+
+	return len(files)
+}
+
+// Get the parsers that finished indexing.
+func (i *Indexer) GetParsers() []parser.Parser {
+	i.mtx.RLock()
+	defer i.mtx.RUnlock()
+
+	return i.Parsers
+}
+
+// Update the index with the text of an edited document, so its
+// classes, functions and references don't go stale until the next run.
+func (i *Indexer) UpdateDocument(path string, text string) {
+	if utils.InSlice(phpFileExtensions, filepath.Ext(path)) {
+		i.updatePhpSymbols(path, []byte(text))
+	}
+
+	for _, item := range i.GetParsers() {
+		if provider, ok := item.(parser.ReferenceProvider); ok {
+			provider.UpdateReferences(path, text)
+		}
+	}
+}
+
+// Replace the php classes and functions of a file. The slices are copied,
+// as the requests may still use the previous ones.
+func (i *Indexer) updatePhpSymbols(path string, src []byte) {
+	classes, functions := phpSymbols(path, src)
+
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	phpClasses := make([]parser.PhpClass, 0, len(i.PhpClasses)+len(classes))
+	for _, class := range i.PhpClasses {
+		if class.Path != path {
+			phpClasses = append(phpClasses, class)
+		}
+	}
+	phpFunctions := make([]parser.PhpFunction, 0, len(i.PhpFunctions)+len(functions))
+	for _, function := range i.PhpFunctions {
+		if function.Path != path {
+			phpFunctions = append(phpFunctions, function)
+		}
+	}
+
+	i.PhpClasses = append(phpClasses, classes...)
+	i.PhpFunctions = append(phpFunctions, functions...)
+	i.phpClassIndex = nil
+}
+
+// Get the php classes indexed so far.
+func (i *Indexer) GetPhpClasses() []parser.PhpClass {
+	i.mtx.RLock()
+	defer i.mtx.RUnlock()
+
+	return i.PhpClasses
+}
+
+// Get the php classes indexed so far by their fully qualified name. The
+// map is shared by the requests, so it must not be modified.
+func (i *Indexer) GetPhpClassIndex() map[string]parser.PhpClass {
+	i.mtx.RLock()
+	index := i.phpClassIndex
+	i.mtx.RUnlock()
+
+	if index != nil {
+		return index
+	}
+
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	if i.phpClassIndex == nil {
+		i.phpClassIndex = make(map[string]parser.PhpClass, len(i.PhpClasses))
+		for _, class := range i.PhpClasses {
+			i.phpClassIndex[class.Namespace] = class
+		}
 	}
+
+	return i.phpClassIndex
+}
+
+// Get the php functions indexed so far.
+func (i *Indexer) GetPhpFunctions() []parser.PhpFunction {
+	i.mtx.RLock()
+	defer i.mtx.RUnlock()
+
+	return i.PhpFunctions
 }
 
 // Remove the file:// prefix so we can access the folder.
@@ -0,0 +1,529 @@
+package langserver
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nkoporec/drupal-lsp/langserver/parser"
+	"github.com/nkoporec/drupal-lsp/php"
+	"github.com/nkoporec/drupal-lsp/utils"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+// The services returned by the \Drupal static shorthands, eg.
+// \Drupal::messenger().
+var drupalServices = map[string]string{
+	"accessManager":                 "access_manager",
+	"classResolver":                 "class_resolver",
+	"configFactory":                 "config.factory",
+	"csrfToken":                     "csrf_token",
+	"currentUser":                   "current_user",
+	"database":                      "database",
+	"destination":                   "redirect.destination",
+	"entityDefinitionUpdateManager": "entity.definition_update_manager",
+	"entityRepository":              "entity.repository",
+	"entityTypeManager":             "entity_type.manager",
+	"flood":                         "flood",
+	"formBuilder":                   "form_builder",
+	"httpClient":                    "http_client",
+	"languageManager":               "language_manager",
+	"linkGenerator":                 "link_generator",
+	"lock":                          "lock",
+	"menuTree":                      "menu.link_tree",
+	"messenger":                     "messenger",
+	"moduleHandler":                 "module_handler",
+	"pathValidator":                 "path.validator",
+	"requestStack":                  "request_stack",
+	"routeMatch":                    "current_route_match",
+	"state":                         "state",
+	"theme":                         "theme.manager",
+	"time":                          "datetime.time",
+	"token":                         "token",
+	"translation":                   "string_translation",
+	"transliteration":               "transliteration",
+	"typedDataManager":              "typed_data_manager",
+	"urlGenerator":                  "url_generator",
+}
+
+// The classes returned by the \Drupal static shorthands that get them
+// from a service, eg. \Drupal::config('system.site').
+var drupalClasses = map[string]string{
+	"config":               "Drupal\\Core\\Config\\ImmutableConfig",
+	"entityQuery":          "Drupal\\Core\\Entity\\Query\\QueryInterface",
+	"entityQueryAggregate": "Drupal\\Core\\Entity\\Query\\QueryAggregateInterface",
+	"getContainer":         "Symfony\\Component\\DependencyInjection\\ContainerInterface",
+	"keyValue":             "Drupal\\Core\\KeyValueStore\\KeyValueStoreInterface",
+	"keyValueExpirable":    "Drupal\\Core\\KeyValueStore\\KeyValueStoreExpirableInterface",
+	"logger":               "Drupal\\Core\\Logger\\LoggerChannelInterface",
+	"queue":                "Drupal\\Core\\Queue\\QueueInterface",
+	"request":              "Symfony\\Component\\HttpFoundation\\Request",
+}
+
+// A method being typed after an expression, eg. $messenger->add
+var memberCompletionRegex = regexp.MustCompile(`->\s*(\w*)$`)
+
+// Infers the class of php expressions, eg. the service returned by
+// \Drupal::service('foo'), from the services and classes of the workspace
+// and the assignments of the document.
+type TypeInference struct {
+	src       []byte
+	parsedDoc *php.ParsedDoc
+	services  *parser.Service
+	classes   map[string]parser.PhpClass
+}
+
+// A method of a class, or one it inherits, and the class declaring it.
+type InferredMethod struct {
+	parser.PhpMethod
+	Class string
+}
+
+func NewTypeInference(i *Indexer, src []byte, parsedDoc *php.ParsedDoc) *TypeInference {
+	t := &TypeInference{
+		src:       src,
+		parsedDoc: parsedDoc,
+		classes:   i.GetPhpClassIndex(),
+	}
+
+	for _, item := range i.GetParsers() {
+		if service, ok := item.(*parser.Service); ok {
+			t.services = service
+		}
+	}
+
+	return t
+}
+
+// Get the class of an expression found at an offset of the document, or
+// an empty string if it's unknown.
+func (t *TypeInference) ExpressionClass(expr *php.PhpExpression, offset int) string {
+	return t.expressionClass(expr, offset, 0)
+}
+
+func (t *TypeInference) expressionClass(expr *php.PhpExpression, offset int, depth int) string {
+	// Give up on assignments that go in circles.
+	if expr == nil || depth > 20 {
+		return ""
+	}
+
+	switch expr.Kind {
+	case "new":
+		return t.keywordClass(expr.Class, offset)
+	case "variable":
+		if expr.Name == "this" {
+			return t.keywordClass("static", offset)
+		}
+
+		return t.variableClass(expr.Name, offset, depth)
+	case "static":
+		if expr.Class == "Drupal" {
+			if class, ok := t.drupalClass(expr); ok {
+				return class
+			}
+		}
+
+		return t.returnClass(t.keywordClass(expr.Class, offset), expr.Name)
+	case "method":
+		class := t.expressionClass(expr.Var, offset, depth+1)
+
+		// The container returns the service with the given id.
+		isContainer := strings.HasSuffix(class, "ContainerInterface") || (expr.Var != nil && expr.Var.Kind == "variable" && expr.Var.Name == "container")
+		if expr.Name == "get" && expr.Argument != "" && isContainer {
+			return t.ServiceClass(expr.Argument)
+		}
+
+		return t.returnClass(class, expr.Name)
+	}
+
+	return ""
+}
+
+// Get the class returned by a \Drupal static shorthand.
+func (t *TypeInference) drupalClass(expr *php.PhpExpression) (string, bool) {
+	switch {
+	case expr.Name == "service":
+		return t.ServiceClass(expr.Argument), true
+	case expr.Name == "cache":
+		bin := expr.Argument
+		if bin == "" {
+			bin = "default"
+		}
+
+		return t.ServiceClass("cache." + bin), true
+	case drupalServices[expr.Name] != "":
+		return t.ServiceClass(drupalServices[expr.Name]), true
+	case drupalClasses[expr.Name] != "":
+		return drupalClasses[expr.Name], true
+	}
+
+	return "", false
+}
+
+// Get the class of a service, through its aliases and parents.
+func (t *TypeInference) ServiceClass(name string) string {
+	if t.services == nil || name == "" {
+		return ""
+	}
+
+	for _, def := range t.services.GetGoToDefinition(name) {
+		if def.Class != "" {
+			return strings.TrimPrefix(def.Class, "\\")
+		}
+	}
+
+	return ""
+}
+
+// Get the class of a variable from its last assignment before the offset,
+// or the type of the parameter of the enclosing function.
+func (t *TypeInference) variableClass(name string, offset int, depth int) string {
+	start, end, parameters := t.scope(offset)
+
+	var last *php.PhpAssignment
+	for _, assignment := range t.parsedDoc.Assignments {
+		position := assignment.Position
+		if assignment.Var != name || assignment.Key != "" || position == nil || position.EndPos > offset || position.StartPos < start || position.EndPos > end {
+			continue
+		}
+
+		if last == nil || position.StartPos > last.Position.StartPos {
+			last = assignment
+		}
+	}
+
+	if last != nil {
+		return t.expressionClass(last.Value, last.Position.StartPos, depth+1)
+	}
+
+	for _, parameter := range parameters {
+		if parameter.Name == name {
+			return t.typeClass(parameter.Type, offset)
+		}
+	}
+
+	return ""
+}
+
+// Get the body of the method or function around an offset, and its
+// parameters. Outside of functions the whole document is the scope.
+func (t *TypeInference) scope(offset int) (int, int, []*php.PhpParameter) {
+	for _, method := range t.parsedDoc.ClassMethods {
+		if method.Body != nil && method.Body.StartPos <= offset && offset <= method.Body.EndPos {
+			return method.Body.StartPos, method.Body.EndPos, method.Parameters
+		}
+	}
+
+	for _, function := range t.parsedDoc.Functions {
+		if function.Body != nil && function.Body.StartPos <= offset && offset <= function.Body.EndPos {
+			return function.Body.StartPos, function.Body.EndPos, function.Parameters
+		}
+	}
+
+	return 0, len(t.src), nil
+}
+
+// Get the class of a type as written in the document, eg. ?FooInterface.
+func (t *TypeInference) typeClass(typ string, offset int) string {
+	for _, part := range strings.Split(strings.TrimPrefix(typ, "?"), "|") {
+		if part == "" || strings.ToLower(part) == "null" || !isClassType(part) {
+			continue
+		}
+
+		return t.keywordClass(t.parsedDoc.ResolveName(part), offset)
+	}
+
+	return ""
+}
+
+// Resolve the self, static and parent keywords to the class declared
+// around the offset.
+func (t *TypeInference) keywordClass(class string, offset int) string {
+	if class != "self" && class != "static" && class != "parent" {
+		return class
+	}
+
+	declaration, _ := classAt(t.src, t.parsedDoc, offset)
+	if declaration == nil {
+		return ""
+	}
+
+	if class == "parent" {
+		return declaration.Parent
+	}
+
+	return declaration.Name
+}
+
+// Get the class returned by a method of a class.
+func (t *TypeInference) returnClass(class string, name string) string {
+	method, ok := t.Method(class, name)
+	if !ok {
+		return ""
+	}
+
+	// Fluent methods return the class they're called on.
+	if method.ReturnType == "static" {
+		return class
+	}
+
+	if !isClassType(method.ReturnType) {
+		return ""
+	}
+
+	return method.ReturnType
+}
+
+// Find a method of a class or of the classes and interfaces it inherits.
+func (t *TypeInference) Method(class string, name string) (InferredMethod, bool) {
+	for _, method := range t.Methods(class) {
+		if strings.EqualFold(method.Name, name) {
+			return method, true
+		}
+	}
+
+	return InferredMethod{}, false
+}
+
+// Get the methods of a class, including the ones of its parents and
+// interfaces. Methods documented with {@inheritdoc} get the return type
+// of the method they override.
+func (t *TypeInference) Methods(class string) []InferredMethod {
+	result := []InferredMethod{}
+	index := map[string]int{}
+	seen := map[string]bool{}
+
+	queue := []string{class}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		item, ok := t.classes[name]
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		for _, method := range item.Methods {
+			key := strings.ToLower(method.Name)
+			if i, ok := index[key]; ok {
+				if result[i].ReturnType == "" {
+					result[i].ReturnType = method.ReturnType
+				}
+				continue
+			}
+
+			index[key] = len(result)
+			result = append(result, InferredMethod{
+				PhpMethod: method,
+				Class:     name,
+			})
+		}
+
+		if item.Parent != "" {
+			queue = append(queue, item.Parent)
+		}
+		queue = append(queue, item.Interfaces...)
+	}
+
+	return result
+}
+
+// Check if a type is a class, builtin types are lowercase and not
+// namespaced.
+func isClassType(typ string) bool {
+	if typ == "" || strings.HasSuffix(typ, "[]") {
+		return false
+	}
+
+	return strings.Contains(typ, "\\") || typ != strings.ToLower(typ)
+}
+
+// Find where the expression that ends at an offset starts, eg. $this->foo
+// in $this->foo->bar(, by going back over names, calls and arrows.
+func expressionStart(text string, end int) int {
+	isNameByte := func(c byte) bool {
+		return c == '_' || c == '$' || c == '\\' || c >= 0x80 ||
+			(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+	}
+
+	i := end
+	for {
+		// The arguments of a call, eg. getStorage('node').
+		if i > 0 && text[i-1] == ')' {
+			open := matchingOpenParenthesis(text, i-1)
+			if open == -1 {
+				return i
+			}
+			i = open
+		}
+
+		j := i
+		for j > 0 && isNameByte(text[j-1]) {
+			j--
+		}
+
+		// A bracketed expression, eg. (new Foo()), has no name.
+		if j == i {
+			return i
+		}
+		i = j
+
+		// Chained calls may be split over several lines.
+		before := strings.TrimRight(text[:i], " \t\r\n")
+		if !strings.HasSuffix(before, "->") && !strings.HasSuffix(before, "::") {
+			return i
+		}
+		i = len(strings.TrimRight(before[:len(before)-2], " \t\r\n"))
+	}
+}
+
+// Find the parenthesis that opens the one closed at an offset, skipping
+// the ones in strings.
+func matchingOpenParenthesis(text string, close int) int {
+	depth := 0
+
+	for i := close; i >= 0; i-- {
+		switch text[i] {
+		case '\'', '"':
+			quote := text[i]
+			for i--; i >= 0 && (text[i] != quote || (i > 0 && text[i-1] == '\\')); i-- {
+			}
+		case ')':
+			depth++
+		case '(':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// Parse a document for type inference while an expression is typed, with
+// the incomplete code between start and end replaced, eg. by null.
+func inferenceDoc(text string, start int, end int, replacement string) (*php.ParsedDoc, []byte) {
+	src := []byte(text[:start] + replacement + text[end:])
+
+	parsedDoc, err := php.Parse(src)
+	if err != nil {
+		return nil, nil
+	}
+
+	return parsedDoc, src
+}
+
+// Complete the methods of the class of the expression before ->, eg.
+// $messenger-> after $messenger = \Drupal::messenger().
+func (h *LspHandler) memberCompletion(doc *Document, position lsp.Position) []lsp.CompletionItem {
+	result := []lsp.CompletionItem{}
+
+	if !utils.InSlice(phpFileExtensions, filepath.Ext(doc.URI)) {
+		return result
+	}
+
+	offset, err := doc.Offset(position)
+	if err != nil {
+		return result
+	}
+
+	match := memberCompletionRegex.FindStringSubmatchIndex(doc.Text[:offset])
+	if match == nil {
+		return result
+	}
+
+	// The arrow may be on the next line of a chained call.
+	end := len(strings.TrimRight(doc.Text[:match[0]], " \t\r\n"))
+	start := expressionStart(doc.Text, end)
+	if start == end {
+		return result
+	}
+
+	parsedDoc, src := inferenceDoc(doc.Text, start, offset, "")
+	if parsedDoc == nil {
+		return result
+	}
+
+	receiver := doc.Text[start:end]
+	expr, err := parsedDoc.ParseExpression(receiver)
+	if err != nil {
+		return result
+	}
+
+	inference := NewTypeInference(h.Indexer, src, parsedDoc)
+	class := inference.ExpressionClass(expr, start)
+	if class == "" {
+		return result
+	}
+
+	// Protected and private methods are only available on $this.
+	isThis := expr.Kind == "variable" && expr.Name == "this"
+
+	editRange := lsp.Range{
+		Start: lsp.Position{
+			Line:      position.Line,
+			Character: position.Character - float64(match[3]-match[2]),
+		},
+		End: position,
+	}
+
+	for _, method := range inference.Methods(class) {
+		if method.Static || strings.HasPrefix(method.Name, "__") || (!isThis && method.Visibility != "" && method.Visibility != "public") {
+			continue
+		}
+
+		result = append(result, lsp.CompletionItem{
+			Kind:       lsp.MethodCompletion,
+			Label:      method.Name,
+			Detail:     methodSignature(method.PhpMethod),
+			FilterText: method.Name,
+			Documentation: lsp.MarkupContent{
+				Kind:  lsp.PlainText,
+				Value: method.Class,
+			},
+			TextEdit: &lsp.TextEdit{
+				Range:   editRange,
+				NewText: method.Name,
+			},
+		})
+	}
+
+	return result
+}
+
+// Format the signature of a method, eg. getStorage($entity_type_id):
+// EntityStorageInterface.
+func methodSignature(method parser.PhpMethod) string {
+	parameters := []string{}
+	for _, parameter := range method.Parameters {
+		parameters = append(parameters, parameterLabel(parameter))
+	}
+
+	result := method.Name + "(" + strings.Join(parameters, ", ") + ")"
+	if method.ReturnType != "" {
+		result += ": " + method.ReturnType[strings.LastIndex(method.ReturnType, "\\")+1:]
+	}
+
+	return result
+}
+
+// Format a parameter the way it's declared, eg. array $options = [].
+func parameterLabel(parameter php.PhpParameter) string {
+	label := "$" + parameter.Name
+	if parameter.Variadic {
+		label = "..." + label
+	}
+
+	if parameter.Type != "" {
+		label = parameter.Type + " " + label
+	}
+
+	if parameter.Default != "" {
+		label += " = " + parameter.Default
+	}
+
+	return label
+}
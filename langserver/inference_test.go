@@ -0,0 +1,139 @@
+package langserver
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nkoporec/drupal-lsp/langserver/parser"
+	"github.com/nkoporec/drupal-lsp/php"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+// An indexer with the messenger and entity type manager services.
+func inferenceIndexer() *Indexer {
+	return &Indexer{
+		Parsers: []parser.Parser{
+			&parser.Service{
+				Definitions: []parser.ParserDefinition{
+					{Name: "messenger", Class: "Drupal\\Core\\Messenger\\Messenger"},
+					{Name: "entity_type.manager", Class: "Drupal\\Core\\Entity\\EntityTypeManager"},
+				},
+			},
+		},
+		PhpClasses: []parser.PhpClass{
+			{
+				Namespace:  "Drupal\\Core\\Messenger\\Messenger",
+				Kind:       "class",
+				Interfaces: []string{"Drupal\\Core\\Messenger\\MessengerInterface"},
+				Methods: []parser.PhpMethod{
+					{Name: "__construct", Visibility: "public"},
+					{Name: "addMessage", Visibility: "public"},
+					{Name: "setLogger", Visibility: "protected"},
+				},
+			},
+			{
+				Namespace: "Drupal\\Core\\Messenger\\MessengerInterface",
+				Kind:      "interface",
+				Methods: []parser.PhpMethod{
+					{Name: "addMessage", Visibility: "public", ReturnType: "static"},
+					{Name: "addStatus", Visibility: "public", ReturnType: "static"},
+				},
+			},
+			{
+				Namespace: "Drupal\\Core\\Entity\\EntityTypeManager",
+				Kind:      "class",
+				Methods: []parser.PhpMethod{
+					{Name: "getStorage", Visibility: "public", ReturnType: "Drupal\\Core\\Entity\\EntityStorageInterface", Parameters: []php.PhpParameter{{Name: "entity_type_id"}}},
+				},
+			},
+			{
+				Namespace: "Drupal\\Core\\Entity\\EntityStorageInterface",
+				Kind:      "interface",
+				Methods: []parser.PhpMethod{
+					{Name: "load", Visibility: "public"},
+				},
+			},
+		},
+	}
+}
+
+func TestMemberCompletion(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		{"<?php\nfunction foo() {\n  $messenger = \\Drupal::service('messenger');\n  $messenger->|\n}\n", []string{"addMessage", "addStatus"}},
+		{"<?php\nfunction foo() {\n  $messenger = \\Drupal::messenger();\n  $other = $messenger;\n  $other->add|\n}\n", []string{"addMessage", "addStatus"}},
+		{"<?php\nfunction foo() {\n  \\Drupal::messenger()->addStatus('hi')\n    ->|\n}\n", []string{"addMessage", "addStatus"}},
+		{"<?php\nfunction foo() {\n  $storage = \\Drupal::entityTypeManager()->getStorage('node');\n  $storage->|\n}\n", []string{"load"}},
+		{"<?php\nfunction foo() {\n  $messenger = \\Drupal::service('unknown');\n  $messenger->|\n}\n", []string{}},
+	}
+
+	h := &LspHandler{Indexer: inferenceIndexer()}
+
+	for _, test := range tests {
+		// The cursor is at the |.
+		cursor := strings.Index(test.text, "|")
+		lines := strings.Split(test.text[:cursor], "\n")
+		position := lsp.Position{
+			Line:      float64(len(lines) - 1),
+			Character: float64(len(lines[len(lines)-1])),
+		}
+
+		text := strings.Replace(test.text, "|", "", 1)
+		labels := []string{}
+		for _, item := range h.memberCompletion(&Document{URI: "/foo/foo.module", Text: text}, position) {
+			labels = append(labels, item.Label)
+		}
+		sort.Strings(labels)
+
+		if strings.Join(labels, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("memberCompletion(%q) = %v, want %v", test.text, labels, test.expected)
+		}
+	}
+}
+
+func TestExpressionClassParameters(t *testing.T) {
+	src := "<?php\nnamespace Drupal\\foo;\n\nuse Drupal\\Core\\Messenger\\MessengerInterface;\n\nclass Foo {\n  public function build(MessengerInterface $messenger) {\n    return $messenger;\n  }\n}\n"
+
+	parsedDoc, err := php.Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	inference := NewTypeInference(inferenceIndexer(), []byte(src), parsedDoc)
+	offset := strings.Index(src, "return")
+
+	class := inference.ExpressionClass(&php.PhpExpression{Kind: "variable", Name: "messenger"}, offset)
+	if class != "Drupal\\Core\\Messenger\\MessengerInterface" {
+		t.Errorf("Invalid parameter class %q", class)
+	}
+
+	class = inference.ExpressionClass(&php.PhpExpression{Kind: "variable", Name: "this"}, offset)
+	if class != "Drupal\\foo\\Foo" {
+		t.Errorf("Invalid class of $this %q", class)
+	}
+}
+
+func TestExpressionStart(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"return $this->foo", "$this->foo"},
+		{"$a = \\Drupal::service('foo')", "\\Drupal::service('foo')"},
+		{"foo($x->get(')')->bar()", "$x->get(')')->bar()"},
+		{"$x\n  ->foo()", "$x\n  ->foo()"},
+		{"$x = (new Foo())", "(new Foo())"},
+	}
+
+	for _, test := range tests {
+		start := expressionStart(test.text, len(test.text))
+		if test.text[start:] != test.expected {
+			t.Errorf("expressionStart(%q) = %q, want %q", test.text, test.text[start:], test.expected)
+		}
+	}
+}
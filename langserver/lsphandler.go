@@ -3,8 +3,12 @@ package langserver
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"os"
+	"strings"
 
+	"github.com/nkoporec/drupal-lsp/langserver/parser"
 	"github.com/nkoporec/drupal-lsp/utils"
 
 	"go.lsp.dev/jsonrpc2"
@@ -18,12 +22,19 @@ type LspHandler struct {
 	rootUri string
 	Indexer *Indexer
 	Buffer  *Buffer
+	// Indexers shared with other connections, if any.
+	pool *IndexerPool
 }
 
 // InitializeParams
 type InitializeParams struct {
-	ProcessID int    `json:"processId,omitempty"`
-	RootURI   string `json:"rootUri,omitempty"`
+	ProcessID    int    `json:"processId,omitempty"`
+	RootURI      string `json:"rootUri,omitempty"`
+	Capabilities struct {
+		Window struct {
+			WorkDoneProgress bool `json:"workDoneProgress,omitempty"`
+		} `json:"window,omitempty"`
+	} `json:"capabilities,omitempty"`
 }
 
 // NewLspHandler ...
@@ -31,24 +42,66 @@ func NewLspHandler() *LspHandler {
 	return &LspHandler{}
 }
 
+// NewLspHandlerWithPool creates a handler that shares its indexer with
+// the other connections of the pool opening the same document root.
+func NewLspHandlerWithPool(pool *IndexerPool) *LspHandler {
+	return &LspHandler{
+		pool: pool,
+	}
+}
+
+// Close releases the shared indexer once the connection is closed.
+func (h *LspHandler) Close() {
+	if h.pool != nil && h.Indexer != nil {
+		h.pool.Release(h.rootUri)
+	}
+}
+
 func (h *LspHandler) handleTextDocumentCompletion(ctx context.Context, params *lsp.CompletionParams) ([]lsp.CompletionItem, error) {
 	result := make([]lsp.CompletionItem, 0, 200)
 
 	// Get the doc.
 	doc := h.Buffer.GetBufferDoc(UriToFilename(params.TextDocument.URI))
+
+	// Get all parsers.
+	parsers := h.Indexer.GetParsers()
+
+	// Parsers that complete the document itself.
+	for _, item := range parsers {
+		if completer, ok := item.(parser.DocumentCompleter); ok {
+			result = append(result, completer.DocumentCompletion(doc.URI, doc.Text, params.Position)...)
+		}
+	}
+
+	if doc.IsTwig() {
+		return result, nil
+	}
+
+	// Methods of the class of the expression before ->.
+	if members := h.memberCompletion(doc, params.Position); len(members) > 0 {
+		return append(result, members...), nil
+	}
+
 	method, err := doc.GetMethodCall(params.Position)
 	if err != nil {
+		if len(result) > 0 {
+			return result, nil
+		}
+
 		return result, err
 	}
 
-	// Get all parsers.
-	parsers := h.Indexer.Parsers
-	for _, parser := range parsers {
+	for _, item := range parsers {
+		// These parsers already completed the method call.
+		if _, ok := item.(parser.DocumentCompleter); ok {
+			continue
+		}
+
 		// Get the method call.
-		methods := parser.Methods()
+		methods := item.Methods()
 		if utils.InSlice(methods, method) {
-			for _, def := range parser.GetDefinitions() {
-				completion, err := parser.CompletionItem(def)
+			for _, def := range item.GetDefinitions() {
+				completion, err := item.CompletionItem(def)
 				if err != nil {
 					return result, err
 				}
@@ -62,17 +115,31 @@ func (h *LspHandler) handleTextDocumentCompletion(ctx context.Context, params *l
 }
 
 func (h *LspHandler) handleGoToDefinition(ctx context.Context, params *lsp.TextDocumentPositionParams, i *Indexer) ([]lsp.Location, error) {
-	// @todo: Implement.
 	result := make([]lsp.Location, 0, 200)
 
 	doc := h.Buffer.GetBufferDoc(UriToFilename(params.TextDocument.URI))
+
+	// Get all parsers.
+	parsers := h.Indexer.GetParsers()
+
+	// Parsers that resolve names in the document itself.
+	for _, item := range parsers {
+		if provider, ok := item.(parser.DefinitionProvider); ok {
+			for _, def := range provider.DocumentDefinition(doc.URI, doc.Text, params.Position) {
+				result = append(result, definitionLocations(i, def)...)
+			}
+		}
+	}
+
+	if len(result) > 0 || doc.IsTwig() {
+		return result, nil
+	}
+
 	method, err := doc.GetMethodCall(params.Position)
 	if err != nil {
 		return result, err
 	}
 
-	// Get all parsers.
-	parsers := h.Indexer.Parsers
 	for _, parser := range parsers {
 		// Get the method call.
 		methods := parser.Methods()
@@ -83,16 +150,8 @@ func (h *LspHandler) handleGoToDefinition(ctx context.Context, params *lsp.TextD
 			}
 
 			definitions := parser.GetGoToDefinition(methodParams)
-			for _, class := range definitions {
-				for _, item := range i.PhpClasses {
-					if item.Namespace == class {
-						// @todo: Implement start/end range.
-						result = append(result, lsp.Location{
-							URI:   uri.File(item.Path),
-							Range: lsp.Range{},
-						})
-					}
-				}
+			for _, def := range definitions {
+				result = append(result, definitionLocations(i, def)...)
 			}
 		}
 	}
@@ -100,17 +159,84 @@ func (h *LspHandler) handleGoToDefinition(ctx context.Context, params *lsp.TextD
 	return result, nil
 }
 
+// Get the locations of a definition, its class and the place where it's
+// declared.
+func definitionLocations(i *Indexer, def parser.ParserDefinition) []lsp.Location {
+	result := []lsp.Location{}
+
+	declared := false
+	for _, item := range i.GetPhpClasses() {
+		if def.Class != "" && item.Namespace == def.Class {
+			// The definition is declared by the class itself, eg. a plugin.
+			declared = declared || item.Path == def.File
+
+			location := lsp.Location{
+				URI:   uri.File(item.Path),
+				Range: item.Range,
+			}
+
+			// Point to the method if the definition has one, eg. a controller.
+			for _, method := range item.Methods {
+				if def.Method != "" && method.Name == def.Method {
+					location.Range = method.Range
+				}
+			}
+
+			result = append(result, location)
+		}
+	}
+
+	// The place where the definition is declared, eg. a services.yml key.
+	if def.File != "" && !declared {
+		end := def.Position
+		end.Character += float64(len(def.Name))
+
+		result = append(result, lsp.Location{
+			URI: uri.File(def.File),
+			Range: lsp.Range{
+				Start: def.Position,
+				End:   end,
+			},
+		})
+	}
+
+	return result
+}
+
 func (h *LspHandler) handleHoverDefinition(ctx context.Context, params *lsp.TextDocumentPositionParams, i *Indexer) (lsp.Hover, error) {
 	result := lsp.Hover{}
 
 	doc := h.Buffer.GetBufferDoc(UriToFilename(params.TextDocument.URI))
+
+	// Get all parsers.
+	parsers := h.Indexer.GetParsers()
+
+	// Parsers that describe the document itself.
+	for _, item := range parsers {
+		provider, ok := item.(parser.HoverProvider)
+		if !ok {
+			continue
+		}
+
+		if value := provider.Hover(doc.URI, doc.Text, params.Position); value != "" {
+			return lsp.Hover{
+				Contents: lsp.MarkupContent{
+					Kind:  lsp.PlainText,
+					Value: value,
+				},
+			}, nil
+		}
+	}
+
+	if doc.IsTwig() {
+		return result, nil
+	}
+
 	method, err := doc.GetMethodCall(params.Position)
 	if err != nil {
 		return result, err
 	}
 
-	// Get all parsers.
-	parsers := h.Indexer.Parsers
 	for _, parser := range parsers {
 		// Get the method call.
 		methods := parser.Methods()
@@ -121,15 +247,13 @@ func (h *LspHandler) handleHoverDefinition(ctx context.Context, params *lsp.Text
 			}
 
 			definitions := parser.GetGoToDefinition(methodParams)
-			for _, class := range definitions {
-				for _, item := range i.PhpClasses {
-					if item.Namespace == class {
-						result = lsp.Hover{
-							Contents: lsp.MarkupContent{
-								Kind:  "php",
-								Value: item.Namespace + "\n\n" + item.Path + "\n\n" + item.Description,
-							},
-						}
+			for _, def := range definitions {
+				if item, ok := i.GetPhpClassIndex()[def.Class]; ok {
+					result = lsp.Hover{
+						Contents: lsp.MarkupContent{
+							Kind:  "php",
+							Value: item.Namespace + "\n\n" + item.Path + "\n\n" + item.Description,
+						},
 					}
 				}
 			}
@@ -139,26 +263,185 @@ func (h *LspHandler) handleHoverDefinition(ctx context.Context, params *lsp.Text
 	return result, nil
 }
 
+func (h *LspHandler) handleReferences(ctx context.Context, params *lsp.ReferenceParams) ([]lsp.Location, error) {
+	result := make([]lsp.Location, 0, 200)
+
+	doc := h.Buffer.GetBufferDoc(UriToFilename(params.TextDocument.URI))
+	if doc == nil {
+		return result, nil
+	}
+
+	// Parsers that find the references of the document itself.
+	for _, item := range h.Indexer.GetParsers() {
+		if provider, ok := item.(parser.DocumentReferenceProvider); ok {
+			result = append(result, provider.DocumentReferences(doc.URI, doc.Text, params.Position, params.Context.IncludeDeclaration)...)
+		}
+	}
+
+	item, name, _, ok := h.referenceAt(doc, params.Position)
+	if !ok {
+		return result, nil
+	}
+
+	return append(result, item.(parser.ReferenceProvider).GetReferences(name, params.Context.IncludeDeclaration)...), nil
+}
+
+// Get the parser whose definition is used or declared under the cursor,
+// the name of the definition and its range.
+func (h *LspHandler) referenceAt(doc *Document, position lsp.Position) (parser.Parser, string, lsp.Range, bool) {
+	for _, item := range h.Indexer.GetParsers() {
+		provider, ok := item.(parser.ReferenceProvider)
+		if !ok {
+			continue
+		}
+
+		if name, nameRange, ok := provider.ReferenceAt(doc.URI, doc.Text, position); ok {
+			return item, name, nameRange, true
+		}
+	}
+
+	return nil, "", lsp.Range{}, false
+}
+
+func (h *LspHandler) handleCodeLens(ctx context.Context, params *lsp.CodeLensParams) ([]lsp.CodeLens, error) {
+	result := []lsp.CodeLens{}
+
+	doc := h.Buffer.GetBufferDoc(UriToFilename(params.TextDocument.URI))
+	if doc == nil {
+		return result, nil
+	}
+
+	for _, item := range h.Indexer.GetParsers() {
+		if provider, ok := item.(parser.CodeLensProvider); ok {
+			result = append(result, provider.CodeLens(doc.URI, doc.Text)...)
+		}
+	}
+
+	return result, nil
+}
+
+func (h *LspHandler) handlePrepareRename(ctx context.Context, params *lsp.TextDocumentPositionParams) (*lsp.Range, error) {
+	doc := h.Buffer.GetBufferDoc(UriToFilename(params.TextDocument.URI))
+	if doc == nil {
+		return nil, nil
+	}
+
+	// Only definitions we can find the references of can be renamed.
+	item, name, nameRange, ok := h.referenceAt(doc, params.Position)
+	if !ok || len(item.GetGoToDefinition(name)) == 0 {
+		return nil, nil
+	}
+
+	return &nameRange, nil
+}
+
+func (h *LspHandler) handleRename(ctx context.Context, params *lsp.RenameParams) (*lsp.WorkspaceEdit, error) {
+	doc := h.Buffer.GetBufferDoc(UriToFilename(params.TextDocument.URI))
+	if doc == nil {
+		return nil, nil
+	}
+
+	item, name, _, ok := h.referenceAt(doc, params.Position)
+	if !ok || len(item.GetGoToDefinition(name)) == 0 {
+		return nil, nil
+	}
+
+	if params.NewName == "" || strings.ContainsAny(params.NewName, " \t'\"@") {
+		return nil, jsonrpc2.Errorf(jsonrpc2.InvalidParams, "Invalid name '%s'", params.NewName)
+	}
+
+	if len(item.GetGoToDefinition(params.NewName)) > 0 {
+		return nil, jsonrpc2.Errorf(jsonrpc2.InvalidParams, "'%s' is already defined", params.NewName)
+	}
+
+	result := &lsp.WorkspaceEdit{
+		Changes: make(map[uri.URI][]lsp.TextEdit),
+	}
+
+	renamer, ok := item.(parser.RenameProvider)
+	if !ok {
+		return result, nil
+	}
+
+	// The indexed ranges are outdated once a file is edited, so they
+	// are only used to know which files to look at. Open documents
+	// may use the name without being saved yet.
+	files := h.Buffer.DocumentURIs()
+	for _, location := range item.(parser.ReferenceProvider).GetReferences(name, true) {
+		if path := UriToFilename(location.URI); !utils.InSlice(files, path) {
+			files = append(files, path)
+		}
+	}
+
+	for _, path := range files {
+		text, err := h.Buffer.FileText(path)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		for _, nameRange := range renamer.RenameRanges(path, text, name) {
+			documentUri := uri.File(path)
+			result.Changes[documentUri] = append(result.Changes[documentUri], lsp.TextEdit{
+				Range:   nameRange,
+				NewText: params.NewName,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// Index the document root and report the progress to the client if it
+// supports it.
+func (h *LspHandler) runIndexer(conn *jsonrpc2.Conn, progressConn *jsonrpc2.Conn) {
+	ctx := context.Background()
+
+	progress := NewWorkDoneProgress(ctx, progressConn, "drupal-lsp/indexing", "Indexing Drupal files")
+	h.Indexer.Progress = func(message string, done int, total int) {
+		progress.Report(ctx, message, done, total)
+	}
+
+	h.Indexer.Run()
+	progress.End(ctx, "Indexing completed")
+
+	// Diagnostics of documents opened while indexing are incomplete.
+	h.Buffer.PublishDiagnostics(ctx, conn, h.Indexer)
+}
+
+// Wait for an indexer run by another connection.
+func (h *LspHandler) waitIndexer(conn *jsonrpc2.Conn) {
+	h.Indexer.Wait()
+	h.Buffer.PublishDiagnostics(context.Background(), conn, h.Indexer)
+}
+
 // Deliver ...
 func (h *LspHandler) Deliver(ctx context.Context, r *jsonrpc2.Request, delivered bool) bool {
 	switch r.Method {
 	case lsp.MethodInitialize:
 		// Get params.
 		var params InitializeParams
-		if err := json.Unmarshal(*r.Params, &params); err != nil {
-			log.Fatal(err)
+		if err := unmarshalParams(r, &params); err != nil {
+			r.Reply(ctx, nil, jsonrpc2.Errorf(jsonrpc2.InvalidParams, "%s", err))
+			return true
+		}
+
+		// Only this connection fails, the others may share the server.
+		if _, err := os.Stat(FixDocumentRootUri(params.RootURI)); err != nil {
+			r.Reply(ctx, nil, jsonrpc2.Errorf(jsonrpc2.InvalidParams, "Invalid rootUri '%s'", params.RootURI))
+			return true
 		}
 
 		// Set rootUri.
 		h.rootUri = params.RootURI
 
-		// Run the index.
-		// @todo make it async.
-		indexer := NewIndexer(h.rootUri)
-		indexer.Run()
-
-		// Set the indexer.
-		h.Indexer = indexer
+		// Set the indexer, it's run once we replied.
+		created := true
+		if h.pool != nil {
+			h.Indexer, created = h.pool.Get(h.rootUri)
+		} else {
+			h.Indexer = NewIndexer(h.rootUri)
+		}
 
 		// Buffer.
 		h.Buffer = NewBuffer()
@@ -169,8 +452,20 @@ func (h *LspHandler) Deliver(ctx context.Context, r *jsonrpc2.Request, delivered
 				CompletionProvider: &lsp.CompletionOptions{},
 				DefinitionProvider: true,
 				HoverProvider:      true,
+				ReferencesProvider: true,
+				CodeLensProvider:   &lsp.CodeLensOptions{},
+				ExecuteCommandProvider: &lsp.ExecuteCommandOptions{
+					Commands: []string{parser.EventCodeLensCommand},
+				},
+				CodeActionProvider: true,
+				SignatureHelpProvider: &lsp.SignatureHelpOptions{
+					TriggerCharacters: []string{"(", ","},
+				},
+				RenameProvider: lsp.RenameOptions{
+					PrepareProvider: true,
+				},
 				TextDocumentSync: lsp.TextDocumentSyncOptions{
-					Change:    float64(lsp.Full),
+					Change:    float64(lsp.Incremental),
 					OpenClose: true,
 					Save: &lsp.SaveOptions{
 						IncludeText: true,
@@ -179,11 +474,32 @@ func (h *LspHandler) Deliver(ctx context.Context, r *jsonrpc2.Request, delivered
 			},
 		}, nil)
 
+		// The client is gone, its connection ends on its own.
 		if err != nil {
-			log.Fatal(err)
-			panic(err)
+			log.Println(err)
+			return true
+		}
+
+		// Requests are answered with partial results until the
+		// indexing is done.
+		var conn *jsonrpc2.Conn
+		if params.Capabilities.Window.WorkDoneProgress {
+			conn = r.Conn()
 		}
+		if created {
+			go h.runIndexer(r.Conn(), conn)
+		} else {
+			go h.waitIndexer(r.Conn())
+		}
+
+		return true
+	}
 
+	// Requests sent before a successful initialize.
+	if h.Indexer == nil {
+		if !r.IsNotify() {
+			r.Reply(ctx, nil, jsonrpc2.Errorf(jsonrpc2.ServerNotInitialized, "Server not initialized"))
+		}
 		return true
 	}
 
@@ -191,41 +507,90 @@ func (h *LspHandler) Deliver(ctx context.Context, r *jsonrpc2.Request, delivered
 	switch r.Method {
 	case lsp.MethodTextDocumentDidOpen:
 		var params lsp.DidOpenTextDocumentParams
-		json.Unmarshal(*r.Params, &params)
+		unmarshalParams(r, &params)
 		documentUri := UriToFilename(params.TextDocument.URI)
 		if documentUri != "" {
 			h.Buffer.UpdateBufferDoc(documentUri, params.TextDocument.Text, ctx, r, h.Indexer)
 		}
 	case lsp.MethodTextDocumentDidChange:
 		var params lsp.DidChangeTextDocumentParams
-		json.Unmarshal(*r.Params, &params)
+		unmarshalParams(r, &params)
 		documentUri := UriToFilename(params.TextDocument.URI)
 		if documentUri != "" && len(params.ContentChanges) > 0 {
-			h.Buffer.UpdateBufferDoc(documentUri, params.ContentChanges[0].Text, ctx, r, h.Indexer)
+			h.Buffer.ApplyBufferChanges(documentUri, params.ContentChanges, r, h.Indexer)
+		}
+	case lsp.MethodTextDocumentDidClose:
+		var params lsp.DidCloseTextDocumentParams
+		unmarshalParams(r, &params)
+		documentUri := UriToFilename(params.TextDocument.URI)
+		if documentUri != "" {
+			h.Buffer.CloseBufferDoc(documentUri, h.Indexer)
 		}
 	case lsp.MethodTextDocumentDidSave:
 		var params lsp.DidSaveTextDocumentParams
-		json.Unmarshal(*r.Params, &params)
+		unmarshalParams(r, &params)
 		documentUri := UriToFilename(params.TextDocument.URI)
 		if documentUri != "" {
 			h.Buffer.UpdateBufferDoc(documentUri, params.Text, ctx, r, h.Indexer)
 		}
 	case lsp.MethodTextDocumentCompletion:
 		var params lsp.CompletionParams
-		json.Unmarshal(*r.Params, &params)
+		unmarshalParams(r, &params)
 		items, err := h.handleTextDocumentCompletion(ctx, &params)
 		r.Reply(ctx, items, err)
 	case lsp.MethodTextDocumentDefinition:
 		var params lsp.TextDocumentPositionParams
-		json.Unmarshal(*r.Params, &params)
+		unmarshalParams(r, &params)
 		found, err := h.handleGoToDefinition(ctx, &params, h.Indexer)
 		r.Reply(ctx, found, err)
+	case lsp.MethodTextDocumentReferences:
+		var params lsp.ReferenceParams
+		unmarshalParams(r, &params)
+		found, err := h.handleReferences(ctx, &params)
+		r.Reply(ctx, found, err)
+	case lsp.MethodTextDocumentCodeAction:
+		var params lsp.CodeActionParams
+		unmarshalParams(r, &params)
+		actions, err := h.handleCodeAction(ctx, &params)
+		r.Reply(ctx, actions, err)
+	case lsp.MethodTextDocumentCodeLens:
+		var params lsp.CodeLensParams
+		unmarshalParams(r, &params)
+		lenses, err := h.handleCodeLens(ctx, &params)
+		r.Reply(ctx, lenses, err)
+	case lsp.MethodWorkspaceExecuteCommand:
+		// The commands of the code lenses only label them.
+		r.Reply(ctx, nil, nil)
+	case lsp.MethodTextDocumentPrepareRename:
+		var params lsp.TextDocumentPositionParams
+		unmarshalParams(r, &params)
+		found, err := h.handlePrepareRename(ctx, &params)
+		r.Reply(ctx, found, err)
+	case lsp.MethodTextDocumentRename:
+		var params lsp.RenameParams
+		unmarshalParams(r, &params)
+		edit, err := h.handleRename(ctx, &params)
+		r.Reply(ctx, edit, err)
 	case lsp.MethodTextDocumentHover:
 		var params lsp.TextDocumentPositionParams
-		json.Unmarshal(*r.Params, &params)
+		unmarshalParams(r, &params)
 		found, err := h.handleHoverDefinition(ctx, &params, h.Indexer)
 		r.Reply(ctx, found, err)
+	case lsp.MethodTextDocumentSignatureHelp:
+		var params lsp.TextDocumentPositionParams
+		unmarshalParams(r, &params)
+		help, err := h.handleSignatureHelp(ctx, &params)
+		r.Reply(ctx, help, err)
 	}
 
 	return true
 }
+
+// Decode the params of a request, which may have none.
+func unmarshalParams(r *jsonrpc2.Request, params interface{}) error {
+	if r.Params == nil {
+		return errors.New("Missing params")
+	}
+
+	return json.Unmarshal(*r.Params, params)
+}
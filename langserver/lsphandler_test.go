@@ -0,0 +1,217 @@
+package langserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nkoporec/drupal-lsp/langserver/parser"
+
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// Index a services.yml file and a module using its services, the module
+// is edited after it was indexed, without being saved.
+func serviceHandler(t *testing.T) (*LspHandler, string, string, func()) {
+	dir, err := ioutil.TempDir("", "rename")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	services := filepath.Join(dir, "foo.services.yml")
+	module := filepath.Join(dir, "foo.module")
+	ioutil.WriteFile(services, []byte("services:\n  foo:\n    class: Drupal\\foo\\Foo\n  bar:\n    arguments: ['@foo']\n"), 0644)
+	ioutil.WriteFile(module, []byte("<?php\n\\Drupal::service('foo');\n"), 0644)
+
+	service := &parser.Service{}
+	service.AddDefinitions([]string{services})
+	service.AddReferences([]string{module})
+
+	h := &LspHandler{
+		Indexer: &Indexer{Parsers: []parser.Parser{service}},
+		Buffer:  NewBuffer(),
+	}
+
+	h.Buffer.Documents[module] = Document{
+		URI:  module,
+		Text: "<?php\n\n// Moved.\n$foo = \\Drupal::service('foo');\n$this->foo->bar();\n",
+	}
+	h.Buffer.Documents[services] = Document{
+		URI:  services,
+		Text: "services:\n  foo:\n    class: Drupal\\foo\\Foo\n  bar:\n    arguments: ['@foo']\n",
+	}
+
+	return h, services, module, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestHandleRename(t *testing.T) {
+	h, services, module, cleanup := serviceHandler(t)
+	defer cleanup()
+
+	edit, err := h.handleRename(context.Background(), &lsp.RenameParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: uri.File(module)},
+		Position:     lsp.Position{Line: 3, Character: 26},
+		NewName:      "foo.renamed",
+	})
+	if err != nil || edit == nil {
+		t.Fatalf("handleRename() = %v, %v", edit, err)
+	}
+
+	expected := map[string][]lsp.Range{
+		services: {
+			{Start: lsp.Position{Line: 1, Character: 2}, End: lsp.Position{Line: 1, Character: 5}},
+			{Start: lsp.Position{Line: 4, Character: 18}, End: lsp.Position{Line: 4, Character: 21}},
+		},
+		module: {
+			{Start: lsp.Position{Line: 3, Character: 25}, End: lsp.Position{Line: 3, Character: 28}},
+		},
+	}
+
+	if len(edit.Changes) != len(expected) {
+		t.Errorf("Invalid changes %v", edit.Changes)
+	}
+
+	for path, ranges := range expected {
+		edits := edit.Changes[uri.File(path)]
+		if len(edits) != len(ranges) {
+			t.Errorf("Invalid edits of %s: %v", filepath.Base(path), edits)
+			continue
+		}
+
+		for i, item := range edits {
+			if item.Range != ranges[i] || item.NewText != "foo.renamed" {
+				t.Errorf("Invalid edit of %s: %v, want %v", filepath.Base(path), item, ranges[i])
+			}
+		}
+	}
+
+	// Names that are already used are rejected.
+	_, err = h.handleRename(context.Background(), &lsp.RenameParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: uri.File(module)},
+		Position:     lsp.Position{Line: 3, Character: 26},
+		NewName:      "bar",
+	})
+	if err == nil {
+		t.Errorf("Renaming to an existing service is allowed")
+	}
+
+	// A property named after a service isn't renamed.
+	edit, err = h.handleRename(context.Background(), &lsp.RenameParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: uri.File(module)},
+		Position:     lsp.Position{Line: 4, Character: 8},
+		NewName:      "foo.renamed",
+	})
+	if edit != nil || err != nil {
+		t.Errorf("handleRename($this->foo) = %v, %v", edit, err)
+	}
+}
+
+func TestHandlePrepareRename(t *testing.T) {
+	h, services, module, cleanup := serviceHandler(t)
+	defer cleanup()
+
+	tests := []struct {
+		path     string
+		position lsp.Position
+		expected *lsp.Range
+	}{
+		{module, lsp.Position{Line: 3, Character: 26}, &lsp.Range{Start: lsp.Position{Line: 3, Character: 25}, End: lsp.Position{Line: 3, Character: 28}}},
+		{services, lsp.Position{Line: 1, Character: 3}, &lsp.Range{Start: lsp.Position{Line: 1, Character: 2}, End: lsp.Position{Line: 1, Character: 5}}},
+		{services, lsp.Position{Line: 4, Character: 19}, &lsp.Range{Start: lsp.Position{Line: 4, Character: 18}, End: lsp.Position{Line: 4, Character: 21}}},
+		// Words that aren't a service reference.
+		{module, lsp.Position{Line: 3, Character: 2}, nil},
+		{module, lsp.Position{Line: 4, Character: 8}, nil},
+		{services, lsp.Position{Line: 2, Character: 6}, nil},
+	}
+
+	for _, test := range tests {
+		nameRange, err := h.handlePrepareRename(context.Background(), &lsp.TextDocumentPositionParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: uri.File(test.path)},
+			Position:     test.position,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (nameRange == nil) != (test.expected == nil) || (nameRange != nil && *nameRange != *test.expected) {
+			t.Errorf("handlePrepareRename(%s, %v) = %v, want %v", filepath.Base(test.path), test.position, nameRange, test.expected)
+		}
+	}
+}
+
+func TestHandleReferences(t *testing.T) {
+	h, services, module, cleanup := serviceHandler(t)
+	defer cleanup()
+
+	tests := []struct {
+		path     string
+		position lsp.Position
+		expected int
+	}{
+		// The declaration, its use as an argument and in the module.
+		{module, lsp.Position{Line: 3, Character: 26}, 3},
+		{services, lsp.Position{Line: 1, Character: 3}, 3},
+		{module, lsp.Position{Line: 4, Character: 8}, 0},
+		{module, lsp.Position{Line: 3, Character: 2}, 0},
+	}
+
+	for _, test := range tests {
+		locations, err := h.handleReferences(context.Background(), &lsp.ReferenceParams{
+			TextDocumentPositionParams: lsp.TextDocumentPositionParams{
+				TextDocument: lsp.TextDocumentIdentifier{URI: uri.File(test.path)},
+				Position:     test.position,
+			},
+			Context: lsp.ReferenceContext{IncludeDeclaration: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(locations) != test.expected {
+			t.Errorf("handleReferences(%s, %v) = %v, want %d locations", filepath.Base(test.path), test.position, locations, test.expected)
+		}
+	}
+}
+
+func TestDeliverInitializeErrors(t *testing.T) {
+	serverSide, clientSide := net.Pipe()
+	defer serverSide.Close()
+	defer clientSide.Close()
+
+	ctx := context.Background()
+
+	server := jsonrpc2.NewConn(jsonrpc2.NewStream(serverSide, serverSide))
+	server.AddHandler(NewLspHandler())
+	go server.Run(ctx)
+
+	client := jsonrpc2.NewConn(jsonrpc2.NewStream(clientSide, clientSide))
+	go client.Run(ctx)
+
+	// Bad clients get an error, the server keeps running.
+	tests := []struct {
+		method string
+		params interface{}
+		code   jsonrpc2.Code
+	}{
+		{lsp.MethodInitialize, map[string]interface{}{"rootUri": 1}, jsonrpc2.InvalidParams},
+		{lsp.MethodInitialize, map[string]interface{}{"rootUri": "file:///drupal-lsp/missing"}, jsonrpc2.InvalidParams},
+		{lsp.MethodTextDocumentHover, map[string]interface{}{}, jsonrpc2.ServerNotInitialized},
+	}
+
+	for _, test := range tests {
+		var result interface{}
+		err := client.Call(ctx, test.method, test.params, &result)
+
+		rpcErr, ok := err.(*jsonrpc2.Error)
+		if !ok || rpcErr.Code != test.code {
+			t.Errorf("Call(%s, %v) = %v, want code %d", test.method, test.params, err, test.code)
+		}
+	}
+}
@@ -0,0 +1,731 @@
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nkoporec/drupal-lsp/utils"
+
+	lsp "go.lsp.dev/protocol"
+	"gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	// Names of the config objects, from install files and schemas.
+	Definitions []ParserDefinition
+	// Schema types keyed by their name, eg. system.site or block.block.*.
+	Schemas map[string]*ConfigSchema
+	// The config sync directories of the sites.
+	syncDirectories []string
+}
+
+// ConfigSchema is a type of a config schema or an element of its mapping.
+type ConfigSchema struct {
+	Type     string
+	Label    string
+	Mapping  map[string]*ConfigSchema
+	Sequence *ConfigSchema
+	// The file a schema type is declared in.
+	File string
+}
+
+// Schema types that hold a single value, and the kind of that value.
+var configPrimitives = map[string]string{
+	"boolean":        "boolean",
+	"integer":        "integer",
+	"timestamp":      "integer",
+	"float":          "float",
+	"string":         "string",
+	"label":          "string",
+	"required_label": "string",
+	"plural_label":   "string",
+	"text":           "string",
+	"uri":            "string",
+	"email":          "string",
+	"path":           "string",
+	"machine_name":   "string",
+	"color_hex":      "string",
+	"date_format":    "string",
+	"langcode":       "string",
+	"uuid":           "string",
+}
+
+// Keys every config object may have, even if the schema of config_object
+// is not indexed.
+var configObjectKeys = []string{
+	"_core",
+	"langcode",
+	"uuid",
+	"dependencies",
+}
+
+// A config name being typed, eg. \Drupal::config('system.
+var configNameRegex = regexp.MustCompile(`(?:config|getEditable)\(\s*['"][\w.]*$`)
+
+// A config key being typed, with the config name of the chained call or
+// the variable the config is stored in.
+var configKeyRegex = regexp.MustCompile(`(?:(?:config|getEditable)\(\s*['"]([\w.]+)['"]\s*\)|\$(\w+))\s*->\s*(?:get|set|clear)\(\s*(['"])([\w.]*)$`)
+
+// $config = \Drupal::config('system.site');
+var configAssignmentRegex = regexp.MustCompile(`\$(\w+)\s*=\s*[^;]*?(?:config|getEditable)\(\s*['"]([\w.]+)['"]`)
+
+// The booleans of yaml 1.2, which Drupal uses. The others of yaml 1.1, eg.
+// yes or off, are strings.
+var configBooleans = []string{
+	"true",
+	"false",
+}
+
+func (c *Config) ParseFile(path string) interface{} {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	if !strings.HasSuffix(path, ".schema.yml") {
+		return file
+	}
+
+	schemas := map[string]interface{}{}
+	if err := yaml.Unmarshal(file, &schemas); err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return schemas
+}
+
+func (c *Config) AddDefinitions(items []string) {
+	if c.Schemas == nil {
+		c.Schemas = make(map[string]*ConfigSchema)
+	}
+
+	for _, file := range items {
+		item := c.ParseFile(file)
+		if item == nil {
+			continue
+		}
+
+		schemas, ok := item.(map[string]interface{})
+		if !ok {
+			// The name of an installed config object is its file name.
+			c.Definitions = append(c.Definitions, ParserDefinition{
+				Name: strings.TrimSuffix(filepath.Base(file), ".yml"),
+				Type: "install",
+				File: file,
+			})
+			continue
+		}
+
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		for name, value := range schemas {
+			schema := newConfigSchema(value)
+			if schema == nil {
+				continue
+			}
+			schema.File = file
+			c.Schemas[name] = schema
+
+			// Only config objects can be loaded by name.
+			if schema.Type != "config_object" || strings.Contains(name, "*") {
+				continue
+			}
+
+			def := ParserDefinition{
+				Name:        name,
+				Type:        "schema",
+				Description: schema.Label,
+				File:        file,
+			}
+
+			if position, ok := yamlKeyPosition(src, name, ""); ok {
+				def.Position = position
+			}
+
+			c.Definitions = append(c.Definitions, def)
+		}
+	}
+}
+
+func (c *Config) RemoveDefinitions(items []string) {
+	c.Definitions = removeFileDefinitions(c.Definitions, items)
+
+	if len(items) == 0 {
+		return
+	}
+
+	removed := make(map[string]bool, len(items))
+	for _, file := range items {
+		removed[file] = true
+	}
+
+	for name, schema := range c.Schemas {
+		if removed[schema.File] {
+			delete(c.Schemas, name)
+		}
+	}
+}
+
+func (c *Config) SetSyncDirectories(dirs []string) {
+	c.syncDirectories = dirs
+}
+
+func (c *Config) FileExtension() string {
+	return "/config/"
+}
+
+// Config schemas and the config installed by extensions.
+func (c *Config) MatchFile(path string) bool {
+	if filepath.Ext(path) != ".yml" {
+		return false
+	}
+
+	if strings.Contains(path, "/config/schema/") {
+		return strings.HasSuffix(path, ".schema.yml")
+	}
+
+	return strings.Contains(path, "/config/install/") || strings.Contains(path, "/config/optional/")
+}
+
+func (c *Config) Methods() []string {
+	return []string{
+		"config",
+		"getEditable",
+	}
+}
+
+func (c *Config) GetDefinitions() []ParserDefinition {
+	return c.Definitions
+}
+
+func (c *Config) CompletionItem(def ParserDefinition) (lsp.CompletionItem, error) {
+	detail := "Config object"
+	if def.Description != "" {
+		detail = def.Description
+	}
+
+	return lsp.CompletionItem{
+		Kind:   lsp.ValueCompletion,
+		Label:  def.Name,
+		Detail: detail,
+		Documentation: lsp.MarkupContent{
+			Kind:  lsp.PlainText,
+			Value: def.File,
+		},
+	}, nil
+}
+
+// Complete config names, eg. \Drupal::config('system.site'), and their
+// keys, eg. ->get('page.front').
+func (c *Config) DocumentCompletion(path string, text string, position lsp.Position) []lsp.CompletionItem {
+	result := []lsp.CompletionItem{}
+
+	if filepath.Ext(path) == ".yml" {
+		return result
+	}
+
+	line, ok := linePrefix(text, position)
+	if !ok {
+		return result
+	}
+
+	if configNameRegex.MatchString(line) {
+		seen := make(map[string]bool)
+		for _, def := range c.GetDefinitions() {
+			if seen[def.Name] {
+				continue
+			}
+			seen[def.Name] = true
+
+			completion, err := c.CompletionItem(def)
+			if err != nil {
+				continue
+			}
+
+			result = append(result, completion)
+		}
+
+		return result
+	}
+
+	match := configKeyRegex.FindStringSubmatchIndex(line)
+	if match == nil {
+		return result
+	}
+
+	name := ""
+	if match[2] != -1 {
+		name = line[match[2]:match[3]]
+	} else {
+		// The config is stored in a variable, use its last assignment.
+		lines := strings.Split(text, "\n")
+		before := strings.Join(append(lines[:int(position.Line)], line), "\n")
+		name = configVariableName(before, line[match[4]:match[5]])
+	}
+
+	schema := c.lookupSchema(name)
+	if schema == nil {
+		return result
+	}
+
+	// Replace everything typed after the quote, as clients split words
+	// on dots.
+	editRange := lsp.Range{
+		Start: lsp.Position{
+			Line:      position.Line,
+			Character: LineCharacter(line, match[8]),
+		},
+		End: position,
+	}
+
+	for _, key := range c.keys(schema, "") {
+		result = append(result, lsp.CompletionItem{
+			Kind:       lsp.PropertyCompletion,
+			Label:      key.path,
+			Detail:     key.schema.Type,
+			FilterText: key.path,
+			Documentation: lsp.MarkupContent{
+				Kind:  lsp.PlainText,
+				Value: key.schema.Label,
+			},
+			TextEdit: &lsp.TextEdit{
+				Range:   editRange,
+				NewText: key.path,
+			},
+		})
+	}
+
+	return result
+}
+
+func (c *Config) Diagnostics(text string, defs []ParserDefinition) []lsp.Diagnostic {
+	return []lsp.Diagnostic{}
+}
+
+// Validate config files against their schema.
+func (c *Config) FileDiagnostics(path string, text string) []lsp.Diagnostic {
+	result := []lsp.Diagnostic{}
+
+	if !c.isConfigFile(path) {
+		return result
+	}
+
+	schema := c.lookupSchema(strings.TrimSuffix(filepath.Base(path), ".yml"))
+	if schema == nil {
+		return result
+	}
+
+	data := &configYaml{}
+	if err := yaml.Unmarshal([]byte(text), data); err != nil {
+		return result
+	}
+
+	keyRanges := yamlKeyRanges(text)
+	for _, item := range c.validate(data.value, schema, "") {
+		result = append(result, lsp.Diagnostic{
+			Code:     6,
+			Message:  item.message,
+			Source:   "drupal-lsp",
+			Severity: lsp.SeverityError,
+			Range:    keyRanges[item.path],
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Range.Start.Line < result[j].Range.Start.Line
+	})
+
+	return result
+}
+
+// Check if a file is a config object installed by an extension or
+// exported by a site, eg. config/install/system.site.yml.
+func (c *Config) isConfigFile(path string) bool {
+	if filepath.Ext(path) != ".yml" || strings.HasSuffix(path, ".schema.yml") {
+		return false
+	}
+
+	if strings.Contains(path, "/config/install/") || strings.Contains(path, "/config/optional/") {
+		return true
+	}
+
+	for _, dir := range c.syncDirectories {
+		if filepath.Dir(path) == filepath.Clean(dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Config) GetGoToDefinition(params string) []ParserDefinition {
+	result := make([]ParserDefinition, 0, 200)
+
+	for _, def := range c.GetDefinitions() {
+		if def.Name == params {
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Find the schema of a config name. Names without a schema of their own
+// use a wildcard schema, eg. block.block.* for block.block.olivero_main.
+func (c *Config) lookupSchema(name string) *ConfigSchema {
+	if schema, ok := c.Schemas[name]; ok {
+		return schema
+	}
+
+	parts := strings.Split(name, ".")
+	for i := len(parts) - 1; i > 0; i-- {
+		wildcards := strings.Repeat(".*", len(parts)-i)
+		if schema, ok := c.Schemas[strings.Join(parts[:i], ".")+wildcards]; ok {
+			return schema
+		}
+
+		if schema, ok := c.Schemas[strings.Join(parts[:i], ".")+".*"]; ok {
+			return schema
+		}
+	}
+
+	return nil
+}
+
+// Resolve an element to the kind of its value, either a primitive like
+// string, mapping or sequence. The mappings of the types it extends are
+// merged. An empty kind means the type can't be resolved, eg. a dynamic
+// type like [%parent.plugin].
+func (c *Config) resolve(element *ConfigSchema) (string, map[string]*ConfigSchema, *ConfigSchema) {
+	mapping := make(map[string]*ConfigSchema)
+	var sequence *ConfigSchema
+
+	for depth := 0; element != nil && depth < 20; depth++ {
+		// The keys of the element override the ones it extends.
+		for key, value := range element.Mapping {
+			if _, ok := mapping[key]; !ok {
+				mapping[key] = value
+			}
+		}
+
+		if sequence == nil {
+			sequence = element.Sequence
+		}
+
+		name := element.Type
+		if _, ok := configPrimitives[name]; ok || name == "mapping" || name == "sequence" {
+			return name, mapping, sequence
+		}
+
+		if name == "" || strings.Contains(name, "[") {
+			break
+		}
+
+		element = c.lookupSchema(name)
+	}
+
+	// Types that extend an unknown type, eg. config_object without the
+	// core schemas, are still mappings.
+	if len(mapping) > 0 {
+		return "mapping", mapping, sequence
+	}
+
+	return "", mapping, sequence
+}
+
+type configKey struct {
+	path   string
+	schema *ConfigSchema
+}
+
+// Get the keys of a schema and of its nested mappings, eg. page.front.
+func (c *Config) keys(schema *ConfigSchema, prefix string) []configKey {
+	result := []configKey{}
+
+	kind, mapping, _ := c.resolve(schema)
+	if kind != "mapping" || len(prefix) > 200 {
+		return result
+	}
+
+	names := []string{}
+	for name := range mapping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		result = append(result, configKey{path, mapping[name]})
+		result = append(result, c.keys(mapping[name], path)...)
+	}
+
+	return result
+}
+
+type configError struct {
+	path    string
+	message string
+}
+
+// Check a config value against its schema.
+func (c *Config) validate(value interface{}, schema *ConfigSchema, path string) []configError {
+	result := []configError{}
+
+	if value == nil {
+		return result
+	}
+
+	kind, mapping, sequence := c.resolve(schema)
+	switch kind {
+	case "":
+		return result
+	case "mapping":
+		values, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return append(result, configError{path, fmt.Sprintf("Invalid value of config key '%s', expected a mapping", path)})
+		}
+
+		// Mappings without keys, eg. third party settings, accept any key.
+		if len(mapping) == 0 {
+			return result
+		}
+
+		for key, item := range values {
+			name := fmt.Sprint(key)
+			itemPath := name
+			if path != "" {
+				itemPath = path + "." + name
+			}
+
+			element, ok := mapping[name]
+			if !ok {
+				if path == "" && utils.InSlice(configObjectKeys, name) {
+					continue
+				}
+
+				result = append(result, configError{itemPath, fmt.Sprintf("Undefined config key '%s'", itemPath)})
+				continue
+			}
+
+			result = append(result, c.validate(item, element, itemPath)...)
+		}
+	case "sequence":
+		if sequence == nil {
+			return result
+		}
+
+		switch values := value.(type) {
+		case []interface{}:
+			for i, item := range values {
+				result = append(result, c.validate(item, sequence, joinConfigPath(path, strconv.Itoa(i)))...)
+			}
+		case map[interface{}]interface{}:
+			for key, item := range values {
+				result = append(result, c.validate(item, sequence, joinConfigPath(path, fmt.Sprint(key)))...)
+			}
+		default:
+			result = append(result, configError{path, fmt.Sprintf("Invalid value of config key '%s', expected a sequence", path)})
+		}
+	default:
+		valid := true
+		switch configPrimitives[kind] {
+		case "boolean":
+			_, valid = value.(bool)
+		case "integer":
+			_, valid = value.(int)
+		case "float":
+			_, isInt := value.(int)
+			_, isFloat := value.(float64)
+			valid = isInt || isFloat
+		case "string":
+			_, valid = value.(string)
+		}
+
+		if !valid {
+			result = append(result, configError{path, fmt.Sprintf("Invalid value of config key '%s', expected %s", path, kind)})
+		}
+	}
+
+	return result
+}
+
+// Convert a schema element decoded from yaml.
+func newConfigSchema(value interface{}) *ConfigSchema {
+	values, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil
+	}
+
+	schema := &ConfigSchema{}
+	schema.Type, _ = values["type"].(string)
+	schema.Label, _ = values["label"].(string)
+
+	if mapping, ok := values["mapping"].(map[interface{}]interface{}); ok {
+		schema.Mapping = make(map[string]*ConfigSchema)
+		for key, item := range mapping {
+			element := newConfigSchema(item)
+			if element == nil {
+				element = &ConfigSchema{}
+			}
+			schema.Mapping[fmt.Sprint(key)] = element
+		}
+	}
+
+	// Sequences are either an element or a list with one element.
+	switch sequence := values["sequence"].(type) {
+	case map[interface{}]interface{}:
+		schema.Sequence = newConfigSchema(sequence)
+	case []interface{}:
+		if len(sequence) > 0 {
+			schema.Sequence = newConfigSchema(sequence[0])
+		}
+	}
+
+	return schema
+}
+
+// Get the config name of the last assignment of a variable, eg.
+// system.site for $config = \Drupal::config('system.site').
+func configVariableName(text string, variable string) string {
+	result := ""
+	for _, match := range configAssignmentRegex.FindAllStringSubmatch(text, -1) {
+		if match[1] == variable {
+			result = match[2]
+		}
+	}
+
+	return result
+}
+
+// A config file decoded like Drupal does, with yaml 1.2.
+type configYaml struct {
+	value interface{}
+}
+
+func (c *configYaml) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value interface{}
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+
+	switch value.(type) {
+	case map[interface{}]interface{}:
+		items := map[interface{}]configYaml{}
+		if err := unmarshal(&items); err != nil {
+			return err
+		}
+
+		values := make(map[interface{}]interface{}, len(items))
+		for key, item := range items {
+			values[key] = item.value
+		}
+		value = values
+	case []interface{}:
+		items := []configYaml{}
+		if err := unmarshal(&items); err != nil {
+			return err
+		}
+
+		values := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			values = append(values, item.value)
+		}
+		value = values
+	case bool:
+		// The scalar as written, eg. no.
+		var text string
+		if err := unmarshal(&text); err == nil && !utils.InSlice(configBooleans, strings.ToLower(text)) {
+			value = text
+		}
+	}
+
+	c.value = value
+
+	return nil
+}
+
+func joinConfigPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// A yaml key, optionally quoted, eg. page: or 'front':
+var yamlKeyRegex = regexp.MustCompile(`^(['"]?)([^'":#][^'"]*?)(['"]?):(?:\s|$)`)
+
+// Find the range of every key in a yaml file, keyed by its path, eg.
+// page.front. Items of sequences are keyed by their index. Config files
+// are exported in block style, so the indentation is enough to nest them.
+func yamlKeyRanges(text string) map[string]lsp.Range {
+	result := make(map[string]lsp.Range)
+
+	type frame struct {
+		indent int
+		path   string
+		// Index of the next sequence item.
+		index int
+	}
+	stack := []*frame{{indent: -1}}
+
+	keyRange := func(line int, start int, length int) lsp.Range {
+		return lsp.Range{
+			Start: lsp.Position{Line: float64(line), Character: float64(start)},
+			End:   lsp.Position{Line: float64(line), Character: float64(start + length)},
+		}
+	}
+
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		content := strings.TrimLeft(line, " ")
+		if content == "" || strings.HasPrefix(content, "#") || content == "---" {
+			continue
+		}
+		indent := len(line) - len(content)
+
+		for len(stack) > 1 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1]
+
+		// A sequence item, which may start with a key.
+		if content == "-" || strings.HasPrefix(content, "- ") {
+			path := joinConfigPath(parent.path, strconv.Itoa(parent.index))
+			parent.index++
+
+			result[path] = keyRange(i, indent, 1)
+			stack = append(stack, &frame{indent: indent, path: path})
+
+			indent += 2
+			content = strings.TrimPrefix(strings.TrimPrefix(content, "-"), " ")
+			parent = stack[len(stack)-1]
+		}
+
+		match := yamlKeyRegex.FindStringSubmatchIndex(content)
+		if match == nil {
+			continue
+		}
+
+		path := joinConfigPath(parent.path, content[match[4]:match[5]])
+		result[path] = keyRange(i, indent+match[4], match[5]-match[4])
+		stack = append(stack, &frame{indent: indent, path: path})
+	}
+
+	return result
+}
@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+const configSchemaSource = `system.site:
+  type: config_object
+  label: 'Site information'
+  mapping:
+    name:
+      type: label
+    langcode:
+      type: string
+    page:
+      type: mapping
+      mapping:
+        front:
+          type: path
+    weight:
+      type: integer
+    enabled:
+      type: boolean
+`
+
+// Index a config schema and an installed config object.
+func testConfig(t *testing.T) (*Config, string, func()) {
+	root, paths, cleanup := writeFiles(t, [][2]string{
+		{"system/config/schema/system.schema.yml", configSchemaSource},
+		{"system/config/install/system.site.yml", "name: Drupal\n"},
+		{"foo/foo.info.yml", "name: Foo\n"},
+	})
+
+	config := &Config{}
+	config.SetSyncDirectories([]string{root + "/sync/"})
+
+	matched := []string{}
+	for _, path := range paths {
+		if config.MatchFile(path) {
+			matched = append(matched, path)
+		}
+	}
+	config.AddDefinitions(matched)
+
+	return config, root, cleanup
+}
+
+func TestConfigFileDiagnostics(t *testing.T) {
+	config, root, cleanup := testConfig(t)
+	defer cleanup()
+
+	tests := []struct {
+		path     string
+		text     string
+		expected []string
+	}{
+		{"/foo/config/install/system.site.yml", "name: Foo\npage:\n  front: /node\nweight: 1\nenabled: true\n", []string{}},
+		{"/foo/config/optional/system.site.yml", "name: Foo\nslogan: Bar\n", []string{"Undefined config key 'slogan'"}},
+		{"/foo/config/install/system.site.yml", "page:\n  front: [a]\nweight: heavy\n", []string{
+			"Invalid value of config key 'page.front', expected path",
+			"Invalid value of config key 'weight', expected integer",
+		}},
+		// Drupal reads yaml 1.2, where only true and false are booleans.
+		{"/foo/config/install/system.site.yml", "langcode: no\nname: yes\nenabled: on\n", []string{"Invalid value of config key 'enabled', expected boolean"}},
+		{root + "/sync/system.site.yml", "slogan: Bar\n", []string{"Undefined config key 'slogan'"}},
+		// Other yaml files in config directories aren't config objects.
+		{"/foo/config/system.site.yml", "slogan: Bar\n", []string{}},
+		{"/foo/tests/config/sync/system.site.yml", "slogan: Bar\n", []string{}},
+		{root + "/sync/language/fr/system.site.yml", "slogan: Bar\n", []string{}},
+		{"/foo/config/install/foo.settings.yml", "slogan: Bar\n", []string{}},
+	}
+
+	for _, test := range tests {
+		messages := []string{}
+		for _, diagnostic := range config.FileDiagnostics(test.path, test.text) {
+			messages = append(messages, diagnostic.Message)
+		}
+		sort.Strings(messages)
+
+		if strings.Join(messages, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("FileDiagnostics(%q, %q) = %v, want %v", test.path, test.text, messages, test.expected)
+		}
+	}
+}
+
+func TestConfigDocumentCompletion(t *testing.T) {
+	config, _, cleanup := testConfig(t)
+	defer cleanup()
+
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"\\Drupal::config('sys", "system.site"},
+		{"$this->config('system.site')->get('", "enabled,langcode,name,page,page.front,weight"},
+		{"$config = \\Drupal::config('system.site');\n$other = \\Drupal::config('foo.settings');\n$config->get('", "enabled,langcode,name,page,page.front,weight"},
+		// The last assignment of the variable is used.
+		{"$config = \\Drupal::config('system.site');\n$config = \\Drupal::config('foo.settings');\n$config->get('", ""},
+		{"$config = $other;\n$config->get('", ""},
+	}
+
+	for _, test := range tests {
+		text := "<?php\n" + test.text
+		lines := strings.Split(text, "\n")
+		position := lsp.Position{
+			Line:      float64(len(lines) - 1),
+			Character: float64(len(lines[len(lines)-1])),
+		}
+
+		labels := []string{}
+		for _, item := range config.DocumentCompletion("/foo/foo.module", text, position) {
+			labels = append(labels, item.Label)
+		}
+		sort.Strings(labels)
+
+		if strings.Join(labels, ",") != test.expected {
+			t.Errorf("DocumentCompletion(%q) = %v, want %s", test.text, labels, test.expected)
+		}
+	}
+}
+
+func TestConfigVariableName(t *testing.T) {
+	text := "$config = \\Drupal::config('system.site');\n$settings = \\Drupal::configFactory()->getEditable('foo.settings');\n"
+
+	tests := map[string]string{
+		"config":   "system.site",
+		"settings": "foo.settings",
+		"conf":     "",
+	}
+
+	for variable, expected := range tests {
+		if name := configVariableName(text, variable); name != expected {
+			t.Errorf("configVariableName(%q) = %q, want %q", variable, name, expected)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nkoporec/drupal-lsp/php"
+	"github.com/nkoporec/drupal-lsp/utils"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+type Entity struct {
+	Definitions []ParserDefinition
+	// Entity types keyed by their id.
+	Types map[string]EntityType
+}
+
+type EntityType struct {
+	Id    string
+	Label string
+	// Either content or config.
+	Kind  string
+	Class string
+	// Handler classes keyed by the handler, nested handlers are joined
+	// with a dot, eg. form.default.
+	Handlers         map[string]string
+	BaseTable        string
+	EntityKeys       map[string]string
+	BundleEntityType string
+}
+
+// Annotations that define an entity type and the kind they define.
+var entityAnnotations = map[string]string{
+	"ContentEntityType": "content",
+	"ConfigEntityType":  "config",
+}
+
+// The annotation or attribute of an entity type, eg. @ContentEntityType(
+// or #[ConfigEntityType(. Other files aren't parsed.
+var entityTypeRegex = regexp.MustCompile(`(?:@|#\[\s*)\\?(?:[\w\\]+\\)?(?:Content|Config)EntityType\s*\(`)
+
+// Calls on the entity type manager that take an entity type id.
+var entityCalls = []string{
+	"getStorage",
+	"getAccessControlHandler",
+	"getViewBuilder",
+	"getListBuilder",
+}
+
+func (e *Entity) ParseFile(path string) interface{} {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	if !entityTypeRegex.Match(file) {
+		return nil
+	}
+
+	parsedDoc, err := php.Parse(file)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return parsedDoc
+}
+
+func (e *Entity) AddDefinitions(items []string) {
+	if e.Types == nil {
+		e.Types = make(map[string]EntityType)
+	}
+
+	for _, file := range items {
+		if filepath.Ext(file) != ".php" {
+			continue
+		}
+
+		item := e.ParseFile(file)
+		if item == nil {
+			continue
+		}
+
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		parsedDoc := item.(*php.ParsedDoc)
+		for _, class := range parsedDoc.Classes {
+			for _, annotation := range class.Annotations {
+				kind, ok := entityAnnotations[annotation.Name]
+				if !ok || annotation.String("id") == "" {
+					continue
+				}
+
+				entityType := EntityType{
+					Id:               annotation.String("id"),
+					Label:            annotation.String("label"),
+					Kind:             kind,
+					Class:            class.Name,
+					Handlers:         flattenAnnotation("", annotation.Map("handlers")),
+					BaseTable:        annotation.String("base_table"),
+					EntityKeys:       flattenAnnotation("", annotation.Map("entity_keys")),
+					BundleEntityType: annotation.String("bundle_entity_type"),
+				}
+				e.Types[entityType.Id] = entityType
+
+				e.Definitions = append(e.Definitions, ParserDefinition{
+					Name:        entityType.Id,
+					Class:       class.Name,
+					Type:        kind,
+					Description: entityDescription(entityType),
+					File:        file,
+					Position:    stringRange(src, annotation.Positions["id"]).Start,
+				})
+			}
+		}
+	}
+}
+
+func (e *Entity) RemoveDefinitions(items []string) {
+	e.Definitions = removeFileDefinitions(e.Definitions, items)
+
+	if len(items) == 0 {
+		return
+	}
+
+	// Keep the types that are still defined.
+	types := make(map[string]EntityType)
+	for _, def := range e.Definitions {
+		if entityType, ok := e.Types[def.Name]; ok {
+			types[def.Name] = entityType
+		}
+	}
+	e.Types = types
+}
+
+func (e *Entity) FileExtension() string {
+	return ".php"
+}
+
+// Entity types are defined in any php file, eg. the ones of core in
+// core/lib/Drupal/Core/Entity/Entity.
+func (e *Entity) MatchFile(path string) bool {
+	return filepath.Ext(path) == ".php"
+}
+
+func (e *Entity) Methods() []string {
+	return entityCalls
+}
+
+func (e *Entity) GetDefinitions() []ParserDefinition {
+	return e.Definitions
+}
+
+func (e *Entity) CompletionItem(def ParserDefinition) (lsp.CompletionItem, error) {
+	return lsp.CompletionItem{
+		Kind:   lsp.ValueCompletion,
+		Label:  def.Name,
+		Detail: fmt.Sprintf("%s entity type", def.Type),
+		Documentation: lsp.MarkupContent{
+			Kind:  lsp.PlainText,
+			Value: def.Description,
+		},
+	}, nil
+}
+
+func (e *Entity) Diagnostics(text string, defs []ParserDefinition) []lsp.Diagnostic {
+	result := []lsp.Diagnostic{}
+	src := []byte(text)
+
+	// Without any entity type the index is incomplete.
+	if len(defs) == 0 {
+		return result
+	}
+
+	defsNames := []string{}
+	for _, def := range defs {
+		defsNames = append(defsNames, def.Name)
+	}
+
+	parsedDoc, err := php.Parse(src)
+	if err != nil {
+		log.Println(err)
+		return result
+	}
+
+	for _, call := range parsedDoc.MethodCalls {
+		if !utils.InSlice(entityCalls, call.Method.Name) || len(call.Args) == 0 || call.Args[0].Name == "" {
+			continue
+		}
+
+		if !isEntityTypeManager(call) {
+			continue
+		}
+
+		argName := unquote(call.Args[0].Name)
+		if !utils.InSlice(defsNames, argName) {
+			result = append(result, lsp.Diagnostic{
+				Code:     5,
+				Message:  fmt.Sprintf("Undefined entity type '%s'", argName),
+				Source:   "drupal-lsp",
+				Severity: lsp.SeverityError,
+				Range:    NodeRange(src, call.Args[0].Position),
+			})
+		}
+	}
+
+	return result
+}
+
+func (e *Entity) GetGoToDefinition(params string) []ParserDefinition {
+	result := make([]ParserDefinition, 0, 200)
+
+	for _, def := range e.GetDefinitions() {
+		if def.Name == params {
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Check if a method is called on the entity type manager, eg.
+// \Drupal::entityTypeManager(), $this->entityTypeManager or
+// \Drupal::service('entity_type.manager').
+func isEntityTypeManager(call *php.PhpMethodCall) bool {
+	names := []string{call.Var, call.Property}
+
+	if call.StaticCall != nil && call.StaticCall.Method != nil {
+		names = append(names, call.StaticCall.Method.Name)
+
+		if call.StaticCall.Method.Name == "service" && len(call.StaticCall.Args) == 1 {
+			names = append(names, unquote(call.StaticCall.Args[0].Name))
+		}
+	}
+
+	if call.MethodCall != nil {
+		names = append(names, call.MethodCall.Method.Name)
+	}
+
+	for _, name := range names {
+		name = strings.ToLower(strings.NewReplacer("_", "", ".", "").Replace(name))
+		if name == "entitytypemanager" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Flatten the nested values of an annotation, eg. handlers, into keys
+// joined by a dot.
+func flattenAnnotation(prefix string, values map[string]interface{}) map[string]string {
+	result := make(map[string]string)
+
+	for key, value := range values {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch value := value.(type) {
+		case string:
+			result[key] = value
+		case map[string]interface{}:
+			for nestedKey, nestedValue := range flattenAnnotation(key, value) {
+				result[nestedKey] = nestedValue
+			}
+		}
+	}
+
+	return result
+}
+
+// Summary of the entity type, the first line is its label.
+func entityDescription(entityType EntityType) string {
+	lines := []string{}
+
+	if entityType.Label != "" {
+		lines = append(lines, entityType.Label)
+	}
+	lines = append(lines, entityType.Class)
+
+	if entityType.BaseTable != "" {
+		lines = append(lines, fmt.Sprintf("base_table: %s", entityType.BaseTable))
+	}
+
+	if entityType.BundleEntityType != "" {
+		lines = append(lines, fmt.Sprintf("bundle_entity_type: %s", entityType.BundleEntityType))
+	}
+
+	lines = append(lines, describeValues("entity_keys", entityType.EntityKeys)...)
+	lines = append(lines, describeValues("handlers", entityType.Handlers)...)
+
+	return strings.Join(lines, "\n")
+}
+
+// List the values of a map under its name, sorted by key.
+func describeValues(name string, values map[string]string) []string {
+	items := []string{}
+	for key, value := range values {
+		items = append(items, fmt.Sprintf("  %s: %s", key, value))
+	}
+	sort.Strings(items)
+
+	if len(items) == 0 {
+		return items
+	}
+
+	return append([]string{name + ":"}, items...)
+}
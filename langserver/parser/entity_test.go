@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+// A config entity type of core, outside of a src/Entity directory.
+const entityViewDisplay = `<?php
+
+namespace Drupal\Core\Entity\Entity;
+
+use Drupal\Core\Entity\EntityDisplayBase;
+
+/**
+ * Configuration entity.
+ *
+ * @ConfigEntityType(
+ *   id = "entity_view_display",
+ *   label = @Translation("Entity view display"),
+ *   entity_keys = {
+ *     "id" = "id",
+ *     "status" = "status"
+ *   },
+ *   handlers = {
+ *     "access" = "\Drupal\entity\Entity\Access\EntityViewDisplayAccessControlHandler",
+ *   },
+ * )
+ */
+class EntityViewDisplay extends EntityDisplayBase {
+}
+`
+
+// A content entity type declared with an attribute.
+const entityFoo = `<?php
+
+namespace Drupal\foo\Entity;
+
+use Drupal\Core\Entity\Attribute\ContentEntityType;
+use Drupal\Core\Entity\ContentEntityBase;
+use Drupal\Core\StringTranslation\TranslatableMarkup;
+
+#[ContentEntityType(
+  id: 'foo',
+  label: new TranslatableMarkup('Foo'),
+  base_table: 'foo',
+  entity_keys: [
+    'id' => 'id',
+  ],
+)]
+class Foo extends ContentEntityBase {
+}
+`
+
+// Only mentions an entity type annotation.
+const entityNotEntityType = `<?php
+
+namespace Drupal\foo;
+
+use Drupal\Core\Entity\Annotation\ContentEntityType;
+
+class Bar {
+}
+`
+
+func entityFixture(t *testing.T) (*Entity, []string, func()) {
+	_, paths, cleanup := writeFiles(t, [][2]string{
+		{"core/lib/Drupal/Core/Entity/Entity/EntityViewDisplay.php", entityViewDisplay},
+		{"modules/foo/src/Entity/Foo.php", entityFoo},
+		{"modules/foo/src/Bar.php", entityNotEntityType},
+		{"modules/foo/foo.services.yml", "services:\n"},
+	})
+
+	entity := &Entity{}
+	items := []string{}
+	for _, path := range paths {
+		if entity.MatchFile(path) {
+			items = append(items, path)
+		}
+	}
+	entity.AddDefinitions(items)
+
+	return entity, paths, cleanup
+}
+
+func TestEntityDefinitions(t *testing.T) {
+	entity, paths, cleanup := entityFixture(t)
+	defer cleanup()
+
+	if entity.MatchFile(paths[3]) {
+		t.Errorf("MatchFile(%s) = true", paths[3])
+	}
+
+	names := []string{}
+	for _, def := range entity.GetDefinitions() {
+		names = append(names, def.Type+":"+def.Name)
+	}
+
+	if strings.Join(names, " ") != "config:entity_view_display content:foo" {
+		t.Errorf("GetDefinitions() = %v", names)
+	}
+
+	if entityType := entity.Types["entity_view_display"]; entityType.Class != "Drupal\\Core\\Entity\\Entity\\EntityViewDisplay" || entityType.EntityKeys["status"] != "status" {
+		t.Errorf("Types[entity_view_display] = %+v", entityType)
+	}
+
+	if entityType := entity.Types["foo"]; entityType.BaseTable != "foo" || entityType.Label != "Foo" {
+		t.Errorf("Types[foo] = %+v", entityType)
+	}
+
+	if def := entity.GetDefinitions()[0]; def.File != paths[0] || def.Position.Line != 10 {
+		t.Errorf("GetDefinitions()[0] = %+v", def)
+	}
+
+	if entity.ParseFile(paths[2]) != nil {
+		t.Errorf("ParseFile(%s) parsed a file without entity type", paths[2])
+	}
+
+	entity.RemoveDefinitions(paths[:1])
+	if _, ok := entity.Types["entity_view_display"]; ok || len(entity.GetDefinitions()) != 1 {
+		t.Errorf("RemoveDefinitions() left %+v", entity.Types)
+	}
+}
+
+func TestEntityDiagnostics(t *testing.T) {
+	entity, _, cleanup := entityFixture(t)
+	defer cleanup()
+
+	text := "<?php\n$storage = \\Drupal::entityTypeManager()->getStorage('entity_view_display');\n$this->entityTypeManager->getStorage('foo');\n$this->entityTypeManager->getViewBuilder('missing');\n"
+
+	diagnostics := entity.Diagnostics(text, entity.GetDefinitions())
+	if len(diagnostics) != 1 || diagnostics[0].Code != 5 || !strings.Contains(diagnostics[0].Message, "missing") {
+		t.Fatalf("Diagnostics() = %+v", diagnostics)
+	}
+
+	if start := diagnostics[0].Range.Start; start != (lsp.Position{Line: 3, Character: 41}) {
+		t.Errorf("Diagnostics() at %+v", start)
+	}
+}
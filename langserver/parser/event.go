@@ -0,0 +1,715 @@
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nkoporec/drupal-lsp/php"
+	"github.com/nkoporec/drupal-lsp/utils"
+
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+type Event struct {
+	// Event names declared by the constants of event classes, eg.
+	// kernel.request by KernelEvents::REQUEST.
+	Definitions []ParserDefinition
+	// Event names keyed by their fully qualified constant, eg.
+	// Symfony\Component\HttpKernel\KernelEvents::REQUEST.
+	Constants map[string]EventConstant
+	// Methods listening to an event, from getSubscribedEvents().
+	Subscribers []EventSubscriber
+
+	classes func() map[string]PhpClass
+}
+
+type EventConstant struct {
+	Event string
+	File  string
+}
+
+type EventSubscriber struct {
+	// Either the event name or the fully qualified constant holding it,
+	// which is resolved once every event class is indexed.
+	Event    string
+	Constant string
+	// The constant as written, eg. KernelEvents::REQUEST.
+	Key      string
+	Class    string
+	Method   string
+	Priority int
+	File     string
+	Position lsp.Position
+}
+
+// EventCodeLensCommand is the command of the code lenses of subscriber
+// methods. The lenses only show the event, so executing it does nothing.
+const EventCodeLensCommand = "drupal-lsp.event"
+
+// The interface of the classes listening to events.
+const eventSubscriberInterface = "Symfony\\Component\\EventDispatcher\\EventSubscriberInterface"
+
+// The constants of an event class, eg. const REQUEST = 'kernel.request';
+var eventConstantRegex = regexp.MustCompile(`const\s+(\w+)\s*=\s*['"]([^'"]+)['"]\s*;`)
+
+// The events of getSubscribedEvents(), either returned or assigned, eg.
+// return [...] or $events[KernelEvents::REQUEST][] = ['onRequest', 100];
+var eventSubscriptionRegex = regexp.MustCompile(`return\s*(?:\[|array\s*\()|\$\w+\[\s*('[^']*'|"[^"]*"|[\w\\]+::\w+)\s*\]\s*(?:\[\s*\]\s*)?=\s*(?:['"](\w+)['"]|\[|array\s*\()`)
+
+// An event being typed as a key of getSubscribedEvents(), eg. $events['kernel.
+var eventKeyCompletionRegex = regexp.MustCompile(`(?:\$\w+\[|^\s*(?:return\s*\[)?)\s*['"]([^'"]*)$`)
+
+// An event being dispatched, eg. ->dispatch($event, 'kernel. or the older
+// ->dispatch('kernel.
+var eventDispatchCompletionRegex = regexp.MustCompile(`->dispatch\(\s*(?:\$[\w>-]+\s*,\s*)?['"]([^'"]*)$`)
+
+// A class constant, eg. KernelEvents::REQUEST.
+var eventClassConstantRegex = regexp.MustCompile(`[\w\\]+::\w+`)
+
+func (e *Event) ParseFile(path string) interface{} {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	// Only event classes and subscribers are worth parsing.
+	if !isEventClassFile(path) && !strings.Contains(string(file), "getSubscribedEvents") {
+		return nil
+	}
+
+	parsedDoc, err := php.Parse(file)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return parsedDoc
+}
+
+func (e *Event) AddDefinitions(items []string) {
+	if e.Constants == nil {
+		e.Constants = make(map[string]EventConstant)
+	}
+
+	classes := classIndex(e.classes)
+	for _, file := range items {
+		item := e.ParseFile(file)
+		if item == nil {
+			continue
+		}
+
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		parsedDoc := item.(*php.ParsedDoc)
+		if isEventClassFile(file) {
+			e.addConstants(file, src, parsedDoc)
+		}
+
+		e.Subscribers = append(e.Subscribers, eventSubscribers(file, src, parsedDoc, classes)...)
+	}
+}
+
+// The subscribers are checked against the php classes, to only index the
+// ones implementing EventSubscriberInterface.
+func (e *Event) SetClassIndex(classes func() map[string]PhpClass) {
+	e.classes = classes
+}
+
+// Index the event names declared by the constants of an event class.
+func (e *Event) addConstants(file string, src []byte, parsedDoc *php.ParsedDoc) {
+	class := ""
+	for _, declaration := range parsedDoc.Classes {
+		if strings.HasSuffix(declaration.Name, "Events") {
+			class = declaration.Name
+			break
+		}
+	}
+
+	if class == "" {
+		return
+	}
+
+	short := class[strings.LastIndex(class, "\\")+1:]
+	for _, match := range eventConstantRegex.FindAllSubmatchIndex(src, -1) {
+		constant := string(src[match[2]:match[3]])
+		name := string(src[match[4]:match[5]])
+
+		description := short + "::" + constant
+		if docblock := eventConstantDocblock(src, match[0]); docblock != "" {
+			description += "\n\n" + docblock
+		}
+
+		line, column := php.LineColumn(src, match[4])
+		e.Definitions = append(e.Definitions, ParserDefinition{
+			Name:        name,
+			Type:        "event",
+			Description: description,
+			File:        file,
+			Position: lsp.Position{
+				Line:      float64(line),
+				Character: float64(column),
+			},
+		})
+
+		e.Constants[class+"::"+constant] = EventConstant{
+			Event: name,
+			File:  file,
+		}
+	}
+}
+
+func (e *Event) RemoveDefinitions(items []string) {
+	e.Definitions = removeFileDefinitions(e.Definitions, items)
+
+	if len(items) == 0 {
+		return
+	}
+
+	removed := make(map[string]bool, len(items))
+	for _, file := range items {
+		removed[file] = true
+	}
+
+	for name, constant := range e.Constants {
+		if removed[constant.File] {
+			delete(e.Constants, name)
+		}
+	}
+
+	subscribers := []EventSubscriber{}
+	for _, subscriber := range e.Subscribers {
+		if !removed[subscriber.File] {
+			subscribers = append(subscribers, subscriber)
+		}
+	}
+	e.Subscribers = subscribers
+}
+
+func (e *Event) FileExtension() string {
+	return ".php"
+}
+
+// Event classes and subscribers are classes, so only php files are
+// indexed.
+func (e *Event) MatchFile(path string) bool {
+	return filepath.Ext(path) == ".php"
+}
+
+func (e *Event) Methods() []string {
+	return []string{}
+}
+
+func (e *Event) GetDefinitions() []ParserDefinition {
+	return e.Definitions
+}
+
+func (e *Event) CompletionItem(def ParserDefinition) (lsp.CompletionItem, error) {
+	return lsp.CompletionItem{
+		Kind:   lsp.EventCompletion,
+		Label:  def.Name,
+		Detail: "Event",
+		Documentation: lsp.MarkupContent{
+			Kind:  lsp.PlainText,
+			Value: def.Description,
+		},
+	}, nil
+}
+
+// Complete event names in getSubscribedEvents() and when dispatching an
+// event.
+func (e *Event) DocumentCompletion(path string, text string, position lsp.Position) []lsp.CompletionItem {
+	result := []lsp.CompletionItem{}
+
+	if filepath.Ext(path) != ".php" {
+		return result
+	}
+
+	line, ok := linePrefix(text, position)
+	if !ok {
+		return result
+	}
+
+	match := eventDispatchCompletionRegex.FindStringSubmatchIndex(line)
+	if match == nil && isSubscribedEventsBody(text, position) {
+		match = eventKeyCompletionRegex.FindStringSubmatchIndex(line)
+	}
+
+	if match == nil {
+		return result
+	}
+
+	editRange := lsp.Range{
+		Start: lsp.Position{
+			Line:      position.Line,
+			Character: LineCharacter(line, match[2]),
+		},
+		End: position,
+	}
+
+	for _, def := range e.events() {
+		item, _ := e.CompletionItem(def)
+		item.FilterText = def.Name
+		item.TextEdit = &lsp.TextEdit{
+			Range:   editRange,
+			NewText: def.Name,
+		}
+
+		result = append(result, item)
+	}
+
+	return result
+}
+
+func (e *Event) Diagnostics(text string, defs []ParserDefinition) []lsp.Diagnostic {
+	return []lsp.Diagnostic{}
+}
+
+// Resolve an event name or constant to the constant declaring it.
+func (e *Event) DocumentDefinition(path string, text string, position lsp.Position) []ParserDefinition {
+	name, ok := e.eventAt(path, text, position)
+	if !ok {
+		return []ParserDefinition{}
+	}
+
+	return e.GetGoToDefinition(name)
+}
+
+// Find the methods listening to the event under the cursor.
+func (e *Event) DocumentReferences(path string, text string, position lsp.Position, includeDeclaration bool) []lsp.Location {
+	result := []lsp.Location{}
+
+	name, ok := e.eventAt(path, text, position)
+	if !ok {
+		return result
+	}
+
+	if includeDeclaration {
+		for _, def := range e.GetGoToDefinition(name) {
+			result = append(result, lsp.Location{
+				URI:   uri.File(def.File),
+				Range: nameRef{name: def.Name, line: int(def.Position.Line), start: int(def.Position.Character)}.Range(),
+			})
+		}
+	}
+
+	for _, subscriber := range e.Subscribers {
+		if e.subscriberEvent(subscriber) != name {
+			continue
+		}
+
+		result = append(result, lsp.Location{
+			URI:   uri.File(subscriber.File),
+			Range: nameRef{name: subscriber.Method, line: int(subscriber.Position.Line), start: int(subscriber.Position.Character)}.Range(),
+		})
+	}
+
+	return result
+}
+
+// Show the event and priority above each method of a subscriber.
+func (e *Event) CodeLens(path string, text string) []lsp.CodeLens {
+	result := []lsp.CodeLens{}
+
+	if filepath.Ext(path) != ".php" || !strings.Contains(text, "getSubscribedEvents") {
+		return result
+	}
+
+	src := []byte(text)
+	parsedDoc, err := php.Parse(src)
+	if err != nil {
+		return result
+	}
+
+	for _, subscriber := range eventSubscribers(path, src, parsedDoc, classIndex(e.classes)) {
+		name := e.subscriberEvent(subscriber)
+		if name == "" {
+			name = subscriber.Key
+		}
+
+		result = append(result, lsp.CodeLens{
+			Range: nameRef{name: subscriber.Method, line: int(subscriber.Position.Line), start: int(subscriber.Position.Character)}.Range(),
+			Command: &lsp.Command{
+				Title:     fmt.Sprintf("%s, priority %d", name, subscriber.Priority),
+				Command:   EventCodeLensCommand,
+				Arguments: []interface{}{name},
+			},
+		})
+	}
+
+	return result
+}
+
+func (e *Event) GetGoToDefinition(params string) []ParserDefinition {
+	result := make([]ParserDefinition, 0, 200)
+
+	for _, def := range e.GetDefinitions() {
+		if def.Name == params {
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Get the event name of a subscriber, or an empty string if its constant
+// isn't indexed.
+func (e *Event) subscriberEvent(subscriber EventSubscriber) string {
+	if subscriber.Constant == "" {
+		return subscriber.Event
+	}
+
+	return e.Constants[subscriber.Constant].Event
+}
+
+// Every known event, the ones declared by constants and the ones only
+// subscribed to by name.
+func (e *Event) events() []ParserDefinition {
+	result := []ParserDefinition{}
+
+	seen := map[string]bool{}
+	for _, def := range e.GetDefinitions() {
+		if !seen[def.Name] {
+			seen[def.Name] = true
+			result = append(result, def)
+		}
+	}
+
+	for _, subscriber := range e.Subscribers {
+		if subscriber.Constant != "" || seen[subscriber.Event] {
+			continue
+		}
+
+		seen[subscriber.Event] = true
+		result = append(result, ParserDefinition{
+			Name:        subscriber.Event,
+			Type:        "event",
+			Description: subscriber.Class,
+		})
+	}
+
+	return result
+}
+
+// Get the event under the cursor, either a known event name or a constant
+// holding one, eg. KernelEvents::REQUEST.
+func (e *Event) eventAt(path string, text string, position lsp.Position) (string, bool) {
+	if filepath.Ext(path) != ".php" {
+		return "", false
+	}
+
+	lines := strings.Split(text, "\n")
+	if int(position.Line) >= len(lines) {
+		return "", false
+	}
+
+	line := lines[int(position.Line)]
+	if position.Character > LineCharacter(line, len(line)) {
+		return "", false
+	}
+	character := LineOffset(line, position.Character)
+
+	// The constant being declared, eg. const REQUEST = 'kernel.request';
+	if match := eventConstantRegex.FindStringSubmatchIndex(line); match != nil && character >= match[0] && character <= match[1] && isEventClassFile(path) {
+		return line[match[4]:match[5]], true
+	}
+
+	for _, match := range eventClassConstantRegex.FindAllStringIndex(line, -1) {
+		if character < match[0] || character > match[1] {
+			continue
+		}
+
+		// The imports and classes are enough to resolve the constant.
+		src := []byte(text)
+		parsedDoc := php.ScanDocument(src)
+
+		offset := len(strings.Join(lines[:int(position.Line)], "\n")) + match[0]
+		class, _, _ := eventMethodClass(parsedDoc, offset, len(src))
+
+		constant := eventConstantName(parsedDoc, line[match[0]:match[1]], class)
+		if constant, ok := e.Constants[constant]; ok {
+			return constant.Event, true
+		}
+
+		return "", false
+	}
+
+	if name, _, ok := quotedStringAt(line, character); ok {
+		for _, def := range e.events() {
+			if def.Name == name {
+				return name, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Get the subscribers declared by the getSubscribedEvents() methods of the
+// event subscriber classes of a file.
+func eventSubscribers(file string, src []byte, parsedDoc *php.ParsedDoc, classes map[string]PhpClass) []EventSubscriber {
+	result := []EventSubscriber{}
+
+	for _, method := range parsedDoc.ClassMethods {
+		if method.Name != "getSubscribedEvents" || method.Body == nil {
+			continue
+		}
+
+		class, start, end := eventMethodClass(parsedDoc, method.Position.StartPos, len(src))
+		if !isEventSubscriberClass(parsedDoc, class, classes) {
+			continue
+		}
+		for _, subscription := range eventSubscriptions(src, method.Body.StartPos, method.Body.EndPos) {
+			subscriber := EventSubscriber{
+				Key:      subscription.key,
+				Class:    class,
+				Method:   subscription.method,
+				Priority: subscription.priority,
+				File:     file,
+			}
+
+			if strings.Contains(subscription.key, "::") {
+				subscriber.Constant = eventConstantName(parsedDoc, subscription.key, class)
+			} else {
+				subscriber.Event = subscription.key
+			}
+
+			// Point to the listening method, or getSubscribedEvents() if
+			// it's inherited.
+			position := method.Position
+			for _, listener := range parsedDoc.ClassMethods {
+				if listener.Name == subscription.method && listener.Position.StartPos >= start && listener.Position.StartPos < end {
+					position = listener.Position
+					break
+				}
+			}
+
+			line, column := php.LineColumn(src, position.StartPos)
+			subscriber.Position = lsp.Position{
+				Line:      float64(line),
+				Character: float64(column),
+			}
+
+			result = append(result, subscriber)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Position.Line < result[j].Position.Line
+	})
+
+	return result
+}
+
+type eventSubscription struct {
+	// The event name or the constant as written.
+	key      string
+	method   string
+	priority int
+}
+
+// Parse the events of a getSubscribedEvents() body.
+func eventSubscriptions(src []byte, start int, end int) []eventSubscription {
+	result := []eventSubscription{}
+
+	body := src[start:end]
+	for _, match := range eventSubscriptionRegex.FindAllSubmatchIndex(body, -1) {
+		// $events[KernelEvents::REQUEST][] = 'onRequest';
+		if match[2] != -1 {
+			key := unquote(string(body[match[2]:match[3]]))
+
+			if match[4] != -1 {
+				result = append(result, eventSubscription{
+					key:    key,
+					method: string(body[match[4]:match[5]]),
+				})
+				continue
+			}
+
+			value, _ := php.ParseArray(src, start+eventArrayStart(body, match[0], match[1]))
+			for _, listener := range eventListeners(value) {
+				listener.key = key
+				result = append(result, listener)
+			}
+			continue
+		}
+
+		// return [KernelEvents::REQUEST => ['onRequest', 100]];
+		values, _ := php.ParseArray(src, start+eventArrayStart(body, match[0], match[1]))
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			for _, listener := range eventListeners(values[key]) {
+				listener.key = key
+				result = append(result, listener)
+			}
+		}
+	}
+
+	return result
+}
+
+// Find where the array of a match starts, eg. the "[" or "array".
+func eventArrayStart(body []byte, start int, end int) int {
+	if body[end-1] == '[' {
+		return end - 1
+	}
+
+	return start + strings.LastIndex(string(body[start:end]), "array")
+}
+
+// Get the listeners of an event, which are either a method, a method and
+// its priority, or a list of those.
+func eventListeners(value interface{}) []eventSubscription {
+	result := []eventSubscription{}
+
+	switch value := value.(type) {
+	case string:
+		if value != "" {
+			result = append(result, eventSubscription{method: value})
+		}
+	case map[string]interface{}:
+		if method, ok := value["0"].(string); ok {
+			priority, _ := value["1"].(string)
+			number, _ := strconv.Atoi(priority)
+
+			return append(result, eventSubscription{
+				method:   method,
+				priority: number,
+			})
+		}
+
+		for i := 0; ; i++ {
+			item, ok := value[strconv.Itoa(i)]
+			if !ok {
+				break
+			}
+
+			result = append(result, eventListeners(item)...)
+		}
+	}
+
+	return result
+}
+
+// Get the class declaring the method at the offset, and the offsets
+// between which its methods are declared.
+func eventMethodClass(parsedDoc *php.ParsedDoc, offset int, size int) (string, int, int) {
+	class, start, end := "", 0, size
+
+	for _, declaration := range parsedDoc.Classes {
+		if declaration.Position == nil {
+			continue
+		}
+
+		if declaration.Position.StartPos <= offset && declaration.Position.StartPos >= start {
+			class, start = declaration.Name, declaration.Position.StartPos
+		}
+	}
+
+	for _, declaration := range parsedDoc.Classes {
+		if declaration.Position != nil && declaration.Position.StartPos > start && declaration.Position.StartPos < end {
+			end = declaration.Position.StartPos
+		}
+	}
+
+	return class, start, end
+}
+
+// Resolve a class constant as written in a file to its fully qualified
+// name, eg. KernelEvents::REQUEST.
+func eventConstantName(parsedDoc *php.ParsedDoc, constant string, class string) string {
+	parts := strings.SplitN(constant, "::", 2)
+
+	name := parts[0]
+	switch strings.ToLower(name) {
+	case "self", "static":
+		name = class
+	default:
+		name = parsedDoc.ResolveName(name)
+	}
+
+	return strings.TrimPrefix(name, "\\") + "::" + parts[1]
+}
+
+// Get the text of the docblock right before a constant.
+func eventConstantDocblock(src []byte, offset int) string {
+	before := strings.TrimRight(string(src[:offset]), " \t\r\n")
+	for _, modifier := range []string{"public", "protected", "private", "final"} {
+		before = strings.TrimRight(strings.TrimSuffix(before, modifier), " \t\r\n")
+	}
+
+	if !strings.HasSuffix(before, "*/") {
+		return ""
+	}
+
+	start := strings.LastIndex(before, "/**")
+	if start == -1 {
+		return ""
+	}
+
+	text := php.DocblockText(before[start:])
+	if end := strings.Index(text, "\n\n"); end != -1 {
+		text = text[:end]
+	}
+
+	return text
+}
+
+// Check that a class of a file implements EventSubscriberInterface, itself
+// or through the classes and interfaces it extends.
+func isEventSubscriberClass(parsedDoc *php.ParsedDoc, class string, classes map[string]PhpClass) bool {
+	for _, declaration := range parsedDoc.Classes {
+		if declaration.Name != class {
+			continue
+		}
+
+		if utils.InSlice(declaration.Interfaces, eventSubscriberInterface) {
+			return true
+		}
+
+		for _, name := range append([]string{declaration.Parent}, declaration.Interfaces...) {
+			if name != "" && utils.InSlice(classInherited(classes, name), eventSubscriberInterface) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Event names are declared by classes like KernelEvents.
+func isEventClassFile(path string) bool {
+	return strings.HasSuffix(path, "Events.php")
+}
+
+// Check that the cursor is in the body of getSubscribedEvents(), as the
+// last function declared before it.
+func isSubscribedEventsBody(text string, position lsp.Position) bool {
+	lines := strings.Split(text, "\n")
+	if int(position.Line) >= len(lines) {
+		return false
+	}
+
+	before := strings.Join(lines[:int(position.Line)], "\n")
+	index := strings.LastIndex(before, "function ")
+	if index == -1 {
+		return false
+	}
+
+	return strings.HasPrefix(strings.TrimSpace(before[index+len("function "):]), "getSubscribedEvents")
+}
@@ -0,0 +1,229 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+const eventKernelEvents = `<?php
+
+namespace Symfony\Component\HttpKernel;
+
+final class KernelEvents {
+
+  /**
+   * The REQUEST event occurs at the very beginning of request dispatching.
+   */
+  const REQUEST = 'kernel.request';
+
+  const RESPONSE = 'kernel.response';
+
+}
+`
+
+const eventSubscriber = `<?php
+
+namespace Drupal\foo\EventSubscriber;
+
+use Symfony\Component\EventDispatcher\EventSubscriberInterface;
+use Symfony\Component\HttpKernel\KernelEvents;
+
+class FooSubscriber implements EventSubscriberInterface {
+
+  public function onRequest($event) {
+  }
+
+  public static function getSubscribedEvents() {
+    $events[KernelEvents::REQUEST][] = ['onRequest', 100];
+    $events['foo.custom'][] = 'onCustom';
+    return $events;
+  }
+
+  public function onCustom($event) {
+  }
+
+}
+`
+
+// Extends a base class implementing EventSubscriberInterface.
+const eventRouteSubscriber = `<?php
+
+namespace Drupal\foo\Routing;
+
+use Drupal\Core\Routing\RouteSubscriberBase;
+use Symfony\Component\HttpKernel\KernelEvents;
+
+class RouteSubscriber extends RouteSubscriberBase {
+
+  public static function getSubscribedEvents() {
+    return [KernelEvents::RESPONSE => 'onResponse'];
+  }
+
+  public function onResponse($event) {
+  }
+
+}
+`
+
+// Has a getSubscribedEvents() method without being a subscriber.
+const eventNotSubscriber = `<?php
+
+namespace Drupal\foo;
+
+class Listeners {
+
+  public static function getSubscribedEvents() {
+    return ['foo.other' => 'onOther'];
+  }
+
+}
+`
+
+// Index an event class and subscribers in a temporary directory.
+func testEvent(t *testing.T) (*Event, []string, func()) {
+	_, paths, cleanup := writeFiles(t, [][2]string{
+		{"vendor/symfony/http-kernel/KernelEvents.php", eventKernelEvents},
+		{"foo/src/EventSubscriber/FooSubscriber.php", eventSubscriber},
+		{"foo/src/Routing/RouteSubscriber.php", eventRouteSubscriber},
+		{"foo/src/Listeners.php", eventNotSubscriber},
+	})
+
+	classes := []PhpClass{
+		{Namespace: "Drupal\\Core\\Routing\\RouteSubscriberBase", Interfaces: []string{"Symfony\\Component\\EventDispatcher\\EventSubscriberInterface"}},
+	}
+
+	event := &Event{}
+	event.SetClassIndex(testClassIndex(classes))
+	event.AddDefinitions(paths)
+
+	return event, paths, cleanup
+}
+
+func TestEventDefinitions(t *testing.T) {
+	event, paths, cleanup := testEvent(t)
+	defer cleanup()
+
+	names := []string{}
+	for _, def := range event.GetDefinitions() {
+		names = append(names, def.Name)
+	}
+
+	if strings.Join(names, " ") != "kernel.request kernel.response" {
+		t.Errorf("GetDefinitions() = %v", names)
+	}
+
+	if def := event.GetDefinitions()[0]; !strings.Contains(def.Description, "very beginning") || def.Position.Line != 9 {
+		t.Errorf("GetDefinitions()[0] = %+v", def)
+	}
+
+	subscribers := []string{}
+	for _, subscriber := range event.Subscribers {
+		subscribers = append(subscribers, event.subscriberEvent(subscriber)+":"+subscriber.Method)
+	}
+
+	// Listeners isn't a subscriber.
+	expected := "kernel.request:onRequest foo.custom:onCustom kernel.response:onResponse"
+	if strings.Join(subscribers, " ") != expected {
+		t.Errorf("Subscribers = %v, want %s", subscribers, expected)
+	}
+
+	event.RemoveDefinitions(paths[1:2])
+	if len(event.Subscribers) != 1 {
+		t.Errorf("RemoveDefinitions() left %d subscribers", len(event.Subscribers))
+	}
+}
+
+func TestEventDocumentCompletion(t *testing.T) {
+	event, paths, cleanup := testEvent(t)
+	defer cleanup()
+
+	tests := []struct {
+		text     string
+		expected bool
+	}{
+		{"  public static function getSubscribedEvents() {\n    $events['kernel.", true},
+		{"  public function onRequest() {\n    $this->dispatcher->dispatch($event, 'kernel.", true},
+		{"  public function onRequest() {\n    $this->dispatcher->dispatch('kernel.", true},
+		// Not an event.
+		{"  public function onRequest() {\n    $events['kernel.", false},
+	}
+
+	for _, test := range tests {
+		text := "<?php\n" + test.text
+		lines := strings.Split(text, "\n")
+		position := lsp.Position{
+			Line:      float64(len(lines) - 1),
+			Character: float64(len(lines[len(lines)-1])),
+		}
+
+		items := event.DocumentCompletion(paths[1], text, position)
+		if (len(items) > 0) != test.expected {
+			t.Errorf("DocumentCompletion(%q) = %d items, want %v", test.text, len(items), test.expected)
+		}
+
+		// Events only subscribed to by name are known as well.
+		found := false
+		for _, item := range items {
+			found = found || item.Label == "foo.custom"
+		}
+
+		if test.expected && !found {
+			t.Errorf("DocumentCompletion(%q) misses foo.custom", test.text)
+		}
+	}
+}
+
+func TestEventDocumentDefinition(t *testing.T) {
+	event, paths, cleanup := testEvent(t)
+	defer cleanup()
+
+	tests := []struct {
+		text     string
+		position lsp.Position
+		expected string
+	}{
+		{eventSubscriber, lsp.Position{Line: 13, Character: 26}, "kernel.request"},
+		{eventSubscriber, lsp.Position{Line: 14, Character: 14}, ""},
+		{eventRouteSubscriber, lsp.Position{Line: 10, Character: 16}, "kernel.response"},
+		// Syntax the parser doesn't support doesn't matter.
+		{strings.Replace(eventRouteSubscriber, "public function onResponse($event) {", "public function onResponse($event): static {\n    return match(1) { default => $this };", 1), lsp.Position{Line: 10, Character: 16}, "kernel.response"},
+		{eventSubscriber, lsp.Position{Line: 9, Character: 20}, ""},
+	}
+
+	for _, test := range tests {
+		defs := event.DocumentDefinition(paths[1], test.text, test.position)
+
+		name := ""
+		if len(defs) > 0 {
+			name = defs[0].Name
+		}
+
+		if name != test.expected {
+			t.Errorf("DocumentDefinition(%v) = %q, want %q", test.position, name, test.expected)
+		}
+	}
+}
+
+func TestEventCodeLens(t *testing.T) {
+	event, paths, cleanup := testEvent(t)
+	defer cleanup()
+
+	lenses := event.CodeLens(paths[1], eventSubscriber)
+	if len(lenses) != 2 {
+		t.Fatalf("CodeLens() = %d lenses, want 2", len(lenses))
+	}
+
+	if command := lenses[0].Command; command.Title != "kernel.request, priority 100" || command.Command != EventCodeLensCommand {
+		t.Errorf("CodeLens()[0] = %+v", command)
+	}
+
+	if lenses[1].Range.Start.Line != 18 {
+		t.Errorf("CodeLens()[1] at %+v", lenses[1].Range.Start)
+	}
+
+	if lenses := event.CodeLens(paths[3], eventNotSubscriber); len(lenses) != 0 {
+		t.Errorf("CodeLens(Listeners.php) = %d lenses, want none", len(lenses))
+	}
+}
@@ -0,0 +1,658 @@
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nkoporec/drupal-lsp/php"
+	"github.com/nkoporec/drupal-lsp/utils"
+
+	"github.com/z7zmey/php-parser/pkg/position"
+	lsp "go.lsp.dev/protocol"
+	"gopkg.in/yaml.v2"
+)
+
+type Field struct {
+	// Field storages, the definition type is the entity type.
+	Definitions []ParserDefinition
+	// Storage of every field keyed by the entity type and the field
+	// name, eg. node.field_tags.
+	Storages map[string]FieldStorage
+	// Fields attached to a bundle.
+	Instances []FieldInstance
+	// Content entity types keyed by their class.
+	EntityClasses map[string]FieldEntityClass
+	classes       func() map[string]PhpClass
+}
+
+type FieldStorage struct {
+	EntityType  string
+	Name        string
+	Type        string
+	Cardinality int
+	// Base fields are declared in code by the entity type.
+	Base bool
+}
+
+// The content entity type of a class and the file declaring it.
+type FieldEntityClass struct {
+	EntityType string
+	File       string
+}
+
+type FieldInstance struct {
+	EntityType string
+	Bundle     string
+	Name       string
+	Label      string
+	File       string
+}
+
+// A field.storage.*.yml or a field.field.*.yml config file.
+type FieldYaml struct {
+	FieldName   string `yaml:"field_name"`
+	EntityType  string `yaml:"entity_type"`
+	Bundle      string `yaml:"bundle"`
+	Label       string `yaml:"label"`
+	Type        string `yaml:"type"`
+	Cardinality int    `yaml:"cardinality"`
+}
+
+const baseFieldDefinitionClass = "Drupal\\Core\\Field\\BaseFieldDefinition"
+
+// Fields created by the entity base class for the entity keys.
+var entityKeyFieldTypes = map[string]string{
+	"id":       "integer",
+	"uuid":     "uuid",
+	"revision": "integer",
+	"bundle":   "entity_reference",
+	"langcode": "language",
+}
+
+// Field access where the field name is being typed, eg. $node->get('
+// or $node->field_, with the variable it's called on.
+var fieldCompletionRegexes = []*regexp.Regexp{
+	regexp.MustCompile(`\$(\w+)->(?:get|hasField)\(\s*['"]\w*$`),
+	regexp.MustCompile(`\$(\w+)->\w*$`),
+}
+
+// Static methods of entity classes that return an entity, eg. Node::load(1).
+var entityConstructors = []string{
+	"create",
+	"load",
+}
+
+func (f *Field) ParseFile(path string) interface{} {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	if filepath.Ext(path) == ".php" {
+		parsedDoc, err := php.Parse(file)
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+
+		return parsedDoc
+	}
+
+	field := &FieldYaml{}
+	if err := yaml.Unmarshal(file, field); err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return field
+}
+
+func (f *Field) AddDefinitions(items []string) {
+	if f.Storages == nil {
+		f.Storages = make(map[string]FieldStorage)
+	}
+
+	if f.EntityClasses == nil {
+		f.EntityClasses = make(map[string]FieldEntityClass)
+	}
+
+	for _, file := range items {
+		item := f.ParseFile(file)
+		if item == nil {
+			continue
+		}
+
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		switch item := item.(type) {
+		case *php.ParsedDoc:
+			f.addBaseFields(file, src, item)
+		case *FieldYaml:
+			if item.FieldName == "" || item.EntityType == "" {
+				continue
+			}
+
+			// Fields attached to a bundle.
+			if item.Bundle != "" {
+				f.Instances = append(f.Instances, FieldInstance{
+					EntityType: item.EntityType,
+					Bundle:     item.Bundle,
+					Name:       item.FieldName,
+					Label:      item.Label,
+					File:       file,
+				})
+				continue
+			}
+
+			f.addStorage(FieldStorage{
+				EntityType:  item.EntityType,
+				Name:        item.FieldName,
+				Type:        item.Type,
+				Cardinality: item.Cardinality,
+			}, file, yamlValuePosition(src, "field_name"))
+		}
+	}
+}
+
+// Index the fields declared in baseFieldDefinitions() of an entity type,
+// eg. $fields['title'] = BaseFieldDefinition::create('string').
+func (f *Field) addBaseFields(file string, src []byte, parsedDoc *php.ParsedDoc) {
+	for _, class := range parsedDoc.Classes {
+		for _, annotation := range class.Annotations {
+			if annotation.Name != "ContentEntityType" || annotation.String("id") == "" {
+				continue
+			}
+			entityType := annotation.String("id")
+
+			f.EntityClasses[class.Name] = FieldEntityClass{
+				EntityType: entityType,
+				File:       file,
+			}
+
+			// The entity base class creates the fields of the entity keys.
+			for key, name := range flattenAnnotation("", annotation.Map("entity_keys")) {
+				fieldType, ok := entityKeyFieldTypes[key]
+				if !ok {
+					continue
+				}
+
+				f.addStorage(FieldStorage{
+					EntityType:  entityType,
+					Name:        name,
+					Type:        fieldType,
+					Cardinality: 1,
+					Base:        true,
+				}, file, stringRange(src, annotation.Positions["id"]).Start)
+			}
+
+			var body *position.Position
+			for _, method := range class.Methods {
+				if method.Name == "baseFieldDefinitions" {
+					body = method.Body
+				}
+			}
+
+			if body == nil {
+				continue
+			}
+
+			for _, assignment := range parsedDoc.Assignments {
+				position := assignment.Position
+				if assignment.Key == "" || position == nil || position.StartPos < body.StartPos || position.EndPos > body.EndPos {
+					continue
+				}
+
+				fieldType, ok := baseFieldType(assignment.Value)
+				if !ok {
+					continue
+				}
+
+				line, character := php.LineColumn(src, assignment.KeyPosition.StartPos)
+				f.addStorage(FieldStorage{
+					EntityType:  entityType,
+					Name:        assignment.Key,
+					Type:        fieldType,
+					Cardinality: baseFieldCardinality(src, parsedDoc, position),
+					Base:        true,
+				}, file, lsp.Position{
+					Line:      float64(line),
+					Character: float64(character),
+				})
+			}
+		}
+	}
+}
+
+// Get the type of a base field definition from the create() call its
+// chain of setters starts with.
+func baseFieldType(expr *php.PhpExpression) (string, bool) {
+	for ; expr != nil; expr = expr.Var {
+		if expr.Kind == "static" {
+			return expr.Argument, expr.Class == baseFieldDefinitionClass && expr.Name == "create" && expr.Argument != ""
+		}
+	}
+
+	return "", false
+}
+
+// Get the cardinality set in the statement of a base field definition,
+// -1 if it's unlimited.
+func baseFieldCardinality(src []byte, parsedDoc *php.ParsedDoc, statement *position.Position) int {
+	for _, call := range parsedDoc.MethodCalls {
+		if call.Method.Name != "setCardinality" || len(call.Args) == 0 || call.Position == nil || call.Position.StartPos < statement.StartPos || call.Position.EndPos > statement.EndPos {
+			continue
+		}
+
+		argument := call.Args[0].Position
+		if argument == nil {
+			continue
+		}
+
+		value := string(src[argument.StartPos:argument.EndPos])
+		if strings.HasSuffix(value, "::CARDINALITY_UNLIMITED") {
+			return -1
+		}
+
+		if cardinality, err := strconv.Atoi(value); err == nil {
+			return cardinality
+		}
+	}
+
+	return 1
+}
+
+func (f *Field) addStorage(storage FieldStorage, file string, position lsp.Position) {
+	f.Storages[storage.EntityType+"."+storage.Name] = storage
+
+	f.Definitions = append(f.Definitions, ParserDefinition{
+		Name:     storage.Name,
+		Type:     storage.EntityType,
+		File:     file,
+		Position: position,
+	})
+}
+
+func (f *Field) RemoveDefinitions(items []string) {
+	f.Definitions = removeFileDefinitions(f.Definitions, items)
+
+	if len(items) == 0 {
+		return
+	}
+
+	removed := make(map[string]bool, len(items))
+	for _, file := range items {
+		removed[file] = true
+	}
+
+	instances := []FieldInstance{}
+	for _, instance := range f.Instances {
+		if !removed[instance.File] {
+			instances = append(instances, instance)
+		}
+	}
+	f.Instances = instances
+
+	// Keep the storages that are still defined.
+	storages := make(map[string]FieldStorage)
+	for _, def := range f.Definitions {
+		key := def.Type + "." + def.Name
+		if storage, ok := f.Storages[key]; ok {
+			storages[key] = storage
+		}
+	}
+	f.Storages = storages
+
+	classes := make(map[string]FieldEntityClass)
+	for class, entityClass := range f.EntityClasses {
+		if !removed[entityClass.File] {
+			classes[class] = entityClass
+		}
+	}
+	f.EntityClasses = classes
+}
+
+func (f *Field) SetClassIndex(classes func() map[string]PhpClass) {
+	f.classes = classes
+}
+
+func (f *Field) FileExtension() string {
+	return "/field."
+}
+
+// Field config files and entity types with base fields.
+func (f *Field) MatchFile(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasSuffix(name, ".yml") {
+		return strings.HasPrefix(name, "field.storage.") || strings.HasPrefix(name, "field.field.")
+	}
+
+	return strings.Contains(path, "/src/Entity/") && filepath.Ext(path) == ".php"
+}
+
+// Config objects have a get() method too, so the fields accessed with it
+// are resolved from the class of the entity instead, see fieldAt.
+func (f *Field) Methods() []string {
+	return []string{
+		"hasField",
+	}
+}
+
+func (f *Field) GetDefinitions() []ParserDefinition {
+	return f.Definitions
+}
+
+func (f *Field) CompletionItem(def ParserDefinition) (lsp.CompletionItem, error) {
+	storage := f.Storages[def.Type+"."+def.Name]
+
+	return lsp.CompletionItem{
+		Kind:   lsp.FieldCompletion,
+		Label:  def.Name,
+		Detail: fmt.Sprintf("%s field of %s", storage.Type, def.Type),
+		Documentation: lsp.MarkupContent{
+			Kind:  lsp.PlainText,
+			Value: f.describe(storage),
+		},
+	}, nil
+}
+
+// Complete the field names of entities, eg. $node->get('field_tags') and
+// $node->field_tags.
+func (f *Field) DocumentCompletion(path string, text string, position lsp.Position) []lsp.CompletionItem {
+	result := []lsp.CompletionItem{}
+
+	if !phpFile(path) {
+		return result
+	}
+
+	line, ok := linePrefix(text, position)
+	if !ok {
+		return result
+	}
+
+	name, start, ok := fieldReceiver(line)
+	if !ok || name == "this" {
+		return result
+	}
+
+	// The access being typed is left out, so it doesn't break the
+	// statement for the parser.
+	lineStart := textLineStart(text, int(position.Line))
+	entityTypes := f.entityTypes(text, lineStart+start, lineStart+len(line), name)
+
+	// A field is offered once, even if its storage is in several
+	// config directories.
+	seen := make(map[string]bool)
+	for _, def := range f.GetDefinitions() {
+		key := def.Type + "." + def.Name
+		if seen[key] || !entityTypes[def.Type] {
+			continue
+		}
+		seen[key] = true
+
+		completion, err := f.CompletionItem(def)
+		if err != nil {
+			continue
+		}
+
+		result = append(result, completion)
+	}
+
+	return result
+}
+
+// Show the type, cardinality and bundles of the field under the cursor.
+func (f *Field) Hover(path string, text string, position lsp.Position) string {
+	fieldName, entityTypes, ok := f.fieldAt(path, text, position)
+	if !ok {
+		return ""
+	}
+
+	descriptions := []string{}
+	for key, storage := range f.Storages {
+		if storage.Name != fieldName || !entityTypes[storage.EntityType] {
+			continue
+		}
+
+		descriptions = append(descriptions, fmt.Sprintf("%s\n%s", key, f.describe(storage)))
+	}
+	sort.Strings(descriptions)
+
+	return strings.Join(descriptions, "\n\n")
+}
+
+// Go to the storages of the field under the cursor.
+func (f *Field) DocumentDefinition(path string, text string, position lsp.Position) []ParserDefinition {
+	result := []ParserDefinition{}
+
+	fieldName, entityTypes, ok := f.fieldAt(path, text, position)
+	if !ok {
+		return result
+	}
+
+	for _, def := range f.GetDefinitions() {
+		if def.Name == fieldName && entityTypes[def.Type] {
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Get the field accessed under the cursor, eg. $node->field_tags or
+// $node->get('field_tags'), and the entity types of the variable.
+func (f *Field) fieldAt(path string, text string, position lsp.Position) (string, map[string]bool, bool) {
+	if !phpFile(path) {
+		return "", nil, false
+	}
+
+	lines := strings.Split(text, "\n")
+	if int(position.Line) >= len(lines) {
+		return "", nil, false
+	}
+	line := lines[int(position.Line)]
+
+	if position.Character > LineCharacter(line, len(line)) {
+		return "", nil, false
+	}
+	start := LineOffset(line, position.Character)
+	end := start
+	for start > 0 && isFieldNameByte(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isFieldNameByte(line[end]) {
+		end++
+	}
+
+	fieldName := line[start:end]
+	if fieldName == "" {
+		return "", nil, false
+	}
+
+	name, _, ok := fieldReceiver(line[:start])
+	if !ok {
+		return "", nil, false
+	}
+
+	offset := textLineStart(text, int(position.Line)) + start
+	entityTypes := f.entityTypes(text, offset, offset, name)
+
+	return fieldName, entityTypes, len(entityTypes) > 0
+}
+
+func (f *Field) Diagnostics(text string, defs []ParserDefinition) []lsp.Diagnostic {
+	return []lsp.Diagnostic{}
+}
+
+func (f *Field) GetGoToDefinition(params string) []ParserDefinition {
+	result := make([]ParserDefinition, 0, 200)
+
+	for _, def := range f.GetDefinitions() {
+		if def.Name == params {
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Get the content entity types of a variable, from its class at an
+// offset of a php file, eg. NodeInterface $node. Classes shared by several
+// entity types, eg. ContentEntityInterface, give all of them. The text
+// between start and end is left out when parsing the file.
+func (f *Field) entityTypes(text string, start int, end int, name string) map[string]bool {
+	result := make(map[string]bool)
+
+	parsedDoc, err := php.Parse([]byte(text[:start] + text[end:]))
+	if err != nil {
+		return result
+	}
+
+	class := variableClass(parsedDoc, name, start)
+	if class == "" {
+		return result
+	}
+
+	classes := classIndex(f.classes)
+	for entityClass, item := range f.EntityClasses {
+		if utils.InSlice(classInherited(classes, entityClass), class) {
+			result[item.EntityType] = true
+		}
+	}
+
+	return result
+}
+
+// Get the class of a variable at an offset of a php file, from the last
+// assignment of a new or loaded entity, eg. $node = Node::load(1), or the
+// type of the parameter of the enclosing function.
+func variableClass(parsedDoc *php.ParsedDoc, name string, offset int) string {
+	start, end := 0, -1
+	parameters := []*php.PhpParameter{}
+
+	for _, method := range parsedDoc.ClassMethods {
+		if method.Body != nil && method.Body.StartPos <= offset && offset <= method.Body.EndPos {
+			start, end, parameters = method.Body.StartPos, method.Body.EndPos, method.Parameters
+		}
+	}
+
+	for _, function := range parsedDoc.Functions {
+		if function.Body != nil && function.Body.StartPos <= offset && offset <= function.Body.EndPos {
+			start, end, parameters = function.Body.StartPos, function.Body.EndPos, function.Parameters
+		}
+	}
+
+	var last *php.PhpAssignment
+	for _, assignment := range parsedDoc.Assignments {
+		position := assignment.Position
+		if assignment.Var != name || assignment.Key != "" || position == nil || position.EndPos > offset || position.StartPos < start || (end != -1 && position.EndPos > end) {
+			continue
+		}
+
+		if last == nil || position.StartPos > last.Position.StartPos {
+			last = assignment
+		}
+	}
+
+	if last != nil {
+		value := last.Value
+		if value.Kind == "new" || (value.Kind == "static" && utils.InSlice(entityConstructors, strings.ToLower(value.Name))) {
+			return value.Class
+		}
+
+		return ""
+	}
+
+	for _, parameter := range parameters {
+		if parameter.Name != name {
+			continue
+		}
+
+		for _, part := range strings.Split(strings.TrimPrefix(parameter.Type, "?"), "|") {
+			if part != "" && strings.ToLower(part) != "null" {
+				return parsedDoc.ResolveName(part)
+			}
+		}
+	}
+
+	return ""
+}
+
+// Get the variable a field is accessed on and where it starts, eg. node
+// for $node->get('.
+func fieldReceiver(line string) (string, int, bool) {
+	for _, regex := range fieldCompletionRegexes {
+		if match := regex.FindStringSubmatchIndex(line); match != nil {
+			return line[match[2]:match[3]], match[0], true
+		}
+	}
+
+	return "", 0, false
+}
+
+// Get the offset of the start of a line of the text.
+func textLineStart(text string, line int) int {
+	offset := 0
+	for i := 0; i < line; i++ {
+		end := strings.IndexRune(text[offset:], '\n')
+		if end == -1 {
+			return len(text)
+		}
+		offset += end + 1
+	}
+
+	return offset
+}
+
+// Summary of a field, its type, cardinality and bundles.
+func (f *Field) describe(storage FieldStorage) string {
+	cardinality := strconv.Itoa(storage.Cardinality)
+	if storage.Cardinality == -1 {
+		cardinality = "unlimited"
+	}
+
+	lines := []string{
+		fmt.Sprintf("Type: %s", storage.Type),
+		fmt.Sprintf("Cardinality: %s", cardinality),
+	}
+
+	if storage.Base {
+		lines = append(lines, "Base field")
+	}
+
+	bundles := []string{}
+	for _, instance := range f.Instances {
+		if instance.EntityType != storage.EntityType || instance.Name != storage.Name {
+			continue
+		}
+
+		bundle := instance.Bundle
+		if instance.Label != "" {
+			bundle = fmt.Sprintf("%s (%s)", bundle, instance.Label)
+		}
+
+		if !utils.InSlice(bundles, bundle) {
+			bundles = append(bundles, bundle)
+		}
+	}
+	sort.Strings(bundles)
+
+	if len(bundles) > 0 {
+		lines = append(lines, fmt.Sprintf("Bundles: %s", strings.Join(bundles, ", ")))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func isFieldNameByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,203 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+const fieldEntitySource = `<?php
+
+namespace Drupal\node\Entity;
+
+use Drupal\Core\Entity\ContentEntityBase;
+use Drupal\Core\Field\BaseFieldDefinition;
+use Drupal\Core\Field\FieldStorageDefinitionInterface;
+use Drupal\node\NodeInterface;
+
+/**
+ * @ContentEntityType(
+ *   id = "node",
+ *   entity_keys = {
+ *     "id" = "nid",
+ *     "uuid" = "uuid",
+ *   },
+ * )
+ */
+class Node extends ContentEntityBase implements NodeInterface {
+
+  public static function baseFieldDefinitions($entity_type) {
+    $fields = parent::baseFieldDefinitions($entity_type);
+
+    $fields['title'] = BaseFieldDefinition::create('string')
+      ->setLabel(t('Title'))
+      ->setRequired(TRUE);
+
+    $fields['tags'] = BaseFieldDefinition::create('entity_reference')
+      ->setCardinality(FieldStorageDefinitionInterface::CARDINALITY_UNLIMITED);
+
+    $fields['weight'] = BaseFieldDefinition::create('integer')
+      ->setCardinality(2);
+
+    return $fields;
+  }
+
+  public function label() {
+    $fields['label'] = BaseFieldDefinition::create('string');
+    return $this->get('title')->value;
+  }
+
+}
+`
+
+// Index an entity type with config fields in a temporary directory.
+func testField(t *testing.T) (*Field, func()) {
+	_, paths, cleanup := writeFiles(t, [][2]string{
+		{"node/src/Entity/Node.php", fieldEntitySource},
+		{"node/src/NodeInterface.php", "<?php\nnamespace Drupal\\node;\ninterface NodeInterface {}\n"},
+		{"foo/config/install/field.storage.node.field_tags.yml", "field_name: field_tags\nentity_type: node\ntype: entity_reference\ncardinality: -1\n"},
+		{"foo/config/install/field.field.node.article.field_tags.yml", "field_name: field_tags\nentity_type: node\nbundle: article\nlabel: Tags\n"},
+		{"foo/config/install/field.storage.user.field_tags.yml", "field_name: field_tags\nentity_type: user\ntype: string\n"},
+	})
+
+	field := &Field{}
+	field.SetClassIndex(testClassIndex([]PhpClass{
+		{Namespace: "Drupal\\node\\Entity\\Node", Kind: "class", Parent: "Drupal\\Core\\Entity\\ContentEntityBase", Interfaces: []string{"Drupal\\node\\NodeInterface"}},
+		{Namespace: "Drupal\\node\\NodeInterface", Kind: "interface", Interfaces: []string{"Drupal\\Core\\Entity\\ContentEntityInterface"}},
+		{Namespace: "Drupal\\Core\\Entity\\ContentEntityBase", Kind: "class", Interfaces: []string{"Drupal\\Core\\Entity\\ContentEntityInterface"}},
+	}))
+
+	matched := []string{}
+	for _, path := range paths {
+		if field.MatchFile(path) {
+			matched = append(matched, path)
+		}
+	}
+	field.AddDefinitions(matched)
+
+	return field, cleanup
+}
+
+func TestFieldDefinitions(t *testing.T) {
+	field, cleanup := testField(t)
+	defer cleanup()
+
+	names := []string{}
+	for key, storage := range field.Storages {
+		names = append(names, key+":"+storage.Type)
+	}
+	sort.Strings(names)
+
+	// Only the assignments of baseFieldDefinitions() are base fields.
+	expected := "node.field_tags:entity_reference,node.nid:integer,node.tags:entity_reference,node.title:string,node.uuid:uuid,node.weight:integer,user.field_tags:string"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("Storages = %v, want %s", names, expected)
+	}
+
+	cardinalities := map[string]int{
+		"node.title":      1,
+		"node.tags":       -1,
+		"node.weight":     2,
+		"node.field_tags": -1,
+	}
+	for key, cardinality := range cardinalities {
+		if field.Storages[key].Cardinality != cardinality {
+			t.Errorf("Cardinality of %s = %d, want %d", key, field.Storages[key].Cardinality, cardinality)
+		}
+	}
+
+	for _, def := range field.GetDefinitions() {
+		if def.Name == "title" && (def.Position.Line != 23 || def.Position.Character != 13) {
+			t.Errorf("Position of title = %v", def.Position)
+		}
+	}
+
+	if len(field.Instances) != 1 || field.Instances[0].Bundle != "article" {
+		t.Errorf("Instances = %v", field.Instances)
+	}
+
+	if class, ok := field.EntityClasses["Drupal\\node\\Entity\\Node"]; !ok || class.EntityType != "node" {
+		t.Errorf("EntityClasses = %v", field.EntityClasses)
+	}
+
+	// Config objects have a get() method too.
+	if methods := field.Methods(); len(methods) != 1 || methods[0] != "hasField" {
+		t.Errorf("Methods() = %v", methods)
+	}
+
+	files := []string{}
+	for _, class := range field.EntityClasses {
+		files = append(files, class.File)
+	}
+	field.RemoveDefinitions(files)
+
+	if len(field.EntityClasses) != 0 || len(field.Storages) != 2 {
+		t.Errorf("Removed fields are indexed %v %v", field.EntityClasses, field.Storages)
+	}
+}
+
+func TestFieldDocumentCompletion(t *testing.T) {
+	field, cleanup := testField(t)
+	defer cleanup()
+
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"function foo(NodeInterface $node) {\n  $node->get('", "field_tags,nid,tags,title,uuid,weight"},
+		{"function foo(?NodeInterface $node) {\n  $node->field_", "field_tags,nid,tags,title,uuid,weight"},
+		{"function foo() {\n  $node = Node::load(1);\n  $node->hasField(\"", "field_tags,nid,tags,title,uuid,weight"},
+		// Shared interfaces give the fields of every entity type using them.
+		{"function foo(\\Drupal\\Core\\Entity\\ContentEntityInterface $entity) {\n  $entity->get('", "field_tags,nid,tags,title,uuid,weight"},
+		// The class of the variable is unknown, whatever its name.
+		{"function foo($node) {\n  $node->get('", ""},
+		{"function foo(ImmutableConfig $node) {\n  $node->get('", ""},
+		{"function foo() {\n  $node = \\Drupal::config('foo');\n  $node->get('", ""},
+	}
+
+	for _, test := range tests {
+		text := "<?php\nuse Drupal\\node\\Entity\\Node;\nuse Drupal\\node\\NodeInterface;\n" + test.text
+		lines := strings.Split(text, "\n")
+		// Editors close the brackets before the cursor.
+		text += "\n}\n"
+		position := lsp.Position{
+			Line:      float64(len(lines) - 1),
+			Character: float64(len(lines[len(lines)-1])),
+		}
+
+		labels := []string{}
+		for _, item := range field.DocumentCompletion("/foo/foo.module", text, position) {
+			labels = append(labels, item.Label)
+		}
+		sort.Strings(labels)
+
+		if strings.Join(labels, ",") != test.expected {
+			t.Errorf("DocumentCompletion(%q) = %v, want %s", test.text, labels, test.expected)
+		}
+	}
+}
+
+func TestFieldHover(t *testing.T) {
+	field, cleanup := testField(t)
+	defer cleanup()
+
+	text := "<?php\nuse Drupal\\node\\NodeInterface;\nfunction foo(NodeInterface $node, $user) {\n  $node->field_tags->value;\n  $user->field_tags->value;\n}\n"
+
+	hover := field.Hover("/foo/foo.module", text, lsp.Position{Line: 3, Character: 12})
+	expected := "node.field_tags\nType: entity_reference\nCardinality: unlimited\nBundles: article (Tags)"
+	if hover != expected {
+		t.Errorf("Hover() = %q, want %q", hover, expected)
+	}
+
+	// The class of $user is unknown.
+	if hover := field.Hover("/foo/foo.module", text, lsp.Position{Line: 4, Character: 12}); hover != "" {
+		t.Errorf("Hover() = %q", hover)
+	}
+
+	definitions := field.DocumentDefinition("/foo/foo.module", text, lsp.Position{Line: 3, Character: 12})
+	if len(definitions) != 1 || definitions[0].Type != "node" || !strings.HasSuffix(definitions[0].File, "field.storage.node.field_tags.yml") {
+		t.Errorf("DocumentDefinition() = %v", definitions)
+	}
+}
@@ -0,0 +1,200 @@
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/nkoporec/drupal-lsp/php"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+type Hook struct {
+	Definitions []ParserDefinition
+	// Parameter list of every hook, keyed by the hook name.
+	Params map[string]string
+}
+
+// Files that can contain hook implementations.
+var hookFileExtensions = []string{
+	".module",
+	".install",
+	".profile",
+	".theme",
+	".inc",
+}
+
+func (h *Hook) ParseFile(path string) interface{} {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	parsedDoc, err := php.Parse(file)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return parsedDoc
+}
+
+func (h *Hook) AddDefinitions(items []string) {
+	if h.Params == nil {
+		h.Params = make(map[string]string)
+	}
+
+	for _, file := range items {
+		item := h.ParseFile(file)
+		if item == nil {
+			continue
+		}
+
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		parsedDoc := item.(*php.ParsedDoc)
+		for _, function := range parsedDoc.Functions {
+			if !strings.HasPrefix(function.Name, "hook_") {
+				continue
+			}
+
+			h.Params[function.Name] = function.Params
+			h.Definitions = append(h.Definitions, ParserDefinition{
+				Name:        function.Name,
+				Description: php.DocblockText(function.Docblock),
+				File:        file,
+				Position:    NodeRange(src, function.Position).Start,
+			})
+		}
+	}
+}
+
+func (h *Hook) RemoveDefinitions(items []string) {
+	h.Definitions = removeFileDefinitions(h.Definitions, items)
+
+	// Keep the parameters of hooks that are still defined.
+	defined := make(map[string]bool, len(h.Definitions))
+	for _, def := range h.Definitions {
+		defined[def.Name] = true
+	}
+
+	for name := range h.Params {
+		if !defined[name] {
+			delete(h.Params, name)
+		}
+	}
+}
+
+func (h *Hook) FileExtension() string {
+	return ".api.php"
+}
+
+// Hooks are not referenced from method calls.
+func (h *Hook) Methods() []string {
+	return []string{}
+}
+
+func (h *Hook) GetDefinitions() []ParserDefinition {
+	return h.Definitions
+}
+
+func (h *Hook) CompletionItem(def ParserDefinition) (lsp.CompletionItem, error) {
+	return lsp.CompletionItem{
+		Kind:   lsp.FunctionCompletion,
+		Label:  def.Name,
+		Detail: fmt.Sprintf("%s(%s)", def.Name, h.Params[def.Name]),
+		Documentation: lsp.MarkupContent{
+			Kind:  lsp.PlainText,
+			Value: def.Description,
+		},
+	}, nil
+}
+
+func (h *Hook) Diagnostics(text string, defs []ParserDefinition) []lsp.Diagnostic {
+	return []lsp.Diagnostic{}
+}
+
+func (h *Hook) GetGoToDefinition(params string) []ParserDefinition {
+	result := make([]ParserDefinition, 0, 200)
+
+	for _, def := range h.GetDefinitions() {
+		if def.Name == params {
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Complete hook implementations, eg. mymodule_form_alter, into a
+// function stub.
+func (h *Hook) DocumentCompletion(path string, text string, position lsp.Position) []lsp.CompletionItem {
+	result := []lsp.CompletionItem{}
+
+	ext := filepath.Ext(path)
+	isHookFile := false
+	for _, item := range hookFileExtensions {
+		if ext == item {
+			isHookFile = true
+		}
+	}
+
+	if !isHookFile {
+		return result
+	}
+
+	line, ok := linePrefix(text, position)
+	if !ok {
+		return result
+	}
+
+	// Only complete at the start of a line, optionally after the
+	// function keyword.
+	start := len(line) - len(strings.TrimLeft(line, " \t"))
+	word := strings.TrimPrefix(line[start:], "function ")
+	if strings.ContainsAny(word, " \t(") {
+		return result
+	}
+
+	// The module name is before the first dot, eg. mymodule.views.inc.
+	moduleName := strings.SplitN(filepath.Base(path), ".", 2)[0]
+	editRange := lsp.Range{
+		Start: lsp.Position{
+			Line:      position.Line,
+			Character: LineCharacter(line, start),
+		},
+		End: position,
+	}
+
+	for _, def := range h.GetDefinitions() {
+		name := moduleName + strings.TrimPrefix(def.Name, "hook")
+		if !strings.HasPrefix(name, word) {
+			continue
+		}
+
+		stub := fmt.Sprintf("/**\n * Implements %s().\n */\nfunction %s(%s) {\n\n}", def.Name, name, h.Params[def.Name])
+
+		completion, err := h.CompletionItem(def)
+		if err != nil {
+			continue
+		}
+		completion.Label = name
+		completion.FilterText = strings.TrimSuffix(line[start:], word) + name
+		completion.InsertTextFormat = lsp.TextFormatPlainText
+		completion.TextEdit = &lsp.TextEdit{
+			Range:   editRange,
+			NewText: stub,
+		}
+
+		result = append(result, completion)
+	}
+
+	return result
+}
@@ -0,0 +1,56 @@
+package parser
+
+import (
+	"testing"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestHookDocumentCompletion(t *testing.T) {
+	hook := &Hook{
+		Definitions: []ParserDefinition{
+			{Name: "hook_form_alter"},
+			{Name: "hook_views_data"},
+		},
+		Params: map[string]string{
+			"hook_form_alter": "&$form, $form_state, $form_id",
+			"hook_views_data": "",
+		},
+	}
+
+	tests := []struct {
+		path     string
+		line     string
+		expected []string
+	}{
+		{"/foo/mymodule.module", "mymodule_form", []string{"mymodule_form_alter"}},
+		{"/foo/mymodule.views.inc", "function mymodule_views", []string{"mymodule_views_data"}},
+		{"/foo/mymodule.install", "", []string{"mymodule_form_alter", "mymodule_views_data"}},
+		// Install profiles implement hooks too.
+		{"/foo/myprofile.profile", "myprofile_form", []string{"myprofile_form_alter"}},
+		// Not a hook file.
+		{"/foo/src/Foo.php", "mymodule_form", []string{}},
+	}
+
+	for _, test := range tests {
+		position := lsp.Position{Line: 1, Character: float64(len(test.line))}
+		items := hook.DocumentCompletion(test.path, "<?php\n"+test.line+"\n", position)
+
+		labels := []string{}
+		for _, item := range items {
+			labels = append(labels, item.Label)
+		}
+
+		if len(labels) != len(test.expected) {
+			t.Errorf("DocumentCompletion(%q, %q) = %v, want %v", test.path, test.line, labels, test.expected)
+			continue
+		}
+
+		for i := range labels {
+			if labels[i] != test.expected[i] {
+				t.Errorf("DocumentCompletion(%q, %q) = %v, want %v", test.path, test.line, labels, test.expected)
+				break
+			}
+		}
+	}
+}
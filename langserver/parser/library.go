@@ -0,0 +1,567 @@
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nkoporec/drupal-lsp/php"
+	"github.com/nkoporec/drupal-lsp/utils"
+
+	lsp "go.lsp.dev/protocol"
+	"gopkg.in/yaml.v2"
+)
+
+type Library struct {
+	Definitions []ParserDefinition
+}
+
+type LibraryYaml struct {
+	// Css files keyed by their category, eg. theme.
+	Css          map[string]map[string]interface{} `yaml:"css"`
+	Js           map[string]interface{}            `yaml:"js"`
+	Dependencies []string                          `yaml:"dependencies"`
+}
+
+// Files that can attach a library to a render array.
+var libraryPhpExtensions = []string{
+	".php",
+	".module",
+	".theme",
+	".inc",
+	".install",
+}
+
+// The libraries of a render array, eg. '#attached' => ['library' => [...]].
+var libraryArrayRegex = regexp.MustCompile(`['"]library['"]\s*=>\s*(?:\[|array\s*\()`)
+
+// A library appended to a render array, eg. $build['#attached']['library'][] = 'core/drupal';
+var libraryAppendRegex = regexp.MustCompile(`['"]library['"]\s*\]\s*\[\s*\]\s*=\s*(['"])([^'"$]*)['"]\s*;`)
+
+// A library appended to a render array, while it's being typed.
+var libraryAppendCompletionRegex = regexp.MustCompile(`['"]library['"]\s*\]\s*\[\s*\]\s*=\s*['"]([^'"]*)$`)
+
+// The key of a render array that holds libraries, before its array.
+var libraryKeyRegex = regexp.MustCompile(`['"]library['"]\s*=>\s*(?:array\s*)?$`)
+
+var libraryTwigRegex = regexp.MustCompile(`attach_library\(\s*['"]([^'"]+)['"]`)
+
+var libraryTwigCompletionRegex = regexp.MustCompile(`attach_library\(\s*['"]([^'"]*)$`)
+
+func (l *Library) ParseFile(path string) interface{} {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	libraries := yaml.MapSlice{}
+	if err := yaml.Unmarshal(file, &libraries); err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return libraries
+}
+
+func (l *Library) AddDefinitions(items []string) {
+	for _, file := range items {
+		item := l.ParseFile(file)
+		if item == nil {
+			continue
+		}
+
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		// Libraries are prefixed by their extension, eg. core/drupal.
+		extension := strings.TrimSuffix(filepath.Base(file), ".libraries.yml")
+
+		for _, entry := range item.(yaml.MapSlice) {
+			key, ok := entry.Key.(string)
+			if !ok {
+				continue
+			}
+
+			// Decode the library again to get its fields.
+			out, err := yaml.Marshal(entry.Value)
+			if err != nil {
+				continue
+			}
+
+			library := LibraryYaml{}
+			if err := yaml.Unmarshal(out, &library); err != nil {
+				log.Println(err)
+			}
+
+			def := ParserDefinition{
+				Name:        extension + "/" + key,
+				Description: libraryDescription(library),
+				File:        file,
+			}
+
+			if position, ok := yamlKeyPosition(src, key, ""); ok {
+				def.Position = position
+			}
+
+			l.Definitions = append(l.Definitions, def)
+		}
+	}
+}
+
+func (l *Library) RemoveDefinitions(items []string) {
+	l.Definitions = removeFileDefinitions(l.Definitions, items)
+}
+
+func (l *Library) FileExtension() string {
+	return ".libraries.yml"
+}
+
+func (l *Library) Methods() []string {
+	return []string{}
+}
+
+func (l *Library) GetDefinitions() []ParserDefinition {
+	return l.Definitions
+}
+
+func (l *Library) CompletionItem(def ParserDefinition) (lsp.CompletionItem, error) {
+	return lsp.CompletionItem{
+		Kind:   lsp.ModuleCompletion,
+		Label:  def.Name,
+		Detail: "Library",
+		Documentation: lsp.MarkupContent{
+			Kind:  lsp.PlainText,
+			Value: def.Description,
+		},
+	}, nil
+}
+
+// Complete libraries of render arrays, attach_library() in twig templates,
+// dependencies of libraries and libraries-extend and libraries-override of
+// themes.
+func (l *Library) DocumentCompletion(path string, text string, position lsp.Position) []lsp.CompletionItem {
+	result := []lsp.CompletionItem{}
+
+	line, ok := linePrefix(text, position)
+	if !ok {
+		return result
+	}
+
+	start := -1
+	switch {
+	case filepath.Ext(path) == ".yml":
+		lines := strings.Split(text, "\n")
+		if valueStart, ok := libraryYamlValue(path, lines, int(position.Line)); ok && valueStart <= len(line) {
+			start = valueStart
+			if start < len(line) && (line[start] == '\'' || line[start] == '"') {
+				start++
+			}
+		}
+	case strings.HasSuffix(path, ".twig"):
+		if match := libraryTwigCompletionRegex.FindStringSubmatchIndex(line); match != nil {
+			start = match[2]
+		}
+	case utils.InSlice(libraryPhpExtensions, filepath.Ext(path)):
+		if match := libraryAppendCompletionRegex.FindStringSubmatchIndex(line); match != nil {
+			start = match[2]
+			break
+		}
+
+		// Inside a string of a library array.
+		quote := openQuote(line)
+		if quote == -1 {
+			break
+		}
+
+		lines := strings.Split(text, "\n")
+		before := strings.Join(append(lines[:int(position.Line)], line[:quote]), "\n")
+		if isLibraryArray(before) {
+			start = quote + 1
+		}
+	}
+
+	if start == -1 || start > len(line) {
+		return result
+	}
+
+	// Library names contain slashes and dots, so replace everything that
+	// was typed.
+	editRange := lsp.Range{
+		Start: lsp.Position{
+			Line:      position.Line,
+			Character: LineCharacter(line, start),
+		},
+		End: position,
+	}
+
+	for _, def := range l.GetDefinitions() {
+		completion, err := l.CompletionItem(def)
+		if err != nil {
+			continue
+		}
+
+		completion.FilterText = def.Name
+		completion.TextEdit = &lsp.TextEdit{
+			Range:   editRange,
+			NewText: def.Name,
+		}
+
+		result = append(result, completion)
+	}
+
+	return result
+}
+
+func (l *Library) Diagnostics(text string, defs []ParserDefinition) []lsp.Diagnostic {
+	return []lsp.Diagnostic{}
+}
+
+// Check the libraries referenced by render arrays, templates and yaml files.
+func (l *Library) FileDiagnostics(path string, text string) []lsp.Diagnostic {
+	result := []lsp.Diagnostic{}
+
+	// Without any library the index is incomplete.
+	if len(l.GetDefinitions()) == 0 {
+		return result
+	}
+
+	for _, ref := range libraryReferences(path, text) {
+		if len(l.GetGoToDefinition(ref.name)) > 0 {
+			continue
+		}
+
+		result = append(result, lsp.Diagnostic{
+			Code:     8,
+			Message:  fmt.Sprintf("Undefined library '%s'", ref.name),
+			Source:   "drupal-lsp",
+			Severity: lsp.SeverityError,
+			Range:    ref.Range(),
+		})
+	}
+
+	return result
+}
+
+// Describe the library under the cursor.
+func (l *Library) Hover(path string, text string, position lsp.Position) string {
+	for _, def := range l.DocumentDefinition(path, text, position) {
+		return def.Name + "\n" + def.Description
+	}
+
+	return ""
+}
+
+// Get the library under the cursor.
+func (l *Library) DocumentDefinition(path string, text string, position lsp.Position) []ParserDefinition {
+	for _, ref := range libraryReferences(path, text) {
+		r := ref.Range()
+		if r.Start.Line == position.Line && r.Start.Character <= position.Character && position.Character <= r.End.Character {
+			return l.GetGoToDefinition(ref.name)
+		}
+	}
+
+	return []ParserDefinition{}
+}
+
+func (l *Library) GetGoToDefinition(params string) []ParserDefinition {
+	result := make([]ParserDefinition, 0, 200)
+
+	for _, def := range l.GetDefinitions() {
+		if def.Name == params {
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Find the libraries referenced by a file.
+func libraryReferences(path string, text string) []nameRef {
+	result := []nameRef{}
+
+	switch {
+	case filepath.Ext(path) == ".yml":
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			start, ok := libraryYamlValue(path, lines, i)
+			if !ok {
+				continue
+			}
+
+			name, nameStart := libraryYamlName(line, start)
+			if name == "" {
+				continue
+			}
+			result = append(result, nameRef{name, i, nameStart, line})
+
+			// A library replaced by another one, eg. core/drupal.dialog: theme/dialog
+			if strings.HasSuffix(path, ".info.yml") && !strings.HasPrefix(strings.TrimLeft(line, " "), "- ") {
+				valueStart := strings.Index(line[nameStart:], ":")
+				if valueStart == -1 {
+					continue
+				}
+				valueStart += nameStart + 1
+				for valueStart < len(line) && line[valueStart] == ' ' {
+					valueStart++
+				}
+
+				if value, start := libraryYamlName(line, valueStart); strings.Contains(value, "/") {
+					result = append(result, nameRef{value, i, start, line})
+				}
+			}
+		}
+	case strings.HasSuffix(path, ".twig"):
+		for i, line := range strings.Split(text, "\n") {
+			for _, match := range libraryTwigRegex.FindAllStringSubmatchIndex(line, -1) {
+				result = append(result, nameRef{line[match[2]:match[3]], i, match[2], line})
+			}
+		}
+	case utils.InSlice(libraryPhpExtensions, filepath.Ext(path)):
+		src := []byte(text)
+
+		add := func(start int, end int) {
+			line, column := php.LineColumn(src, start)
+			result = append(result, nameRef{text[start:end], line, column, ""})
+		}
+
+		for _, match := range libraryAppendRegex.FindAllStringSubmatchIndex(text, -1) {
+			add(match[4], match[5])
+		}
+
+		for _, match := range libraryArrayRegex.FindAllStringIndex(text, -1) {
+			for _, item := range libraryArrayStrings(text, match[1]) {
+				add(item[0], item[1])
+			}
+		}
+	}
+
+	return result
+}
+
+// Get the offsets of the string literals of an array, until it's closed.
+// Strings that are concatenated, or that contain variables, are skipped.
+func libraryArrayStrings(text string, offset int) [][]int {
+	result := [][]int{}
+
+	depth := 0
+	for i := offset; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '[', '(':
+			depth++
+		case ']', ')':
+			depth--
+			if depth < 0 {
+				return result
+			}
+		case ';':
+			return result
+		case '\'', '"':
+			end := i + 1
+			for end < len(text) && text[end] != c {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(text) {
+				return result
+			}
+
+			before := strings.TrimRight(text[offset:i], " \t\r\n")
+			after := strings.TrimLeft(text[end+1:], " \t\r\n")
+			value := text[i+1 : end]
+			if depth == 0 && !strings.HasSuffix(before, ".") && !strings.HasPrefix(after, ".") && !strings.HasPrefix(after, "=>") && !strings.Contains(value, "$") {
+				result = append(result, []int{i + 1, end})
+			}
+
+			i = end
+		}
+	}
+
+	return result
+}
+
+// Check if the text ends inside a library array, eg. 'library' => ['core/drupal',
+// Strings and comments are skipped.
+func isLibraryArray(text string) bool {
+	open := []int{}
+
+	for i := 0; i < len(text); i++ {
+		next := byte(0)
+		if i+1 < len(text) {
+			next = text[i+1]
+		}
+
+		switch c := text[i]; {
+		case c == '[' || c == '(' || c == '{':
+			open = append(open, i)
+		case c == ']' || c == ')' || c == '}':
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+		case c == '\'' || c == '"':
+			for i++; i < len(text) && text[i] != c; i++ {
+				if text[i] == '\\' {
+					i++
+				}
+			}
+		case (c == '#' && next != '[') || (c == '/' && next == '/'):
+			end := strings.IndexByte(text[i:], '\n')
+			if end == -1 {
+				return false
+			}
+			i += end
+		case c == '/' && next == '*':
+			end := strings.Index(text[i+2:], "*/")
+			if end == -1 {
+				return false
+			}
+			i += end + 3
+		}
+	}
+
+	if len(open) == 0 {
+		return false
+	}
+	last := open[len(open)-1]
+
+	return text[last] != '{' && libraryKeyRegex.MatchString(text[:last])
+}
+
+// Get the column where a library starts if the line references one, eg.
+// the dependencies of a library, or libraries, libraries-extend and
+// libraries-override of an info.yml file.
+func libraryYamlValue(path string, lines []string, i int) (int, bool) {
+	if i >= len(lines) {
+		return 0, false
+	}
+
+	line := strings.TrimRight(lines[i], "\r")
+	content := strings.TrimLeft(line, " ")
+	indent := len(line) - len(content)
+	if indent == 0 || content == "" || strings.HasPrefix(content, "#") {
+		return 0, false
+	}
+
+	item := content == "-" || strings.HasPrefix(content, "- ")
+	start := indent
+	if item {
+		start += 2
+	}
+
+	parents := yamlParentKeys(lines, i)
+	if len(parents) == 0 {
+		return 0, false
+	}
+
+	switch {
+	case strings.HasSuffix(path, ".libraries.yml"):
+		return start, item && parents[0] == "dependencies"
+	case strings.HasSuffix(path, ".info.yml"):
+		switch parents[len(parents)-1] {
+		case "libraries":
+			return start, item && len(parents) == 1
+		case "libraries-extend":
+			return start, (!item && len(parents) == 1) || (item && len(parents) == 2)
+		case "libraries-override":
+			return start, !item && len(parents) == 1
+		}
+	}
+
+	return 0, false
+}
+
+// Get the name of a library at the given column of a yaml line, either a
+// key or a value, and the column where the name starts.
+func libraryYamlName(line string, start int) (string, int) {
+	if start >= len(line) {
+		return "", start
+	}
+
+	if line[start] == '\'' || line[start] == '"' {
+		start++
+	}
+
+	name := line[start:]
+	if end := strings.IndexAny(name, `'":#`); end != -1 {
+		name = name[:end]
+	}
+
+	return strings.TrimSpace(name), start
+}
+
+// Get the keys that enclose a line of a yaml file, nearest first.
+func yamlParentKeys(lines []string, i int) []string {
+	result := []string{}
+
+	line := strings.TrimRight(lines[i], "\r")
+	content := strings.TrimLeft(line, " ")
+	indent := len(line) - len(content)
+
+	// The items of a sequence may have the indentation of its key.
+	item := strings.HasPrefix(content, "-")
+
+	for j := i - 1; j >= 0 && indent > 0; j-- {
+		parent := strings.TrimRight(lines[j], "\r")
+		content := strings.TrimLeft(parent, " ")
+		parentIndent := len(parent) - len(content)
+		if content == "" || strings.HasPrefix(content, "#") || strings.HasPrefix(content, "-") {
+			continue
+		}
+
+		if parentIndent < indent || (item && parentIndent == indent) {
+			key := strings.TrimSpace(strings.SplitN(content, ":", 2)[0])
+			result = append(result, strings.Trim(key, `'"`))
+
+			indent = parentIndent
+			item = false
+		}
+	}
+
+	return result
+}
+
+// Summary of the library, its files and dependencies.
+func libraryDescription(library LibraryYaml) string {
+	lines := []string{}
+
+	css := []string{}
+	for category, files := range library.Css {
+		for file := range files {
+			css = append(css, fmt.Sprintf("  %s (%s)", file, category))
+		}
+	}
+	sort.Strings(css)
+
+	if len(css) > 0 {
+		lines = append(lines, "css:")
+		lines = append(lines, css...)
+	}
+
+	js := []string{}
+	for file := range library.Js {
+		js = append(js, "  "+file)
+	}
+	sort.Strings(js)
+
+	if len(js) > 0 {
+		lines = append(lines, "js:")
+		lines = append(lines, js...)
+	}
+
+	if len(library.Dependencies) > 0 {
+		lines = append(lines, "dependencies:")
+		for _, dependency := range library.Dependencies {
+			lines = append(lines, "  "+dependency)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
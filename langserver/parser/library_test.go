@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+var libraryDefinitions = []ParserDefinition{
+	{Name: "core/drupal", Description: "js:\n  misc/drupal.js"},
+	{Name: "core/drupal.dialog"},
+	{Name: "olivero/global-styling"},
+}
+
+func TestLibraryDocumentCompletion(t *testing.T) {
+	library := &Library{Definitions: libraryDefinitions}
+
+	tests := []struct {
+		path     string
+		text     string
+		expected bool
+	}{
+		{"/foo/foo.module", "$build['#attached'] = ['library' => ['core/dr", true},
+		{"/foo/foo.module", "$build['#attached'] = ['library' => [\n  'core/drupal',\n  'core/dr", true},
+		{"/foo/foo.module", "$build['#attached']['library'][] = 'core/", true},
+		{"/foo/foo.module", "$build['#attached'] = ['library' => array('core/dr", true},
+		// The string is closed.
+		{"/foo/foo.module", "$build['#attached'] = ['library' => ['core/drupal'", false},
+		// Escaped quotes don't close a string.
+		{"/foo/foo.module", "$build['#attached'] = ['library' => ['it\\'s", true},
+		{"/foo/foo.module", "$build['#attached'] = ['library' => ['it\\'s'", false},
+		// Quotes in comments.
+		{"/foo/foo.module", "$build['#attached'] = ['library' => [ // Don't", false},
+		{"/foo/foo.module", "$build['#attached'] = ['library' => [ /* Don't */ 'core/", true},
+		{"/foo/foo.module", "$build['#attached'] = ['library' => [ # Don't", false},
+		{"/foo/foo.module", "$build['#attached'] = ['library' => [\n  // Don't forget.\n  'core/", true},
+		{"/foo/foo.module", "$build['#attached'] = ['library' => ['core/drupal']];\n$items = ['core/", false},
+		// Not a library array.
+		{"/foo/foo.module", "$build['#markup'] = ['core/", false},
+		{"/foo/templates/foo.html.twig", "{{ attach_library('core/", true},
+		{"/foo/foo.libraries.yml", "foo:\n  dependencies:\n    - core/", true},
+		{"/foo/foo.info.yml", "libraries:\n  - olivero/", true},
+		{"/foo/foo.info.yml", "name: Foo\ndescription: core/", false},
+	}
+
+	for _, test := range tests {
+		text := "<?php\n" + test.text
+		lines := strings.Split(text, "\n")
+		position := lsp.Position{
+			Line:      float64(len(lines) - 1),
+			Character: float64(len(lines[len(lines)-1])),
+		}
+
+		items := library.DocumentCompletion(test.path, text, position)
+		if (len(items) > 0) != test.expected {
+			t.Errorf("DocumentCompletion(%q) = %d items, want %v", test.text, len(items), test.expected)
+		}
+	}
+}
+
+func TestOpenQuote(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected int
+	}{
+		{"'core/", 0},
+		{"'core/drupal', 'core/", 15},
+		{"'core/drupal'", -1},
+		{`"it\"s`, 0},
+		{`'it\'s'`, -1},
+		{`'a\\' . 'b`, 8},
+		{"// 'core/", -1},
+		{"# 'core/", -1},
+		{"/* 'a */ 'core/", 9},
+		{"/* 'a", -1},
+	}
+
+	for _, test := range tests {
+		if quote := openQuote(test.line); quote != test.expected {
+			t.Errorf("openQuote(%q) = %d, want %d", test.line, quote, test.expected)
+		}
+	}
+}
+
+func TestLibraryFileDiagnostics(t *testing.T) {
+	library := &Library{Definitions: libraryDefinitions}
+
+	tests := []struct {
+		path     string
+		text     string
+		expected []string
+	}{
+		{"/foo/foo.module", "<?php\n$build['#attached']['library'][] = 'core/missing';\n$build['#attached'] = ['library' => ['core/drupal', 'foo/' . $name, 'foo/bar']];\n", []string{"core/missing", "foo/bar"}},
+		{"/foo/foo.module", "<?php\n$build['#attached'] = ['library' => ['it\\'s', 'core/drupal']];\n", []string{"it\\'s"}},
+		{"/foo/templates/foo.html.twig", "{{ attach_library('olivero/missing') }}\n", []string{"olivero/missing"}},
+		{"/foo/foo.libraries.yml", "foo:\n  dependencies:\n    - core/drupal\n    - core/missing\n", []string{"core/missing"}},
+		{"/foo/foo.info.yml", "libraries-override:\n  core/drupal.dialog: foo/dialog\n  core/missing: false\n", []string{"core/missing", "foo/dialog"}},
+	}
+
+	for _, test := range tests {
+		names := []string{}
+		for _, diagnostic := range library.FileDiagnostics(test.path, test.text) {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(diagnostic.Message, "Undefined library '"), "'"))
+		}
+		sort.Strings(names)
+
+		if strings.Join(names, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("FileDiagnostics(%q) = %v, want %v", test.text, names, test.expected)
+		}
+	}
+}
+
+func TestLibraryHover(t *testing.T) {
+	library := &Library{Definitions: libraryDefinitions}
+
+	text := "<?php\n$build['#attached']['library'][] = 'core/drupal';\n"
+	if hover := library.Hover("/foo/foo.module", text, lsp.Position{Line: 1, Character: 40}); hover != "core/drupal\njs:\n  misc/drupal.js" {
+		t.Errorf("Hover() = %q", hover)
+	}
+
+	if hover := library.Hover("/foo/foo.module", text, lsp.Position{Line: 1, Character: 5}); hover != "" {
+		t.Errorf("Hover() = %q", hover)
+	}
+}
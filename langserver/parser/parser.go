@@ -1,6 +1,15 @@
 package parser
 
 import (
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/nkoporec/drupal-lsp/php"
+	"github.com/nkoporec/drupal-lsp/utils"
+
+	"github.com/z7zmey/php-parser/pkg/position"
 	lsp "go.lsp.dev/protocol"
 )
 
@@ -8,30 +17,568 @@ type Parser interface {
 	FileExtension() string
 	ParseFile(path string) interface{}
 	AddDefinitions(files []string)
+	RemoveDefinitions(files []string)
 	Methods() []string
 	Diagnostics(text string, defs []ParserDefinition) []lsp.Diagnostic
 	GetDefinitions() []ParserDefinition
 	CompletionItem(def ParserDefinition) (lsp.CompletionItem, error)
-	GetGoToDefinition(params string) []string
+	GetGoToDefinition(params string) []ParserDefinition
+}
+
+// DocumentCompleter is implemented by parsers that offer completion
+// outside of a method call, eg. hook implementations.
+type DocumentCompleter interface {
+	DocumentCompletion(path string, text string, position lsp.Position) []lsp.CompletionItem
+}
+
+// ReferenceProvider is implemented by parsers that know where their
+// definitions are used across the workspace. UpdateReferences replaces
+// the references of a file once it's edited, ReferenceAt gets the name
+// used or declared under the cursor and its range.
+type ReferenceProvider interface {
+	AddReferences(files []string)
+	UpdateReferences(path string, text string)
+	GetReferences(name string, includeDeclaration bool) []lsp.Location
+	ReferenceAt(path string, text string, position lsp.Position) (string, lsp.Range, bool)
+}
+
+// RenameProvider is implemented by reference providers that find a name
+// in the current text of a file, so a rename doesn't use ranges indexed
+// before the file was edited.
+type RenameProvider interface {
+	RenameRanges(path string, text string, name string) []lsp.Range
+}
+
+// FileDiagnoser is implemented by parsers whose diagnostics depend on
+// the file, eg. yaml config files.
+type FileDiagnoser interface {
+	FileDiagnostics(path string, text string) []lsp.Diagnostic
+}
+
+// FileMatcher is implemented by parsers that index several kinds of
+// files, which can't be matched by FileExtension alone.
+type FileMatcher interface {
+	MatchFile(path string) bool
+}
+
+// HoverProvider is implemented by parsers that describe names outside
+// of a method call, eg. entity fields.
+type HoverProvider interface {
+	Hover(path string, text string, position lsp.Position) string
+}
+
+// DefinitionProvider is implemented by parsers that resolve names outside
+// of a method call, eg. asset libraries in render arrays.
+type DefinitionProvider interface {
+	DocumentDefinition(path string, text string, position lsp.Position) []ParserDefinition
+}
+
+// DocumentReferenceProvider is implemented by parsers that find the
+// references of a name under the cursor, eg. the subscribers of an event.
+type DocumentReferenceProvider interface {
+	DocumentReferences(path string, text string, position lsp.Position, includeDeclaration bool) []lsp.Location
+}
+
+// ClassIndexUser is implemented by parsers that need the php classes of
+// the workspace, eg. to check the class of a service.
+type ClassIndexUser interface {
+	SetClassIndex(classes func() map[string]PhpClass)
+}
+
+// CodeLensProvider is implemented by parsers that annotate a document,
+// eg. the event and priority of subscriber methods.
+type CodeLensProvider interface {
+	CodeLens(path string, text string) []lsp.CodeLens
+}
+
+// Files that can contain php code.
+var PhpFileExtensions = []string{
+	".php",
+	".module",
+	".install",
+	".theme",
+	".inc",
+	".profile",
 }
 
 type ParserDefinition struct {
-	Name      string
-	Class     string `yaml:"class"`
-	Arguments string `yaml:"arguments"`
-	Parent    string `yaml:"parent"`
-	Tags      string `yaml:"tags"`
+	Name  string
+	Class string
+
+	// Method on Class the definition points to, eg. a route controller.
+	Method string
+	// Kind of the definition, eg. the plugin type of a plugin.
+	Type string
+	// Extra information shown in the completion documentation.
+	Description string
+	// The file and position where the definition is declared.
+	File     string
+	Position lsp.Position
 }
 
 type PhpClass struct {
+	// Fully qualified name of the class.
 	Namespace   string
 	Path        string
 	Description string
+	// Range of the class name in its declaration.
+	Range lsp.Range
+	// One of class, interface, trait or enum.
+	Kind       string
+	Parent     string
+	Interfaces []string
+	// Methods declared by the class itself.
+	Methods []PhpMethod
+	// Properties declared by the class itself, without the $, and the
+	// traits it uses.
+	Properties []string
+	Traits     []string
+}
+
+type PhpMethod struct {
+	Name       string
+	Parameters []php.PhpParameter
+	// The class the method returns, static for fluent methods, or a
+	// builtin type.
+	ReturnType string
+	Static     bool
+	Visibility string
+	// Range of the method name in its declaration.
+	Range lsp.Range
+	Doc   PhpDoc
+}
+
+type PhpFunction struct {
+	Name string
+	// The namespace the function is declared in.
+	Namespace string
+	Path      string
+	// Range of the function name in its declaration.
+	Range      lsp.Range
+	Parameters []php.PhpParameter
+	Doc        PhpDoc
+}
+
+// The summary and the documented parameters of a docblock, keyed by their
+// name. It's empty for {@inheritdoc}, the docblock of the overridden
+// method applies.
+type PhpDoc struct {
+	Summary    string
+	Parameters map[string]PhpDocParameter
+}
+
+// A parameter as documented in a docblock.
+type PhpDocParameter struct {
+	Type        string
+	Description string
 }
 
 // Get all structs that implements Parser interface
 func InitParsers() map[string]Parser {
 	return map[string]Parser{
-		"service": &Service{},
+		"service":    &Service{},
+		"route":      &Route{},
+		"hook":       &Hook{},
+		"plugin":     &Plugin{},
+		"entity":     &Entity{},
+		"field":      &Field{},
+		"config":     &Config{},
+		"permission": &Permission{},
+		"library":    &Library{},
+		"twig":       &Twig{},
+		"event":      &Event{},
+	}
+}
+
+// Check if a file can contain php code.
+func phpFile(path string) bool {
+	return utils.InSlice(PhpFileExtensions, filepath.Ext(path))
+}
+
+// Remove the definitions declared in any of the files.
+func removeFileDefinitions(defs []ParserDefinition, files []string) []ParserDefinition {
+	if len(files) == 0 {
+		return defs
+	}
+
+	removed := make(map[string]bool, len(files))
+	for _, file := range files {
+		removed[file] = true
+	}
+
+	result := []ParserDefinition{}
+	for _, def := range defs {
+		if !removed[def.File] {
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Get the class declared before an offset of a php file, eg. the one
+// containing a method call.
+func classDeclarationAt(parsedDoc *php.ParsedDoc, offset int) *php.PhpClassDeclaration {
+	var result *php.PhpClassDeclaration
+
+	for _, declaration := range parsedDoc.Classes {
+		if declaration.Position == nil || declaration.Position.StartPos > offset {
+			continue
+		}
+
+		if result == nil || declaration.Position.StartPos > result.Position.StartPos {
+			result = declaration
+		}
+	}
+
+	return result
+}
+
+// Get the classes of the workspace by their fully qualified name, none
+// until the index is set.
+func classIndex(classes func() map[string]PhpClass) map[string]PhpClass {
+	if classes == nil {
+		return nil
+	}
+
+	return classes()
+}
+
+// Get the names of a class and of the classes it extends, closest first.
+// The chain stops at the first class that isn't indexed.
+func classAncestors(classes map[string]PhpClass, name string) []string {
+	result := []string{}
+
+	for depth := 0; name != "" && depth < 20; depth++ {
+		result = append(result, name)
+
+		class, ok := classes[name]
+		if !ok {
+			break
+		}
+		name = class.Parent
+	}
+
+	return result
+}
+
+// Get the names of a class and of every class and interface it inherits.
+func classInherited(classes map[string]PhpClass, name string) []string {
+	result := []string{}
+	seen := make(map[string]bool)
+
+	queue := []string{name}
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+
+		if item == "" || seen[item] {
+			continue
+		}
+		seen[item] = true
+		result = append(result, item)
+
+		if class, ok := classes[item]; ok {
+			queue = append(queue, class.Parent)
+			queue = append(queue, class.Interfaces...)
+		}
+	}
+
+	return result
+}
+
+// Convert a php node position to a lsp range.
+func NodeRange(src []byte, pos *position.Position) lsp.Range {
+	if pos == nil {
+		return lsp.Range{}
+	}
+
+	startLine, startChar := php.LineColumn(src, pos.StartPos)
+	endLine, endChar := php.LineColumn(src, pos.EndPos)
+
+	return lsp.Range{
+		Start: lsp.Position{
+			Line:      float64(startLine),
+			Character: float64(startChar),
+		},
+		End: lsp.Position{
+			Line:      float64(endLine),
+			Character: float64(endChar),
+		},
+	}
+}
+
+// Convert a php node position to a lsp range with the lines of its file,
+// to convert many positions of the same file.
+func LinesRange(lines *php.LineOffsets, pos *position.Position) lsp.Range {
+	if pos == nil {
+		return lsp.Range{}
 	}
+
+	startLine, startChar := lines.LineColumn(pos.StartPos)
+	endLine, endChar := lines.LineColumn(pos.EndPos)
+
+	return lsp.Range{
+		Start: lsp.Position{
+			Line:      float64(startLine),
+			Character: float64(startChar),
+		},
+		End: lsp.Position{
+			Line:      float64(endLine),
+			Character: float64(endChar),
+		},
+	}
+}
+
+// Convert the position of a php string literal to the range of its
+// content, without the quotes.
+func stringRange(src []byte, pos *position.Position) lsp.Range {
+	result := NodeRange(src, pos)
+	if pos == nil || pos.EndPos-pos.StartPos < 2 {
+		return result
+	}
+
+	result.Start.Character++
+	result.End.Character--
+
+	return result
+}
+
+// Strip quotes from a php string literal.
+func unquote(s string) string {
+	s = strings.Trim(s, "\"")
+	s = strings.Trim(s, "'")
+
+	return s
+}
+
+// Find the position of a key with the given indentation in a yaml file,
+// as yaml.v2 does not expose node positions.
+func yamlKeyPosition(src []byte, key string, indent string) (lsp.Position, bool) {
+	for i, line := range strings.Split(string(src), "\n") {
+		if !strings.HasPrefix(line, indent) {
+			continue
+		}
+
+		name := strings.TrimPrefix(line, indent)
+		name = strings.Trim(name, "\r")
+		if strings.HasPrefix(name, key+":") {
+			return lsp.Position{
+				Line:      float64(i),
+				Character: float64(len(indent)),
+			}, true
+		}
+
+		// Point to the name inside the quotes.
+		if strings.HasPrefix(name, "'"+key+"':") || strings.HasPrefix(name, "\""+key+"\":") {
+			return lsp.Position{
+				Line:      float64(i),
+				Character: float64(len(indent) + 1),
+			}, true
+		}
+	}
+
+	return lsp.Position{}, false
+}
+
+// Find the position of a key of a top level block in a yaml file, eg. a
+// service under services:, so the keys of other blocks are skipped.
+func yamlBlockKeyPosition(src []byte, block string, key string) (lsp.Position, bool) {
+	lines := strings.Split(string(src), "\n")
+
+	start := -1
+	for i, line := range lines {
+		if strings.HasPrefix(line, block+":") {
+			start = i + 1
+			break
+		}
+	}
+
+	if start == -1 {
+		return lsp.Position{}, false
+	}
+
+	// The block ends at the next top level key, and its keys have the
+	// indentation of its first line.
+	end, indent := len(lines), ""
+	for i := start; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		lineIndent := lines[i][:len(lines[i])-len(strings.TrimLeft(lines[i], " "))]
+		if lineIndent == "" {
+			end = i
+			break
+		}
+
+		if indent == "" {
+			indent = lineIndent
+		}
+	}
+
+	if indent == "" {
+		return lsp.Position{}, false
+	}
+
+	position, ok := yamlKeyPosition([]byte(strings.Join(lines[start:end], "\n")), key, indent)
+	position.Line += float64(start)
+
+	return position, ok
+}
+
+// Find the position of the value of a top level key in a yaml file.
+func yamlValuePosition(src []byte, key string) lsp.Position {
+	for i, line := range strings.Split(string(src), "\n") {
+		if !strings.HasPrefix(line, key+":") {
+			continue
+		}
+
+		value := strings.TrimPrefix(line, key+":")
+		value = strings.TrimLeft(value, " '\"")
+
+		return lsp.Position{
+			Line:      float64(i),
+			Character: float64(len(line) - len(value)),
+		}
+	}
+
+	return lsp.Position{}
+}
+
+// Get the text of a line before the position.
+func linePrefix(text string, position lsp.Position) (string, bool) {
+	lines := strings.Split(text, "\n")
+	if int(position.Line) >= len(lines) {
+		return "", false
+	}
+
+	line := lines[int(position.Line)]
+	if position.Character > LineCharacter(line, len(line)) {
+		return "", false
+	}
+
+	return line[:LineOffset(line, position.Character)], true
+}
+
+// Convert the character of a position, counted in UTF-16 code units as
+// the protocol requires, to a byte offset in its line. Characters past the
+// end of the line are clamped to it.
+func LineOffset(line string, character float64) int {
+	offset, count := 0, 0
+	for offset < len(line) && count < int(character) {
+		r, size := utf8.DecodeRuneInString(line[offset:])
+		count += utf16Len(r)
+		offset += size
+	}
+
+	return offset
+}
+
+// Convert a byte offset in a line to a character counted in UTF-16 code
+// units.
+func LineCharacter(line string, offset int) float64 {
+	if offset > len(line) {
+		offset = len(line)
+	}
+
+	count := 0
+	for _, r := range line[:offset] {
+		count += utf16Len(r)
+	}
+
+	return float64(count)
+}
+
+// Get the number of UTF-16 code units of a rune. Invalid runes count as one.
+func utf16Len(r rune) int {
+	if n := utf16.RuneLen(r); n > 0 {
+		return n
+	}
+
+	return 1
+}
+
+// A name referenced in a file, eg. a library, and where it starts. The
+// start is a byte offset in the text of the line, or a character if the
+// text isn't set.
+type nameRef struct {
+	name  string
+	line  int
+	start int
+	text  string
+}
+
+func (r nameRef) Range() lsp.Range {
+	start := float64(r.start)
+	if r.text != "" {
+		start = LineCharacter(r.text, r.start)
+	}
+
+	return lsp.Range{
+		Start: lsp.Position{
+			Line:      float64(r.line),
+			Character: start,
+		},
+		End: lsp.Position{
+			Line:      float64(r.line),
+			Character: start + LineCharacter(r.name, len(r.name)),
+		},
+	}
+}
+
+// Get the quoted string under the cursor and the column where its value
+// starts.
+func quotedStringAt(line string, character int) (string, int, bool) {
+	if character > len(line) {
+		return "", 0, false
+	}
+
+	start := strings.LastIndexAny(line[:character], `'"`)
+	end := strings.IndexAny(line[character:], `'"`)
+	if start == -1 || end == -1 {
+		return "", 0, false
+	}
+
+	return line[start+1 : character+end], start + 1, true
+}
+
+// Get the offset of the quote that opens the php string a line ends in, or
+// -1 if the line doesn't end in a string, eg. after a closed string or in
+// a comment. Escaped quotes don't close a string.
+func openQuote(line string) int {
+	quote := -1
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if quote != -1 {
+			if c == '\\' {
+				i++
+			} else if c == line[quote] {
+				quote = -1
+			}
+			continue
+		}
+
+		next := byte(0)
+		if i+1 < len(line) {
+			next = line[i+1]
+		}
+
+		switch {
+		case c == '\'' || c == '"':
+			quote = i
+		case (c == '#' && next != '[') || (c == '/' && next == '/'):
+			return -1
+		case c == '/' && next == '*':
+			end := strings.Index(line[i+2:], "*/")
+			if end == -1 {
+				return -1
+			}
+			i += end + 3
+		}
+	}
+
+	return quote
 }
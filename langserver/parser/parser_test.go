@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+// Index the classes of a test by their fully qualified name.
+func testClassIndex(classes []PhpClass) func() map[string]PhpClass {
+	index := make(map[string]PhpClass)
+	for _, class := range classes {
+		index[class.Namespace] = class
+	}
+
+	return func() map[string]PhpClass {
+		return index
+	}
+}
+
+// Write the files of a test to a temporary directory, keyed by their path
+// in it. The paths of the written files are returned in the same order.
+func writeFiles(t *testing.T, files [][2]string) (string, []string, func()) {
+	root, err := ioutil.TempDir("", "parser")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{}
+	for _, file := range files {
+		path := filepath.Join(root, file[0])
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(file[1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	return root, paths, func() {
+		os.RemoveAll(root)
+	}
+}
+
+func TestLineOffset(t *testing.T) {
+	// The emoji takes two UTF-16 code units and four bytes, the é one
+	// code unit and two bytes.
+	line := "a😀é b"
+
+	tests := []struct {
+		character float64
+		offset    int
+	}{
+		{0, 0},
+		{1, 1},
+		{3, 5},
+		{4, 7},
+		{6, 9},
+		// Past the end of the line.
+		{10, 9},
+	}
+
+	for _, test := range tests {
+		if offset := LineOffset(line, test.character); offset != test.offset {
+			t.Errorf("LineOffset(%v) = %d, want %d", test.character, offset, test.offset)
+		}
+
+		if test.character <= 6 {
+			if character := LineCharacter(line, test.offset); character != test.character {
+				t.Errorf("LineCharacter(%d) = %v, want %v", test.offset, character, test.character)
+			}
+		}
+	}
+
+	if prefix, ok := linePrefix("<?php\n"+line, lsp.Position{Line: 1, Character: 4}); !ok || prefix != "a😀é" {
+		t.Errorf("linePrefix() = %q, %v", prefix, ok)
+	}
+
+	if _, ok := linePrefix(line, lsp.Position{Line: 0, Character: 7}); ok {
+		t.Errorf("linePrefix() past the end of the line")
+	}
+}
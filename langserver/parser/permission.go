@@ -0,0 +1,571 @@
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nkoporec/drupal-lsp/php"
+
+	lsp "go.lsp.dev/protocol"
+	"gopkg.in/yaml.v2"
+)
+
+type Permission struct {
+	// Permissions declared in permissions.yml files, and the patterns of
+	// the ones built by permission callbacks, eg. "create * content".
+	Definitions []ParserDefinition
+}
+
+type PermissionYaml struct {
+	Title          string `yaml:"title"`
+	Description    string `yaml:"description"`
+	RestrictAccess bool   `yaml:"restrict access"`
+}
+
+// Calls that take a permission and the index of that argument.
+var permissionCalls = map[string]int{
+	"hasPermission":           0,
+	"allowedIfHasPermission":  1,
+	"allowedIfHasPermissions": 1,
+}
+
+// A permission being typed in php, eg. $account->hasPermission('access
+// or AccessResult::allowedIfHasPermissions($account, ['access content', 'adm
+var permissionCompletionRegex = regexp.MustCompile(`(?:hasPermission\(|allowedIfHasPermissions?\(\s*\$[\w>-]+\s*,\s*\[?(?:\s*['"][^'"]*['"]\s*,)*)\s*['"]([^'"]*)$`)
+
+// The permission keys of a permission callback, eg. "create $type content"
+// => [...] or $permissions['administer ' . $type] = [...].
+var permissionCallbackRegex = regexp.MustCompile(`(?:\[\s*(` + permissionKeyExpr + `)\s*\]\s*=|(` + permissionKeyExpr + `)\s*=>)\s*(?:\[|array\()`)
+
+const permissionKeyPart = `'[^'\n]*'|"[^"\n]*"|\$\w+(?:->\w+(?:\(\))?)*`
+const permissionKeyExpr = `(?:` + permissionKeyPart + `)(?:\s*\.\s*(?:` + permissionKeyPart + `))*`
+
+var permissionKeyPartRegex = regexp.MustCompile(permissionKeyPart)
+
+// Variables interpolated in a double quoted string.
+var permissionInterpolationRegex = regexp.MustCompile(`\{\$[^}]*\}|\$\w+(?:->\w+|\[[^\]]*\])*`)
+
+// Yaml keys that hold permissions, eg. _permission: 'access content'.
+var permissionYamlRegex = regexp.MustCompile(`^\s*(_permission|permissions):\s*`)
+
+func (p *Permission) ParseFile(path string) interface{} {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	permissions := map[string]interface{}{}
+	if err := yaml.Unmarshal(file, &permissions); err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return permissions
+}
+
+func (p *Permission) AddDefinitions(items []string) {
+	for _, file := range items {
+		item := p.ParseFile(file)
+		if item == nil {
+			continue
+		}
+
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		permissions := item.(map[string]interface{})
+		for name, value := range permissions {
+			if name == "permission_callbacks" {
+				callbacks, _ := value.([]interface{})
+				for _, callback := range callbacks {
+					if callback, ok := callback.(string); ok {
+						defs := permissionCallbackDefinitions(file, callback)
+						if position, ok := yamlKeyPosition(src, name, ""); ok {
+							for i := range defs {
+								defs[i].Position = position
+							}
+						}
+
+						p.Definitions = append(p.Definitions, defs...)
+					}
+				}
+				continue
+			}
+
+			// Decode the permission again to get its fields.
+			out, err := yaml.Marshal(value)
+			if err != nil {
+				continue
+			}
+
+			permission := PermissionYaml{}
+			if err := yaml.Unmarshal(out, &permission); err != nil {
+				continue
+			}
+
+			def := ParserDefinition{
+				Name:        name,
+				Type:        "permission",
+				Description: permissionDescription(name, permission),
+				File:        file,
+			}
+
+			if position, ok := yamlKeyPosition(src, name, ""); ok {
+				def.Position = position
+			}
+
+			p.Definitions = append(p.Definitions, def)
+		}
+	}
+}
+
+func (p *Permission) RemoveDefinitions(items []string) {
+	p.Definitions = removeFileDefinitions(p.Definitions, items)
+}
+
+func (p *Permission) FileExtension() string {
+	return "permissions.yml"
+}
+
+func (p *Permission) Methods() []string {
+	methods := []string{}
+	for method := range permissionCalls {
+		methods = append(methods, method)
+	}
+
+	return methods
+}
+
+func (p *Permission) GetDefinitions() []ParserDefinition {
+	return p.Definitions
+}
+
+func (p *Permission) CompletionItem(def ParserDefinition) (lsp.CompletionItem, error) {
+	return lsp.CompletionItem{
+		Kind:   lsp.ValueCompletion,
+		Label:  def.Name,
+		Detail: strings.SplitN(def.Description, "\n", 2)[0],
+		Documentation: lsp.MarkupContent{
+			Kind:  lsp.PlainText,
+			Value: def.Description,
+		},
+	}, nil
+}
+
+// Complete the permissions of access checks in php, route requirements and
+// menu links.
+func (p *Permission) DocumentCompletion(path string, text string, position lsp.Position) []lsp.CompletionItem {
+	result := []lsp.CompletionItem{}
+
+	line, ok := linePrefix(text, position)
+	if !ok {
+		return result
+	}
+
+	start := -1
+	if filepath.Ext(path) == ".yml" {
+		lines := strings.Split(text, "\n")
+		if valueStart, ok := permissionYamlValue(path, lines, int(position.Line)); ok && valueStart <= len(line) {
+			// The permission after the last separator, eg. 'a+b.
+			start = strings.LastIndexAny(line, `'"[+,`) + 1
+			if start < valueStart {
+				start = valueStart
+			}
+			for start < len(line) && line[start] == ' ' {
+				start++
+			}
+		}
+	} else if match := permissionCompletionRegex.FindStringSubmatchIndex(line); match != nil {
+		start = match[2]
+	}
+
+	if start == -1 {
+		return result
+	}
+
+	// Permissions contain spaces, so replace everything that was typed.
+	editRange := lsp.Range{
+		Start: lsp.Position{
+			Line:      position.Line,
+			Character: LineCharacter(line, start),
+		},
+		End: position,
+	}
+
+	for _, def := range p.GetDefinitions() {
+		if def.Type != "permission" {
+			continue
+		}
+
+		completion, err := p.CompletionItem(def)
+		if err != nil {
+			continue
+		}
+
+		completion.FilterText = def.Name
+		completion.TextEdit = &lsp.TextEdit{
+			Range:   editRange,
+			NewText: def.Name,
+		}
+
+		result = append(result, completion)
+	}
+
+	return result
+}
+
+func (p *Permission) Diagnostics(text string, defs []ParserDefinition) []lsp.Diagnostic {
+	result := []lsp.Diagnostic{}
+	src := []byte(text)
+
+	// Skip files that don't check a permission.
+	if !strings.Contains(text, "Permission") {
+		return result
+	}
+
+	parsedDoc, err := php.Parse(src)
+	if err != nil {
+		log.Println(err)
+		return result
+	}
+
+	args := []*php.PhpClassArgument{}
+
+	// $account->hasPermission('access content')
+	for _, call := range parsedDoc.MethodCalls {
+		if call.Method.Name == "hasPermission" && len(call.Args) > 0 {
+			args = append(args, call.Args[0])
+		}
+	}
+
+	// AccessResult::allowedIfHasPermission($account, 'access content') and
+	// AccessResult::allowedIfHasPermissions($account, ['access content'])
+	for _, static := range parsedDoc.StaticCalls {
+		if static.Method == nil || len(static.Args) < 2 {
+			continue
+		}
+
+		switch static.Method.Name {
+		case "allowedIfHasPermission":
+			args = append(args, static.Args[1])
+		case "allowedIfHasPermissions":
+			args = append(args, static.Args[1].Items...)
+		}
+	}
+
+	for _, arg := range args {
+		// Only check string literals.
+		if !strings.HasPrefix(arg.Name, "'") && !strings.HasPrefix(arg.Name, "\"") {
+			continue
+		}
+
+		name := unquote(arg.Name)
+		if !hasPermission(defs, name) {
+			result = append(result, permissionDiagnostic(name, NodeRange(src, arg.Position)))
+		}
+	}
+
+	return result
+}
+
+// Check the permissions of route requirements and menu links.
+func (p *Permission) FileDiagnostics(path string, text string) []lsp.Diagnostic {
+	result := []lsp.Diagnostic{}
+
+	if filepath.Ext(path) != ".yml" {
+		return result
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		start, ok := permissionYamlValue(path, lines, i)
+		if !ok {
+			continue
+		}
+
+		for _, ref := range splitPermissions(line, start) {
+			if hasPermission(p.GetDefinitions(), ref.name) {
+				continue
+			}
+
+			result = append(result, permissionDiagnostic(ref.name, lsp.Range{
+				Start: lsp.Position{
+					Line:      float64(i),
+					Character: LineCharacter(line, ref.start),
+				},
+				End: lsp.Position{
+					Line:      float64(i),
+					Character: LineCharacter(line, ref.start+len(ref.name)),
+				},
+			}))
+		}
+	}
+
+	return result
+}
+
+// Describe the permission under the cursor.
+func (p *Permission) Hover(path string, text string, position lsp.Position) string {
+	lines := strings.Split(text, "\n")
+	if int(position.Line) >= len(lines) {
+		return ""
+	}
+
+	line := lines[int(position.Line)]
+	character := LineOffset(line, position.Character)
+
+	name := ""
+	if filepath.Ext(path) == ".yml" {
+		start, ok := permissionYamlValue(path, lines, int(position.Line))
+		if !ok {
+			return ""
+		}
+
+		for _, ref := range splitPermissions(line, start) {
+			if character >= ref.start && character <= ref.start+len(ref.name) {
+				name = ref.name
+			}
+		}
+	} else {
+		name, _, _ = quotedStringAt(line, character)
+	}
+
+	for _, def := range p.GetGoToDefinition(name) {
+		if def.Type == "permission" {
+			return def.Description
+		}
+	}
+
+	return ""
+}
+
+func (p *Permission) GetGoToDefinition(params string) []ParserDefinition {
+	result := make([]ParserDefinition, 0, 200)
+
+	for _, def := range p.GetDefinitions() {
+		if def.Name == params {
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Check if a permission exists, either declared or matching the pattern of
+// a permission callback.
+func hasPermission(defs []ParserDefinition, name string) bool {
+	found := false
+	for _, def := range defs {
+		if def.Name == name {
+			return true
+		}
+
+		switch def.Type {
+		case "permission":
+			// Without any permission the index is incomplete.
+			found = true
+		case "callback":
+			if permissionMatches(def.Name, name) {
+				return true
+			}
+		}
+	}
+
+	return !found
+}
+
+// Check if a permission matches the pattern of a permission callback,
+// where a wildcard stands for one or more characters, eg. create * content.
+func permissionMatches(pattern string, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	rest := name[len(parts[0]):]
+
+	// The first match of a part leaves the most characters to the next
+	// ones.
+	for _, part := range parts[1 : len(parts)-1] {
+		if rest == "" {
+			return false
+		}
+
+		index := strings.Index(rest[1:], part)
+		if index == -1 {
+			return false
+		}
+		rest = rest[1+index+len(part):]
+	}
+
+	last := parts[len(parts)-1]
+
+	return len(rest) > len(last) && strings.HasSuffix(rest, last)
+}
+
+func permissionDiagnostic(name string, r lsp.Range) lsp.Diagnostic {
+	return lsp.Diagnostic{
+		Code:     7,
+		Message:  fmt.Sprintf("Undefined permission '%s'", name),
+		Source:   "drupal-lsp",
+		Severity: lsp.SeverityError,
+		Range:    r,
+	}
+}
+
+// Summary of the permission, the first line is its title.
+func permissionDescription(name string, permission PermissionYaml) string {
+	lines := []string{name}
+	if permission.Title != "" {
+		lines[0] = permission.Title
+	}
+
+	if permission.Description != "" {
+		lines = append(lines, permission.Description)
+	}
+
+	return strings.Join(append(lines, fmt.Sprintf("restrict access: %t", permission.RestrictAccess)), "\n")
+}
+
+// Read the permissions of a callback, eg. \Drupal\node\NodePermissions::
+// nodeTypePermissions. The callback class is looked up in the module of the
+// permissions.yml file. Parts of the names that are built from variables
+// are replaced by a wildcard. The permissions belong to the permissions.yml
+// file, so they are reindexed with it.
+func permissionCallbackDefinitions(file string, callback string) []ParserDefinition {
+	result := []ParserDefinition{}
+
+	parts := strings.SplitN(strings.TrimPrefix(callback, "\\"), "::", 2)
+	module := strings.TrimSuffix(filepath.Base(file), ".permissions.yml")
+	prefix := "Drupal\\" + module + "\\"
+	if !strings.HasPrefix(parts[0], prefix) {
+		return result
+	}
+
+	classFile := filepath.Join(filepath.Dir(file), "src", filepath.FromSlash(strings.Replace(strings.TrimPrefix(parts[0], prefix), "\\", "/", -1))+".php")
+	src, err := ioutil.ReadFile(classFile)
+	if err != nil {
+		return result
+	}
+
+	for _, match := range permissionCallbackRegex.FindAllSubmatchIndex(src, -1) {
+		start, end := match[2], match[3]
+		if start == -1 {
+			start, end = match[4], match[5]
+		}
+
+		name := ""
+		for _, part := range permissionKeyPartRegex.FindAllString(string(src[start:end]), -1) {
+			switch part[0] {
+			case '\'':
+				name += strings.Trim(part, "'")
+			case '"':
+				name += permissionInterpolationRegex.ReplaceAllString(strings.Trim(part, "\""), "*")
+			default:
+				name += "*"
+			}
+		}
+
+		// Keys that can't be told apart from other arrays.
+		if strings.Trim(name, "* ") == "" || name == "dependencies" {
+			continue
+		}
+
+		result = append(result, ParserDefinition{
+			Name:        name,
+			Class:       parts[0],
+			Type:        "callback",
+			Description: callback,
+			File:        file,
+		})
+	}
+
+	return result
+}
+
+// Get the column where the permissions start if the line holds them, eg.
+// _permission: in routing.yml or permissions: in links.menu.yml, including
+// the items of a permissions: list.
+func permissionYamlValue(path string, lines []string, i int) (int, bool) {
+	if i >= len(lines) {
+		return 0, false
+	}
+
+	key := "_permission"
+	if strings.HasSuffix(path, ".links.menu.yml") {
+		key = "permissions"
+	} else if !strings.HasSuffix(path, ".routing.yml") {
+		return 0, false
+	}
+
+	line := lines[i]
+	if match := permissionYamlRegex.FindStringSubmatchIndex(line); match != nil {
+		return match[1], line[match[2]:match[3]] == key
+	}
+
+	// A list item, eg. - 'access content', under permissions:
+	content := strings.TrimLeft(line, " ")
+	if key != "permissions" || !strings.HasPrefix(content, "- ") {
+		return 0, false
+	}
+	indent := len(line) - len(content)
+
+	for j := i - 1; j >= 0; j-- {
+		parent := strings.TrimLeft(lines[j], " ")
+		if parent == "" || strings.HasPrefix(parent, "- ") {
+			continue
+		}
+
+		if len(lines[j])-len(parent) <= indent {
+			return indent + 2, strings.TrimSpace(parent) == "permissions:"
+		}
+	}
+
+	return 0, false
+}
+
+type permissionRef struct {
+	name  string
+	start int
+}
+
+// Split the permissions of a yaml value and get their columns, eg. 'a+b',
+// a,b or [a, b].
+func splitPermissions(line string, start int) []permissionRef {
+	result := []permissionRef{}
+
+	if comment := strings.Index(line, " #"); comment != -1 && comment >= start {
+		line = line[:comment]
+	}
+
+	for start < len(line) {
+		end := strings.IndexAny(line[start:], `'"[]+,`)
+		if end == -1 {
+			end = len(line)
+		} else {
+			end += start
+		}
+
+		name := strings.TrimSpace(line[start:end])
+		if name != "" {
+			result = append(result, permissionRef{
+				name:  name,
+				start: start + strings.Index(line[start:end], name),
+			})
+		}
+
+		start = end + 1
+	}
+
+	return result
+}
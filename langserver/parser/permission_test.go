@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+var permissionDefinitions = []ParserDefinition{
+	{Name: "access content", Type: "permission", Description: "Access content"},
+	{Name: "administer nodes", Type: "permission", Description: "Administer nodes"},
+	{Name: "create * content", Type: "callback"},
+	{Name: "edit own * in *", Type: "callback"},
+}
+
+func TestPermissionDiagnostics(t *testing.T) {
+	permission := &Permission{Definitions: permissionDefinitions}
+
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		{"$account->hasPermission('access content');", []string{}},
+		{"$account->hasPermission('access contents');", []string{"access contents"}},
+		{"$account->hasPermission($permission);", []string{}},
+		{"AccessResult::allowedIfHasPermission($account, 'administer node');", []string{"administer node"}},
+		// Every permission of the list is checked.
+		{"AccessResult::allowedIfHasPermissions($account, ['access content', 'administer node', $other], 'OR');", []string{"administer node"}},
+		{"AccessResult::allowedIfHasPermissions($account, array('access contents', 'administer nodes'));", []string{"access contents"}},
+		// Permissions of callbacks.
+		{"$account->hasPermission('create article content');", []string{}},
+		{"$account->hasPermission('create  content');", []string{"create  content"}},
+		{"$account->hasPermission('edit own tags in article');", []string{}},
+	}
+
+	for _, test := range tests {
+		names := []string{}
+		for _, diagnostic := range permission.Diagnostics("<?php\n"+test.text+"\n", permission.GetDefinitions()) {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(diagnostic.Message, "Undefined permission '"), "'"))
+		}
+
+		if strings.Join(names, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("Diagnostics(%q) = %v, want %v", test.text, names, test.expected)
+		}
+	}
+}
+
+func TestPermissionMatches(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"access content", "access content", true},
+		{"access content", "access contents", false},
+		{"create * content", "create article content", true},
+		{"create * content", "create  content", false},
+		{"create * content", "create content", false},
+		{"create * content", "create article content type", false},
+		{"*", "a", true},
+		{"*", "", false},
+		{"* *", "a b", true},
+		{"edit own * in *", "edit own tags in article", true},
+		{"edit own * in *", "edit own in in in", true},
+		{"edit own * in *", "edit own tags in ", false},
+		{"a*a", "aa", false},
+		{"a*a", "aba", true},
+	}
+
+	for _, test := range tests {
+		if matched := permissionMatches(test.pattern, test.name); matched != test.expected {
+			t.Errorf("permissionMatches(%q, %q) = %v, want %v", test.pattern, test.name, matched, test.expected)
+		}
+	}
+}
+
+func TestPermissionFileDiagnostics(t *testing.T) {
+	permission := &Permission{Definitions: permissionDefinitions}
+
+	tests := []struct {
+		path     string
+		text     string
+		expected []string
+	}{
+		{"/foo/foo.routing.yml", "foo.page:\n  requirements:\n    _permission: 'access content+administer node'\n", []string{"administer node"}},
+		{"/foo/foo.routing.yml", "foo.page:\n  requirements:\n    _permission: 'access content,create page content' # administer node\n", []string{}},
+		{"/foo/foo.links.menu.yml", "foo.page:\n  permissions:\n    - 'access contents'\n    - 'administer nodes'\n", []string{"access contents"}},
+		// Only routes and menu links hold permissions.
+		{"/foo/foo.services.yml", "foo:\n  _permission: 'access contents'\n", []string{}},
+	}
+
+	for _, test := range tests {
+		names := []string{}
+		for _, diagnostic := range permission.FileDiagnostics(test.path, test.text) {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(diagnostic.Message, "Undefined permission '"), "'"))
+		}
+
+		if strings.Join(names, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("FileDiagnostics(%q) = %v, want %v", test.text, names, test.expected)
+		}
+	}
+}
+
+func TestPermissionDocumentCompletion(t *testing.T) {
+	permission := &Permission{Definitions: permissionDefinitions}
+
+	tests := []struct {
+		path     string
+		line     string
+		expected string
+	}{
+		{"/foo/foo.module", "$account->hasPermission('acc", "access content,administer nodes"},
+		{"/foo/foo.module", "AccessResult::allowedIfHasPermissions($account, ['access content', 'adm", "access content,administer nodes"},
+		{"/foo/foo.module", "$account->getPermission('", ""},
+		{"/foo/foo.routing.yml", "    _permission: 'access content+adm", "access content,administer nodes"},
+	}
+
+	for _, test := range tests {
+		text := "<?php\n" + test.line
+		items := permission.DocumentCompletion(test.path, text, lsp.Position{Line: 1, Character: float64(len(test.line))})
+
+		labels := []string{}
+		for _, item := range items {
+			labels = append(labels, item.Label)
+		}
+		sort.Strings(labels)
+
+		if strings.Join(labels, ",") != test.expected {
+			t.Errorf("DocumentCompletion(%q) = %v, want %s", test.line, labels, test.expected)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nkoporec/drupal-lsp/php"
+	"github.com/nkoporec/drupal-lsp/utils"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+type Plugin struct {
+	Definitions []ParserDefinition
+}
+
+// Plugin managers whose service name doesn't follow the name of their
+// plugin type, eg. plugin.manager.block manages Block plugins.
+var pluginManagers = map[string]string{
+	"plugin.manager.field.field_type":           "FieldType",
+	"plugin.manager.migrate.process":            "MigrateProcessPlugin",
+	"plugin.manager.entity_reference_selection": "EntityReferenceSelection",
+	"plugin.manager.queue_worker":               "QueueWorker",
+}
+
+// Attributes that are named differently than the annotation of the same
+// plugin type.
+var pluginAttributes = map[string]string{
+	"MigrateProcess": "MigrateProcessPlugin",
+}
+
+// Annotations that describe something else than a plugin.
+var pluginIgnoredAnnotations = []string{
+	"Translation",
+	"PluralTranslation",
+	"ContextDefinition",
+}
+
+// A createInstance() call where the plugin id is being typed, with the
+// service or the variable it's called on.
+var pluginCompletionRegex = regexp.MustCompile(`(?:\\?Drupal::service\(\s*['"]([\w.]+)['"]\s*\)|\$this->(\w+)|\$(\w+))\s*->\s*createInstance\(\s*['"]?[\w:.-]*$`)
+
+// The plugin of a block config entity, eg. block.block.olivero_branding.yml.
+var pluginBlockYamlRegex = regexp.MustCompile(`^plugin:\s*['"]?[\w:.-]*$`)
+
+func (p *Plugin) ParseFile(path string) interface{} {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	parsedDoc, err := php.Parse(file)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return parsedDoc
+}
+
+func (p *Plugin) AddDefinitions(items []string) {
+	for _, file := range items {
+		if filepath.Ext(file) != ".php" {
+			continue
+		}
+
+		item := p.ParseFile(file)
+		if item == nil {
+			continue
+		}
+
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		parsedDoc := item.(*php.ParsedDoc)
+		for _, class := range parsedDoc.Classes {
+			for _, annotation := range class.Annotations {
+				id := annotation.String("id")
+				if id == "" || utils.InSlice(pluginIgnoredAnnotations, annotation.Name) {
+					continue
+				}
+
+				pluginType := annotation.Name
+				if name, ok := pluginAttributes[pluginType]; ok {
+					pluginType = name
+				}
+
+				p.Definitions = append(p.Definitions, ParserDefinition{
+					Name:        id,
+					Class:       class.Name,
+					Type:        pluginType,
+					Description: pluginDescription(annotation, class.Name),
+					File:        file,
+					Position:    stringRange(src, annotation.Positions["id"]).Start,
+				})
+			}
+		}
+	}
+}
+
+func (p *Plugin) RemoveDefinitions(items []string) {
+	p.Definitions = removeFileDefinitions(p.Definitions, items)
+}
+
+func (p *Plugin) FileExtension() string {
+	return "/Plugin/"
+}
+
+// Plugins are in a Plugin directory, the ones of core in core/lib as well,
+// eg. core/lib/Drupal/Core/Block/Plugin/Block.
+func (p *Plugin) MatchFile(path string) bool {
+	return filepath.Ext(path) == ".php" && strings.Contains(path, "/Plugin/")
+}
+
+func (p *Plugin) Methods() []string {
+	return []string{
+		"createInstance",
+	}
+}
+
+func (p *Plugin) GetDefinitions() []ParserDefinition {
+	return p.Definitions
+}
+
+func (p *Plugin) CompletionItem(def ParserDefinition) (lsp.CompletionItem, error) {
+	return lsp.CompletionItem{
+		Kind:   lsp.ValueCompletion,
+		Label:  def.Name,
+		Detail: fmt.Sprintf("%s plugin", def.Type),
+		Documentation: lsp.MarkupContent{
+			Kind:  lsp.PlainText,
+			Value: def.Description,
+		},
+	}, nil
+}
+
+// Complete the plugin ids of createInstance() calls and of block config
+// entities.
+func (p *Plugin) DocumentCompletion(path string, text string, position lsp.Position) []lsp.CompletionItem {
+	result := []lsp.CompletionItem{}
+
+	line, ok := linePrefix(text, position)
+	if !ok {
+		return result
+	}
+
+	pluginType := ""
+	switch {
+	case isBlockYaml(path):
+		if !pluginBlockYamlRegex.MatchString(line) {
+			return result
+		}
+		pluginType = "Block"
+	case filepath.Ext(path) != ".yml":
+		match := pluginCompletionRegex.FindStringSubmatch(line)
+		if match == nil {
+			return result
+		}
+
+		// Without a known manager every plugin is offered.
+		for _, name := range match[1:] {
+			if name != "" {
+				pluginType = p.managerType(name)
+			}
+		}
+	default:
+		return result
+	}
+
+	for _, def := range p.GetDefinitions() {
+		if pluginType != "" && def.Type != pluginType {
+			continue
+		}
+
+		completion, err := p.CompletionItem(def)
+		if err != nil {
+			continue
+		}
+
+		result = append(result, completion)
+	}
+
+	return result
+}
+
+func (p *Plugin) Diagnostics(text string, defs []ParserDefinition) []lsp.Diagnostic {
+	result := []lsp.Diagnostic{}
+	src := []byte(text)
+
+	// Skip files that can't create a plugin.
+	if !strings.Contains(text, "createInstance") {
+		return result
+	}
+
+	parsedDoc, err := php.Parse(src)
+	if err != nil {
+		log.Println(err)
+		return result
+	}
+
+	for _, call := range parsedDoc.MethodCalls {
+		if call.Method.Name != "createInstance" || len(call.Args) == 0 || call.Args[0].Name == "" {
+			continue
+		}
+
+		name := call.Property
+		if name == "" {
+			name = call.Var
+		}
+		if call.StaticCall != nil && call.StaticCall.Method != nil && call.StaticCall.Method.Name == "service" && len(call.StaticCall.Args) == 1 {
+			name = unquote(call.StaticCall.Args[0].Name)
+		}
+
+		// Only check calls on a known plugin manager.
+		pluginType := p.managerType(name)
+		if pluginType == "" {
+			continue
+		}
+
+		id := unquote(call.Args[0].Name)
+		if !hasPlugin(defs, pluginType, id) {
+			result = append(result, pluginDiagnostic(pluginType, id, NodeRange(src, call.Args[0].Position)))
+		}
+	}
+
+	return result
+}
+
+// Check the plugin of block config entities.
+func (p *Plugin) FileDiagnostics(path string, text string) []lsp.Diagnostic {
+	result := []lsp.Diagnostic{}
+
+	if !isBlockYaml(path) {
+		return result
+	}
+
+	for i, line := range strings.Split(text, "\n") {
+		if !strings.HasPrefix(line, "plugin:") {
+			continue
+		}
+
+		value := strings.TrimSpace(strings.TrimPrefix(line, "plugin:"))
+		id := unquote(value)
+		if id == "" || hasPlugin(p.GetDefinitions(), "Block", id) {
+			continue
+		}
+
+		start := strings.Index(line, id)
+		result = append(result, pluginDiagnostic("Block", id, lsp.Range{
+			Start: lsp.Position{
+				Line:      float64(i),
+				Character: LineCharacter(line, start),
+			},
+			End: lsp.Position{
+				Line:      float64(i),
+				Character: LineCharacter(line, start+len(id)),
+			},
+		}))
+	}
+
+	return result
+}
+
+func (p *Plugin) GetGoToDefinition(params string) []ParserDefinition {
+	result := make([]ParserDefinition, 0, 200)
+
+	for _, def := range p.GetDefinitions() {
+		if def.Name == params {
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Get the plugin type of a manager, by its service name or the name of
+// the variable it's stored in, eg. plugin.manager.block or $blockManager.
+// Returns an empty string if the plugin type is unknown.
+func (p *Plugin) managerType(name string) string {
+	if pluginType, ok := pluginManagers[name]; ok {
+		return pluginType
+	}
+
+	normalize := func(s string) string {
+		s = strings.TrimPrefix(s, "plugin.manager.")
+		s = strings.ToLower(s)
+		s = strings.NewReplacer("_", "", ".", "").Replace(s)
+		s = strings.TrimSuffix(s, "manager")
+		s = strings.TrimSuffix(s, "plugin")
+
+		return s
+	}
+
+	name = normalize(name)
+	if name == "" {
+		return ""
+	}
+
+	for _, def := range p.GetDefinitions() {
+		if normalize(def.Type) == name {
+			return def.Type
+		}
+	}
+
+	return ""
+}
+
+// Check if a plugin exists. Derivative ids, eg. system_menu_block:main,
+// are checked by their base id.
+func hasPlugin(defs []ParserDefinition, pluginType string, id string) bool {
+	base := strings.SplitN(id, ":", 2)[0]
+
+	found := false
+	for _, def := range defs {
+		if def.Type != pluginType {
+			continue
+		}
+
+		// Without any plugin of the type the index is incomplete.
+		found = true
+		if def.Name == id || def.Name == base {
+			return true
+		}
+	}
+
+	return !found
+}
+
+func pluginDiagnostic(pluginType string, id string, r lsp.Range) lsp.Diagnostic {
+	return lsp.Diagnostic{
+		Code:     4,
+		Message:  fmt.Sprintf("Undefined %s plugin '%s'", pluginType, id),
+		Source:   "drupal-lsp",
+		Severity: lsp.SeverityError,
+		Range:    r,
+	}
+}
+
+func isBlockYaml(path string) bool {
+	name := filepath.Base(path)
+
+	return strings.HasPrefix(name, "block.block.") && strings.HasSuffix(name, ".yml")
+}
+
+// Summary of the plugin, its label and class.
+func pluginDescription(annotation *php.PhpAnnotation, class string) string {
+	lines := []string{}
+
+	for _, key := range []string{"admin_label", "label", "title"} {
+		if label := annotation.String(key); label != "" {
+			lines = append(lines, label)
+			break
+		}
+	}
+
+	return strings.Join(append(lines, class), "\n")
+}
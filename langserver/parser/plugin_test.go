@@ -0,0 +1,192 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+// A field type of core, in core/lib.
+const pluginStringItem = `<?php
+
+namespace Drupal\Core\Field\Plugin\Field\FieldType;
+
+use Drupal\Core\Field\FieldItemBase;
+
+/**
+ * Defines the 'string' entity field type.
+ *
+ * @FieldType(
+ *   id = "string",
+ *   label = @Translation("Text (plain)"),
+ *   default_widget = "string_textfield",
+ * )
+ */
+class StringItem extends FieldItemBase {
+}
+`
+
+// A block of core, in core/lib.
+const pluginPageTitleBlock = `<?php
+
+namespace Drupal\Core\Block\Plugin\Block;
+
+use Drupal\Core\Block\BlockBase;
+
+/**
+ * Provides a block to display the page title.
+ *
+ * @Block(
+ *   id = "page_title_block",
+ *   admin_label = @Translation("Page title"),
+ * )
+ */
+class PageTitleBlock extends BlockBase {
+}
+`
+
+// A block of a module, declared with an attribute.
+const pluginFooBlock = `<?php
+
+namespace Drupal\foo\Plugin\Block;
+
+use Drupal\Core\Block\Attribute\Block;
+use Drupal\Core\Block\BlockBase;
+use Drupal\Core\StringTranslation\TranslatableMarkup;
+
+#[Block(
+  id: 'foo_block',
+  admin_label: new TranslatableMarkup('Foo'),
+)]
+class FooBlock extends BlockBase {
+
+  public function build() {
+    $block = \Drupal::service('plugin.manager.block')->createInstance('page_title_block');
+    $this->blockManager->createInstance('missing_block');
+    $this->fieldTypeManager->createInstance('string');
+    return [];
+  }
+
+}
+`
+
+func pluginFixture(t *testing.T) (*Plugin, []string, func()) {
+	_, paths, cleanup := writeFiles(t, [][2]string{
+		{"core/lib/Drupal/Core/Field/Plugin/Field/FieldType/StringItem.php", pluginStringItem},
+		{"core/lib/Drupal/Core/Block/Plugin/Block/PageTitleBlock.php", pluginPageTitleBlock},
+		{"modules/foo/src/Plugin/Block/FooBlock.php", pluginFooBlock},
+		{"modules/foo/src/Controller/FooController.php", "<?php\n\nclass FooController {\n}\n"},
+	})
+
+	plugin := &Plugin{}
+	items := []string{}
+	for _, path := range paths {
+		if plugin.MatchFile(path) {
+			items = append(items, path)
+		}
+	}
+	plugin.AddDefinitions(items)
+
+	return plugin, paths, cleanup
+}
+
+func TestPluginDefinitions(t *testing.T) {
+	plugin, paths, cleanup := pluginFixture(t)
+	defer cleanup()
+
+	if plugin.MatchFile(paths[3]) {
+		t.Errorf("MatchFile(%s) = true", paths[3])
+	}
+
+	names := []string{}
+	for _, def := range plugin.GetDefinitions() {
+		names = append(names, def.Type+":"+def.Name)
+	}
+
+	if strings.Join(names, " ") != "FieldType:string Block:page_title_block Block:foo_block" {
+		t.Errorf("GetDefinitions() = %v", names)
+	}
+
+	if def := plugin.GetDefinitions()[1]; def.Description != "Page title\nDrupal\\Core\\Block\\Plugin\\Block\\PageTitleBlock" || def.Position.Line != 10 {
+		t.Errorf("GetDefinitions()[1] = %+v", def)
+	}
+
+	plugin.RemoveDefinitions(paths[:1])
+	if len(plugin.GetDefinitions()) != 2 {
+		t.Errorf("RemoveDefinitions() left %d definitions", len(plugin.GetDefinitions()))
+	}
+}
+
+func TestPluginDiagnostics(t *testing.T) {
+	plugin, _, cleanup := pluginFixture(t)
+	defer cleanup()
+
+	diagnostics := plugin.Diagnostics(pluginFooBlock, plugin.GetDefinitions())
+	if len(diagnostics) != 1 || diagnostics[0].Code != 4 || !strings.Contains(diagnostics[0].Message, "missing_block") {
+		t.Fatalf("Diagnostics() = %+v", diagnostics)
+	}
+
+	if start := diagnostics[0].Range.Start; start.Line != 16 {
+		t.Errorf("Diagnostics() at %+v", start)
+	}
+}
+
+func TestPluginFileDiagnostics(t *testing.T) {
+	plugin, _, cleanup := pluginFixture(t)
+	defer cleanup()
+
+	tests := []struct {
+		text     string
+		expected int
+	}{
+		{"id: olivero_page_title\nplugin: page_title_block\n", 0},
+		{"id: olivero_foo\nplugin: 'foo_block'\n", 0},
+		// Derivatives are checked by their base id.
+		{"id: olivero_main\nplugin: 'foo_block:main'\n", 0},
+		{"id: olivero_missing\nplugin: missing_block\n", 1},
+	}
+
+	for _, test := range tests {
+		if diagnostics := plugin.FileDiagnostics("/config/sync/block.block.olivero.yml", test.text); len(diagnostics) != test.expected {
+			t.Errorf("FileDiagnostics(%q) = %+v, want %d", test.text, diagnostics, test.expected)
+		}
+	}
+
+	if diagnostics := plugin.FileDiagnostics("/config/sync/system.site.yml", "plugin: missing_block\n"); len(diagnostics) != 0 {
+		t.Errorf("FileDiagnostics(system.site.yml) = %+v", diagnostics)
+	}
+}
+
+func TestPluginDocumentCompletion(t *testing.T) {
+	plugin, paths, cleanup := pluginFixture(t)
+	defer cleanup()
+
+	tests := []struct {
+		path     string
+		text     string
+		expected string
+	}{
+		{paths[3], "<?php\n$this->blockManager->createInstance('", "page_title_block foo_block"},
+		{paths[3], "<?php\n\\Drupal::service('plugin.manager.field.field_type')->createInstance('", "string"},
+		{"/config/sync/block.block.olivero.yml", "plugin: ", "page_title_block foo_block"},
+		{paths[3], "<?php\n$this->blockManager->get('", ""},
+	}
+
+	for _, test := range tests {
+		lines := strings.Split(test.text, "\n")
+		position := lsp.Position{
+			Line:      float64(len(lines) - 1),
+			Character: float64(len(lines[len(lines)-1])),
+		}
+
+		labels := []string{}
+		for _, item := range plugin.DocumentCompletion(test.path, test.text, position) {
+			labels = append(labels, item.Label)
+		}
+
+		if strings.Join(labels, " ") != test.expected {
+			t.Errorf("DocumentCompletion(%q) = %v, want %s", test.text, labels, test.expected)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/nkoporec/drupal-lsp/php"
+	"github.com/nkoporec/drupal-lsp/utils"
+
+	lsp "go.lsp.dev/protocol"
+	"gopkg.in/yaml.v2"
+)
+
+type Route struct {
+	Definitions []ParserDefinition
+	// The php classes of the workspace, to check the receiver of calls.
+	classes func() map[string]PhpClass
+}
+
+type RouteYaml struct {
+	Path         string            `yaml:"path"`
+	Defaults     map[string]string `yaml:"defaults"`
+	Requirements map[string]string `yaml:"requirements"`
+}
+
+// Calls that take a route name and the index of that argument.
+var routeCalls = map[string]int{
+	"fromRoute":       0,
+	"redirect":        0,
+	"createFromRoute": 1,
+}
+
+// Base classes whose redirect() takes a route name, used when they aren't
+// indexed.
+var routeRedirectClasses = []string{
+	"Drupal\\Core\\Controller\\ControllerBase",
+	"Drupal\\Core\\Form\\FormBase",
+}
+
+func (r *Route) ParseFile(path string) interface{} {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	// The routing file has no wrapper key, every top level key is a route.
+	// Non route keys, like route_callbacks, fail to decode and are skipped.
+	routes := map[string]RouteYaml{}
+	yaml.Unmarshal(file, &routes)
+
+	return routes
+}
+
+func (r *Route) AddDefinitions(items []string) {
+	for _, file := range items {
+		item := r.ParseFile(file)
+		if item == nil {
+			continue
+		}
+
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		routes := item.(map[string]RouteYaml)
+		for name, route := range routes {
+			if route.Path == "" {
+				continue
+			}
+
+			def := ParserDefinition{
+				Name:        name,
+				Description: routeDescription(route),
+				File:        file,
+			}
+
+			if position, ok := yamlKeyPosition(src, name, ""); ok {
+				def.Position = position
+			}
+
+			// The controller is either a Class::method or a form class.
+			if controller, ok := route.Defaults["_controller"]; ok {
+				parts := strings.Split(controller, "::")
+				def.Class = strings.TrimPrefix(parts[0], "\\")
+				if len(parts) == 2 {
+					def.Method = parts[1]
+				}
+			} else if form, ok := route.Defaults["_form"]; ok {
+				def.Class = strings.TrimPrefix(form, "\\")
+			}
+
+			r.Definitions = append(r.Definitions, def)
+		}
+	}
+}
+
+func (r *Route) SetClassIndex(classes func() map[string]PhpClass) {
+	r.classes = classes
+}
+
+func (r *Route) RemoveDefinitions(items []string) {
+	r.Definitions = removeFileDefinitions(r.Definitions, items)
+}
+
+func (r *Route) FileExtension() string {
+	return "routing.yml"
+}
+
+func (r *Route) Methods() []string {
+	methods := []string{}
+	for method := range routeCalls {
+		methods = append(methods, method)
+	}
+
+	return methods
+}
+
+func (r *Route) GetDefinitions() []ParserDefinition {
+	return r.Definitions
+}
+
+func (r *Route) CompletionItem(def ParserDefinition) (lsp.CompletionItem, error) {
+	return lsp.CompletionItem{
+		Kind:   lsp.ValueCompletion,
+		Label:  def.Name,
+		Detail: strings.SplitN(def.Description, "\n", 2)[0],
+		Documentation: lsp.MarkupContent{
+			Kind:  lsp.PlainText,
+			Value: def.Description,
+		},
+	}, nil
+}
+
+func (r *Route) Diagnostics(text string, defs []ParserDefinition) []lsp.Diagnostic {
+	result := []lsp.Diagnostic{}
+	src := []byte(text)
+
+	defsNames := []string{}
+	for _, def := range defs {
+		defsNames = append(defsNames, def.Name)
+	}
+
+	// Parse the php file.
+	parsedDoc, err := php.Parse(src)
+	if err != nil {
+		log.Println(err)
+		return result
+	}
+
+	// Collect the route argument of every known call.
+	args := []*php.PhpClassArgument{}
+
+	// Url::fromRoute('foo'), Link::createFromRoute('Foo', 'foo')
+	for _, static := range parsedDoc.StaticCalls {
+		if static.Class.Name != "Url" && static.Class.Name != "Link" {
+			continue
+		}
+
+		if static.Method == nil {
+			continue
+		}
+
+		index, ok := routeCalls[static.Method.Name]
+		if !ok || len(static.Args) <= index {
+			continue
+		}
+
+		args = append(args, static.Args[index])
+	}
+
+	// $this->redirect('foo') in a controller or a form.
+	classes := classIndex(r.classes)
+	for _, call := range parsedDoc.MethodCalls {
+		if call.Method.Name != "redirect" || len(call.Args) == 0 || !r.isRouteRedirect(classes, parsedDoc, call) {
+			continue
+		}
+
+		args = append(args, call.Args[0])
+	}
+
+	for _, arg := range args {
+		if arg.Name == "" {
+			continue
+		}
+
+		argName := unquote(arg.Name)
+
+		// If the arg is not in the list then show the error.
+		if !utils.InSlice(defsNames, argName) {
+			diag := lsp.Diagnostic{
+				Code:     3,
+				Message:  fmt.Sprintf("Undefined route '%s'", argName),
+				Source:   "drupal-lsp",
+				Severity: lsp.SeverityError,
+				Range:    NodeRange(src, arg.Position),
+			}
+			result = append(result, diag)
+		}
+	}
+
+	return result
+}
+
+func (r *Route) GetGoToDefinition(params string) []ParserDefinition {
+	result := make([]ParserDefinition, 0, 200)
+
+	for _, def := range r.GetDefinitions() {
+		if def.Name == params {
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Check if a redirect() call takes a route name, eg. the one of
+// ControllerBase, and not a url like the one of a response.
+func (r *Route) isRouteRedirect(classes map[string]PhpClass, parsedDoc *php.ParsedDoc, call *php.PhpMethodCall) bool {
+	if call.Var != "this" || call.Property != "" || call.Position == nil {
+		return false
+	}
+
+	declaration := classDeclarationAt(parsedDoc, call.Position.StartPos)
+	if declaration == nil {
+		return false
+	}
+
+	for _, method := range declaration.Methods {
+		if strings.EqualFold(method.Name, "redirect") {
+			return len(method.Parameters) > 0 && method.Parameters[0].Name == "route_name"
+		}
+	}
+
+	for _, name := range classAncestors(classes, declaration.Parent) {
+		if utils.InSlice(routeRedirectClasses, name) {
+			return true
+		}
+
+		for _, method := range classes[name].Methods {
+			if strings.EqualFold(method.Name, "redirect") {
+				return len(method.Parameters) > 0 && method.Parameters[0].Name == "route_name"
+			}
+		}
+	}
+
+	return false
+}
+
+// Summary of the route, the first line is the route path.
+func routeDescription(route RouteYaml) string {
+	lines := []string{route.Path}
+
+	for _, key := range []string{"_controller", "_form", "_entity_form", "_title"} {
+		if value, ok := route.Defaults[key]; ok {
+			lines = append(lines, fmt.Sprintf("%s: %s", key, value))
+		}
+	}
+
+	requirements := []string{}
+	for key, value := range route.Requirements {
+		requirements = append(requirements, fmt.Sprintf("  %s: %s", key, value))
+	}
+	sort.Strings(requirements)
+
+	if len(requirements) > 0 {
+		lines = append(lines, "requirements:")
+		lines = append(lines, requirements...)
+	}
+
+	return strings.Join(lines, "\n")
+}
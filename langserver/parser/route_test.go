@@ -0,0 +1,35 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestRouteDiagnostics(t *testing.T) {
+	route := &Route{}
+	route.SetClassIndex(testClassIndex([]PhpClass{
+		{Namespace: "Drupal\\Core\\Controller\\ControllerBase", Kind: "class"},
+	}))
+
+	defs := []ParserDefinition{{Name: "foo.page"}}
+
+	tests := []struct {
+		text     string
+		expected int
+	}{
+		// A controller redirects to a route.
+		{"<?php\nuse Drupal\\Core\\Controller\\ControllerBase;\nclass Foo extends ControllerBase {\n  public function build() {\n    return $this->redirect('foo.missing');\n  }\n}\n", 1},
+		{"<?php\nuse Drupal\\Core\\Controller\\ControllerBase;\nclass Foo extends ControllerBase {\n  public function build() {\n    return $this->redirect('foo.page');\n  }\n}\n", 0},
+		// Other objects redirect to a url.
+		{"<?php\nfunction foo($response) {\n  $response->redirect('/foo');\n}\n", 0},
+		{"<?php\nclass Foo {\n  public function build() {\n    return $this->redirect('/foo');\n  }\n}\n", 0},
+		{"<?php\nclass Foo {\n  public function build() {\n    return $this->redirect('foo.missing');\n  }\n  protected function redirect($route_name) {\n  }\n}\n", 1},
+		{"<?php\nuse Drupal\\Core\\Url;\nUrl::fromRoute('foo.missing');\n", 1},
+	}
+
+	for _, test := range tests {
+		diagnostics := route.Diagnostics(test.text, defs)
+		if len(diagnostics) != test.expected {
+			t.Errorf("Diagnostics(%q) = %v, want %d", test.text, diagnostics, test.expected)
+		}
+	}
+}
@@ -1,36 +1,171 @@
 package parser
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/nkoporec/drupal-lsp/php"
 	"github.com/nkoporec/drupal-lsp/utils"
 
 	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
 	"gopkg.in/yaml.v2"
 )
 
 type Service struct {
 	File        *ServiceYaml
 	Definitions []ParserDefinition
+	// The full declaration of every service in Definitions.
+	Services []ServiceDefinition
+	// Parameters declared by the services.yml files.
+	Parameters []ServiceParameter
+	// Places where a service is used, keyed by the service name.
+	References map[string][]lsp.Location
+
+	// Definitions with their aliases and parents resolved, updated along
+	// with the index.
+	resolved []ParserDefinition
+	classes  func() map[string]PhpClass
+	// Guards References, which are updated as documents are edited.
+	mtx sync.RWMutex
 }
 
 type ServiceYaml struct {
-	Services map[string]ParserDefinition
+	Parameters map[string]interface{} `yaml:"parameters"`
+	Services   map[string]interface{} `yaml:"services"`
+}
+
+// A service as declared in a services.yml file.
+type ServiceDefinition struct {
+	Name      string
+	Class     string
+	Arguments []ServiceArgument
+	Tags      []ServiceTag
+	// The service this one is an alias of.
+	Alias string
+	// The abstract service this one inherits from.
+	Parent string
+	// The service or class creating this one, eg. @foo::create.
+	Factory string
+	// The service this one replaces.
+	Decorates string
+	Public    bool
+	Autowire  bool
+	Abstract  bool
+	// Methods called after the service is created.
+	Calls    []ServiceCall
+	File     string
+	Position lsp.Position
+}
+
+// An argument of a service or of one of its calls.
+type ServiceArgument struct {
+	// One of service, parameter, string or value.
+	Kind string
+	// The service or parameter name, or the literal value.
+	Value string
+	// Set for @?foo services, which may not exist.
+	Optional bool
+	// Set for named arguments, eg. $foo: '@bar'.
+	Name string
+}
+
+type ServiceTag struct {
+	Name       string
+	Attributes map[string]string
+}
+
+type ServiceCall struct {
+	Method    string
+	Arguments []ServiceArgument
+}
+
+type ServiceParameter struct {
+	Name     string
+	Value    string
+	File     string
+	Position lsp.Position
+}
+
+// Services that are registered by the kernel instead of a services.yml
+// file.
+var serviceSynthetic = []string{
+	"service_container",
+	"kernel",
+	"class_loader",
+}
+
+// Tags collected by Drupal core, completed along with the ones used in
+// the workspace.
+var serviceTags = []string{
+	"access_check",
+	"authentication_provider",
+	"breadcrumb_builder",
+	"cache.bin",
+	"cache.context",
+	"cache_tags_invalidator",
+	"config.factory.override",
+	"encoder",
+	"event_subscriber",
+	"http_middleware",
+	"logger",
+	"needs_destruction",
+	"normalizer",
+	"page_cache_request_policy",
+	"page_cache_response_policy",
+	"paramconverter",
+	"path_processor_inbound",
+	"path_processor_outbound",
+	"placeholder_strategy",
+	"render.main_content_renderer",
+	"route_enhancer",
+	"route_filter",
+	"route_processor_outbound",
+	"service_collector",
+	"service_id_collector",
+	"stream_wrapper",
+	"string_translator",
+	"theme_negotiator",
+	"twig.extension",
 }
 
+// A service being typed in php, eg. \Drupal::service('entity_type. or
+// $container->get('entity_type.
+var serviceCompletionRegex = regexp.MustCompile(`(?:::service|(?:\$container|\$this->container|::getContainer\(\))->get)\(\s*['"]([^'"]*)$`)
+
+// A service reference being typed in a services.yml file, eg. '@entity_type.
+// or parent: default_
+var serviceYamlCompletionRegex = regexp.MustCompile(`@\??([A-Za-z0-9_.\\]*)$|^\s*(?:alias|parent|decorates):\s*['"]?([A-Za-z0-9_.\\]*)$`)
+
+// A class being typed in a services.yml file, eg. class: Drupal\foo\
+var serviceClassCompletionRegex = regexp.MustCompile(`^\s*class:\s*['"]?([\w\\]*)$`)
+
+// The name of a tag being typed, eg. - { name: event_
+var serviceTagCompletionRegex = regexp.MustCompile(`(?:^\s*-?\s*|\{\s*)name:\s*['"]?([\w.]*)$`)
+
+// A parameter being typed in a services.yml file, eg. '%app.
+var serviceParameterCompletionRegex = regexp.MustCompile(`(?:^|[\s'"\[,{])%([\w.]*)$`)
+
+// Parameters referenced in a services.yml file, eg. '%app.root%'.
+var serviceParameterRegex = regexp.MustCompile(`%([\w.]+)%`)
+
 func (s *Service) ParseFile(path string) interface{} {
 	file, err := ioutil.ReadFile(path)
 	if err != nil {
-		panic(err)
+		log.Println(err)
+		return nil
 	}
 
 	service := &ServiceYaml{}
-	if err == nil {
-		err = yaml.Unmarshal(file, &service)
+	if err := yaml.Unmarshal(file, service); err != nil {
+		log.Println(err)
+		return nil
 	}
 
 	return service
@@ -43,14 +178,204 @@ func (s *Service) AddDefinitions(items []string) {
 			continue
 		}
 
-		defs := item.(*ServiceYaml)
-		for name, def := range defs.Services {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		services := item.(*ServiceYaml)
+
+		definitions := services.Definitions()
+		for _, name := range sortedServiceNames(definitions) {
+			service := definitions[name]
+			service.File = file
+			if position, ok := yamlBlockKeyPosition(src, "services", name); ok {
+				service.Position = position
+			}
+
+			s.Services = append(s.Services, service)
 			s.Definitions = append(s.Definitions, ParserDefinition{
+				Name:        name,
+				Class:       service.Class,
+				Description: serviceDescription(service),
+				File:        file,
+				Position:    service.Position,
+			})
+		}
+
+		for name, value := range services.Parameters {
+			parameter := ServiceParameter{
 				Name:  name,
-				Class: def.Class,
+				Value: serviceValue(value),
+				File:  file,
+			}
+			if position, ok := yamlBlockKeyPosition(src, "parameters", name); ok {
+				parameter.Position = position
+			}
+
+			s.Parameters = append(s.Parameters, parameter)
+		}
+
+		// Services used by other service definitions.
+		s.addReferences(file, serviceYamlReferences(src))
+	}
+
+	s.resolveDefinitions()
+}
+
+func (s *Service) RemoveDefinitions(items []string) {
+	// The definitions restored from the cache are resolved here as well.
+	defer s.resolveDefinitions()
+
+	s.Definitions = removeFileDefinitions(s.Definitions, items)
+
+	if len(items) == 0 {
+		return
+	}
+
+	removedFiles := make(map[string]bool, len(items))
+	removed := make(map[uri.URI]bool, len(items))
+	for _, file := range items {
+		removedFiles[file] = true
+		removed[uri.File(file)] = true
+	}
+
+	services := []ServiceDefinition{}
+	for _, service := range s.Services {
+		if !removedFiles[service.File] {
+			services = append(services, service)
+		}
+	}
+	s.Services = services
+
+	parameters := []ServiceParameter{}
+	for _, parameter := range s.Parameters {
+		if !removedFiles[parameter.File] {
+			parameters = append(parameters, parameter)
+		}
+	}
+	s.Parameters = parameters
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.removeReferences(removed)
+}
+
+// Remove the references found in files.
+func (s *Service) removeReferences(removed map[uri.URI]bool) {
+	for name, locations := range s.References {
+		references := []lsp.Location{}
+		for _, location := range locations {
+			if !removed[location.URI] {
+				references = append(references, location)
+			}
+		}
+
+		if len(references) == 0 {
+			delete(s.References, name)
+			continue
+		}
+		s.References[name] = references
+	}
+}
+
+// Add the references found in a file.
+func (s *Service) addReferences(file string, references map[string][]lsp.Range) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.References == nil {
+		s.References = make(map[string][]lsp.Location)
+	}
+
+	for name, ranges := range references {
+		for _, item := range ranges {
+			s.References[name] = append(s.References[name], lsp.Location{
+				URI:   uri.File(file),
+				Range: item,
+			})
+		}
+	}
+}
+
+// Find every php file that uses a service.
+func (s *Service) AddReferences(files []string) {
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		s.addReferences(file, servicePhpReferences(src))
+	}
+}
+
+// Replace the references of an edited file, a services.yml or php file.
+func (s *Service) UpdateReferences(path string, text string) {
+	var references map[string][]lsp.Range
+	switch {
+	case strings.HasSuffix(path, "services.yml"):
+		references = serviceYamlReferences([]byte(text))
+	case phpFile(path):
+		references = servicePhpReferences([]byte(text))
+	default:
+		return
+	}
+
+	s.mtx.Lock()
+	s.removeReferences(map[uri.URI]bool{uri.File(path): true})
+	s.mtx.Unlock()
+
+	s.addReferences(path, references)
+}
+
+// Find the declaration and the references of a service in a file.
+func (s *Service) RenameRanges(path string, text string, name string) []lsp.Range {
+	src := []byte(text)
+
+	switch {
+	case strings.HasSuffix(path, "services.yml"):
+		result := []lsp.Range{}
+		if position, ok := yamlBlockKeyPosition(src, "services", name); ok {
+			end := position
+			end.Character += float64(len(name))
+			result = append(result, lsp.Range{Start: position, End: end})
+		}
+
+		return append(result, serviceYamlReferences(src)[name]...)
+	case phpFile(path):
+		return servicePhpReferences(src)[name]
+	}
+
+	return nil
+}
+
+func (s *Service) GetReferences(name string, includeDeclaration bool) []lsp.Location {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	result := []lsp.Location{}
+
+	if includeDeclaration {
+		for _, def := range s.GetGoToDefinition(name) {
+			end := def.Position
+			end.Character += float64(len(def.Name))
+
+			result = append(result, lsp.Location{
+				URI: uri.File(def.File),
+				Range: lsp.Range{
+					Start: def.Position,
+					End:   end,
+				},
 			})
 		}
 	}
+
+	return append(result, s.References[name]...)
+}
+
+func (s *Service) SetClassIndex(classes func() map[string]PhpClass) {
+	s.classes = classes
 }
 
 func (s *Service) FileExtension() string {
@@ -63,22 +388,372 @@ func (s *Service) Methods() []string {
 	}
 }
 
+// Get the services with their aliases and parents resolved, as the class
+// of a service is often declared by another one.
 func (s *Service) GetDefinitions() []ParserDefinition {
-	return s.Definitions
+	if s.resolved == nil {
+		return s.Definitions
+	}
+
+	return s.resolved
+}
+
+// Resolve the aliases and parents of the services, once the index changed.
+// A service declared more than once is the last one indexed, like a module
+// overriding the service of another.
+func (s *Service) resolveDefinitions() {
+	services := make(map[string]ServiceDefinition, len(s.Services))
+	declarations := make(map[string]ServiceDefinition, len(s.Services))
+	for _, service := range s.Services {
+		services[service.Name] = service
+		declarations[service.File+":"+service.Name] = service
+	}
+
+	result := make([]ParserDefinition, 0, len(s.Definitions))
+	for _, def := range s.Definitions {
+		service, ok := declarations[def.File+":"+def.Name]
+		if !ok {
+			result = append(result, def)
+			continue
+		}
+
+		resolved, path := resolveService(services, service)
+		def.Class = resolved.Class
+		def.Description = serviceDescription(resolved)
+		if len(path) > 1 {
+			def.Description = "Resolved: " + strings.Join(path, " -> ") + "\n" + def.Description
+		}
+
+		result = append(result, def)
+	}
+
+	s.resolved = result
 }
 
 func (s *Service) CompletionItem(def ParserDefinition) (lsp.CompletionItem, error) {
+	documentation := def.Description
+	if documentation == "" {
+		documentation = def.Class
+	}
+
 	return lsp.CompletionItem{
 		Kind:   lsp.VariableCompletion,
 		Label:  def.Name,
 		Detail: fmt.Sprintf("Class %s", def.Class),
 		Documentation: lsp.MarkupContent{
 			Kind:  lsp.PlainText,
-			Value: def.Class,
+			Value: documentation,
 		},
 	}, nil
 }
 
+// Complete services in php and in services.yml files, where classes and
+// tag names are completed too.
+func (s *Service) DocumentCompletion(path string, text string, position lsp.Position) []lsp.CompletionItem {
+	result := []lsp.CompletionItem{}
+
+	line, ok := linePrefix(text, position)
+	if !ok {
+		return result
+	}
+
+	editRange := func(start int) lsp.Range {
+		return lsp.Range{
+			Start: lsp.Position{
+				Line:      position.Line,
+				Character: LineCharacter(line, start),
+			},
+			End: position,
+		}
+	}
+
+	if !strings.HasSuffix(path, "services.yml") {
+		if match := serviceCompletionRegex.FindStringSubmatchIndex(line); match != nil {
+			result = append(result, s.serviceCompletion(editRange(match[2]))...)
+		}
+
+		return result
+	}
+
+	if match := serviceYamlCompletionRegex.FindStringSubmatchIndex(line); match != nil {
+		start := match[2]
+		if start == -1 {
+			start = match[4]
+		}
+
+		return append(result, s.serviceCompletion(editRange(start))...)
+	}
+
+	if match := serviceParameterCompletionRegex.FindStringSubmatchIndex(line); match != nil {
+		// Close the parameter unless it already is.
+		suffix := "%"
+		if rest := strings.Split(text, "\n")[int(position.Line)][len(line):]; strings.HasPrefix(rest, "%") {
+			suffix = ""
+		}
+
+		for _, parameter := range s.Parameters {
+			result = append(result, lsp.CompletionItem{
+				Kind:       lsp.ConstantCompletion,
+				Label:      parameter.Name,
+				Detail:     "Parameter",
+				FilterText: parameter.Name,
+				Documentation: lsp.MarkupContent{
+					Kind:  lsp.PlainText,
+					Value: parameter.Value,
+				},
+				TextEdit: &lsp.TextEdit{
+					Range:   editRange(match[2]),
+					NewText: parameter.Name + suffix,
+				},
+			})
+		}
+
+		return result
+	}
+
+	if match := serviceClassCompletionRegex.FindStringSubmatchIndex(line); match != nil {
+		typed := strings.ToLower(line[match[2]:match[3]])
+		classes := classIndex(s.classes)
+
+		names := []string{}
+		for name, class := range classes {
+			if class.Kind == "class" && strings.HasPrefix(strings.ToLower(name), typed) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			class := classes[name]
+
+			result = append(result, lsp.CompletionItem{
+				Kind:       lsp.ClassCompletion,
+				Label:      class.Namespace,
+				Detail:     "Class",
+				FilterText: class.Namespace,
+				Documentation: lsp.MarkupContent{
+					Kind:  lsp.PlainText,
+					Value: strings.TrimSpace(class.Description + "\n\n" + class.Path),
+				},
+				TextEdit: &lsp.TextEdit{
+					Range:   editRange(match[2]),
+					NewText: class.Namespace,
+				},
+			})
+		}
+
+		return result
+	}
+
+	match := serviceTagCompletionRegex.FindStringSubmatchIndex(line)
+	if match == nil || !utils.InSlice(yamlParentKeys(strings.Split(text, "\n"), int(position.Line)), "tags") {
+		return result
+	}
+
+	tags := map[string]bool{}
+	for _, tag := range serviceTags {
+		tags[tag] = true
+	}
+	for _, service := range s.Services {
+		for _, tag := range service.Tags {
+			tags[tag.Name] = true
+		}
+	}
+
+	names := make([]string, 0, len(tags))
+	for tag := range tags {
+		names = append(names, tag)
+	}
+	sort.Strings(names)
+
+	for _, tag := range names {
+		result = append(result, lsp.CompletionItem{
+			Kind:       lsp.ValueCompletion,
+			Label:      tag,
+			Detail:     "Tag",
+			FilterText: tag,
+			TextEdit: &lsp.TextEdit{
+				Range:   editRange(match[2]),
+				NewText: tag,
+			},
+		})
+	}
+
+	return result
+}
+
+// Complete service names, replacing the name typed so far.
+func (s *Service) serviceCompletion(editRange lsp.Range) []lsp.CompletionItem {
+	result := []lsp.CompletionItem{}
+
+	for _, def := range s.GetDefinitions() {
+		item, err := s.CompletionItem(def)
+		if err != nil {
+			continue
+		}
+
+		item.FilterText = def.Name
+		item.TextEdit = &lsp.TextEdit{
+			Range:   editRange,
+			NewText: def.Name,
+		}
+
+		result = append(result, item)
+	}
+
+	return result
+}
+
+// Check the services, classes and arguments of a services.yml file.
+func (s *Service) FileDiagnostics(path string, text string) []lsp.Diagnostic {
+	result := []lsp.Diagnostic{}
+
+	// Without any service the index is incomplete.
+	if !strings.HasSuffix(path, "services.yml") || len(s.Definitions) == 0 {
+		return result
+	}
+
+	defined := make(map[string]bool, len(s.Definitions))
+	for _, def := range s.Definitions {
+		defined[def.Name] = true
+	}
+
+	entries := map[string]ServiceDefinition{}
+	file := &ServiceYaml{}
+	if err := yaml.Unmarshal([]byte(text), file); err == nil {
+		entries = file.Definitions()
+	}
+	lines := strings.Split(text, "\n")
+
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		for _, match := range serviceReferenceRegex.FindAllStringSubmatchIndex(line, -1) {
+			start, end := match[4], match[5]
+			message := "Undefined service '%s'"
+			if start == -1 {
+				start, end = match[8], match[9]
+				if strings.HasPrefix(strings.TrimSpace(line), "parent:") {
+					message = "Undefined parent service '%s'"
+				}
+			} else if line[match[2]:match[3]] == "@?" || (match[2] > 0 && line[match[2]-1] == '@') {
+				// Optional services and escaped @ are fine.
+				continue
+			}
+
+			name := line[start:end]
+			if _, ok := entries[name]; ok || utils.InSlice(serviceSynthetic, name) || defined[name] {
+				continue
+			}
+
+			result = append(result, lsp.Diagnostic{
+				Code:     2,
+				Message:  fmt.Sprintf(message, name),
+				Source:   "drupal-lsp",
+				Severity: lsp.SeverityError,
+				Range: lsp.Range{
+					Start: lsp.Position{Line: float64(i), Character: LineCharacter(line, start)},
+					End:   lsp.Position{Line: float64(i), Character: LineCharacter(line, end)},
+				},
+			})
+		}
+	}
+
+	// Without any class the index is incomplete.
+	classes := classIndex(s.classes)
+	if len(classes) == 0 {
+		return result
+	}
+
+	indexedNamespaces := make(map[string]bool)
+	for name := range classes {
+		indexedNamespaces[classNamespace(name)] = true
+	}
+
+	keys := yamlKeyRanges(text)
+	for _, name := range sortedServiceNames(entries) {
+		entry := entries[name]
+		if entry.Alias != "" || entry.Parent != "" || entry.Abstract {
+			continue
+		}
+
+		// Services named after their class may omit it.
+		class, classRange := strings.TrimPrefix(entry.Class, "\\"), yamlValueRange(lines, keys["services."+name+".class"])
+		if class == "" && strings.Contains(name, "\\") {
+			class, classRange = name, keys["services."+name]
+		}
+
+		if class == "" {
+			continue
+		}
+
+		if _, ok := classes[class]; !ok {
+			// Only the classes of indexed namespaces are known to be
+			// missing, others may be in directories that aren't indexed.
+			if indexedNamespaces[classNamespace(class)] {
+				result = append(result, lsp.Diagnostic{
+					Code:     11,
+					Message:  fmt.Sprintf("Undefined class '%s'", class),
+					Source:   "drupal-lsp",
+					Severity: lsp.SeverityWarning,
+					Range:    classRange,
+				})
+			}
+			continue
+		}
+
+		// The arguments of factories don't go to the constructor, and
+		// autowired or named ones can't be counted.
+		if entry.Factory != "" || entry.Autowire || serviceNamedArguments(entry.Arguments) {
+			continue
+		}
+		arguments := entry.Arguments
+
+		constructor, ok := classConstructor(classes, class)
+		if !ok {
+			continue
+		}
+
+		required, total := 0, len(constructor.Parameters)
+		for _, parameter := range constructor.Parameters {
+			if parameter.Variadic {
+				total = -1
+			} else if parameter.Default == "" {
+				required++
+			}
+		}
+
+		if len(arguments) >= required && (total == -1 || len(arguments) <= total) {
+			continue
+		}
+
+		expected := fmt.Sprintf("%d", required)
+		switch {
+		case total == -1:
+			expected = fmt.Sprintf("at least %d", required)
+		case total != required:
+			expected = fmt.Sprintf("%d to %d", required, total)
+		}
+
+		argumentsRange, ok := keys["services."+name+".arguments"]
+		if !ok {
+			argumentsRange = keys["services."+name]
+		}
+
+		result = append(result, lsp.Diagnostic{
+			Code:     12,
+			Message:  fmt.Sprintf("%s::__construct() expects %s arguments, %d given", class, expected, len(arguments)),
+			Source:   "drupal-lsp",
+			Severity: lsp.SeverityWarning,
+			Range:    argumentsRange,
+		})
+	}
+
+	return result
+}
+
 func (s *Service) Diagnostics(text string, defs []ParserDefinition) []lsp.Diagnostic {
 	result := []lsp.Diagnostic{}
 	src := []byte(text)
@@ -91,12 +766,13 @@ func (s *Service) Diagnostics(text string, defs []ParserDefinition) []lsp.Diagno
 	// Parse the php file.
 	parsedDoc, err := php.Parse(src)
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return result
 	}
 
 	// Get all \Drupal::service calls.
 	for _, static := range parsedDoc.StaticCalls {
-		if static.Class.Name != "Drupal" {
+		if static.Class.Name != "Drupal" || static.Method == nil {
 			continue
 		}
 
@@ -109,28 +785,21 @@ func (s *Service) Diagnostics(text string, defs []ParserDefinition) []lsp.Diagno
 		}
 
 		arg := static.Args[0]
+		if arg.Name == "" {
+			continue
+		}
 
 		// Strip quotes from a string.
-		argName := strings.Trim(arg.Name, "\"")
-		argName = strings.Trim(arg.Name, "'")
+		argName := unquote(arg.Name)
 
 		// If the arg is not in the list then show the error.
 		if !utils.InSlice(defsNames, argName) {
 			diag := lsp.Diagnostic{
 				Code:     2,
-				Message:  fmt.Sprintf("Undefined service '%s'", arg.Name),
+				Message:  fmt.Sprintf("Undefined service '%s'", argName),
 				Source:   "drupal-lsp",
 				Severity: lsp.SeverityError,
-				Range: lsp.Range{
-					Start: lsp.Position{
-						Line:      float64(arg.Position.StartLine - 1),
-						Character: float64(arg.Position.StartPos),
-					},
-					End: lsp.Position{
-						Line:      float64(arg.Position.EndLine),
-						Character: float64(arg.Position.EndPos),
-					},
-				},
+				Range:    NodeRange(src, arg.Position),
 			}
 			result = append(result, diag)
 		}
@@ -139,12 +808,727 @@ func (s *Service) Diagnostics(text string, defs []ParserDefinition) []lsp.Diagno
 	return result
 }
 
-func (s *Service) GetGoToDefinition(params string) []string {
-	result := make([]string, 0, 200)
+func (s *Service) GetGoToDefinition(params string) []ParserDefinition {
+	result := make([]ParserDefinition, 0, 200)
 
 	for _, def := range s.GetDefinitions() {
 		if def.Name == params {
-			result = append(result, def.Class)
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Describe the service or parameter under the cursor.
+func (s *Service) Hover(path string, text string, position lsp.Position) string {
+	if name, ok := serviceParameterAt(path, text, position); ok {
+		for _, parameter := range s.Parameters {
+			if parameter.Name == name {
+				return "%" + name + "%\nValue: " + parameter.Value
+			}
+		}
+
+		return ""
+	}
+
+	name, _, ok := serviceAt(path, text, position)
+	if !ok {
+		return ""
+	}
+
+	for _, def := range s.GetGoToDefinition(name) {
+		result := strings.TrimSpace(name + "\n" + def.Description)
+
+		if class, ok := classIndex(s.classes)[def.Class]; ok && class.Description != "" {
+			result += "\n\n" + class.Description
+		}
+
+		return result
+	}
+
+	return ""
+}
+
+// Get the service used or declared under the cursor, so a variable named
+// after a service isn't taken for it.
+func (s *Service) ReferenceAt(path string, text string, position lsp.Position) (string, lsp.Range, bool) {
+	return serviceAt(path, text, position)
+}
+
+// Get the service under the cursor, with its class resolved through
+// aliases and parents.
+func (s *Service) DocumentDefinition(path string, text string, position lsp.Position) []ParserDefinition {
+	name, _, ok := serviceAt(path, text, position)
+	if !ok {
+		return []ParserDefinition{}
+	}
+
+	return s.GetGoToDefinition(name)
+}
+
+// Get the service under the cursor and its range, used by a php service
+// call or declared or referenced in a services.yml file.
+func serviceAt(path string, text string, position lsp.Position) (string, lsp.Range, bool) {
+	lines := strings.Split(text, "\n")
+	i := int(position.Line)
+	if i >= len(lines) {
+		return "", lsp.Range{}, false
+	}
+	line := strings.TrimRight(lines[i], "\r")
+	character := LineOffset(line, position.Character)
+
+	nameRange := func(start int, end int) lsp.Range {
+		return lsp.Range{
+			Start: lsp.Position{Line: float64(i), Character: LineCharacter(line, start)},
+			End:   lsp.Position{Line: float64(i), Character: LineCharacter(line, end)},
+		}
+	}
+
+	if !strings.HasSuffix(path, "services.yml") {
+		name, start, ok := quotedStringAt(line, character)
+		if !ok || name == "" || !serviceCompletionRegex.MatchString(line[:start]) {
+			return "", lsp.Range{}, false
+		}
+
+		return name, nameRange(start, start+len(name)), true
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return "", lsp.Range{}, false
+	}
+
+	for _, match := range serviceReferenceRegex.FindAllStringSubmatchIndex(line, -1) {
+		start, end := match[4], match[5]
+		if start == -1 {
+			start, end = match[8], match[9]
+		}
+
+		if start <= character && character <= end {
+			return line[start:end], nameRange(start, end), true
+		}
+	}
+
+	// The name of a service declaration.
+	parents := yamlParentKeys(lines, i)
+	if len(parents) != 1 || parents[0] != "services" {
+		return "", lsp.Range{}, false
+	}
+
+	content := strings.TrimLeft(line, " ")
+	match := yamlKeyRegex.FindStringSubmatchIndex(content)
+	if match == nil {
+		return "", lsp.Range{}, false
+	}
+
+	start := len(line) - len(content) + match[4]
+	if character < start || character > start+match[5]-match[4] {
+		return "", lsp.Range{}, false
+	}
+
+	return content[match[4]:match[5]], nameRange(start, start+match[5]-match[4]), true
+}
+
+// Get the parameter under the cursor in a services.yml file.
+func serviceParameterAt(path string, text string, position lsp.Position) (string, bool) {
+	lines := strings.Split(text, "\n")
+	if !strings.HasSuffix(path, "services.yml") || int(position.Line) >= len(lines) {
+		return "", false
+	}
+
+	line := lines[int(position.Line)]
+	character := LineOffset(line, position.Character)
+	for _, match := range serviceParameterRegex.FindAllStringSubmatchIndex(line, -1) {
+		if match[0] <= character && character <= match[1] {
+			return line[match[2]:match[3]], true
+		}
+	}
+
+	return "", false
+}
+
+// Find the services used in a php file.
+func servicePhpReferences(src []byte) map[string][]lsp.Range {
+	result := make(map[string][]lsp.Range)
+
+	// Parsing every php file is slow, so skip the ones that can't
+	// reference a service.
+	if !bytes.Contains(src, []byte("::service(")) && !bytes.Contains(src, []byte("->get(")) {
+		return result
+	}
+
+	parsedDoc, err := php.Parse(src)
+	if err != nil {
+		log.Println(err)
+		return result
+	}
+
+	for _, arg := range serviceArguments(parsedDoc) {
+		name := unquote(arg.Name)
+		result[name] = append(result[name], stringRange(src, arg.Position))
+	}
+
+	return result
+}
+
+// Get the service name arguments of \Drupal::service('foo') and of
+// ->get('foo') calls on the container.
+func serviceArguments(parsedDoc *php.ParsedDoc) []*php.PhpClassArgument {
+	result := []*php.PhpClassArgument{}
+
+	for _, static := range parsedDoc.StaticCalls {
+		if static.Class.Name != "Drupal" || static.Method == nil || static.Method.Name != "service" {
+			continue
+		}
+
+		if len(static.Args) != 1 || static.Args[0].Name == "" {
+			continue
+		}
+
+		result = append(result, static.Args[0])
+	}
+
+	for _, call := range parsedDoc.MethodCalls {
+		if call.Method.Name != "get" || !isContainerCall(parsedDoc, call) {
+			continue
+		}
+
+		if len(call.Args) == 0 || call.Args[0].Name == "" {
+			continue
+		}
+
+		result = append(result, call.Args[0])
+	}
+
+	return result
+}
+
+// Check if a method is called on the container, eg. $container in create(),
+// $this->container, \Drupal::getContainer() or a parameter typed as
+// ContainerInterface.
+func isContainerCall(parsedDoc *php.ParsedDoc, call *php.PhpMethodCall) bool {
+	switch {
+	case call.StaticCall != nil:
+		return call.StaticCall.Class.Name == "Drupal" && call.StaticCall.Method != nil && call.StaticCall.Method.Name == "getContainer"
+	case call.Property != "":
+		return call.Var == "this" && call.Property == "container"
+	case call.MethodCall != nil || call.Var == "":
+		return false
+	case call.Var == "container":
+		return true
+	}
+
+	for _, method := range parsedDoc.ClassMethods {
+		if method.Name != call.Scope {
+			continue
+		}
+
+		for _, parameter := range method.Parameters {
+			if parameter.Name == call.Var && strings.HasSuffix(parameter.Type, "ContainerInterface") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Decode the services of a services.yml file, keyed by their name, with
+// the _defaults of the file applied.
+func (y *ServiceYaml) Definitions() map[string]ServiceDefinition {
+	result := make(map[string]ServiceDefinition)
+
+	defaults := ServiceDefinition{Public: true}
+	if value, ok := y.Services["_defaults"].(map[interface{}]interface{}); ok {
+		defaults = serviceDefinition(value, defaults)
+	}
+
+	for name, value := range y.Services {
+		// _defaults and _instanceof aren't services.
+		if strings.HasPrefix(name, "_") {
+			continue
+		}
+
+		service := ServiceDefinition{
+			Public:   defaults.Public,
+			Autowire: defaults.Autowire,
+			Tags:     append([]ServiceTag{}, defaults.Tags...),
+		}
+
+		switch value := value.(type) {
+		case string:
+			// Aliases can be declared as foo: '@bar'.
+			service.Alias = strings.TrimPrefix(value, "@")
+		case map[interface{}]interface{}:
+			service = serviceDefinition(value, service)
+		}
+
+		service.Name = name
+		result[name] = service
+	}
+
+	return result
+}
+
+// Decode the keys of a service on top of its defaults.
+func serviceDefinition(value map[interface{}]interface{}, service ServiceDefinition) ServiceDefinition {
+	for key, item := range value {
+		text, _ := item.(string)
+		flag, isFlag := item.(bool)
+
+		switch key {
+		case "class":
+			service.Class = strings.TrimPrefix(text, "\\")
+		case "alias":
+			service.Alias = strings.TrimPrefix(text, "@")
+		case "parent":
+			service.Parent = strings.TrimPrefix(text, "@")
+		case "decorates":
+			service.Decorates = strings.TrimPrefix(text, "@")
+		case "factory":
+			service.Factory = serviceFactory(item)
+		case "arguments":
+			service.Arguments = serviceYamlArguments(item)
+		case "tags":
+			service.Tags = append(service.Tags, serviceYamlTags(item)...)
+		case "calls":
+			service.Calls = serviceYamlCalls(item)
+		case "public":
+			if isFlag {
+				service.Public = flag
+			}
+		case "autowire":
+			service.Autowire = isFlag && flag
+		case "abstract":
+			service.Abstract = isFlag && flag
+		}
+	}
+
+	return service
+}
+
+// Decode a list of arguments, or named arguments.
+func serviceYamlArguments(value interface{}) []ServiceArgument {
+	result := []ServiceArgument{}
+
+	switch value := value.(type) {
+	case []interface{}:
+		for _, item := range value {
+			result = append(result, serviceArgument(item))
+		}
+	case map[interface{}]interface{}:
+		// Named arguments, eg. $foo: '@bar', lose their order.
+		named := make(map[string]interface{}, len(value))
+		names := make([]string, 0, len(value))
+		for key, item := range value {
+			name := fmt.Sprint(key)
+			named[name] = item
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			argument := serviceArgument(named[name])
+			argument.Name = name
+			result = append(result, argument)
+		}
+	}
+
+	return result
+}
+
+// Decode an argument, eg. '@foo', '@?foo', '%foo%' or a literal.
+func serviceArgument(value interface{}) ServiceArgument {
+	text, ok := value.(string)
+	if !ok {
+		return ServiceArgument{Kind: "value", Value: serviceValue(value)}
+	}
+
+	switch {
+	case strings.HasPrefix(text, "@@"):
+		return ServiceArgument{Kind: "string", Value: text[1:]}
+	case strings.HasPrefix(text, "@?"):
+		return ServiceArgument{Kind: "service", Value: text[2:], Optional: true}
+	case strings.HasPrefix(text, "@"):
+		return ServiceArgument{Kind: "service", Value: text[1:]}
+	}
+
+	if match := serviceParameterRegex.FindStringSubmatchIndex(text); match != nil && match[0] == 0 && match[1] == len(text) {
+		return ServiceArgument{Kind: "parameter", Value: text[match[2]:match[3]]}
+	}
+
+	return ServiceArgument{Kind: "string", Value: text}
+}
+
+// Format an argument the way it's written in a services.yml file.
+func (a ServiceArgument) String() string {
+	value := a.Value
+
+	switch a.Kind {
+	case "service":
+		if a.Optional {
+			value = "@?" + value
+		} else {
+			value = "@" + value
+		}
+	case "parameter":
+		value = "%" + value + "%"
+	case "string":
+		value = "'" + value + "'"
+	}
+
+	if a.Name != "" {
+		value = a.Name + ": " + value
+	}
+
+	return value
+}
+
+// Decode the tags of a service, eg. - { name: foo, priority: 10 }.
+func serviceYamlTags(value interface{}) []ServiceTag {
+	result := []ServiceTag{}
+
+	items, _ := value.([]interface{})
+	for _, item := range items {
+		switch item := item.(type) {
+		case string:
+			result = append(result, ServiceTag{Name: item})
+		case map[interface{}]interface{}:
+			tag := ServiceTag{Attributes: make(map[string]string)}
+
+			// Tags can be declared as - foo: { priority: 10 }.
+			if _, ok := item["name"]; !ok && len(item) == 1 {
+				for key, attributes := range item {
+					tag.Name = fmt.Sprint(key)
+					item, _ = attributes.(map[interface{}]interface{})
+				}
+			}
+
+			for key, attribute := range item {
+				if key == "name" {
+					tag.Name = serviceValue(attribute)
+					continue
+				}
+				tag.Attributes[fmt.Sprint(key)] = serviceValue(attribute)
+			}
+
+			if tag.Name != "" {
+				result = append(result, tag)
+			}
+		}
+	}
+
+	return result
+}
+
+// Format a tag with its attributes, eg. event_subscriber (priority: 10).
+func (t ServiceTag) String() string {
+	attributes := []string{}
+	for key, value := range t.Attributes {
+		attributes = append(attributes, key+": "+value)
+	}
+
+	if len(attributes) == 0 {
+		return t.Name
+	}
+	sort.Strings(attributes)
+
+	return t.Name + " (" + strings.Join(attributes, ", ") + ")"
+}
+
+// Decode the method calls of a service, eg. - [setFoo, ['@bar']].
+func serviceYamlCalls(value interface{}) []ServiceCall {
+	result := []ServiceCall{}
+
+	items, _ := value.([]interface{})
+	for _, item := range items {
+		call := ServiceCall{}
+
+		switch item := item.(type) {
+		case []interface{}:
+			if len(item) > 0 {
+				call.Method = serviceValue(item[0])
+			}
+			if len(item) > 1 {
+				call.Arguments = serviceYamlArguments(item[1])
+			}
+		case map[interface{}]interface{}:
+			// Either - { method: setFoo, arguments: [] } or - setFoo: [].
+			if method, ok := item["method"]; ok {
+				call.Method = serviceValue(method)
+				call.Arguments = serviceYamlArguments(item["arguments"])
+				break
+			}
+
+			for method, arguments := range item {
+				call.Method = fmt.Sprint(method)
+				call.Arguments = serviceYamlArguments(arguments)
+			}
+		}
+
+		if call.Method != "" {
+			result = append(result, call)
+		}
+	}
+
+	return result
+}
+
+// Decode the factory of a service, which is either a string or a service
+// or class and a method.
+func serviceFactory(value interface{}) string {
+	if items, ok := value.([]interface{}); ok && len(items) == 2 {
+		return serviceValue(items[0]) + "::" + serviceValue(items[1])
+	}
+
+	return serviceValue(value)
+}
+
+// Format a yaml value on a single line.
+func serviceValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return value
+	case []interface{}:
+		items := []string{}
+		for _, item := range value {
+			items = append(items, serviceValue(item))
+		}
+
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[interface{}]interface{}:
+		items := []string{}
+		for key, item := range value {
+			items = append(items, fmt.Sprintf("%v: %s", key, serviceValue(item)))
+		}
+		sort.Strings(items)
+
+		return "{ " + strings.Join(items, ", ") + " }"
+	}
+
+	return fmt.Sprint(value)
+}
+
+// Check if a service uses named arguments.
+func serviceNamedArguments(arguments []ServiceArgument) bool {
+	for _, argument := range arguments {
+		if argument.Name != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get the names of the services, so they're indexed in a stable order.
+func sortedServiceNames(services map[string]ServiceDefinition) []string {
+	result := make([]string, 0, len(services))
+	for name := range services {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// Follow the aliases and parents of a service, which gives the service
+// actually created and the steps taken to get there.
+func resolveService(services map[string]ServiceDefinition, service ServiceDefinition) (ServiceDefinition, []string) {
+	path := []string{service.Name}
+	seen := map[string]bool{service.Name: true}
+
+	for service.Alias != "" {
+		target, ok := services[service.Alias]
+		if !ok || seen[target.Name] {
+			break
+		}
+
+		seen[target.Name] = true
+		path = append(path, target.Name+" (alias)")
+		service = target
+	}
+
+	// Children inherit the class, factory and calls of their parents,
+	// and add their arguments to the ones of the parents.
+	for name := service.Parent; name != ""; {
+		parent, ok := services[name]
+		if !ok || seen[parent.Name] {
+			break
+		}
+
+		seen[parent.Name] = true
+		path = append(path, parent.Name+" (parent)")
+
+		if service.Class == "" {
+			service.Class = parent.Class
+		}
+		if service.Factory == "" {
+			service.Factory = parent.Factory
+		}
+		service.Arguments = append(append([]ServiceArgument{}, parent.Arguments...), service.Arguments...)
+		service.Calls = append(append([]ServiceCall{}, parent.Calls...), service.Calls...)
+
+		name = parent.Parent
+	}
+
+	// Services named after their class may omit it.
+	if service.Class == "" && service.Alias == "" && strings.Contains(service.Name, "\\") {
+		service.Class = strings.TrimPrefix(service.Name, "\\")
+		path = append(path, "class from service id")
+	}
+
+	return service, path
+}
+
+// Describe a service in the completion documentation and the hover.
+func serviceDescription(service ServiceDefinition) string {
+	lines := []string{}
+	add := func(label string, value string) {
+		if value != "" {
+			lines = append(lines, label+": "+value)
+		}
+	}
+
+	join := func(arguments []ServiceArgument) string {
+		items := []string{}
+		for _, argument := range arguments {
+			items = append(items, argument.String())
+		}
+
+		return strings.Join(items, ", ")
+	}
+
+	add("Class", service.Class)
+	add("Alias of", service.Alias)
+	add("Parent", service.Parent)
+	add("Factory", service.Factory)
+	add("Decorates", service.Decorates)
+	add("Arguments", join(service.Arguments))
+
+	tags := []string{}
+	for _, tag := range service.Tags {
+		tags = append(tags, tag.String())
+	}
+	add("Tags", strings.Join(tags, ", "))
+
+	calls := []string{}
+	for _, call := range service.Calls {
+		calls = append(calls, call.Method+"("+join(call.Arguments)+")")
+	}
+	add("Calls", strings.Join(calls, ", "))
+
+	flags := []string{}
+	if !service.Public {
+		flags = append(flags, "private")
+	}
+	if service.Autowire {
+		flags = append(flags, "autowired")
+	}
+	if service.Abstract {
+		flags = append(flags, "abstract")
+	}
+	add("Flags", strings.Join(flags, ", "))
+
+	return strings.Join(lines, "\n")
+}
+
+// Get the namespace of a fully qualified class name.
+func classNamespace(name string) string {
+	if index := strings.LastIndex(name, "\\"); index != -1 {
+		return name[:index]
+	}
+
+	return ""
+}
+
+// Find the constructor of a class, declared by the class or one of its
+// parents. It's unknown if a parent isn't indexed.
+func classConstructor(classes map[string]PhpClass, name string) (PhpMethod, bool) {
+	for depth := 0; depth < 20; depth++ {
+		class, ok := classes[name]
+		if !ok {
+			return PhpMethod{}, false
+		}
+
+		for _, method := range class.Methods {
+			if strings.ToLower(method.Name) == "__construct" {
+				return method, true
+			}
+		}
+
+		if class.Parent == "" {
+			return PhpMethod{}, true
+		}
+		name = class.Parent
+	}
+
+	return PhpMethod{}, false
+}
+
+// Get the range of the value of a yaml key, without its quotes.
+func yamlValueRange(lines []string, key lsp.Range) lsp.Range {
+	i := int(key.Start.Line)
+	if i >= len(lines) {
+		return key
+	}
+
+	line := strings.TrimRight(lines[i], "\r")
+	start := LineOffset(line, key.End.Character)
+	if colon := strings.Index(line[start:], ":"); colon != -1 {
+		start += colon + 1
+	}
+
+	value := strings.TrimSpace(line[start:])
+	if value == "" {
+		return key
+	}
+	start = strings.Index(line[start:], value) + start
+
+	if trimmed := strings.Trim(value, `'"`); trimmed != value {
+		start++
+		value = trimmed
+	}
+
+	return lsp.Range{
+		Start: lsp.Position{Line: key.Start.Line, Character: LineCharacter(line, start)},
+		End:   lsp.Position{Line: key.Start.Line, Character: LineCharacter(line, start+len(value))},
+	}
+}
+
+// Services are referenced as '@foo' or '@?foo' values, eg. arguments, and
+// by the alias, parent and decorates keys. The @ starts the value, so
+// emails and escaped '@@foo' strings don't match.
+var serviceReferenceRegex = regexp.MustCompile(`(?:^|[\s\[,{'"])(@\??)([A-Za-z0-9_.\\]+)|^(\s*(?:alias|parent|decorates):\s*['"]?)([A-Za-z0-9_.\\]+)`)
+
+// Find the services used in a services.yml file.
+func serviceYamlReferences(src []byte) map[string][]lsp.Range {
+	result := make(map[string][]lsp.Range)
+
+	for i, line := range strings.Split(string(src), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		for _, match := range serviceReferenceRegex.FindAllStringSubmatchIndex(line, -1) {
+			// Either the @ argument or the key groups matched.
+			start, end := match[4], match[5]
+			if start == -1 {
+				start, end = match[8], match[9]
+			}
+
+			name := line[start:end]
+			result[name] = append(result[name], lsp.Range{
+				Start: lsp.Position{
+					Line:      float64(i),
+					Character: LineCharacter(line, start),
+				},
+				End: lsp.Position{
+					Line:      float64(i),
+					Character: LineCharacter(line, end),
+				},
+			})
 		}
 	}
 
@@ -0,0 +1,464 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nkoporec/drupal-lsp/php"
+
+	lsp "go.lsp.dev/protocol"
+	"gopkg.in/yaml.v2"
+)
+
+// Get the names of references, with the number of times each one is used.
+func referenceNames(references map[string][]lsp.Range) string {
+	names := []string{}
+	for name, ranges := range references {
+		names = append(names, fmt.Sprintf("%s:%d", name, len(ranges)))
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ",")
+}
+
+func TestServiceYamlReferences(t *testing.T) {
+	src := `parameters:
+  site.mail: 'admin@example.com'
+
+services:
+  foo:
+    class: Drupal\foo\Foo
+    arguments: ['@bar', '@?baz', '@@literal', 'user@example.com']
+  foo.alias:
+    alias: foo
+  foo.child:
+    parent: foo
+    # '@commented'
+    factory: ['@bar', 'create']
+`
+
+	references := serviceYamlReferences([]byte(src))
+	if names := referenceNames(references); names != "bar:2,baz:1,foo:2" {
+		t.Errorf("serviceYamlReferences() = %s", names)
+	}
+
+	// The range points to the name, without the @.
+	if item := references["baz"][0]; item.Start.Line != 6 || item.Start.Character != 27 || item.End.Character != 30 {
+		t.Errorf("Invalid range %v", item)
+	}
+}
+
+func TestServicePhpReferences(t *testing.T) {
+	src := `<?php
+namespace Drupal\foo;
+
+use Symfony\Component\DependencyInjection\ContainerInterface;
+
+class Foo {
+  public static function create(ContainerInterface $services) {
+    return new static(
+      $services->get('bar'),
+      \Drupal::service('baz'),
+      \Drupal::getContainer()->get('qux')
+    );
+  }
+
+  public function build() {
+    $this->container->get('foo');
+    $this->config->get('not.a.service');
+    $config->get('not.a.service');
+  }
+}
+`
+
+	if names := referenceNames(servicePhpReferences([]byte(src))); names != "bar:1,baz:1,foo:1,qux:1" {
+		t.Errorf("servicePhpReferences() = %s", names)
+	}
+}
+
+func TestServiceUpdateReferences(t *testing.T) {
+	service := &Service{}
+	service.UpdateReferences("/foo/foo.module", "<?php\n\\Drupal::service('bar');\n")
+	service.UpdateReferences("/foo/foo.services.yml", "services:\n  foo:\n    arguments: ['@bar']\n")
+
+	if references := service.GetReferences("bar", false); len(references) != 2 {
+		t.Errorf("GetReferences() = %v", references)
+	}
+
+	// An edit replaces the references of the file.
+	service.UpdateReferences("/foo/foo.module", "<?php\n\\Drupal::service('baz');\n")
+
+	if references := service.GetReferences("bar", false); len(references) != 1 || !strings.HasSuffix(string(references[0].URI), "foo.services.yml") {
+		t.Errorf("GetReferences() = %v", references)
+	}
+
+	if references := service.GetReferences("baz", false); len(references) != 1 {
+		t.Errorf("GetReferences() = %v", references)
+	}
+}
+
+func TestYamlBlockKeyPosition(t *testing.T) {
+	src := []byte(`parameters:
+  foo: bar
+services:
+    # foo:
+    foo:
+      class: Drupal\foo\Foo
+other:
+  bar: baz
+`)
+
+	tests := []struct {
+		block     string
+		key       string
+		line      int
+		character int
+		ok        bool
+	}{
+		{"services", "foo", 4, 4, true},
+		{"parameters", "foo", 1, 2, true},
+		{"services", "bar", 0, 0, false},
+		{"missing", "foo", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		position, ok := yamlBlockKeyPosition(src, test.block, test.key)
+		if ok != test.ok || ok && (int(position.Line) != test.line || int(position.Character) != test.character) {
+			t.Errorf("yamlBlockKeyPosition(%s, %s) = %v, %v", test.block, test.key, position, ok)
+		}
+	}
+
+	// An empty block has no keys, the next block is not part of it.
+	if _, ok := yamlBlockKeyPosition([]byte("parameters:\nservices:\n  foo:\n    class: Foo\n"), "parameters", "services"); ok {
+		t.Errorf("Key found in an empty block")
+	}
+}
+
+func TestServiceFileDiagnostics(t *testing.T) {
+	classes := []PhpClass{
+		{Namespace: "Drupal\\foo\\Foo", Methods: []PhpMethod{
+			{Name: "__construct", Parameters: []php.PhpParameter{{Name: "bar"}, {Name: "baz", Default: "NULL"}}},
+		}},
+		{Namespace: "Drupal\\foo\\FooChild", Parent: "Drupal\\foo\\Foo"},
+		{Namespace: "Drupal\\foo\\Unknown", Parent: "Drupal\\bar\\Base"},
+	}
+
+	service := &Service{
+		Definitions: []ParserDefinition{{Name: "bar"}},
+	}
+	service.SetClassIndex(testClassIndex(classes))
+
+	text := `services:
+  foo.valid:
+    class: Drupal\foo\Foo
+    arguments: ['@bar']
+  foo.missing:
+    class: Drupal\foo\Missing
+  foo.elsewhere:
+    class: Drupal\other\Missing
+  foo.few:
+    class: Drupal\foo\FooChild
+  foo.many:
+    class: Drupal\foo\Foo
+    arguments: ['@bar', '@bar', '@bar']
+  foo.unknown_parent:
+    class: Drupal\foo\Unknown
+  foo.factory:
+    class: Drupal\foo\Foo
+    factory: ['@bar', 'create']
+  Drupal\foo\Foo:
+    arguments: ['@bar']
+`
+
+	diagnostics := []string{}
+	for _, diagnostic := range service.FileDiagnostics("/foo/foo.services.yml", text) {
+		if diagnostic.Severity != lsp.SeverityWarning {
+			t.Errorf("Diagnostic %q is not a warning", diagnostic.Message)
+		}
+
+		diagnostics = append(diagnostics, fmt.Sprintf("%d:%v:%s", diagnostic.Code, diagnostic.Range.Start.Line, diagnostic.Message))
+	}
+
+	// Drupal\other isn't indexed, and the constructor of Unknown is
+	// unknown.
+	expected := []string{
+		"12:8:Drupal\\foo\\FooChild::__construct() expects 1 to 2 arguments, 0 given",
+		"12:12:Drupal\\foo\\Foo::__construct() expects 1 to 2 arguments, 3 given",
+		"11:5:Undefined class 'Drupal\\foo\\Missing'",
+	}
+	if strings.Join(diagnostics, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("FileDiagnostics() = %v", diagnostics)
+	}
+}
+
+func TestServiceArgument(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected ServiceArgument
+	}{
+		{"@foo", ServiceArgument{Kind: "service", Value: "foo"}},
+		{"@?foo", ServiceArgument{Kind: "service", Value: "foo", Optional: true}},
+		{"@@foo", ServiceArgument{Kind: "string", Value: "@foo"}},
+		{"%app.root%", ServiceArgument{Kind: "parameter", Value: "app.root"}},
+		{"%app.root%/core", ServiceArgument{Kind: "string", Value: "%app.root%/core"}},
+		{"foo", ServiceArgument{Kind: "string", Value: "foo"}},
+		{10, ServiceArgument{Kind: "value", Value: "10"}},
+		{true, ServiceArgument{Kind: "value", Value: "true"}},
+		{nil, ServiceArgument{Kind: "value", Value: "null"}},
+		{[]interface{}{"@foo", "bar"}, ServiceArgument{Kind: "value", Value: "[@foo, bar]"}},
+	}
+
+	for _, test := range tests {
+		if argument := serviceArgument(test.value); argument != test.expected {
+			t.Errorf("serviceArgument(%v) = %+v, want %+v", test.value, argument, test.expected)
+		}
+	}
+}
+
+func TestServiceYamlTags(t *testing.T) {
+	tests := []struct {
+		yaml     string
+		expected string
+	}{
+		{"[{ name: event_subscriber }]", "event_subscriber"},
+		{"[{ name: cache.bin, priority: 10, default_backend: cache.backend.memory }]", "cache.bin (default_backend: cache.backend.memory, priority: 10)"},
+		{"[event_subscriber, { name: needs_destruction }]", "event_subscriber,needs_destruction"},
+		{"[{ access_check: { applies_to: _foo } }]", "access_check (applies_to: _foo)"},
+		// Tags without a name are skipped.
+		{"[{ priority: 10, applies_to: _foo }, 10]", ""},
+		{"event_subscriber", ""},
+	}
+
+	for _, test := range tests {
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(test.yaml), &value); err != nil {
+			t.Fatal(err)
+		}
+
+		tags := []string{}
+		for _, tag := range serviceYamlTags(value) {
+			tags = append(tags, tag.String())
+		}
+
+		if strings.Join(tags, ",") != test.expected {
+			t.Errorf("serviceYamlTags(%s) = %v, want %s", test.yaml, tags, test.expected)
+		}
+	}
+}
+
+func TestServiceYamlCalls(t *testing.T) {
+	tests := []struct {
+		yaml     string
+		expected string
+	}{
+		{"[[setContainer, ['@service_container']]]", "setContainer(@service_container)"},
+		{"[[setFoo]]", "setFoo()"},
+		{"[{ method: setFoo, arguments: ['@foo', '%bar%'] }]", "setFoo(@foo, %bar%)"},
+		{"[{ setFoo: ['@?foo'] }]", "setFoo(@?foo)"},
+		{"[[setFoo, { $foo: '@foo' }]]", "setFoo($foo: @foo)"},
+		// Calls without a method are skipped.
+		{"[[], {}]", ""},
+	}
+
+	for _, test := range tests {
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(test.yaml), &value); err != nil {
+			t.Fatal(err)
+		}
+
+		calls := []string{}
+		for _, call := range serviceYamlCalls(value) {
+			arguments := []string{}
+			for _, argument := range call.Arguments {
+				arguments = append(arguments, argument.String())
+			}
+			calls = append(calls, call.Method+"("+strings.Join(arguments, ", ")+")")
+		}
+
+		if strings.Join(calls, ",") != test.expected {
+			t.Errorf("serviceYamlCalls(%s) = %v, want %s", test.yaml, calls, test.expected)
+		}
+	}
+}
+
+func TestServiceYamlDefinitions(t *testing.T) {
+	src := `services:
+  _defaults:
+    autowire: true
+    public: false
+    tags: [foo_tag]
+  _instanceof:
+    Drupal\foo\FooInterface:
+      tags: [instance_tag]
+  foo:
+    class: \Drupal\foo\Foo
+    arguments: ['@bar', '%foo.parameter%', 10]
+    tags:
+      - { name: event_subscriber }
+  foo.public:
+    class: Drupal\foo\Foo
+    public: true
+    autowire: false
+  foo.alias: '@foo'
+  foo.alias_key:
+    alias: foo
+  foo.child:
+    parent: foo
+    abstract: true
+    decorates: bar
+  foo.factory:
+    factory: ['@foo', 'create']
+    calls:
+      - [setBar, ['@bar']]
+  Drupal\foo\Bar: ~
+`
+
+	file := &ServiceYaml{}
+	if err := yaml.Unmarshal([]byte(src), file); err != nil {
+		t.Fatal(err)
+	}
+
+	definitions := file.Definitions()
+
+	names := sortedServiceNames(definitions)
+	if strings.Join(names, ",") != "Drupal\\foo\\Bar,foo,foo.alias,foo.alias_key,foo.child,foo.factory,foo.public" {
+		t.Errorf("Definitions() = %v", names)
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		// The defaults apply to every service, unless it overrides them.
+		{"foo", "Class: Drupal\\foo\\Foo\nArguments: @bar, %foo.parameter%, 10\nTags: foo_tag, event_subscriber\nFlags: private, autowired"},
+		{"foo.public", "Class: Drupal\\foo\\Foo\nTags: foo_tag"},
+		{"foo.alias", "Alias of: foo\nTags: foo_tag\nFlags: private, autowired"},
+		{"foo.alias_key", "Alias of: foo\nTags: foo_tag\nFlags: private, autowired"},
+		{"foo.child", "Parent: foo\nDecorates: bar\nTags: foo_tag\nFlags: private, autowired, abstract"},
+		{"foo.factory", "Factory: @foo::create\nTags: foo_tag\nCalls: setBar(@bar)\nFlags: private, autowired"},
+		{"Drupal\\foo\\Bar", "Tags: foo_tag\nFlags: private, autowired"},
+	}
+
+	for _, test := range tests {
+		if description := serviceDescription(definitions[test.name]); description != test.expected {
+			t.Errorf("Definitions()[%s] = %q, want %q", test.name, description, test.expected)
+		}
+	}
+}
+
+func TestServiceAddDefinitions(t *testing.T) {
+	// The parameters block is empty, the services block follows it.
+	_, paths, cleanup := writeFiles(t, [][2]string{
+		{"foo/foo.services.yml", "parameters:\nservices:\n  foo:\n    class: Drupal\\foo\\Foo\n"},
+	})
+	defer cleanup()
+
+	service := &Service{}
+	service.AddDefinitions(paths)
+
+	if len(service.Parameters) != 0 {
+		t.Errorf("Parameters = %+v", service.Parameters)
+	}
+
+	defs := service.GetDefinitions()
+	if len(defs) != 1 || defs[0].Name != "foo" || defs[0].Class != "Drupal\\foo\\Foo" || defs[0].Position.Line != 2 || defs[0].Position.Character != 2 {
+		t.Errorf("GetDefinitions() = %+v", defs)
+	}
+}
+
+func TestResolveService(t *testing.T) {
+	services := map[string]ServiceDefinition{}
+	for _, service := range []ServiceDefinition{
+		{Name: "foo", Class: "Drupal\\foo\\Foo", Arguments: []ServiceArgument{{Kind: "service", Value: "bar"}}},
+		{Name: "foo.alias", Alias: "foo"},
+		{Name: "foo.alias_alias", Alias: "foo.alias"},
+		{Name: "foo.base", Abstract: true, Factory: "@foo::create", Arguments: []ServiceArgument{{Kind: "parameter", Value: "foo.options"}}},
+		{Name: "foo.child", Parent: "foo.base", Arguments: []ServiceArgument{{Kind: "string", Value: "baz"}}},
+		{Name: "foo.grandchild", Parent: "foo.child", Class: "Drupal\\foo\\Grandchild"},
+		{Name: "loop.a", Alias: "loop.b"},
+		{Name: "loop.b", Alias: "loop.a"},
+		{Name: "Drupal\\foo\\Bar"},
+	} {
+		services[service.Name] = service
+	}
+
+	tests := []struct {
+		name      string
+		class     string
+		factory   string
+		arguments int
+		path      string
+	}{
+		{"foo", "Drupal\\foo\\Foo", "", 1, "foo"},
+		{"foo.alias_alias", "Drupal\\foo\\Foo", "", 1, "foo.alias_alias -> foo.alias (alias) -> foo (alias)"},
+		// The arguments of the parents come first.
+		{"foo.grandchild", "Drupal\\foo\\Grandchild", "@foo::create", 2, "foo.grandchild -> foo.child (parent) -> foo.base (parent)"},
+		{"loop.a", "", "", 0, "loop.a -> loop.b (alias)"},
+		{"Drupal\\foo\\Bar", "Drupal\\foo\\Bar", "", 0, "Drupal\\foo\\Bar -> class from service id"},
+	}
+
+	for _, test := range tests {
+		service, path := resolveService(services, services[test.name])
+		if service.Class != test.class || service.Factory != test.factory || len(service.Arguments) != test.arguments || strings.Join(path, " -> ") != test.path {
+			t.Errorf("resolveService(%s) = %+v, %v", test.name, service, path)
+		}
+	}
+
+	if service, _ := resolveService(services, services["foo.grandchild"]); service.Arguments[0].Value != "foo.options" {
+		t.Errorf("resolveService(foo.grandchild) arguments = %+v", service.Arguments)
+	}
+}
+
+func TestServiceResolvedDefinitions(t *testing.T) {
+	_, paths, cleanup := writeFiles(t, [][2]string{
+		{"foo/foo.services.yml", "services:\n  foo:\n    class: Drupal\\foo\\Foo\n  foo.alias:\n    alias: foo\n"},
+		{"bar/bar.services.yml", "services:\n  foo:\n    class: Drupal\\bar\\Foo\n"},
+	})
+	defer cleanup()
+
+	service := &Service{}
+	service.AddDefinitions(paths)
+
+	classes := func() map[string]string {
+		result := map[string]string{}
+		for _, def := range service.GetDefinitions() {
+			result[def.File+":"+def.Name] = def.Class
+		}
+		return result
+	}
+
+	// The last declaration of a service wins.
+	if class := classes()[paths[0]+":foo.alias"]; class != "Drupal\\bar\\Foo" {
+		t.Errorf("GetDefinitions()[foo.alias] = %s", class)
+	}
+
+	// Removing it resolves the services again.
+	service.RemoveDefinitions(paths[1:])
+	if class := classes()[paths[0]+":foo.alias"]; class != "Drupal\\foo\\Foo" {
+		t.Errorf("GetDefinitions()[foo.alias] after RemoveDefinitions() = %s", class)
+	}
+}
+
+func TestServiceDiagnostics(t *testing.T) {
+	service := &Service{}
+	defs := []ParserDefinition{{Name: "messenger"}}
+
+	tests := []struct {
+		text     string
+		expected int
+	}{
+		{"<?php\n\\Drupal::service('messenger');\n\\Drupal::service('missing');\n", 1},
+		// Dynamic method names.
+		{"<?php\n\\Drupal::$method('missing');\n", 0},
+		// Documents being typed don't parse.
+		{"<?php class A { public function b() { $this->", 0},
+	}
+
+	for _, test := range tests {
+		if diagnostics := service.Diagnostics(test.text, defs); len(diagnostics) != test.expected {
+			t.Errorf("Diagnostics(%q) = %+v, want %d", test.text, diagnostics, test.expected)
+		}
+	}
+}
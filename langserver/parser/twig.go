@@ -0,0 +1,853 @@
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nkoporec/drupal-lsp/php"
+	"github.com/nkoporec/drupal-lsp/utils"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+type Twig struct {
+	// Templates keyed by their namespaced name, eg. @node/node.html.twig,
+	// and preprocess functions keyed by their hook, eg. node__article.
+	Definitions []ParserDefinition
+	// Theme hooks declared by hook_theme(), keyed by their name.
+	Hooks map[string]ThemeHook
+}
+
+type ThemeHook struct {
+	Name string
+	// Variables of the template, or the render element it receives.
+	Variables     []string
+	RenderElement string
+	// Template without the extension, eg. node-edit-form.
+	Template string
+	// Theme function of hooks that don't use a template.
+	Function string
+	File     string
+	Position lsp.Position
+}
+
+// Variables that template_preprocess() adds to every template.
+var twigDefaultVariables = []string{
+	"attributes",
+	"title_attributes",
+	"content_attributes",
+	"title_prefix",
+	"title_suffix",
+	"db_is_active",
+	"is_admin",
+	"logged_in",
+	"user",
+	"directory",
+}
+
+// Functions added by the Drupal twig extension, and their arguments.
+var twigFunctions = map[string]string{
+	"attach_library":    "library",
+	"path":              "name, parameters, options",
+	"url":               "name, parameters, options",
+	"file_url":          "uri",
+	"link":              "text, url, attributes",
+	"render_var":        "arg",
+	"active_theme":      "",
+	"active_theme_path": "",
+	"create_attribute":  "attributes",
+}
+
+// Filters added by the Drupal twig extension.
+var twigFilters = map[string]string{
+	"t":              "Translates a string",
+	"trans":          "Translates a string",
+	"placeholder":    "Escapes and emphasizes a string",
+	"without":        "Prints a render array without the given keys",
+	"clean_class":    "Prepares a string for use as a css class",
+	"clean_id":       "Prepares a string for use as a css id",
+	"render":         "Renders a render array",
+	"format_date":    "Formats a timestamp",
+	"safe_join":      "Joins values with a separator",
+	"add_class":      "Adds css classes to an element",
+	"set_attribute":  "Sets an attribute of an element",
+	"add_suggestion": "Adds a template suggestion",
+}
+
+// Files that can declare theme hooks or preprocess functions, eg.
+// node.module or theme.inc.
+var twigHookFileExtensions = []string{
+	".module",
+	".theme",
+	".inc",
+}
+
+// A template being typed, eg. {% include '@node/ or include('@node/
+var twigTemplateCompletionRegex = regexp.MustCompile(`(?:^\{%-?\s*(?:include|extends|embed|import|from)\s+|(?:include|source)\(\s*)['"]([^'"]*)$`)
+
+// The templates used by a template.
+var twigTemplateRegex = regexp.MustCompile(`(?:\{%-?\s*(?:include|extends|embed|import|from)\s+|(?:include|source)\(\s*)['"]([^'"]+)['"]`)
+
+// The theme hook of a render array, eg. '#theme' => 'item_list'.
+var themeRenderArrayRegex = regexp.MustCompile(`['"]#theme['"]\s*(?:=>|\]\s*=)\s*['"](\w+)['"]`)
+
+// The theme hook of a render array, while it's being typed.
+var themeCompletionRegex = regexp.MustCompile(`['"]#theme['"]\s*(?:=>|\]\s*=)\s*['"](\w*)$`)
+
+// The hooks of a hook_theme() implementation, either returned or assigned,
+// eg. return $items + [...] or $items['foo'] = [...].
+var twigHookArrayRegex = regexp.MustCompile(`return\s*(?:\$\w+\s*\+\s*)?(?:\[|array\s*\()|\$\w+\[\s*['"](\w+)['"]\s*\]\s*=\s*(?:\[|array\s*\()`)
+
+// The functions that declare theme hooks or preprocess variables, eg.
+// node_theme() or olivero_preprocess_node().
+var twigHookFunctionRegex = regexp.MustCompile(`function\s+&?\s*\w+_(?:theme|preprocess_\w+)\s*\(`)
+
+func (t *Twig) ParseFile(path string) interface{} {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	if strings.HasSuffix(path, ".html.twig") {
+		return file
+	}
+
+	parsedDoc := parseHookFile(file)
+	if parsedDoc == nil {
+		return nil
+	}
+
+	return parsedDoc
+}
+
+func (t *Twig) AddDefinitions(items []string) {
+	if t.Hooks == nil {
+		t.Hooks = make(map[string]ThemeHook)
+	}
+
+	for _, file := range items {
+		if strings.HasSuffix(file, ".html.twig") {
+			if name := templateName(file); name != "" {
+				t.Definitions = append(t.Definitions, ParserDefinition{
+					Name: name,
+					Type: "template",
+					File: file,
+				})
+			}
+			continue
+		}
+
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		parsedDoc := parseHookFile(src)
+		if parsedDoc == nil {
+			continue
+		}
+
+		extension := fileExtensionName(file)
+		for _, function := range parsedDoc.Functions {
+			// template_preprocess_node() or olivero_preprocess_node__article()
+			for _, prefix := range []string{"template_preprocess_", extension + "_preprocess_"} {
+				if !strings.HasPrefix(function.Name, prefix) || function.Name == prefix {
+					continue
+				}
+
+				line, column := php.LineColumn(src, function.Position.StartPos)
+				t.Definitions = append(t.Definitions, ParserDefinition{
+					Name:   strings.TrimPrefix(function.Name, prefix),
+					Type:   "preprocess",
+					Method: function.Name,
+					File:   file,
+					Position: lsp.Position{
+						Line:      float64(line),
+						Character: float64(column),
+					},
+				})
+			}
+
+			if function.Name != extension+"_theme" || function.Body == nil {
+				continue
+			}
+
+			for _, hook := range themeHooks(src, function.Body.StartPos, function.Body.EndPos) {
+				hook.File = file
+				t.Hooks[hook.Name] = hook
+			}
+		}
+	}
+}
+
+func (t *Twig) RemoveDefinitions(items []string) {
+	t.Definitions = removeFileDefinitions(t.Definitions, items)
+
+	if len(items) == 0 {
+		return
+	}
+
+	removed := make(map[string]bool, len(items))
+	for _, file := range items {
+		removed[file] = true
+	}
+
+	for name, hook := range t.Hooks {
+		if removed[hook.File] {
+			delete(t.Hooks, name)
+		}
+	}
+}
+
+func (t *Twig) FileExtension() string {
+	return ".html.twig"
+}
+
+// Templates and the files that can declare theme hooks. Only the files
+// with a hook_theme() or preprocess function are parsed.
+func (t *Twig) MatchFile(path string) bool {
+	if strings.HasSuffix(path, ".html.twig") {
+		return true
+	}
+
+	for _, ext := range twigHookFileExtensions {
+		if filepath.Ext(path) == ext {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Twig) Methods() []string {
+	return []string{}
+}
+
+func (t *Twig) GetDefinitions() []ParserDefinition {
+	return t.Definitions
+}
+
+func (t *Twig) CompletionItem(def ParserDefinition) (lsp.CompletionItem, error) {
+	return lsp.CompletionItem{
+		Kind:   lsp.FileCompletion,
+		Label:  def.Name,
+		Detail: "Template",
+		Documentation: lsp.MarkupContent{
+			Kind:  lsp.PlainText,
+			Value: def.File,
+		},
+	}, nil
+}
+
+// Complete templates, variables, functions and filters inside twig
+// expressions.
+func (t *Twig) DocumentCompletion(path string, text string, position lsp.Position) []lsp.CompletionItem {
+	result := []lsp.CompletionItem{}
+
+	if utils.InSlice(PhpFileExtensions, filepath.Ext(path)) {
+		return t.themeCompletion(text, position)
+	}
+
+	if !strings.HasSuffix(path, ".twig") {
+		return result
+	}
+
+	expression, ok := twigExpression(text, position)
+	if !ok {
+		return result
+	}
+
+	// A template inside a string, eg. {% include '@node/
+	if match := twigTemplateCompletionRegex.FindStringSubmatch(expression); match != nil {
+		editRange := lsp.Range{
+			Start: lsp.Position{
+				Line:      position.Line,
+				Character: position.Character - float64(len(match[1])),
+			},
+			End: position,
+		}
+
+		for _, def := range t.GetDefinitions() {
+			if def.Type != "template" {
+				continue
+			}
+
+			completion, err := t.CompletionItem(def)
+			if err != nil {
+				continue
+			}
+
+			// Template names contain slashes and dots, so replace
+			// everything that was typed.
+			completion.FilterText = def.Name
+			completion.TextEdit = &lsp.TextEdit{
+				Range:   editRange,
+				NewText: def.Name,
+			}
+
+			result = append(result, completion)
+		}
+
+		return result
+	}
+
+	// Skip other strings and attributes, eg. node.label.
+	if (strings.Count(expression, "'")+strings.Count(expression, "\""))%2 == 1 {
+		return result
+	}
+
+	before := strings.TrimRight(strings.TrimRightFunc(expression, isTwigNameRune), " ")
+	switch {
+	case strings.HasSuffix(before, "|"):
+		for _, name := range sortedKeys(twigFilters) {
+			result = append(result, lsp.CompletionItem{
+				Kind:   lsp.FunctionCompletion,
+				Label:  name,
+				Detail: twigFilters[name],
+			})
+		}
+	case strings.HasSuffix(before, "."):
+		// The attributes of a variable are unknown.
+	default:
+		hookName := ""
+		variables := twigDefaultVariables
+		if hook, ok := t.templateHook(path); ok {
+			hookName = hook.Name
+			variables = append(append([]string{}, hook.Variables...), twigDefaultVariables...)
+			if hook.RenderElement != "" {
+				variables = append([]string{hook.RenderElement}, variables...)
+			}
+		}
+
+		for _, name := range variables {
+			detail := "Variable"
+			if hookName != "" {
+				detail = fmt.Sprintf("Variable of %s", hookName)
+			}
+
+			result = append(result, lsp.CompletionItem{
+				Kind:   lsp.VariableCompletion,
+				Label:  name,
+				Detail: detail,
+			})
+		}
+
+		for _, name := range sortedKeys(twigFunctions) {
+			result = append(result, lsp.CompletionItem{
+				Kind:   lsp.FunctionCompletion,
+				Label:  name,
+				Detail: fmt.Sprintf("%s(%s)", name, twigFunctions[name]),
+			})
+		}
+	}
+
+	return result
+}
+
+func (t *Twig) Diagnostics(text string, defs []ParserDefinition) []lsp.Diagnostic {
+	return []lsp.Diagnostic{}
+}
+
+// Check the templates used by a template, and the templates of the theme
+// hooks declared by a module or theme.
+func (t *Twig) FileDiagnostics(path string, text string) []lsp.Diagnostic {
+	result := []lsp.Diagnostic{}
+
+	// Without any template the index is incomplete.
+	if len(t.GetDefinitions()) == 0 {
+		return result
+	}
+
+	if utils.InSlice(twigHookFileExtensions, filepath.Ext(path)) {
+		return t.hookDiagnostics(path, text)
+	}
+
+	if !strings.HasSuffix(path, ".twig") {
+		return result
+	}
+
+	for _, ref := range twigTemplateReferences(text) {
+		if len(t.GetGoToDefinition(ref.name)) > 0 {
+			continue
+		}
+
+		result = append(result, lsp.Diagnostic{
+			Code:     9,
+			Message:  fmt.Sprintf("Undefined template '%s'", ref.name),
+			Source:   "drupal-lsp",
+			Severity: lsp.SeverityError,
+			Range:    ref.Range(),
+		})
+	}
+
+	return result
+}
+
+// Get the template under the cursor, or the templates and preprocess
+// functions of the theme hook of a render array.
+func (t *Twig) DocumentDefinition(path string, text string, position lsp.Position) []ParserDefinition {
+	if hook, ok := themeHookAt(text, position); ok {
+		return t.hookDefinitions(hook)
+	}
+
+	if !strings.HasSuffix(path, ".twig") {
+		return []ParserDefinition{}
+	}
+
+	for _, ref := range twigTemplateReferences(text) {
+		r := ref.Range()
+		if r.Start.Line == position.Line && r.Start.Character <= position.Character && position.Character <= r.End.Character {
+			return t.GetGoToDefinition(ref.name)
+		}
+	}
+
+	return []ParserDefinition{}
+}
+
+func (t *Twig) GetGoToDefinition(params string) []ParserDefinition {
+	result := make([]ParserDefinition, 0, 200)
+
+	for _, def := range t.GetDefinitions() {
+		if def.Name == params {
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Describe the theme hook of a render array under the cursor.
+func (t *Twig) Hover(path string, text string, position lsp.Position) string {
+	name, ok := themeHookAt(text, position)
+	if !ok {
+		return ""
+	}
+
+	base := strings.SplitN(name, "__", 2)[0]
+	hook, ok := t.Hooks[base]
+	if !ok {
+		return ""
+	}
+
+	lines := []string{name}
+	if hook.RenderElement != "" {
+		lines = append(lines, "render element: "+hook.RenderElement)
+	}
+
+	if len(hook.Variables) > 0 {
+		lines = append(lines, "variables: "+strings.Join(hook.Variables, ", "))
+	}
+
+	templates := []string{}
+	preprocess := []string{}
+	for _, def := range t.hookDefinitions(name) {
+		switch def.Type {
+		case "template":
+			templates = append(templates, "  "+def.Name)
+		case "preprocess":
+			preprocess = append(preprocess, "  "+def.Method)
+		}
+	}
+
+	if len(templates) > 0 {
+		lines = append(append(lines, "templates:"), templates...)
+	}
+
+	if len(preprocess) > 0 {
+		lines = append(append(lines, "preprocess:"), preprocess...)
+	}
+
+	if suggestions := t.suggestions(hook); len(suggestions) > 0 {
+		lines = append(lines, "suggestions:")
+		for _, suggestion := range suggestions {
+			lines = append(lines, "  "+suggestion)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Complete the theme hooks of render arrays, eg. '#theme' => 'item_list'.
+func (t *Twig) themeCompletion(text string, position lsp.Position) []lsp.CompletionItem {
+	result := []lsp.CompletionItem{}
+
+	line, ok := linePrefix(text, position)
+	if !ok || !themeCompletionRegex.MatchString(line) {
+		return result
+	}
+
+	for _, name := range t.hookNames() {
+		hook := t.Hooks[name]
+
+		detail := hook.Template + ".html.twig"
+		if hook.Function != "" {
+			detail = hook.Function + "()"
+		}
+
+		result = append(result, lsp.CompletionItem{
+			Kind:   lsp.ValueCompletion,
+			Label:  name,
+			Detail: detail,
+			Documentation: lsp.MarkupContent{
+				Kind:  lsp.PlainText,
+				Value: strings.Join(hook.Variables, ", "),
+			},
+		})
+	}
+
+	return result
+}
+
+// Report the theme hooks of hook_theme() that have no template.
+func (t *Twig) hookDiagnostics(path string, text string) []lsp.Diagnostic {
+	result := []lsp.Diagnostic{}
+	src := []byte(text)
+
+	extension := fileExtensionName(path)
+	if !strings.Contains(text, extension+"_theme") {
+		return result
+	}
+
+	parsedDoc, err := php.Parse(src)
+	if err != nil {
+		log.Println(err)
+		return result
+	}
+
+	for _, function := range parsedDoc.Functions {
+		if function.Name != extension+"_theme" || function.Body == nil {
+			continue
+		}
+
+		for _, hook := range themeHooks(src, function.Body.StartPos, function.Body.EndPos) {
+			if hook.Function != "" || len(t.templates(hook.Template)) > 0 {
+				continue
+			}
+
+			end := hook.Position
+			end.Character += float64(len(hook.Name))
+
+			result = append(result, lsp.Diagnostic{
+				Code:     10,
+				Message:  fmt.Sprintf("Theme hook '%s' has no template '%s.html.twig'", hook.Name, hook.Template),
+				Source:   "drupal-lsp",
+				Severity: lsp.SeverityError,
+				Range: lsp.Range{
+					Start: hook.Position,
+					End:   end,
+				},
+			})
+		}
+	}
+
+	return result
+}
+
+// Get the templates and preprocess functions of a theme hook or of one of
+// its suggestions, eg. node__article.
+func (t *Twig) hookDefinitions(name string) []ParserDefinition {
+	result := []ParserDefinition{}
+
+	base := strings.SplitN(name, "__", 2)[0]
+	hook, ok := t.Hooks[base]
+	if !ok {
+		return result
+	}
+
+	// The template of the suggestion, if there is one.
+	template := strings.Replace(name, "_", "-", -1)
+	templates := t.templates(template)
+	if len(templates) == 0 || name == base {
+		templates = t.templates(hook.Template)
+	}
+	result = append(result, templates...)
+
+	for _, def := range t.GetDefinitions() {
+		if def.Type == "preprocess" && (def.Name == base || def.Name == name) {
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Get the templates with the given name, eg. node--article or
+// form/node-edit-form, in any module or theme.
+func (t *Twig) templates(template string) []ParserDefinition {
+	result := []ParserDefinition{}
+
+	for _, def := range t.GetDefinitions() {
+		if def.Type == "template" && isTemplate(def.Name, template) {
+			result = append(result, def)
+		}
+	}
+
+	return result
+}
+
+// Get the template suggestions of a theme hook that have a template, eg.
+// node--article--teaser.
+func (t *Twig) suggestions(hook ThemeHook) []string {
+	result := []string{}
+	seen := make(map[string]bool)
+
+	for _, def := range t.GetDefinitions() {
+		template := strings.TrimSuffix(filepath.Base(def.File), ".html.twig")
+		if def.Type != "template" || !strings.HasPrefix(template, path.Base(hook.Template)+"--") || seen[template] {
+			continue
+		}
+		seen[template] = true
+
+		result = append(result, template)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// Get the theme hook of a render array under the cursor.
+func themeHookAt(text string, position lsp.Position) (string, bool) {
+	lines := strings.Split(text, "\n")
+	if int(position.Line) >= len(lines) {
+		return "", false
+	}
+
+	line := lines[int(position.Line)]
+	character := LineOffset(line, position.Character)
+	for _, match := range themeRenderArrayRegex.FindAllStringSubmatchIndex(line, -1) {
+		if match[2] <= character && character <= match[3] {
+			return line[match[2]:match[3]], true
+		}
+	}
+
+	return "", false
+}
+
+// Get the theme hook of a template, eg. node--article.html.twig uses the
+// node hook.
+func (t *Twig) templateHook(path string) (ThemeHook, bool) {
+	path = filepath.ToSlash(path)
+	template := strings.TrimSuffix(filepath.Base(path), ".html.twig")
+
+	// The hook named after the template comes first, eg. item_list for
+	// item-list.html.twig.
+	if hook, ok := t.Hooks[strings.Replace(template, "-", "_", -1)]; ok && isTemplate(path, hook.Template) {
+		return hook, true
+	}
+
+	for _, name := range t.hookNames() {
+		if hook := t.Hooks[name]; isTemplate(path, hook.Template) {
+			return hook, true
+		}
+	}
+
+	// Suggestions of a hook, eg. node--article.
+	base := strings.SplitN(template, "--", 2)[0]
+	hook, ok := t.Hooks[strings.Replace(base, "-", "_", -1)]
+
+	return hook, ok
+}
+
+// Get the names of the theme hooks, sorted.
+func (t *Twig) hookNames() []string {
+	names := []string{}
+	for name := range t.Hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Parse a module or theme file, unless it has no hook_theme() or preprocess
+// function.
+func parseHookFile(src []byte) *php.ParsedDoc {
+	if !twigHookFunctionRegex.Match(src) {
+		return nil
+	}
+
+	parsedDoc, err := php.Parse(src)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return parsedDoc
+}
+
+// Read the theme hooks of a hook_theme() body.
+func themeHooks(src []byte, start int, end int) []ThemeHook {
+	result := []ThemeHook{}
+
+	body := src[start:end]
+	for _, match := range twigHookArrayRegex.FindAllSubmatchIndex(body, -1) {
+		// The array starts with its bracket.
+		arrayStart := match[1] - 1
+		if body[arrayStart] == '(' {
+			arrayStart = match[0] + strings.LastIndex(string(body[match[0]:match[1]]), "array")
+		}
+
+		values, _ := php.ParseArray(body, arrayStart)
+		hooks := map[string]interface{}{}
+		if match[2] != -1 {
+			hooks[string(body[match[2]:match[3]])] = values
+		} else {
+			hooks = values
+		}
+
+		for name, value := range hooks {
+			info, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			hook := ThemeHook{
+				Name:     name,
+				Template: strings.Replace(name, "_", "-", -1),
+			}
+
+			if template, ok := info["template"].(string); ok && template != "" {
+				hook.Template = template
+			}
+
+			if element, ok := info["render element"].(string); ok {
+				hook.RenderElement = element
+			}
+
+			if function, ok := info["function"].(string); ok {
+				hook.Function = function
+			}
+
+			variables, _ := info["variables"].(map[string]interface{})
+			for variable := range variables {
+				hook.Variables = append(hook.Variables, variable)
+			}
+			sort.Strings(hook.Variables)
+
+			// Point to the name of the hook.
+			for _, quote := range []string{"'", "\""} {
+				if index := strings.Index(string(body[match[0]:]), quote+name+quote); index != -1 {
+					line, column := php.LineColumn(src, start+match[0]+index+1)
+					hook.Position = lsp.Position{
+						Line:      float64(line),
+						Character: float64(column),
+					}
+					break
+				}
+			}
+
+			result = append(result, hook)
+		}
+	}
+
+	return result
+}
+
+// Whether a template, by its namespaced name or path, is the template of a
+// theme hook. The template of a hook is relative to the templates directory,
+// eg. form/node-edit-form, and templates can be in any subdirectory of it.
+func isTemplate(name string, template string) bool {
+	return strings.HasSuffix(name, "/"+template+".html.twig")
+}
+
+// Get the name of the module or theme of a file, eg. node for
+// node.pages.inc.
+func fileExtensionName(path string) string {
+	return strings.SplitN(filepath.Base(path), ".", 2)[0]
+}
+
+// Get the namespaced name of a template, eg. @node/field/field.html.twig
+// for core/modules/node/templates/field/field.html.twig. Templates outside
+// the templates directory of an extension have no name.
+func templateName(path string) string {
+	parts := strings.Split(path, string(filepath.Separator))
+
+	for i := len(parts) - 1; i > 0; i-- {
+		if parts[i] != "templates" {
+			continue
+		}
+
+		extension := parts[i-1]
+		dir := strings.Join(parts[:i], string(filepath.Separator))
+		if _, err := os.Stat(filepath.Join(dir, extension+".info.yml")); err != nil {
+			continue
+		}
+
+		return "@" + extension + "/" + strings.Join(parts[i+1:], "/")
+	}
+
+	return ""
+}
+
+// Get the twig expression or tag before the cursor, eg. {{ node.label if
+// the cursor is inside one.
+func twigExpression(text string, position lsp.Position) (string, bool) {
+	line, ok := linePrefix(text, position)
+	if !ok {
+		return "", false
+	}
+
+	lines := strings.Split(text, "\n")
+	before := strings.Join(append(lines[:int(position.Line)], line), "\n")
+
+	open := strings.LastIndex(before, "{{")
+	if tag := strings.LastIndex(before, "{%"); tag > open {
+		open = tag
+	}
+
+	closed := strings.LastIndex(before, "}}")
+	if tag := strings.LastIndex(before, "%}"); tag > closed {
+		closed = tag
+	}
+
+	if open == -1 || closed > open {
+		return "", false
+	}
+
+	return before[open:], true
+}
+
+// Find the namespaced templates used by a template, eg. {% include
+// '@node/node.html.twig' %}.
+func twigTemplateReferences(text string) []nameRef {
+	result := []nameRef{}
+
+	for i, line := range strings.Split(text, "\n") {
+		for _, match := range twigTemplateRegex.FindAllStringSubmatchIndex(line, -1) {
+			name := line[match[2]:match[3]]
+
+			// Only namespaced templates can be resolved, components use
+			// their own syntax, eg. olivero:teaser.
+			if !strings.HasPrefix(name, "@") {
+				continue
+			}
+
+			result = append(result, nameRef{name, i, match[2], line})
+		}
+	}
+
+	return result
+}
+
+func isTwigNameRune(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
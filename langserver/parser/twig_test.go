@@ -0,0 +1,240 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+const twigModule = `<?php
+
+function foo_theme($existing, $type, $theme, $path) {
+  return [
+    'foo_item' => [
+      'variables' => ['label' => NULL, 'items' => []],
+    ],
+    'foo_form' => [
+      'render element' => 'form',
+    ],
+    'foo_missing' => [
+      'variables' => [],
+    ],
+    'foo_legacy' => [
+      'function' => 'theme_foo_legacy',
+    ],
+    'foo_page' => [
+      'variables' => ['content' => NULL],
+      'template' => 'page/foo-page',
+    ],
+  ];
+}
+
+function template_preprocess_foo_item(&$variables) {
+}
+`
+
+// Write a module with templates, and index them.
+func twigFixture(t *testing.T) (*Twig, []string, func()) {
+	_, paths, cleanup := writeFiles(t, [][2]string{
+		{"foo/foo.info.yml", "name: Foo\ntype: module\n"},
+		{"foo/foo.module", twigModule},
+		{"foo/templates/foo-item.html.twig", "{{ label }}\n"},
+		{"foo/templates/form/foo-form.html.twig", "{{ form }}\n"},
+		{"foo/foo.install", "<?php\n\nfunction foo_install() {\n}\n"},
+		{"foo/templates/page/foo-page.html.twig", "{{ content }}\n"},
+		{"foo/foo.pages.inc", "<?php\n\nfunction foo_preprocess_foo_page(&$variables) {\n}\n"},
+	})
+
+	twig := &Twig{}
+	twig.AddDefinitions(paths)
+
+	return twig, paths, cleanup
+}
+
+func TestTwigDefinitions(t *testing.T) {
+	twig, paths, cleanup := twigFixture(t)
+	defer cleanup()
+
+	names := []string{}
+	for _, def := range twig.GetDefinitions() {
+		names = append(names, def.Type+":"+def.Name)
+	}
+
+	expected := "preprocess:foo_item template:@foo/foo-item.html.twig template:@foo/form/foo-form.html.twig template:@foo/page/foo-page.html.twig preprocess:foo_page"
+	if strings.Join(names, " ") != expected {
+		t.Errorf("GetDefinitions() = %v, want %s", names, expected)
+	}
+
+	if hook := twig.Hooks["foo_item"]; strings.Join(hook.Variables, ",") != "items,label" || hook.Template != "foo-item" {
+		t.Errorf("Hooks[foo_item] = %+v", hook)
+	}
+
+	if hook := twig.Hooks["foo_form"]; hook.RenderElement != "form" {
+		t.Errorf("Hooks[foo_form] = %+v", hook)
+	}
+
+	// Files without theme hooks or preprocess functions aren't parsed.
+	if twig.ParseFile(paths[4]) != nil {
+		t.Errorf("ParseFile(%s) parsed a file without theme hooks", paths[4])
+	}
+
+	twig.RemoveDefinitions(paths[1:2])
+	if len(twig.Hooks) != 0 || len(twig.GetDefinitions()) != 4 {
+		t.Errorf("RemoveDefinitions() left %d hooks and %d definitions", len(twig.Hooks), len(twig.GetDefinitions()))
+	}
+}
+
+func TestTwigDocumentCompletion(t *testing.T) {
+	twig, paths, cleanup := twigFixture(t)
+	defer cleanup()
+
+	tests := []struct {
+		path     string
+		text     string
+		expected string
+	}{
+		{paths[2], "{{ la", "label"},
+		{paths[2], "{{ la", "attributes"},
+		{paths[3], "{% if fo", "form"},
+		{paths[2], "{{ label|", "clean_class"},
+		{paths[2], "{{ attach", "attach_library"},
+		{paths[2], "{% include '@foo/", "@foo/form/foo-form.html.twig"},
+		{paths[1], "$build = ['#theme' => 'foo_", "foo_item"},
+		{"/bar/src/Controller/BarController.php", "$build = ['#theme' => 'foo_", "foo_page"},
+		// Templates in a subdirectory of the templates directory.
+		{paths[5], "{{ con", "content"},
+		// Outside of expressions and strings.
+		{paths[2], "la", ""},
+		{paths[2], "{{ 'la", ""},
+		{paths[2], "{{ label }} la", ""},
+		{paths[1], "$build = ['#markup' => 'foo_", ""},
+	}
+
+	for _, test := range tests {
+		lines := strings.Split(test.text, "\n")
+		position := lsp.Position{
+			Line:      float64(len(lines) - 1),
+			Character: float64(len(lines[len(lines)-1])),
+		}
+
+		labels := []string{}
+		for _, item := range twig.DocumentCompletion(test.path, test.text, position) {
+			labels = append(labels, item.Label)
+		}
+
+		if test.expected == "" {
+			if len(labels) > 0 {
+				t.Errorf("DocumentCompletion(%q) = %v, want none", test.text, labels)
+			}
+			continue
+		}
+
+		found := false
+		for _, label := range labels {
+			found = found || label == test.expected
+		}
+
+		if !found {
+			t.Errorf("DocumentCompletion(%q) = %v, want %s", test.text, labels, test.expected)
+		}
+	}
+}
+
+func TestTwigFileDiagnostics(t *testing.T) {
+	twig, paths, cleanup := twigFixture(t)
+	defer cleanup()
+
+	// The template of foo_page is in a subdirectory.
+	diagnostics := twig.FileDiagnostics(paths[1], twigModule)
+	if len(diagnostics) != 1 || diagnostics[0].Code != 10 || !strings.Contains(diagnostics[0].Message, "foo_missing") {
+		t.Fatalf("FileDiagnostics(foo.module) = %+v", diagnostics)
+	}
+
+	if start := diagnostics[0].Range.Start; start.Line != 10 || start.Character != 5 {
+		t.Errorf("FileDiagnostics(foo.module) at %+v", start)
+	}
+
+	text := "{% include '@foo/foo-item.html.twig' %}\n{% include '@foo/missing.html.twig' %}\n{% include 'olivero:teaser' %}\n"
+	diagnostics = twig.FileDiagnostics(paths[2], text)
+	if len(diagnostics) != 1 || diagnostics[0].Code != 9 || diagnostics[0].Range.Start.Line != 1 {
+		t.Errorf("FileDiagnostics(foo-item.html.twig) = %+v", diagnostics)
+	}
+}
+
+func TestTwigTemplateHook(t *testing.T) {
+	twig := &Twig{Hooks: map[string]ThemeHook{
+		"node":         {Name: "node", Template: "node"},
+		"node_preview": {Name: "node_preview", Template: "node"},
+		"a_node":       {Name: "a_node", Template: "node"},
+		"item_list":    {Name: "item_list", Template: "item-list"},
+		"b_list":       {Name: "b_list", Template: "item-list"},
+		"links":        {Name: "links", Template: "custom-links"},
+	}}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		// The hook named after the template wins over the others.
+		{"/node/templates/node.html.twig", "node"},
+		{"/system/templates/item-list.html.twig", "item_list"},
+		{"/system/templates/custom-links.html.twig", "links"},
+		// Suggestions.
+		{"/olivero/templates/node--article.html.twig", "node"},
+		{"/olivero/templates/item-list--search.html.twig", "item_list"},
+		{"/olivero/templates/unknown.html.twig", ""},
+	}
+
+	for _, test := range tests {
+		// The hooks are a map, so look them up a few times.
+		for i := 0; i < 10; i++ {
+			hook, _ := twig.templateHook(test.path)
+			if hook.Name != test.expected {
+				t.Errorf("templateHook(%s) = %q, want %q", test.path, hook.Name, test.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestTwigSuggestions(t *testing.T) {
+	twig := &Twig{Definitions: []ParserDefinition{
+		{Type: "template", Name: "@olivero/page/foo-page--front.html.twig", File: "/olivero/templates/page/foo-page--front.html.twig"},
+		{Type: "template", Name: "@olivero/foo-page--admin.html.twig", File: "/olivero/templates/foo-page--admin.html.twig"},
+		{Type: "template", Name: "@olivero/foo-pages.html.twig", File: "/olivero/templates/foo-pages.html.twig"},
+	}}
+
+	suggestions := twig.suggestions(ThemeHook{Name: "foo_page", Template: "page/foo-page"})
+	if strings.Join(suggestions, " ") != "foo-page--admin foo-page--front" {
+		t.Errorf("suggestions(foo_page) = %v", suggestions)
+	}
+}
+
+func TestTwigDocumentDefinition(t *testing.T) {
+	twig, paths, cleanup := twigFixture(t)
+	defer cleanup()
+
+	text := "<?php\n$build = ['#theme' => 'foo_item'];\n"
+	defs := twig.DocumentDefinition(paths[1], text, lsp.Position{Line: 1, Character: 26})
+	if len(defs) != 2 || defs[0].File != paths[2] || defs[1].Method != "template_preprocess_foo_item" {
+		t.Errorf("DocumentDefinition(foo_item) = %+v", defs)
+	}
+
+	// Preprocess functions of include files.
+	text = "<?php\n$build = ['#theme' => 'foo_page'];\n"
+	defs = twig.DocumentDefinition(paths[1], text, lsp.Position{Line: 1, Character: 26})
+	if len(defs) != 2 || defs[0].File != paths[5] || defs[1].Method != "foo_preprocess_foo_page" {
+		t.Errorf("DocumentDefinition(foo_page) = %+v", defs)
+	}
+
+	text = "{% include '@foo/form/foo-form.html.twig' %}\n"
+	defs = twig.DocumentDefinition(paths[2], text, lsp.Position{Line: 0, Character: 15})
+	if len(defs) != 1 || defs[0].File != paths[3] {
+		t.Errorf("DocumentDefinition(@foo/form/foo-form.html.twig) = %+v", defs)
+	}
+
+	if hover := twig.Hover(paths[1], "<?php\n$build = ['#theme' => 'foo_item'];\n", lsp.Position{Line: 1, Character: 26}); !strings.Contains(hover, "variables: items, label") {
+		t.Errorf("Hover(foo_item) = %q", hover)
+	}
+}
@@ -0,0 +1,58 @@
+package langserver
+
+import (
+	"sync"
+)
+
+// IndexerPool shares one indexer between the connections that open the
+// same document root.
+type IndexerPool struct {
+	indexers map[string]*Indexer
+	// Number of connections using each indexer.
+	users map[string]int
+	mtx   sync.Mutex
+}
+
+func NewIndexerPool() *IndexerPool {
+	return &IndexerPool{
+		indexers: make(map[string]*Indexer),
+		users:    make(map[string]int),
+	}
+}
+
+// Get the indexer of a document root. The second return value is true
+// if the indexer was created, in which case the caller has to run it.
+func (p *IndexerPool) Get(rootUri string) (*Indexer, bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	documentRoot := FixDocumentRootUri(rootUri)
+	p.users[documentRoot]++
+
+	if indexer, ok := p.indexers[documentRoot]; ok {
+		return indexer, false
+	}
+
+	indexer := NewIndexer(rootUri)
+	p.indexers[documentRoot] = indexer
+
+	return indexer, true
+}
+
+// Release the indexer of a document root once a connection is closed.
+// The indexer is dropped when no connection uses it anymore.
+func (p *IndexerPool) Release(rootUri string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	documentRoot := FixDocumentRootUri(rootUri)
+	if p.users[documentRoot] == 0 {
+		return
+	}
+
+	p.users[documentRoot]--
+	if p.users[documentRoot] == 0 {
+		delete(p.users, documentRoot)
+		delete(p.indexers, documentRoot)
+	}
+}
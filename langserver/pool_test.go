@@ -0,0 +1,30 @@
+package langserver
+
+import (
+	"testing"
+)
+
+func TestIndexerPool(t *testing.T) {
+	pool := NewIndexerPool()
+
+	first, created := pool.Get("file:///var/www")
+	if !created {
+		t.Errorf("Get() didn't create the indexer")
+	}
+
+	if second, created := pool.Get("/var/www"); created || second != first {
+		t.Errorf("Get() didn't share the indexer")
+	}
+
+	// The indexer is kept while a connection uses it.
+	pool.Release("/var/www")
+	if indexer, created := pool.Get("/var/www"); created || indexer != first {
+		t.Errorf("Get() didn't share the indexer")
+	}
+
+	pool.Release("/var/www")
+	pool.Release("/var/www")
+	if _, created := pool.Get("/var/www"); !created {
+		t.Errorf("Get() reused a released indexer")
+	}
+}
@@ -0,0 +1,111 @@
+package langserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+// Methods of the work done progress, which are not part of the protocol
+// package yet.
+const (
+	MethodWorkDoneProgressCreate = "window/workDoneProgress/create"
+	MethodProgress               = "$/progress"
+)
+
+type WorkDoneProgressCreateParams struct {
+	Token string `json:"token"`
+}
+
+type ProgressParams struct {
+	Token string      `json:"token"`
+	Value interface{} `json:"value"`
+}
+
+type WorkDoneProgressValue struct {
+	Kind        string `json:"kind"`
+	Title       string `json:"title,omitempty"`
+	Cancellable bool   `json:"cancellable,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Percentage  *int   `json:"percentage,omitempty"`
+}
+
+// WorkDoneProgress reports the progress of a long running task to the
+// client. Without client support the progress is only logged.
+type WorkDoneProgress struct {
+	conn  *jsonrpc2.Conn
+	token string
+	// Last reported percentage, to avoid flooding the client.
+	percentage int
+}
+
+func NewWorkDoneProgress(ctx context.Context, conn *jsonrpc2.Conn, token string, title string) *WorkDoneProgress {
+	p := &WorkDoneProgress{
+		token:      token,
+		percentage: -1,
+	}
+
+	if conn != nil {
+		err := conn.Call(ctx, MethodWorkDoneProgressCreate, WorkDoneProgressCreateParams{
+			Token: token,
+		}, nil)
+
+		if err != nil {
+			log.Println(err)
+		} else {
+			p.conn = conn
+		}
+	}
+
+	log.Println(title)
+	p.notify(ctx, WorkDoneProgressValue{
+		Kind:  "begin",
+		Title: title,
+	})
+
+	return p
+}
+
+// Report the number of processed files.
+func (p *WorkDoneProgress) Report(ctx context.Context, message string, done int, total int) {
+	percentage := 100
+	if total > 0 {
+		percentage = done * 100 / total
+	}
+
+	if percentage == p.percentage {
+		return
+	}
+	p.percentage = percentage
+
+	p.notify(ctx, WorkDoneProgressValue{
+		Kind:       "report",
+		Message:    fmt.Sprintf("%s %d/%d files", message, done, total),
+		Percentage: &percentage,
+	})
+}
+
+func (p *WorkDoneProgress) End(ctx context.Context, message string) {
+	log.Println(message)
+	p.notify(ctx, WorkDoneProgressValue{
+		Kind:    "end",
+		Message: message,
+	})
+}
+
+func (p *WorkDoneProgress) notify(ctx context.Context, value WorkDoneProgressValue) {
+	if p.conn == nil {
+		return
+	}
+
+	err := p.conn.Notify(ctx, MethodProgress, ProgressParams{
+		Token: p.token,
+		Value: value,
+	})
+
+	if err != nil {
+		log.Println(err)
+	}
+}
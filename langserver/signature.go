@@ -0,0 +1,488 @@
+package langserver
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nkoporec/drupal-lsp/langserver/parser"
+	"github.com/nkoporec/drupal-lsp/php"
+	"github.com/nkoporec/drupal-lsp/utils"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+// SignatureHelp is declared here, as the one of the protocol package has
+// no signatures.
+type SignatureHelp struct {
+	Signatures      []SignatureInformation `json:"signatures"`
+	ActiveSignature float64                `json:"activeSignature"`
+	ActiveParameter float64                `json:"activeParameter"`
+}
+
+type SignatureInformation struct {
+	Label         string                     `json:"label"`
+	Documentation *lsp.MarkupContent         `json:"documentation,omitempty"`
+	Parameters    []lsp.ParameterInformation `json:"parameters"`
+}
+
+// A call whose arguments contain the cursor.
+type callArgument struct {
+	// Offset where the called expression starts, eg. $form_state in
+	// $form_state->setValue(.
+	Start int
+	// Offsets of the parentheses, End is -1 while the call isn't closed.
+	Open int
+	End  int
+	// Index of the argument under the cursor.
+	Index int
+}
+
+// @param string $string
+var paramTagRegex = regexp.MustCompile(`^@param\s+(?:([^\s$]+)\s+)?&?(?:\.\.\.)?\$(\w+)\s*(.*)$`)
+
+// {@inheritdoc}
+var inheritdocRegex = regexp.MustCompile(`(?i)\{@inheritdoc\}`)
+
+// The keywords before a name that look like a call but aren't, eg.
+// function foo(, or call the constructor, eg. new Foo(.
+var functionKeywordRegex = regexp.MustCompile(`(?i)(^|[^\w$])function$`)
+var newKeywordRegex = regexp.MustCompile(`(?i)(^|[^\w$])new$`)
+
+// Show the parameters of the function or method called at the cursor,
+// eg. t( or $form_state->setValue(.
+func (h *LspHandler) handleSignatureHelp(ctx context.Context, params *lsp.TextDocumentPositionParams) (*SignatureHelp, error) {
+	doc := h.Buffer.GetBufferDoc(UriToFilename(params.TextDocument.URI))
+	if doc == nil {
+		return nil, nil
+	}
+
+	return h.signatureHelp(doc, params.Position), nil
+}
+
+func (h *LspHandler) signatureHelp(doc *Document, position lsp.Position) *SignatureHelp {
+	if !utils.InSlice(phpFileExtensions, filepath.Ext(doc.URI)) {
+		return nil
+	}
+
+	offset, err := doc.Offset(position)
+	if err != nil {
+		return nil
+	}
+
+	call, ok := callArgumentAt(doc.Text, offset)
+	if !ok {
+		return nil
+	}
+
+	callee := doc.Text[call.Start:call.Open]
+
+	// Function declarations look like calls.
+	before := strings.TrimRight(doc.Text[:call.Start], " \t\r\n")
+	if functionKeywordRegex.MatchString(before) {
+		return nil
+	}
+
+	// The constructor of new Foo(.
+	if newKeywordRegex.MatchString(before) {
+		callee = "new " + callee
+		call.Start = len(before) - len("new")
+	}
+
+	// Replace the call by null, so the rest of the statement still parses.
+	end, replacement := offset, ""
+	if call.End != -1 {
+		end, replacement = call.End+1, "null"
+	}
+
+	parsedDoc, src := inferenceDoc(doc.Text, call.Start, end, replacement)
+	if parsedDoc == nil {
+		return nil
+	}
+
+	expr, err := parsedDoc.ParseExpression(strings.TrimSpace(callee) + "()")
+	if err != nil {
+		return nil
+	}
+
+	inference := NewTypeInference(h.Indexer, src, parsedDoc)
+
+	var signature SignatureInformation
+	var parameters []php.PhpParameter
+
+	if expr.Kind == "function" {
+		function, ok := h.phpFunction(expr.Name, parsedDoc)
+		if !ok {
+			return nil
+		}
+
+		signature, parameters = functionSignature(function)
+	} else {
+		var method InferredMethod
+
+		switch expr.Kind {
+		case "static":
+			method, ok = inference.Method(inference.keywordClass(expr.Class, call.Start), expr.Name)
+		case "method":
+			method, ok = inference.Method(inference.ExpressionClass(expr.Var, call.Start), expr.Name)
+		case "new":
+			method, ok = inference.Method(inference.keywordClass(expr.Class, call.Start), "__construct")
+		default:
+			ok = false
+		}
+
+		if !ok {
+			return nil
+		}
+
+		signature, parameters = inference.signature(method)
+	}
+
+	// Extra arguments belong to a variadic parameter.
+	active := call.Index
+	if count := len(parameters); count > 0 && active >= count && parameters[count-1].Variadic {
+		active = count - 1
+	}
+
+	return &SignatureHelp{
+		Signatures:      []SignatureInformation{signature},
+		ActiveParameter: float64(active),
+	}
+}
+
+// Find the function called by name in a document, resolved like php does:
+// an unqualified name is an imported function, or the one of the current
+// namespace, falling back to the global one.
+func (h *LspHandler) phpFunction(name string, parsedDoc *php.ParsedDoc) (parser.PhpFunction, bool) {
+	candidates := []string{}
+	switch {
+	case strings.HasPrefix(name, "\\"):
+		candidates = append(candidates, strings.TrimPrefix(name, "\\"))
+	case strings.Contains(name, "\\"):
+		candidates = append(candidates, parsedDoc.ResolveName(name))
+	default:
+		if use, ok := parsedDoc.FunctionUses[name]; ok {
+			candidates = append(candidates, use)
+			break
+		}
+
+		if parsedDoc.Namespace != "" {
+			candidates = append(candidates, parsedDoc.Namespace+"\\"+name)
+		}
+		candidates = append(candidates, name)
+	}
+
+	functions := h.Indexer.GetPhpFunctions()
+	for _, candidate := range candidates {
+		// Function names are case insensitive. A function declared in
+		// several files is the first one by path, so the result doesn't
+		// depend on the indexing order.
+		var result parser.PhpFunction
+		found := false
+		for _, function := range functions {
+			if strings.EqualFold(functionName(function), candidate) && (!found || function.Path < result.Path) {
+				result, found = function, true
+			}
+		}
+
+		if found {
+			return result, true
+		}
+	}
+
+	return parser.PhpFunction{}, false
+}
+
+// Get the fully qualified name of a function.
+func functionName(function parser.PhpFunction) string {
+	if function.Namespace == "" {
+		return function.Name
+	}
+
+	return function.Namespace + "\\" + function.Name
+}
+
+// Describe a function with its docblock.
+func functionSignature(function parser.PhpFunction) (SignatureInformation, []php.PhpParameter) {
+	method := parser.PhpMethod{
+		Name:       function.Name,
+		Parameters: function.Parameters,
+	}
+
+	return newSignature(method, function.Doc), function.Parameters
+}
+
+// Describe a method with its docblock. The constructor is shown with the
+// name of its class.
+func (t *TypeInference) signature(method InferredMethod) (SignatureInformation, []php.PhpParameter) {
+	doc := t.methodDoc(method.Class, method.Name)
+
+	if strings.EqualFold(method.Name, "__construct") {
+		method.Name = method.Class[strings.LastIndex(method.Class, "\\")+1:]
+		method.ReturnType = ""
+	}
+
+	return newSignature(method.PhpMethod, doc), method.Parameters
+}
+
+// Get the documentation of a method, methods documented with {@inheritdoc}
+// get the one of the method they override.
+func (t *TypeInference) methodDoc(class string, name string) parser.PhpDoc {
+	seen := map[string]bool{}
+
+	queue := []string{class}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		item, ok := t.classes[current]
+		if !ok || seen[current] {
+			continue
+		}
+		seen[current] = true
+
+		for _, method := range item.Methods {
+			if strings.EqualFold(method.Name, name) && (method.Doc.Summary != "" || len(method.Doc.Parameters) > 0) {
+				return method.Doc
+			}
+		}
+
+		if item.Parent != "" {
+			queue = append(queue, item.Parent)
+		}
+		queue = append(queue, item.Interfaces...)
+	}
+
+	return parser.PhpDoc{}
+}
+
+// Build the signature of a method, the types missing from its declaration
+// are taken from the docblock.
+func newSignature(method parser.PhpMethod, doc parser.PhpDoc) SignatureInformation {
+	summary, documented := doc.Summary, doc.Parameters
+
+	parameters := []php.PhpParameter{}
+	for _, parameter := range method.Parameters {
+		if parameter.Type == "" {
+			parameter.Type = documented[parameter.Name].Type
+		}
+		parameters = append(parameters, parameter)
+	}
+	method.Parameters = parameters
+
+	signature := SignatureInformation{
+		Label:      methodSignature(method),
+		Parameters: []lsp.ParameterInformation{},
+	}
+
+	if summary != "" {
+		signature.Documentation = &lsp.MarkupContent{
+			Kind:  lsp.PlainText,
+			Value: summary,
+		}
+	}
+
+	for _, parameter := range parameters {
+		information := lsp.ParameterInformation{
+			Label: parameterLabel(parameter),
+		}
+
+		if description := documented[parameter.Name].Description; description != "" {
+			information.Documentation = description
+		}
+
+		signature.Parameters = append(signature.Parameters, information)
+	}
+
+	return signature
+}
+
+// Get the summary of a docblock and its documented parameters, keyed by
+// their name. Descriptions may continue on the next lines, eg.
+//
+//	@param string $string
+//	  A string containing the English text to translate.
+func docblockDoc(docblock string) parser.PhpDoc {
+	if docblock == "" || inheritdocRegex.MatchString(docblock) {
+		return parser.PhpDoc{}
+	}
+
+	summary := []string{}
+	parameters := map[string]parser.PhpDocParameter{}
+
+	inSummary := true
+	current := ""
+
+	for _, line := range strings.Split(php.DocblockText(docblock), "\n") {
+		text := strings.TrimSpace(line)
+
+		if text == "" || strings.HasPrefix(text, "@") {
+			inSummary = false
+			current = ""
+		}
+
+		if match := paramTagRegex.FindStringSubmatch(text); match != nil {
+			current = match[2]
+			parameters[current] = parser.PhpDocParameter{
+				Type:        match[1],
+				Description: match[3],
+			}
+			continue
+		}
+
+		switch {
+		case inSummary:
+			summary = append(summary, text)
+		case current != "" && text != "":
+			parameter := parameters[current]
+			parameter.Description = strings.TrimSpace(parameter.Description + " " + text)
+			parameters[current] = parameter
+		}
+	}
+
+	return parser.PhpDoc{
+		Summary:    strings.Join(summary, " "),
+		Parameters: parameters,
+	}
+}
+
+// Find the call whose arguments contain the offset, and the argument the
+// offset is in. The text is scanned from the start, so parentheses and
+// commas in strings, comments and nested calls or arrays are skipped.
+func callArgumentAt(text string, offset int) (callArgument, bool) {
+	type bracket struct {
+		char   byte
+		offset int
+		commas int
+	}
+
+	stack := []bracket{}
+	inPhp := false
+
+	// Skip a string or a comment starting at i, and get where it ends.
+	skip := func(i int) int {
+		switch {
+		case text[i] == '\'' || text[i] == '"':
+			for j := i + 1; j < len(text); j++ {
+				if text[j] == '\\' {
+					j++
+				} else if text[j] == text[i] {
+					return j
+				}
+			}
+		case text[i] == '#' || strings.HasPrefix(text[i:], "//"):
+			if end := strings.IndexByte(text[i:], '\n'); end != -1 {
+				return i + end
+			}
+		case strings.HasPrefix(text[i:], "/*"):
+			if end := strings.Index(text[i+2:], "*/"); end != -1 {
+				return i + 2 + end + 1
+			}
+		default:
+			return i
+		}
+
+		return len(text)
+	}
+
+	for i := 0; i < offset; i++ {
+		// Only the code between <?php and ?> counts.
+		if !inPhp {
+			if strings.HasPrefix(text[i:], "<?") {
+				inPhp = true
+				i++
+			}
+			continue
+		}
+
+		if end := skip(i); end != i {
+			// The offset is in a string or a comment.
+			if end >= offset {
+				return callArgument{}, false
+			}
+			i = end
+			continue
+		}
+
+		switch text[i] {
+		case '(', '[', '{':
+			stack = append(stack, bracket{char: text[i], offset: i})
+		case ')', ']', '}':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case ',':
+			if len(stack) > 0 {
+				stack[len(stack)-1].commas++
+			}
+		case '?':
+			if i+1 < len(text) && text[i+1] == '>' {
+				inPhp = false
+				stack = stack[:0]
+			}
+		}
+	}
+
+	if !inPhp {
+		return callArgument{}, false
+	}
+
+	// Arrays are part of the argument, but the body of a closure isn't.
+	var open *bracket
+	depth := 0
+	for i := len(stack) - 1; i >= 0 && stack[i].char != '{'; i-- {
+		if stack[i].char == '(' {
+			open = &stack[i]
+			break
+		}
+		depth++
+	}
+
+	if open == nil {
+		return callArgument{}, false
+	}
+
+	end := len(strings.TrimRight(text[:open.offset], " \t\r\n"))
+	start := expressionStart(text, end)
+	if start == end {
+		return callArgument{}, false
+	}
+
+	return callArgument{
+		Start: start,
+		Open:  open.offset,
+		End:   closingParenthesis(text, offset, depth, skip),
+		Index: open.commas,
+	}, true
+}
+
+// Find the parenthesis that closes the call around an offset, nested in
+// depth brackets, or -1 if it's not closed yet.
+func closingParenthesis(text string, offset int, depth int, skip func(i int) int) int {
+	for i := offset; i < len(text); i++ {
+		if end := skip(i); end != i {
+			i = end
+			continue
+		}
+
+		switch text[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			if depth == 0 {
+				if text[i] == ')' {
+					return i
+				}
+				return -1
+			}
+			depth--
+		case ';':
+			if depth == 0 {
+				return -1
+			}
+		}
+	}
+
+	return -1
+}
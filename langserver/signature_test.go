@@ -0,0 +1,228 @@
+package langserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nkoporec/drupal-lsp/langserver/parser"
+	"github.com/nkoporec/drupal-lsp/php"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+const signatureBootstrap = `<?php
+
+/**
+ * Translates a string to the current language or to a given language.
+ *
+ * @param string $string
+ *   A string containing the English text to translate.
+ * @param array $args
+ *   (optional) An associative array of replacements.
+ * @param array $options
+ *   (optional) An associative array of additional options.
+ */
+function t($string, array $args = [], array $options = []) {
+}
+`
+
+const signatureFormState = `<?php
+
+namespace Drupal\Core\Form;
+
+interface FormStateInterface {
+
+  /**
+   * Sets the value for a specific key.
+   *
+   * @param string|array $key
+   *   Values are stored as a multi-dimensional associative array.
+   * @param mixed $value
+   *   The value to set.
+   */
+  public function setValue($key, $value);
+
+}
+
+class FormState implements FormStateInterface {
+
+  /**
+   * {@inheritdoc}
+   */
+  public function setValue($key, $value) {
+  }
+
+}
+`
+
+func TestSignatureHelp(t *testing.T) {
+	// The docblocks are indexed, the files don't exist.
+	indexer := inferenceIndexer()
+	indexer.UpdateDocument("/core/includes/bootstrap.inc", signatureBootstrap)
+	indexer.UpdateDocument("/core/lib/Drupal/Core/Form/FormState.php", signatureFormState)
+	indexer.PhpClasses = append(indexer.PhpClasses,
+		parser.PhpClass{
+			Namespace: "Drupal\\Core\\Url",
+			Kind:      "class",
+			Methods: []parser.PhpMethod{
+				{Name: "fromRoute", Visibility: "public", Static: true, ReturnType: "static", Parameters: []php.PhpParameter{{Name: "route_name"}, {Name: "route_parameters", Default: "[]"}}},
+			},
+		},
+	)
+
+	tests := []struct {
+		text   string
+		label  string
+		active int
+		// Documentation of the active parameter.
+		documentation string
+	}{
+		{"<?php\nfunction foo() {\n  return t('Hello @name', [|]);\n}\n", "t(string $string, array $args = [], array $options = [])", 1, "(optional) An associative array of replacements."},
+		{"<?php\nfunction foo() {\n  return t(implode(',', $a), [], |\n}\n", "t(string $string, array $args = [], array $options = [])", 2, ""},
+		{"<?php\nuse Drupal\\Core\\Url;\n\nfunction foo() {\n  return Url::fromRoute(|);\n}\n", "fromRoute($route_name, $route_parameters = []): static", 0, ""},
+		{"<?php\nuse Drupal\\Core\\Form\\FormState;\n\nfunction foo(array &$form, FormState $form_state) {\n  $form_state->setValue('foo',\n    |);\n}\n", "setValue(string|array $key, mixed $value)", 1, "The value to set."},
+		{"<?php\nfunction foo() {\n  $storage = \\Drupal::entityTypeManager()->getStorage(|);\n}\n", "getStorage($entity_type_id): EntityStorageInterface", 0, ""},
+		// Not in the arguments of a known call.
+		{"<?php\nfunction foo() {\n  return t('Hello (|');\n}\n", "", 0, ""},
+		{"<?php\nfunction foo(|) {\n}\n", "", 0, ""},
+		{"<?php\nfunction foo() {\n  return t('Hello', [], function () {|});\n}\n", "", 0, ""},
+		{"<?php\nfunction foo() {\n  return unknown(|);\n}\n", "", 0, ""},
+	}
+
+	h := &LspHandler{Indexer: indexer}
+
+	for _, test := range tests {
+		// The cursor is at the |.
+		cursor := strings.Index(test.text, "|")
+		lines := strings.Split(test.text[:cursor], "\n")
+		position := lsp.Position{
+			Line:      float64(len(lines) - 1),
+			Character: float64(len(lines[len(lines)-1])),
+		}
+
+		text := strings.Replace(test.text, "|", "", 1)
+		help := h.signatureHelp(&Document{URI: "/foo/foo.module", Text: text}, position)
+
+		if test.label == "" {
+			if help != nil {
+				t.Errorf("signatureHelp(%q) = %+v, want none", test.text, help)
+			}
+			continue
+		}
+
+		if help == nil || len(help.Signatures) != 1 {
+			t.Errorf("signatureHelp(%q) = %+v, want %q", test.text, help, test.label)
+			continue
+		}
+
+		if help.Signatures[0].Label != test.label || int(help.ActiveParameter) != test.active {
+			t.Errorf("signatureHelp(%q) = %q (%v), want %q (%d)", test.text, help.Signatures[0].Label, help.ActiveParameter, test.label, test.active)
+			continue
+		}
+
+		if parameter := help.Signatures[0].Parameters[test.active]; test.documentation != "" && parameter.Documentation != test.documentation {
+			t.Errorf("signatureHelp(%q) documents %q, want %q", test.text, parameter.Documentation, test.documentation)
+		}
+	}
+}
+
+func TestSignatureHelpDocumentation(t *testing.T) {
+	doc := docblockDoc("/**\n * Translates a string.\n *\n * @param string $string\n *   A string containing\n *   the English text.\n * @param array $args The replacements.\n *\n * @return string\n *   The translated string.\n */")
+
+	summary, parameters := doc.Summary, doc.Parameters
+	if summary != "Translates a string." {
+		t.Errorf("Invalid summary %q", summary)
+	}
+
+	if parameters["string"].Type != "string" || parameters["string"].Description != "A string containing the English text." {
+		t.Errorf("Invalid parameter %+v", parameters["string"])
+	}
+
+	if parameters["args"].Type != "array" || parameters["args"].Description != "The replacements." {
+		t.Errorf("Invalid parameter %+v", parameters["args"])
+	}
+
+	if len(parameters) != 2 {
+		t.Errorf("Invalid parameters %+v", parameters)
+	}
+
+	// The overridden method is documented.
+	if doc := docblockDoc("/**\n * {@inheritdoc}\n */"); doc.Summary != "" || len(doc.Parameters) != 0 {
+		t.Errorf("Invalid {@inheritdoc} documentation %+v", doc)
+	}
+}
+
+func TestPhpFunction(t *testing.T) {
+	indexer := &Indexer{
+		PhpFunctions: []parser.PhpFunction{
+			{Name: "foo_load", Path: "/foo/foo.module"},
+			{Name: "foo_load", Namespace: "Drupal\\foo", Path: "/foo/src/functions.php"},
+			{Name: "bar_load", Namespace: "Drupal\\bar", Path: "/bar/src/functions.php"},
+			// Declared twice, the first path wins.
+			{Name: "t", Path: "/core/includes/bootstrap.inc"},
+			{Name: "t", Path: "/core/includes/a.inc"},
+		},
+	}
+
+	tests := []struct {
+		src      string
+		call     string
+		expected string
+	}{
+		{"<?php\n", "foo_load()", "/foo/foo.module"},
+		{"<?php\nnamespace Drupal\\foo;\n", "foo_load()", "/foo/src/functions.php"},
+		{"<?php\nnamespace Drupal\\foo;\n", "FOO_LOAD()", "/foo/src/functions.php"},
+		// The global function is the fallback.
+		{"<?php\nnamespace Drupal\\baz;\n", "foo_load()", "/foo/foo.module"},
+		{"<?php\nnamespace Drupal\\foo;\n", "\\foo_load()", "/foo/foo.module"},
+		{"<?php\nnamespace Drupal;\n", "foo\\foo_load()", "/foo/src/functions.php"},
+		{"<?php\nnamespace Drupal\\baz;\nuse function Drupal\\foo\\foo_load;\n", "foo_load()", "/foo/src/functions.php"},
+		{"<?php\nnamespace Drupal\\baz;\nuse function Drupal\\bar\\bar_load as load;\n", "load()", "/bar/src/functions.php"},
+		{"<?php\nnamespace Drupal\\baz;\n", "bar_load()", ""},
+		{"<?php\n", "t()", "/core/includes/a.inc"},
+	}
+
+	h := &LspHandler{Indexer: indexer}
+
+	for _, test := range tests {
+		parsedDoc, err := php.Parse([]byte(test.src))
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", test.src, err)
+		}
+
+		expr, err := parsedDoc.ParseExpression(test.call)
+		if err != nil {
+			t.Fatalf("ParseExpression(%q) error = %v", test.call, err)
+		}
+
+		function, ok := h.phpFunction(expr.Name, parsedDoc)
+		if (ok && function.Path != test.expected) || (!ok && test.expected != "") {
+			t.Errorf("phpFunction(%s) in %q = %s, want %q", test.call, test.src, function.Path, test.expected)
+		}
+	}
+}
+
+func TestIndexerUpdateDocument(t *testing.T) {
+	indexer := &Indexer{
+		PhpClasses: []parser.PhpClass{
+			{Namespace: "Drupal\\foo\\Foo", Path: "/foo/src/Foo.php"},
+			{Namespace: "Drupal\\foo\\Bar", Path: "/foo/src/Bar.php"},
+		},
+	}
+
+	// The classes of the document replace the indexed ones.
+	indexer.UpdateDocument("/foo/src/Foo.php", "<?php\nnamespace Drupal\\foo;\n\nclass Foo {\n\n  /**\n   * Builds it.\n   */\n  public function build($name) {}\n\n}\n\nfunction foo_build() {}\n")
+
+	classes := indexer.GetPhpClassIndex()
+	if len(indexer.GetPhpClasses()) != 2 || len(classes) != 2 {
+		t.Fatalf("GetPhpClasses() = %+v", indexer.GetPhpClasses())
+	}
+
+	if methods := classes["Drupal\\foo\\Foo"].Methods; len(methods) != 1 || methods[0].Doc.Summary != "Builds it." {
+		t.Errorf("Methods of Foo = %+v", methods)
+	}
+
+	if functions := indexer.GetPhpFunctions(); len(functions) != 1 || functionName(functions[0]) != "Drupal\\foo\\foo_build" {
+		t.Errorf("GetPhpFunctions() = %+v", functions)
+	}
+}
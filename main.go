@@ -4,19 +4,25 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/nkoporec/drupal-lsp/langserver"
+	"github.com/nkoporec/drupal-lsp/transport"
 
 	"go.lsp.dev/jsonrpc2"
 )
 
 var (
 	mode         = flag.String("mode", "stdio", "communication mode (stdio|tcp|websocket)")
+	addr         = flag.String("addr", "127.0.0.1:7777", "address to listen on in tcp and websocket mode")
+	origins      = flag.String("allowed-origins", "", "comma separated origins allowed to connect in websocket mode, eg. http://localhost:8080")
+	sharedIndex  = flag.Bool("shared-index", false, "share the index between connections with the same root in tcp and websocket mode")
 	logfile      = flag.String("logfile", "", "log to this file (in addition to stderr)")
 	printVersion = flag.Bool("version", false, "print version and exit")
 )
@@ -32,12 +38,6 @@ func main() {
 		os.Exit(0)
 	}
 
-	// @todo: implement other modes.
-	if *mode != "stdio" {
-		fmt.Println("We only support stdio mode")
-		os.Exit(0)
-	}
-
 	if *logfile != "" {
 		f, err := os.OpenFile(*logfile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 		if err != nil {
@@ -56,13 +56,122 @@ func main() {
 		fmt.Sprintf("Starting Drupal Language Server in %s mode ...", *mode),
 	)
 
-	lspHandler := langserver.NewLspHandler()
-	connectLanguageServer(os.Stdin, os.Stdout, lspHandler).Run(ctx)
+	var err error
+	switch *mode {
+	case "stdio":
+		lspHandler := langserver.NewLspHandler()
+		err = connectLanguageServer(jsonrpc2.NewStream(os.Stdin, os.Stdout), lspHandler).Run(ctx)
+	case "tcp":
+		err = serveTcp(ctx, *addr)
+	case "websocket":
+		err = serveWebSocket(ctx, *addr)
+	default:
+		log.Fatalf("Unknown mode %s, expected stdio, tcp or websocket", *mode)
+	}
+
+	if err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+}
+
+// Create a handler for a new connection.
+func newLspHandler(pool *langserver.IndexerPool) *langserver.LspHandler {
+	if pool != nil {
+		return langserver.NewLspHandlerWithPool(pool)
+	}
+
+	return langserver.NewLspHandler()
+}
+
+func newIndexerPool() *langserver.IndexerPool {
+	if *sharedIndex {
+		return langserver.NewIndexerPool()
+	}
+
+	return nil
+}
+
+// Accept tcp connections, each one gets its own handler.
+func serveTcp(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	log.Printf("Listening on %s", listener.Addr())
+
+	pool := newIndexerPool()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+
+			log.Printf("Connection from %s", conn.RemoteAddr())
+			stream := jsonrpc2.NewStream(conn, conn)
+			handler := newLspHandler(pool)
+			defer handler.Close()
+
+			if err := connectLanguageServer(stream, handler).Run(ctx); err != nil {
+				log.Println(err)
+			}
+			log.Printf("Connection from %s closed", conn.RemoteAddr())
+		}()
+	}
+}
+
+// Accept websocket connections, each one gets its own handler.
+func serveWebSocket(ctx context.Context, addr string) error {
+	pool := newIndexerPool()
+
+	allowedOrigins := []string{}
+	for _, origin := range strings.Split(*origins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowedOrigins = append(allowedOrigins, origin)
+		}
+	}
+
+	server := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := transport.Upgrade(w, r, allowedOrigins)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			defer conn.Close()
+
+			handler := newLspHandler(pool)
+			defer handler.Close()
+
+			log.Printf("Connection from %s", r.RemoteAddr)
+			if err := connectLanguageServer(conn, handler).Run(ctx); err != nil {
+				log.Println(err)
+			}
+			log.Printf("Connection from %s closed", r.RemoteAddr)
+		}),
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("Listening on %s", addr)
+
+	return server.ListenAndServe()
 }
 
-func connectLanguageServer(in io.Reader, out io.Writer, handlers ...jsonrpc2.Handler) *jsonrpc2.Conn {
-	bufStream := jsonrpc2.NewStream(in, out)
-	rootConn := jsonrpc2.NewConn(bufStream)
+func connectLanguageServer(stream jsonrpc2.Stream, handlers ...jsonrpc2.Handler) *jsonrpc2.Conn {
+	rootConn := jsonrpc2.NewConn(stream)
 
 	for _, h := range handlers {
 		rootConn.AddHandler(h)
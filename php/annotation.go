@@ -0,0 +1,466 @@
+package php
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/z7zmey/php-parser/pkg/position"
+)
+
+// PhpAnnotation is a class annotation, eg. @Block(id = "foo"), or a php 8
+// attribute, eg. #[Block(id: "foo")].
+type PhpAnnotation struct {
+	// Position of the annotation name.
+	Position *position.Position
+	// Name without the namespace, eg. "Block".
+	Name string
+	// Values are either strings or nested maps. Positional values are
+	// keyed by their index, eg. @Translation("Foo") has the key "0".
+	Values map[string]interface{}
+	// Positions of the top level string values, including the quotes.
+	Positions map[string]*position.Position
+}
+
+// Get a top level string value.
+func (a *PhpAnnotation) String(key string) string {
+	value, _ := a.Values[key].(string)
+
+	return value
+}
+
+// Get a top level map value.
+func (a *PhpAnnotation) Map(key string) map[string]interface{} {
+	value, _ := a.Values[key].(map[string]interface{})
+
+	return value
+}
+
+var annotationStartRegex = regexp.MustCompile(`@([A-Z][\w\\]*)\(`)
+
+// Parse the annotations of a docblock at an offset of the file, eg.
+// @Block(id = "foo").
+func parseDocblockAnnotations(src []byte, lines *LineOffsets, start int, end int, namespace string, uses map[string]string) []*PhpAnnotation {
+	result := []*PhpAnnotation{}
+
+	// Blank the leading stars of the docblock lines, so the values are
+	// parsed without changing their offsets.
+	block := docblockBody(src[start:end])
+	annotationEnd := 0
+	for _, item := range annotationStartRegex.FindAllSubmatchIndex(block, -1) {
+		// Nested annotations are part of the previous one.
+		if item[0] < annotationEnd {
+			continue
+		}
+
+		p := &annotationParser{src: block, pos: item[1], namespace: namespace, uses: uses, base: start, lines: lines}
+		name := string(block[item[2]:item[3]])
+
+		annotation := &PhpAnnotation{
+			Position: p.position(item[2], item[3]),
+			Name:     shortName(name),
+		}
+		annotation.Values, annotation.Positions = p.parseList(')')
+		annotationEnd = p.pos
+
+		result = append(result, annotation)
+	}
+
+	return result
+}
+
+// Parse the attributes that start at an offset of the file, eg.
+// #[Block(id: "foo"), Other]. The offset after them is returned.
+func parseAttribute(src []byte, lines *LineOffsets, start int, namespace string, uses map[string]string) ([]*PhpAnnotation, int) {
+	result := []*PhpAnnotation{}
+
+	p := &annotationParser{src: src, pos: start + len("#["), namespace: namespace, uses: uses, lines: lines}
+	for {
+		p.skipSpace()
+		name, namePos := p.readName()
+		if name == "" {
+			break
+		}
+
+		annotation := &PhpAnnotation{
+			Position: p.position(namePos, namePos+len(name)),
+			Name:     shortName(name),
+		}
+
+		p.skipSpace()
+		if p.peek() == '(' {
+			p.pos++
+			annotation.Values, annotation.Positions = p.parseList(')')
+		}
+		result = append(result, annotation)
+
+		p.skipSpace()
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+
+	return result, p.skipTo(']')
+}
+
+var attributeStartRegex = regexp.MustCompile(`(?m)^[ \t]*#\[`)
+
+// The parser only supports php 7.4, which reads attributes as comments
+// that end with the line, so they are found in the source instead of the
+// syntax tree. Only the attributes that directly precede the declaration
+// at the offset are returned, with the docblock before them if there is
+// one.
+func precedingAttributes(src []byte, lines *LineOffsets, offset int, namespace string, uses map[string]string) ([]*PhpAnnotation, string, int) {
+	result := []*PhpAnnotation{}
+
+	for {
+		end := len(bytes.TrimRight(src[:offset], " \t\r\n"))
+		if !bytes.HasSuffix(src[:end], []byte("]")) {
+			docblock := docblockBefore(src, offset)
+			return result, docblock, end - len(docblock)
+		}
+
+		// The attribute that ends right before the declaration.
+		matches := attributeStartRegex.FindAllIndex(src[:end], -1)
+		found := false
+		for i := len(matches) - 1; i >= 0 && !found; i-- {
+			start := matches[i][1] - len("#[")
+			annotations, attributeEnd := parseAttribute(src, lines, start, namespace, uses)
+			if attributeEnd == end {
+				result = append(annotations, result...)
+				offset = start
+				found = true
+			}
+		}
+
+		if !found {
+			return result, "", -1
+		}
+	}
+}
+
+// Replace the comment delimiters of a docblock with spaces.
+func docblockBody(docblock []byte) []byte {
+	body := make([]byte, len(docblock))
+	copy(body, docblock)
+
+	lineStart := true
+	for i, c := range body {
+		switch {
+		case c == '\n':
+			lineStart = true
+		case lineStart && (c == ' ' || c == '\t' || c == '\r'):
+		case lineStart && c == '*':
+			body[i] = ' '
+			lineStart = false
+		default:
+			lineStart = false
+		}
+	}
+
+	// The opening and closing delimiters.
+	copy(body, "   ")
+	if len(body) >= 5 {
+		copy(body[len(body)-2:], "  ")
+	}
+
+	return body
+}
+
+// Get the last part of a class name.
+func shortName(name string) string {
+	name = strings.TrimPrefix(name, "\\")
+	if index := strings.LastIndex(name, "\\"); index != -1 {
+		return name[index+1:]
+	}
+
+	return name
+}
+
+// ParseArray parses a php array literal, eg. ['key' => 'value'] or
+// array('key' => 'value'), that starts at the given offset. Values are
+// parsed like the ones of annotations, and the offset after the array is
+// returned.
+func ParseArray(src []byte, offset int) (map[string]interface{}, int) {
+	p := &annotationParser{src: src, pos: offset}
+	p.skipSpace()
+
+	closing := byte(']')
+	if p.peek() != '[' {
+		name, _ := p.readName()
+		p.skipSpace()
+		if strings.ToLower(name) != "array" || p.peek() != '(' {
+			return nil, offset
+		}
+		closing = ')'
+	}
+	p.pos++
+
+	values, _ := p.parseList(closing)
+
+	return values, p.pos
+}
+
+// A small parser for the values of annotations and attributes.
+type annotationParser struct {
+	src       []byte
+	pos       int
+	namespace string
+	uses      map[string]string
+	// Offset of src in the file, and the lines of the file. The lines of
+	// src are used if they aren't set.
+	base  int
+	lines *LineOffsets
+}
+
+func (p *annotationParser) position(start int, end int) *position.Position {
+	if p.lines == nil {
+		p.lines = NewLineOffsets(p.src)
+	}
+
+	startLine, _ := p.lines.LineColumn(p.base + start)
+	endLine, _ := p.lines.LineColumn(p.base + end)
+
+	return &position.Position{
+		StartLine: startLine + 1,
+		EndLine:   endLine + 1,
+		StartPos:  p.base + start,
+		EndPos:    p.base + end,
+	}
+}
+
+func (p *annotationParser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+
+	return p.src[p.pos]
+}
+
+func (p *annotationParser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// Skip past the given character, ignoring the ones in strings and
+// brackets. The offset after it is returned.
+func (p *annotationParser) skipTo(c byte) int {
+	depth := 0
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '"', '\'':
+			p.readString()
+			continue
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			if depth == 0 && p.src[p.pos] == c {
+				p.pos++
+				return p.pos
+			}
+			depth--
+		}
+		p.pos++
+	}
+
+	return p.pos
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c == '\\' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
+
+// Read a name, eg. a key, a constant or a class name.
+func (p *annotationParser) readName() (string, int) {
+	start := p.pos
+	for p.pos < len(p.src) {
+		if isNameByte(p.src[p.pos]) {
+			p.pos++
+			continue
+		}
+
+		// Class constants, eg. Foo::class.
+		if p.src[p.pos] == ':' && p.pos+1 < len(p.src) && p.src[p.pos+1] == ':' {
+			p.pos += 2
+			continue
+		}
+
+		break
+	}
+
+	return string(p.src[start:p.pos]), start
+}
+
+// Read a quoted string and return its unescaped value.
+func (p *annotationParser) readString() string {
+	quote := p.src[p.pos]
+	p.pos++
+
+	var value strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+
+		if c == quote {
+			break
+		}
+
+		if c == '\\' && p.pos < len(p.src) && (p.src[p.pos] == quote || p.src[p.pos] == '\\') {
+			c = p.src[p.pos]
+			p.pos++
+		}
+
+		value.WriteByte(c)
+	}
+
+	return value.String()
+}
+
+// Parse the entries of a list until the closing character. Entries are
+// either "value" or "key = value", "key: value" and "key => value".
+func (p *annotationParser) parseList(closing byte) (map[string]interface{}, map[string]*position.Position) {
+	values := make(map[string]interface{})
+	positions := make(map[string]*position.Position)
+
+	index := 0
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			break
+		}
+
+		if p.peek() == closing {
+			p.pos++
+			break
+		}
+
+		if p.peek() == ',' {
+			p.pos++
+			continue
+		}
+
+		start := p.pos
+		value, pos := p.parseValue()
+
+		p.skipSpace()
+		key := ""
+		switch {
+		case p.peek() == '=' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '>':
+			p.pos += 2
+		case p.peek() == '=' || (p.peek() == ':' && (p.pos+1 >= len(p.src) || p.src[p.pos+1] != ':')):
+			p.pos++
+		default:
+			key = strconv.Itoa(index)
+			index++
+		}
+
+		if key == "" {
+			key, _ = value.(string)
+			p.skipSpace()
+			value, pos = p.parseValue()
+		}
+
+		values[key] = value
+		if pos != nil {
+			positions[key] = pos
+		}
+
+		// Skip anything that can't be parsed.
+		if p.pos == start {
+			p.pos++
+		}
+	}
+
+	return values, positions
+}
+
+// Parse a value. The position is only returned for strings.
+func (p *annotationParser) parseValue() (interface{}, *position.Position) {
+	p.skipSpace()
+
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		start := p.pos
+		value := p.readString()
+		return value, p.position(start, p.pos)
+	case c == '{' || c == '[':
+		closing := byte('}')
+		if c == '[' {
+			closing = ']'
+		}
+		p.pos++
+		values, _ := p.parseList(closing)
+		return values, nil
+	case c == '@':
+		p.pos++
+		return p.parseCall(), nil
+	case c == '-' || isNameByte(c):
+		if c == '-' {
+			p.pos++
+		}
+		name, _ := p.readName()
+
+		if name == "new" {
+			p.skipSpace()
+			p.readName()
+			return p.parseCall(), nil
+		}
+
+		p.skipSpace()
+		if p.peek() == '(' {
+			p.pos++
+			values, _ := p.parseList(')')
+			return callValue(values), nil
+		}
+
+		if strings.HasSuffix(name, "::class") {
+			return resolveNameString(strings.TrimSuffix(name, "::class"), p.namespace, p.uses), nil
+		}
+
+		switch strings.ToLower(name) {
+		case "true", "false", "null":
+			name = strings.ToLower(name)
+		}
+
+		if c == '-' {
+			name = "-" + name
+		}
+
+		return name, nil
+	}
+
+	return "", nil
+}
+
+// Parse a nested annotation or an object, eg. @Translation("Foo").
+func (p *annotationParser) parseCall() interface{} {
+	p.readName()
+	p.skipSpace()
+
+	if p.peek() != '(' {
+		return ""
+	}
+	p.pos++
+
+	values, _ := p.parseList(')')
+
+	return callValue(values)
+}
+
+// Calls with a string as first argument, like translations, are replaced
+// by that string.
+func callValue(values map[string]interface{}) interface{} {
+	if value, ok := values["0"].(string); ok {
+		return value
+	}
+
+	return values
+}
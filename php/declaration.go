@@ -0,0 +1,343 @@
+package php
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/z7zmey/php-parser/pkg/position"
+)
+
+// The parser only supports php 7.4, and drops the classes of files with
+// newer syntax, eg. constructor promotion or match expressions. Their
+// declarations are scanned from the source instead, which tolerates any
+// syntax inside the method bodies.
+
+var namespaceRegex = regexp.MustCompile(`(?m)^[ \t]*namespace[ \t]+([\w\\]+)[ \t]*[;{]`)
+
+// Only imports at the start of a line, the use statements of traits are
+// indented in the class body.
+var useRegex = regexp.MustCompile(`(?m)^use[ \t]+\\?([\w\\]+)(?:[ \t]+as[ \t]+(\w+))?[ \t]*;`)
+
+var classDeclarationRegex = regexp.MustCompile(`(?m)^[ \t]*(?:(?:abstract|final|readonly)[ \t]+)*(class|interface|trait)[ \t]+(\w+)(?:\s+extends\s+([\w\\, \t\r\n]+?))?(?:\s+implements\s+([\w\\, \t\r\n]+?))?\s*\{`)
+
+var methodDeclarationRegex = regexp.MustCompile(`(?m)^[ \t]*((?:(?:abstract|final|public|protected|private|static)[ \t]+)*)function[ \t]+&?(\w+)[ \t]*\(`)
+
+var parameterRegex = regexp.MustCompile(`(?s)^(?:([?\w\\|&]+)\s+)??(&\s*)?(\.\.\.\s*)?\$(\w+)(?:\s*=\s*(.+))?$`)
+
+var returnTypeRegex = regexp.MustCompile(`^\s*:\s*(\??[\w\\|]+)`)
+
+var propertyRegex = regexp.MustCompile(`(?m)^[ \t]*(?:(?:static|readonly)[ \t]+)*(?:public|protected|private|var)[ \t]+(?:(?:static|readonly)[ \t]+)*(?:\??[\w\\|]+[ \t]+)?\$(\w+)`)
+
+// The use statements of traits, indented in the class body.
+var traitUseRegex = regexp.MustCompile(`(?m)^[ \t]+use[ \t]+([\w\\]+(?:[ \t]*,[ \t]*[\w\\]+)*)[ \t]*[;{]`)
+
+// The parameters of a constructor that are promoted to properties.
+var promotedParameterRegex = regexp.MustCompile(`(?:public|protected|private)\s+(?:readonly\s+)?(?:[?\w\\|]+\s+)?&?\$(\w+)`)
+
+// Get the namespace in scope at an offset of the source.
+func namespaceAt(src []byte, offset int) string {
+	namespace := ""
+	for _, match := range namespaceRegex.FindAllSubmatchIndex(src, -1) {
+		if match[0] > offset {
+			break
+		}
+		namespace = string(src[match[2]:match[3]])
+	}
+
+	return namespace
+}
+
+// Scan the class, interface and trait declarations of a php file, with
+// their methods.
+func ScanDeclarations(src []byte) []*PhpClassDeclaration {
+	result := []*PhpClassDeclaration{}
+
+	uses := scanUses(src)
+	lines := NewLineOffsets(src)
+	for _, match := range classDeclarationRegex.FindAllSubmatchIndex(src, -1) {
+		namespace := namespaceAt(src, match[0])
+		kind := string(src[match[2]:match[3]])
+
+		declaration := &PhpClassDeclaration{
+			Position: sourcePosition(lines, match[4], match[5]),
+			Kind:     kind,
+			Name:     string(src[match[4]:match[5]]),
+		}
+
+		start := match[0] + len(src[match[0]:match[1]]) - len(bytes.TrimLeft(src[match[0]:match[1]], " \t"))
+		attributes, docblock, docblockStart := precedingAttributes(src, lines, start, namespace, uses)
+		declaration.Docblock = docblock
+		if docblock != "" {
+			declaration.Annotations = parseDocblockAnnotations(src, lines, docblockStart, docblockStart+len(docblock), namespace, uses)
+		}
+		declaration.Annotations = append(declaration.Annotations, attributes...)
+
+		if namespace != "" {
+			declaration.Name = namespace + "\\" + declaration.Name
+		}
+
+		// Interfaces extend other interfaces.
+		extends := []string{}
+		if match[6] != -1 {
+			for _, item := range strings.Split(string(src[match[6]:match[7]]), ",") {
+				extends = append(extends, resolveNameString(strings.TrimSpace(item), namespace, uses))
+			}
+		}
+
+		if kind == "interface" {
+			declaration.Interfaces = extends
+		} else if len(extends) > 0 {
+			declaration.Parent = extends[0]
+		}
+
+		if match[8] != -1 {
+			for _, item := range strings.Split(string(src[match[8]:match[9]]), ",") {
+				declaration.Interfaces = append(declaration.Interfaces, resolveNameString(strings.TrimSpace(item), namespace, uses))
+			}
+		}
+
+		result = append(result, declaration)
+	}
+
+	// Properties and traits belong to the class declared before them.
+	for i, class := range result {
+		end := len(src)
+		if i+1 < len(result) {
+			end = result[i+1].Position.StartPos
+		}
+
+		body := src[class.Position.EndPos:end]
+		for _, match := range propertyRegex.FindAllSubmatch(body, -1) {
+			class.Properties = append(class.Properties, string(match[1]))
+		}
+
+		namespace := namespaceAt(src, class.Position.StartPos)
+		for _, match := range traitUseRegex.FindAllSubmatch(body, -1) {
+			for _, item := range strings.Split(string(match[1]), ",") {
+				class.Traits = append(class.Traits, resolveNameString(strings.TrimSpace(item), namespace, uses))
+			}
+		}
+	}
+
+	// Methods belong to the last class declared before them.
+	for _, match := range methodDeclarationRegex.FindAllSubmatchIndex(src, -1) {
+		var class *PhpClassDeclaration
+		for _, item := range result {
+			if item.Position.StartPos < match[0] {
+				class = item
+			}
+		}
+
+		if class == nil {
+			continue
+		}
+
+		namespace := namespaceAt(src, match[0])
+		method := &PhpClassMethod{
+			Position:   sourcePosition(lines, match[4], match[5]),
+			Name:       string(src[match[4]:match[5]]),
+			Visibility: "public",
+			Docblock:   docblockBefore(src, match[0]),
+		}
+
+		for _, modifier := range strings.Fields(string(src[match[2]:match[3]])) {
+			switch modifier {
+			case "static":
+				method.Static = true
+			case "public", "protected", "private":
+				method.Visibility = modifier
+			}
+		}
+
+		end := closingBracket(src, match[1])
+		if end == -1 {
+			continue
+		}
+
+		method.Params = sourcePosition(lines, match[1], end)
+		method.Parameters = scanParameters(string(src[match[1]:end]))
+
+		if method.Name == "__construct" {
+			// The ones on their own line are found with the properties.
+			for _, promoted := range promotedParameterRegex.FindAllSubmatch(src[match[1]:end], -1) {
+				found := false
+				for _, property := range class.Properties {
+					found = found || property == string(promoted[1])
+				}
+
+				if !found {
+					class.Properties = append(class.Properties, string(promoted[1]))
+				}
+			}
+		}
+
+		declared := ""
+		if returnType := returnTypeRegex.FindSubmatch(src[end+1:]); returnType != nil {
+			declared = string(returnType[1])
+		}
+		method.ReturnType = returnTypeString(declared, method.Docblock, namespace, uses)
+
+		class.Methods = append(class.Methods, method)
+	}
+
+	return result
+}
+
+// Scan the namespace, imports and classes of a php file, which is enough
+// to resolve the names it uses without parsing it.
+func ScanDocument(src []byte) *ParsedDoc {
+	return &ParsedDoc{
+		Namespace: namespaceAt(src, len(src)),
+		Uses:      scanUses(src),
+		Classes:   ScanDeclarations(src),
+	}
+}
+
+// Get the imported names of a php file, keyed by their alias.
+func scanUses(src []byte) map[string]string {
+	uses := make(map[string]string)
+	for _, match := range useRegex.FindAllSubmatch(src, -1) {
+		name := string(match[1])
+		alias := name[strings.LastIndex(name, "\\")+1:]
+		if len(match[2]) > 0 {
+			alias = string(match[2])
+		}
+		uses[alias] = name
+	}
+
+	return uses
+}
+
+// Scan a parameter list, eg. "protected Foo $foo, array $bar = []".
+// Promoted parameters lose their visibility.
+func scanParameters(params string) []*PhpParameter {
+	result := []*PhpParameter{}
+
+	for _, item := range splitArguments(params) {
+		item = strings.TrimSpace(item)
+
+		// Attributes and the modifiers of promoted parameters.
+		for strings.HasPrefix(item, "#[") {
+			end := closingBracket([]byte(item), 2)
+			if end == -1 {
+				break
+			}
+			item = strings.TrimSpace(item[end+1:])
+		}
+
+		for _, modifier := range []string{"public", "protected", "private", "readonly"} {
+			fields := strings.Fields(item)
+			if len(fields) > 1 && fields[0] == modifier {
+				item = strings.TrimSpace(strings.TrimPrefix(item, modifier))
+			}
+		}
+
+		match := parameterRegex.FindStringSubmatch(item)
+		if match == nil {
+			continue
+		}
+
+		result = append(result, &PhpParameter{
+			Name:     match[4],
+			Type:     match[1],
+			Default:  strings.TrimSpace(match[5]),
+			Variadic: match[3] != "",
+		})
+	}
+
+	return result
+}
+
+// Split a list on the commas that aren't nested in brackets or strings.
+func splitArguments(list string) []string {
+	result := []string{}
+	depth, start := 0, 0
+
+	src := []byte(list)
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; c {
+		case '\'', '"':
+			i = closingQuote(src, i)
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				result = append(result, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	if strings.TrimSpace(list[start:]) != "" {
+		result = append(result, list[start:])
+	}
+
+	return result
+}
+
+// Find the bracket that closes the one before offset, skipping strings.
+// It returns -1 if the bracket isn't closed.
+func closingBracket(src []byte, offset int) int {
+	depth := 1
+
+	for i := offset; i < len(src); i++ {
+		switch src[i] {
+		case '\'', '"':
+			i = closingQuote(src, i)
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// Find the quote that closes the string starting at offset, or the end of
+// the text if it isn't closed.
+func closingQuote(src []byte, offset int) int {
+	for i := offset + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case src[offset]:
+			return i
+		}
+	}
+
+	return len(src)
+}
+
+// Get the position of a part of the source.
+func sourcePosition(lines *LineOffsets, start int, end int) *position.Position {
+	startLine, _ := lines.LineColumn(start)
+	endLine, _ := lines.LineColumn(end)
+
+	return &position.Position{
+		StartLine: startLine + 1,
+		EndLine:   endLine + 1,
+		StartPos:  start,
+		EndPos:    end,
+	}
+}
+
+// Get the docblock that ends right before an offset of the source.
+func docblockBefore(src []byte, offset int) string {
+	before := strings.TrimRight(string(src[:offset]), " \t\r\n")
+	if !strings.HasSuffix(before, "*/") {
+		return ""
+	}
+
+	if start := strings.LastIndex(before, "/**"); start != -1 {
+		return before[start:]
+	}
+
+	return ""
+}
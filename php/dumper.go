@@ -2,8 +2,10 @@ package php
 
 import (
 	"io"
+	"strings"
 
 	"github.com/z7zmey/php-parser/pkg/ast"
+	"github.com/z7zmey/php-parser/pkg/position"
 )
 
 type PhpDumper struct {
@@ -12,12 +14,55 @@ type PhpDumper struct {
 	withTokens    bool
 	withPositions bool
 	Expressions   []*Expression
+	MethodCalls   []*MethodExpression
+	ClassMethods  []*ast.StmtClassMethod
+	Functions     []*FunctionExpression
+	Classes       []*ClassExpression
+	Assignments   []*AssignExpression
+	// Name of the class method currently being visited.
+	scope string
+	// The current namespace and its imported names, keyed by alias.
+	namespace string
+	uses      map[string]string
+	// The imported functions, eg. use function Foo\bar, keyed by alias.
+	functionUses map[string]string
 }
 
 type Expression struct {
-	Class ast.Vertex
-	Call  ast.Vertex
-	Args  []ast.Vertex
+	Class    ast.Vertex
+	Call     ast.Vertex
+	Args     []ast.Vertex
+	Position *position.Position
+	Scope    string
+}
+
+// A function declaration with the namespace it's declared in.
+type FunctionExpression struct {
+	Node      *ast.StmtFunction
+	Namespace string
+}
+
+// A class, interface or trait declaration with the namespace and
+// imports needed to resolve its names.
+type ClassExpression struct {
+	Kind      string
+	Node      ast.Vertex
+	Namespace string
+	Uses      map[string]string
+}
+
+// An assignment, eg. $foo = \Drupal::service('foo').
+type AssignExpression struct {
+	Var      ast.Vertex
+	Expr     ast.Vertex
+	Position *position.Position
+}
+
+type MethodExpression struct {
+	Var    ast.Vertex
+	Method ast.Vertex
+	Args   []ast.Vertex
+	Scope  string
 }
 
 func NewPhpDumper(writer io.Writer) *PhpDumper {
@@ -95,6 +140,8 @@ func (v *PhpDumper) StmtCatch(n *ast.StmtCatch) {
 }
 
 func (v *PhpDumper) StmtClass(n *ast.StmtClass) {
+	v.addClass("class", n)
+
 	v.dumpVertexList("Modifiers", n.Modifiers)
 	v.dumpVertex("Name", n.Name)
 	v.dumpVertexList("Args", n.Args)
@@ -110,6 +157,13 @@ func (v *PhpDumper) StmtClassConstList(n *ast.StmtClassConstList) {
 }
 
 func (v *PhpDumper) StmtClassMethod(n *ast.StmtClassMethod) {
+	v.ClassMethods = append(v.ClassMethods, n)
+
+	if name, ok := n.Name.(*ast.Identifier); ok {
+		v.scope = string(name.Value)
+		defer func() { v.scope = "" }()
+	}
+
 	v.dumpVertexList("Modifiers", n.Modifiers)
 	v.dumpVertex("Name", n.Name)
 	v.dumpVertexList("Params", n.Params)
@@ -180,6 +234,11 @@ func (v *PhpDumper) StmtForeach(n *ast.StmtForeach) {
 }
 
 func (v *PhpDumper) StmtFunction(n *ast.StmtFunction) {
+	v.Functions = append(v.Functions, &FunctionExpression{
+		Node:      n,
+		Namespace: v.namespace,
+	})
+
 	v.dumpVertex("Name", n.Name)
 	v.dumpVertexList("Params", n.Params)
 	v.dumpVertex("ReturnType", n.ReturnType)
@@ -210,6 +269,8 @@ func (v *PhpDumper) StmtInlineHtml(n *ast.StmtInlineHtml) {
 }
 
 func (v *PhpDumper) StmtInterface(n *ast.StmtInterface) {
+	v.addClass("interface", n)
+
 	v.dumpVertex("Name", n.Name)
 	v.dumpVertexList("Extends", n.Extends)
 	v.dumpVertexList("Stmts", n.Stmts)
@@ -220,6 +281,10 @@ func (v *PhpDumper) StmtLabel(n *ast.StmtLabel) {
 }
 
 func (v *PhpDumper) StmtNamespace(n *ast.StmtNamespace) {
+	v.namespace = strings.Join(nameParts(n.Name), "\\")
+	v.uses = make(map[string]string)
+	v.functionUses = make(map[string]string)
+
 	v.dumpVertex("Name", n.Name)
 	v.dumpVertexList("Stmts", n.Stmts)
 }
@@ -267,6 +332,8 @@ func (v *PhpDumper) StmtThrow(n *ast.StmtThrow) {
 }
 
 func (v *PhpDumper) StmtTrait(n *ast.StmtTrait) {
+	v.addClass("trait", n)
+
 	v.dumpVertex("Name", n.Name)
 	v.dumpVertexList("Stmts", n.Stmts)
 }
@@ -301,16 +368,87 @@ func (v *PhpDumper) StmtUnset(n *ast.StmtUnset) {
 }
 
 func (v *PhpDumper) StmtUse(n *ast.StmtUseList) {
+	for _, item := range n.Uses {
+		v.addUse(useKind(n.Type), "", item)
+	}
+
 	v.dumpVertex("Type", n.Type)
 	v.dumpVertexList("Uses", n.Uses)
 }
 
 func (v *PhpDumper) StmtGroupUse(n *ast.StmtGroupUseList) {
+	prefix := strings.Join(nameParts(n.Prefix), "\\")
+	for _, item := range n.Uses {
+		v.addUse(useKind(n.Type), prefix, item)
+	}
+
 	v.dumpVertex("Type", n.Type)
 	v.dumpVertex("Prefix", n.Prefix)
 	v.dumpVertexList("Uses", n.Uses)
 }
 
+// Add an imported class or function, constants aren't imported. The items
+// of a group may have their own kind, eg. use Foo\{Bar, function baz}.
+func (v *PhpDumper) addUse(kind string, prefix string, n ast.Vertex) {
+	use, ok := n.(*ast.StmtUse)
+	if !ok {
+		return
+	}
+
+	if use.Type != nil {
+		kind = useKind(use.Type)
+	}
+	if kind != "" && kind != "function" {
+		return
+	}
+
+	parts := nameParts(use.Use)
+	if len(parts) == 0 {
+		return
+	}
+
+	if prefix != "" {
+		parts = append([]string{prefix}, parts...)
+	}
+
+	alias := parts[len(parts)-1]
+	if identifier, ok := use.Alias.(*ast.Identifier); ok {
+		alias = string(identifier.Value)
+	}
+
+	if kind == "function" {
+		if v.functionUses == nil {
+			v.functionUses = make(map[string]string)
+		}
+		v.functionUses[alias] = strings.Join(parts, "\\")
+		return
+	}
+
+	if v.uses == nil {
+		v.uses = make(map[string]string)
+	}
+	v.uses[alias] = strings.Join(parts, "\\")
+}
+
+// Get the kind of a use statement, function or const, or an empty string
+// for classes.
+func useKind(n ast.Vertex) string {
+	if identifier, ok := n.(*ast.Identifier); ok {
+		return strings.ToLower(string(identifier.Value))
+	}
+
+	return ""
+}
+
+func (v *PhpDumper) addClass(kind string, n ast.Vertex) {
+	v.Classes = append(v.Classes, &ClassExpression{
+		Kind:      kind,
+		Node:      n,
+		Namespace: v.namespace,
+		Uses:      v.uses,
+	})
+}
+
 func (v *PhpDumper) StmtUseDeclaration(n *ast.StmtUse) {
 	v.dumpVertex("Type", n.Type)
 	v.dumpVertex("Uses", n.Use)
@@ -424,6 +562,15 @@ func (v *PhpDumper) ExprList(n *ast.ExprList) {
 }
 
 func (v *PhpDumper) ExprMethodCall(n *ast.ExprMethodCall) {
+	// $this->redirect('foo');
+	expr := &MethodExpression{
+		Var:    n.Var,
+		Method: n.Method,
+		Args:   n.Args,
+		Scope:  v.scope,
+	}
+	v.MethodCalls = append(v.MethodCalls, expr)
+
 	v.dumpVertex("Var", n.Var)
 	v.dumpVertex("Method", n.Method)
 	v.dumpVertexList("Args", n.Args)
@@ -475,11 +622,15 @@ func (v *PhpDumper) ExprStaticCall(n *ast.ExprStaticCall) {
 	// Drupal::service('foo');
 	// Drupal -> ast.Class
 	expr := &Expression{
-		Class: n.Class,
-		Call:  n.Call,
-		Args:  n.Args,
+		Class:    n.Class,
+		Call:     n.Call,
+		Args:     n.Args,
+		Position: n.Position,
+		Scope:    v.scope,
 	}
 	v.Expressions = append(v.Expressions, expr)
+
+	v.dumpVertexList("Args", n.Args)
 }
 
 func (v *PhpDumper) ExprStaticPropertyFetch(n *ast.ExprStaticPropertyFetch) {
@@ -515,6 +666,12 @@ func (v *PhpDumper) ExprYieldFrom(n *ast.ExprYieldFrom) {
 }
 
 func (v *PhpDumper) ExprAssign(n *ast.ExprAssign) {
+	v.Assignments = append(v.Assignments, &AssignExpression{
+		Var:      n.Var,
+		Expr:     n.Expr,
+		Position: n.Position,
+	})
+
 	v.dumpVertex("Var", n.Var)
 	v.dumpVertex("Expr", n.Expr)
 }
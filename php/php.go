@@ -1,12 +1,20 @@
 package php
 
 import (
+	"bytes"
+	"errors"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
 
 	"github.com/z7zmey/php-parser/pkg/ast"
 	"github.com/z7zmey/php-parser/pkg/conf"
+	phpErrors "github.com/z7zmey/php-parser/pkg/errors"
 	"github.com/z7zmey/php-parser/pkg/parser"
 	"github.com/z7zmey/php-parser/pkg/position"
+	"github.com/z7zmey/php-parser/pkg/token"
 	"github.com/z7zmey/php-parser/pkg/version"
 )
 
@@ -18,11 +26,33 @@ type PhpClass struct {
 type PhpClassMethod struct {
 	Position *position.Position
 	Name     string
+	// Position of the body, between the curly brackets. Abstract methods
+	// have no body.
+	Body *position.Position
+	// Position of the parameters, between the parentheses.
+	Params     *position.Position
+	Parameters []*PhpParameter
+	Static     bool
+	// One of public, protected or private.
+	Visibility string
+	// The declared or documented return type, see returnType.
+	ReturnType string
+	Docblock   string
+}
+
+// A parameter of a method, with its type and default value as written.
+type PhpParameter struct {
+	Name     string
+	Type     string
+	Default  string
+	Variadic bool
 }
 
 type PhpClassArgument struct {
 	Position *position.Position
 	Name     string
+	// The items of an array argument, eg. ['a', 'b'], as arguments.
+	Items []*PhpClassArgument
 }
 
 type PhpStaticCall struct {
@@ -30,92 +60,931 @@ type PhpStaticCall struct {
 	Class    *PhpClass
 	Method   *PhpClassMethod
 	Args     []*PhpClassArgument
+	// Name of the class method that contains the call.
+	Scope string
+}
+
+type PhpMethodCall struct {
+	Position *position.Position
+	// Name of the variable the method is called on, eg. "container".
+	Var    string
+	Method *PhpClassMethod
+	Args   []*PhpClassArgument
+	// Name of the class method that contains the call.
+	Scope string
+	// Property the method is called on, eg. "blockManager" for
+	// $this->blockManager->createInstance().
+	Property string
+	// Static call the method is called on, eg. \Drupal::service('foo')->get().
+	StaticCall *PhpStaticCall
+	// Method call the method is called on, eg. $this->foo()->bar().
+	MethodCall *PhpMethodCall
+}
+
+type PhpFunction struct {
+	Position *position.Position
+	Name     string
+	// The namespace the function is declared in.
+	Namespace string
+	// The parameter list as written, eg. "array &$form, $form_id".
+	Params   string
+	Docblock string
+	// Position of the body, between the curly brackets.
+	Body       *position.Position
+	Parameters []*PhpParameter
+}
+
+// A class, interface, trait or enum declaration.
+type PhpClassDeclaration struct {
+	Position *position.Position
+	Kind     string
+	// Fully qualified name, without the leading backslash.
+	Name       string
+	Parent     string
+	Interfaces []string
+	Docblock   string
+	// Annotations and attributes of the class, eg. plugin definitions.
+	Annotations []*PhpAnnotation
+	Methods     []*PhpClassMethod
+	// Names of the properties the class declares, without the $, and the
+	// traits it uses.
+	Properties []string
+	Traits     []string
+}
+
+// An expression whose class can be inferred, eg. \Drupal::service('foo')
+// or $storage->load(1).
+type PhpExpression struct {
+	// One of static, method, property, new, variable or function.
+	Kind string
+	// Fully qualified class of static calls and new expressions. The
+	// self, static and parent keywords are kept as is.
+	Class string
+	// Name of the method, property, variable or function. Function names
+	// are kept as written, as php falls back to the global ones.
+	Name string
+	// The first argument of a call if it's a string, eg. a service id.
+	Argument string
+	// The expression a method is called or a property fetched on.
+	Var *PhpExpression
+}
+
+// An assignment to a variable, eg. $messenger = \Drupal::messenger(), or
+// to an element of an array variable, eg. $fields['title'] = ...
+type PhpAssignment struct {
+	Position *position.Position
+	Var      string
+	// The string key of the array element, and the position of the key
+	// without its quotes.
+	Key         string
+	KeyPosition *position.Position
+	Value       *PhpExpression
 }
 
 type ParsedDoc struct {
-	StaticCalls []*PhpStaticCall
+	StaticCalls  []*PhpStaticCall
+	MethodCalls  []*PhpMethodCall
+	ClassMethods []*PhpClassMethod
+	Functions    []*PhpFunction
+	Classes      []*PhpClassDeclaration
+	Assignments  []*PhpAssignment
+	// The namespace of the file and its imported names, keyed by alias.
+	Namespace string
+	Uses      map[string]string
+	// The imported functions, keyed by alias.
+	FunctionUses map[string]string
+	// Syntax errors, eg. of php 8 code the parser doesn't support.
+	Errors []string
+}
+
+// Resolve a class name as written in the file, eg. KernelEvents, to a
+// fully qualified name.
+func (d *ParsedDoc) ResolveName(name string) string {
+	return resolveNameString(name, d.Namespace, d.Uses)
 }
 
 func Parse(src []byte) (*ParsedDoc, error) {
+	syntaxErrors := []string{}
 	rootNode, err := parser.Parse(src, conf.Config{
-		Version: &version.Version{Major: 5, Minor: 6},
+		Version: &version.Version{Major: 7, Minor: 4},
+		ErrorHandlerFunc: func(e *phpErrors.Error) {
+			syntaxErrors = append(syntaxErrors, e.String())
+		},
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
+	// Nothing is left of documents with some syntax errors.
+	if rootNode == nil {
+		return nil, errors.New("Invalid php document")
+	}
+
 	phpDumper := NewPhpDumper(os.Stdout)
 	rootNode.Accept(phpDumper)
 
 	// Create new parsed doc
-	parsedDoc := &ParsedDoc{}
+	parsedDoc := &ParsedDoc{
+		Namespace:    phpDumper.namespace,
+		Uses:         phpDumper.uses,
+		FunctionUses: phpDumper.functionUses,
+		Errors:       syntaxErrors,
+	}
 
 	for _, expr := range phpDumper.Expressions {
-		staticCall := &PhpStaticCall{}
-		isStaticCall := false
-
-		switch expr.Class.(type) {
-		// \Drupal::service('foo')
-		case *ast.NameFullyQualified:
-			class := expr.Class.(*ast.NameFullyQualified)
-			className := ""
-			classParts := class.Parts
-			for _, part := range classParts {
-				classPart := part.(*ast.NamePart)
-				className = string(classPart.Value)
-			}
-
-			isStaticCall = true
-			staticCall.Class = &PhpClass{
-				Position: expr.Class.GetPosition(),
-				Name:     className,
-			}
-		case *ast.Name:
-			class := expr.Class.(*ast.Name)
-			className := ""
-			classParts := class.Parts
-			for _, part := range classParts {
-				classPart := part.(*ast.NamePart)
-				className = string(classPart.Value)
-			}
-
-			isStaticCall = true
-			staticCall.Class = &PhpClass{
-				Position: expr.Class.GetPosition(),
-				Name:     className,
-			}
-		}
-
-		if isStaticCall {
-			switch expr.Call.(type) {
-			case *ast.Identifier:
-				staticCall.Method = &PhpClassMethod{
-					Position: expr.Call.GetPosition(),
-					Name:     string(expr.Call.(*ast.Identifier).Value),
+		if staticCall, ok := newStaticCall(expr.Class, expr.Call, expr.Args); ok {
+			staticCall.Position = expr.Position
+			staticCall.Scope = expr.Scope
+			parsedDoc.StaticCalls = append(parsedDoc.StaticCalls, staticCall)
+		}
+	}
+
+	// $this->redirect('foo')
+	for _, expr := range phpDumper.MethodCalls {
+		if methodCall := newMethodCall(expr.Var, expr.Method, expr.Args, expr.Scope); methodCall != nil {
+			parsedDoc.MethodCalls = append(parsedDoc.MethodCalls, methodCall)
+		}
+	}
+
+	// public function build()
+	for _, method := range phpDumper.ClassMethods {
+		switch method.Name.(type) {
+		case *ast.Identifier:
+			classMethod := &PhpClassMethod{
+				Position:   method.Name.GetPosition(),
+				Name:       string(method.Name.(*ast.Identifier).Value),
+				Parameters: parseParams(src, method.Params),
+				Visibility: "public",
+				Docblock:   docComment(method.FunctionTkn),
+			}
+
+			if body, ok := method.Stmt.(*ast.StmtStmtList); ok && body.OpenCurlyBracketTkn != nil && body.CloseCurlyBracketTkn != nil {
+				classMethod.Body = &position.Position{
+					StartLine: body.OpenCurlyBracketTkn.Position.EndLine,
+					EndLine:   body.CloseCurlyBracketTkn.Position.StartLine,
+					StartPos:  body.OpenCurlyBracketTkn.Position.EndPos,
+					EndPos:    body.CloseCurlyBracketTkn.Position.StartPos,
 				}
 			}
 
-			// Argument
-			for _, item := range expr.Args {
-				switch item.(type) {
-				case *ast.Argument:
-					arg := item.(*ast.Argument)
-					switch arg.Expr.(type) {
-					case *ast.ScalarString:
-						ex := arg.Expr.(*ast.ScalarString)
-						staticCall.Args = append(staticCall.Args, &PhpClassArgument{
-							Position: arg.Expr.GetPosition(),
-							Name:     string(ex.Value),
-						})
-					}
+			if method.OpenParenthesisTkn != nil && method.CloseParenthesisTkn != nil {
+				classMethod.Params = &position.Position{
+					StartLine: method.OpenParenthesisTkn.Position.EndLine,
+					EndLine:   method.CloseParenthesisTkn.Position.StartLine,
+					StartPos:  method.OpenParenthesisTkn.Position.EndPos,
+					EndPos:    method.CloseParenthesisTkn.Position.StartPos,
 				}
 			}
 
-			parsedDoc.StaticCalls = append(parsedDoc.StaticCalls, staticCall)
+			for i, modifier := range method.Modifiers {
+				identifier, ok := modifier.(*ast.Identifier)
+				if !ok {
+					continue
+				}
+
+				// The docblock precedes the first modifier.
+				if i == 0 {
+					classMethod.Docblock = docComment(identifier.IdentifierTkn)
+				}
+
+				switch value := strings.ToLower(string(identifier.Value)); value {
+				case "static":
+					classMethod.Static = true
+				case "public", "protected", "private":
+					classMethod.Visibility = value
+				}
+			}
+
+			classMethod.ReturnType = returnType(src, method.ReturnType, classMethod.Docblock, phpDumper.namespace, phpDumper.uses)
+
+			parsedDoc.ClassMethods = append(parsedDoc.ClassMethods, classMethod)
+		}
+	}
+
+	// function hook_form_alter(&$form, $form_state, $form_id)
+	for _, expr := range phpDumper.Functions {
+		function := expr.Node
+		switch function.Name.(type) {
+		case *ast.Identifier:
+			params := ""
+			if function.OpenParenthesisTkn != nil && function.CloseParenthesisTkn != nil {
+				params = string(src[function.OpenParenthesisTkn.Position.EndPos:function.CloseParenthesisTkn.Position.StartPos])
+				params = strings.Join(strings.Fields(params), " ")
+			}
+
+			var body *position.Position
+			if function.OpenCurlyBracketTkn != nil && function.CloseCurlyBracketTkn != nil {
+				body = &position.Position{
+					StartLine: function.OpenCurlyBracketTkn.Position.EndLine,
+					EndLine:   function.CloseCurlyBracketTkn.Position.StartLine,
+					StartPos:  function.OpenCurlyBracketTkn.Position.EndPos,
+					EndPos:    function.CloseCurlyBracketTkn.Position.StartPos,
+				}
+			}
+
+			parsedDoc.Functions = append(parsedDoc.Functions, &PhpFunction{
+				Position:   function.Name.GetPosition(),
+				Name:       string(function.Name.(*ast.Identifier).Value),
+				Namespace:  expr.Namespace,
+				Params:     params,
+				Docblock:   docComment(function.FunctionTkn),
+				Body:       body,
+				Parameters: parseParams(src, function.Params),
+			})
+		}
+	}
+
+	// $foo = \Drupal::service('foo') and $fields['title'] = ...
+	for _, expr := range phpDumper.Assignments {
+		assignment := &PhpAssignment{
+			Position: expr.Position,
+		}
+
+		variable, ok := expr.Var.(*ast.ExprVariable)
+		if element, isElement := expr.Var.(*ast.ExprArrayDimFetch); isElement {
+			key, isString := element.Dim.(*ast.ScalarString)
+			variable, ok = element.Var.(*ast.ExprVariable)
+			if !isString || key.Position == nil {
+				continue
+			}
+
+			assignment.Key = strings.Trim(string(key.Value), `'"`)
+			assignment.KeyPosition = &position.Position{
+				StartLine: key.Position.StartLine,
+				EndLine:   key.Position.EndLine,
+				StartPos:  key.Position.StartPos + 1,
+				EndPos:    key.Position.EndPos - 1,
+			}
+		}
+
+		if !ok {
+			continue
+		}
+
+		assignment.Var = variableName(variable)
+		assignment.Value = newExpression(expr.Expr, phpDumper.namespace, phpDumper.uses)
+		if assignment.Value == nil || assignment.Var == "" {
+			continue
+		}
+
+		parsedDoc.Assignments = append(parsedDoc.Assignments, assignment)
+	}
+
+	// class Foo extends Bar implements Baz
+	lines := NewLineOffsets(src)
+	for _, expr := range phpDumper.Classes {
+		declaration := &PhpClassDeclaration{
+			Kind: expr.Kind,
+		}
+
+		// The first token of the declaration, which holds its docblock.
+		var name ast.Vertex
+		var first *token.Token
+		switch node := expr.Node.(type) {
+		case *ast.StmtClass:
+			name = node.Name
+			declaration.Properties, declaration.Traits = classMembers(node.Stmts, expr.Namespace, expr.Uses)
+			declaration.Parent = resolveName(node.Extends, expr.Namespace, expr.Uses)
+			for _, item := range node.Implements {
+				declaration.Interfaces = append(declaration.Interfaces, resolveName(item, expr.Namespace, expr.Uses))
+			}
+
+			// The docblock precedes the first modifier, eg. final.
+			first = node.ClassTkn
+			if len(node.Modifiers) > 0 {
+				if modifier, ok := node.Modifiers[0].(*ast.Identifier); ok {
+					first = modifier.IdentifierTkn
+				}
+			}
+		case *ast.StmtInterface:
+			name = node.Name
+			for _, item := range node.Extends {
+				declaration.Interfaces = append(declaration.Interfaces, resolveName(item, expr.Namespace, expr.Uses))
+			}
+			first = node.InterfaceTkn
+		case *ast.StmtTrait:
+			name = node.Name
+			declaration.Properties, declaration.Traits = classMembers(node.Stmts, expr.Namespace, expr.Uses)
+			first = node.TraitTkn
+		}
+
+		// Anonymous classes have no name.
+		identifier, ok := name.(*ast.Identifier)
+		if !ok || first == nil || first.Position == nil {
+			continue
+		}
+
+		attributes, docblock, docblockStart := precedingAttributes(src, lines, first.Position.StartPos, expr.Namespace, expr.Uses)
+		if item := docCommentToken(first); item != nil && item.Position != nil {
+			docblock, docblockStart = string(item.Value), item.Position.StartPos
+		}
+
+		declaration.Docblock = docblock
+		if docblock != "" {
+			declaration.Annotations = parseDocblockAnnotations(src, lines, docblockStart, docblockStart+len(docblock), expr.Namespace, expr.Uses)
+		}
+		declaration.Annotations = append(declaration.Annotations, attributes...)
+
+		declaration.Position = identifier.Position
+		declaration.Name = string(identifier.Value)
+
+		if node := expr.Node.GetPosition(); node != nil {
+			for _, method := range parsedDoc.ClassMethods {
+				if method.Position.StartPos > node.StartPos && method.Position.EndPos < node.EndPos {
+					declaration.Methods = append(declaration.Methods, method)
+				}
+			}
+		}
+		if expr.Namespace != "" {
+			declaration.Name = expr.Namespace + "\\" + declaration.Name
 		}
 
+		parsedDoc.Classes = append(parsedDoc.Classes, declaration)
+	}
+
+	parsedDoc.Classes = append(parsedDoc.Classes, parseEnums(src, lines, phpDumper.uses)...)
+
+	// The classes the parser dropped because of syntax errors are scanned
+	// from the source.
+	if len(syntaxErrors) > 0 {
+		for _, declaration := range ScanDeclarations(src) {
+			found := false
+			for _, item := range parsedDoc.Classes {
+				found = found || item.Name == declaration.Name
+			}
+
+			if !found {
+				parsedDoc.Classes = append(parsedDoc.Classes, declaration)
+			}
+		}
 	}
 
 	return parsedDoc, nil
 }
+
+// Get the properties and traits of a class body.
+func classMembers(stmts []ast.Vertex, namespace string, uses map[string]string) ([]string, []string) {
+	properties := []string{}
+	traits := []string{}
+
+	for _, stmt := range stmts {
+		switch node := stmt.(type) {
+		case *ast.StmtPropertyList:
+			for _, item := range node.Props {
+				if property, ok := item.(*ast.StmtProperty); ok {
+					if variable, ok := property.Var.(*ast.ExprVariable); ok {
+						properties = append(properties, variableName(variable))
+					}
+				}
+			}
+		case *ast.StmtTraitUse:
+			for _, item := range node.Traits {
+				traits = append(traits, resolveName(item, namespace, uses))
+			}
+		}
+	}
+
+	return properties, traits
+}
+
+// Convert a static call node, eg. \Drupal::service('foo'). Calls on
+// variables, like $class::create(), are skipped.
+func newStaticCall(class ast.Vertex, call ast.Vertex, args []ast.Vertex) (*PhpStaticCall, bool) {
+	switch class.(type) {
+	case *ast.NameFullyQualified, *ast.Name:
+	default:
+		return nil, false
+	}
+
+	parts := nameParts(class)
+	if len(parts) == 0 {
+		return nil, false
+	}
+
+	staticCall := &PhpStaticCall{
+		Class: &PhpClass{
+			Position: class.GetPosition(),
+			Name:     parts[len(parts)-1],
+		},
+		Args: parseArgs(args),
+	}
+
+	if identifier, ok := call.(*ast.Identifier); ok {
+		staticCall.Method = &PhpClassMethod{
+			Position: call.GetPosition(),
+			Name:     string(identifier.Value),
+		}
+	}
+
+	return staticCall, true
+}
+
+// Convert a method call node, eg. $this->redirect('foo'). Calls with a
+// dynamic method name are skipped.
+func newMethodCall(variable ast.Vertex, method ast.Vertex, args []ast.Vertex, scope string) *PhpMethodCall {
+	identifier, ok := method.(*ast.Identifier)
+	if !ok {
+		return nil
+	}
+
+	methodCall := &PhpMethodCall{
+		Position: method.GetPosition(),
+		Method: &PhpClassMethod{
+			Position: method.GetPosition(),
+			Name:     string(identifier.Value),
+		},
+		Args:  parseArgs(args),
+		Scope: scope,
+	}
+
+	switch node := variable.(type) {
+	case *ast.ExprVariable:
+		methodCall.Var = variableName(node)
+	case *ast.ExprPropertyFetch:
+		// $this->foo->bar()
+		if variable, ok := node.Var.(*ast.ExprVariable); ok {
+			methodCall.Var = variableName(variable)
+		}
+		if property, ok := node.Prop.(*ast.Identifier); ok {
+			methodCall.Property = string(property.Value)
+		}
+	case *ast.ExprStaticCall:
+		if staticCall, ok := newStaticCall(node.Class, node.Call, node.Args); ok {
+			staticCall.Position = node.Position
+			staticCall.Scope = scope
+			methodCall.StaticCall = staticCall
+		}
+	case *ast.ExprMethodCall:
+		methodCall.MethodCall = newMethodCall(node.Var, node.Method, node.Args, scope)
+	}
+
+	return methodCall
+}
+
+// Parse a single expression, eg. the one before -> while typing, with
+// the names resolved like in the document.
+func (d *ParsedDoc) ParseExpression(code string) (*PhpExpression, error) {
+	rootNode, err := parser.Parse([]byte("<?php "+code+";"), conf.Config{
+		Version: &version.Version{Major: 7, Minor: 4},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	root, ok := rootNode.(*ast.Root)
+	if !ok || len(root.Stmts) != 1 {
+		return nil, errors.New("Not a single expression")
+	}
+
+	statement, ok := root.Stmts[0].(*ast.StmtExpression)
+	if !ok {
+		return nil, errors.New("Not a single expression")
+	}
+
+	expression := newExpression(statement.Expr, d.Namespace, d.Uses)
+	if expression == nil {
+		return nil, errors.New("Unsupported expression")
+	}
+
+	return expression, nil
+}
+
+// Convert the expressions whose class can be inferred, the others are
+// skipped.
+func newExpression(n ast.Vertex, namespace string, uses map[string]string) *PhpExpression {
+	switch node := n.(type) {
+	case *ast.ExprBrackets:
+		return newExpression(node.Expr, namespace, uses)
+	case *ast.ExprVariable:
+		if name := variableName(node); name != "" {
+			return &PhpExpression{Kind: "variable", Name: name}
+		}
+	case *ast.ExprNew:
+		if class := resolveClassName(node.Class, namespace, uses); class != "" {
+			return &PhpExpression{Kind: "new", Class: class}
+		}
+	case *ast.ExprFunctionCall:
+		if name := strings.Join(nameParts(node.Function), "\\"); name != "" {
+			if _, ok := node.Function.(*ast.NameFullyQualified); ok {
+				name = "\\" + name
+			}
+
+			return &PhpExpression{
+				Kind:     "function",
+				Name:     name,
+				Argument: stringArgument(node.Args),
+			}
+		}
+	case *ast.ExprStaticCall:
+		method, ok := node.Call.(*ast.Identifier)
+		class := resolveClassName(node.Class, namespace, uses)
+		if ok && class != "" {
+			return &PhpExpression{
+				Kind:     "static",
+				Class:    class,
+				Name:     string(method.Value),
+				Argument: stringArgument(node.Args),
+			}
+		}
+	case *ast.ExprMethodCall:
+		if method, ok := node.Method.(*ast.Identifier); ok {
+			return &PhpExpression{
+				Kind:     "method",
+				Name:     string(method.Value),
+				Argument: stringArgument(node.Args),
+				Var:      newExpression(node.Var, namespace, uses),
+			}
+		}
+	case *ast.ExprPropertyFetch:
+		if property, ok := node.Prop.(*ast.Identifier); ok {
+			return &PhpExpression{
+				Kind: "property",
+				Name: string(property.Value),
+				Var:  newExpression(node.Var, namespace, uses),
+			}
+		}
+	}
+
+	return nil
+}
+
+// Resolve a class name node, keeping the self, static and parent keywords.
+func resolveClassName(n ast.Vertex, namespace string, uses map[string]string) string {
+	if name, ok := n.(*ast.Name); ok && len(name.Parts) == 1 {
+		switch keyword := strings.ToLower(strings.Join(nameParts(n), "")); keyword {
+		case "self", "static", "parent":
+			return keyword
+		}
+	}
+
+	if identifier, ok := n.(*ast.Identifier); ok && strings.ToLower(string(identifier.Value)) == "static" {
+		return "static"
+	}
+
+	return resolveName(n, namespace, uses)
+}
+
+// Get the first argument of a call if it's a string, without its quotes.
+func stringArgument(args []ast.Vertex) string {
+	if len(args) == 0 {
+		return ""
+	}
+
+	argument, ok := args[0].(*ast.Argument)
+	if !ok {
+		return ""
+	}
+
+	if value, ok := argument.Expr.(*ast.ScalarString); ok {
+		return strings.Trim(string(value.Value), `'"`)
+	}
+
+	return ""
+}
+
+// Types that aren't classes.
+var phpBuiltinTypes = []string{
+	"array", "bool", "boolean", "callable", "double", "false", "float",
+	"int", "integer", "iterable", "mixed", "never", "object", "resource",
+	"string", "true", "void",
+}
+
+var returnTagRegex = regexp.MustCompile(`@return\s+([^\s*]+)`)
+
+// Get the return type of a method from its declaration, or its docblock
+// for older code. Class names are resolved and self, static and $this are
+// all returned as static. Only the first type of a union is kept.
+func returnType(src []byte, declared ast.Vertex, docblock string, namespace string, uses map[string]string) string {
+	text := ""
+	if declared != nil && declared.GetPosition() != nil {
+		pos := declared.GetPosition()
+		text = string(src[pos.StartPos:pos.EndPos])
+	}
+
+	return returnTypeString(text, docblock, namespace, uses)
+}
+
+// Get the return type of a method from its declaration as written.
+func returnTypeString(text string, docblock string, namespace string, uses map[string]string) string {
+	if text == "" {
+		if match := returnTagRegex.FindStringSubmatch(docblock); match != nil {
+			text = match[1]
+		}
+	}
+
+	for _, part := range strings.Split(strings.TrimPrefix(text, "?"), "|") {
+		lower := strings.ToLower(part)
+		switch {
+		case lower == "" || lower == "null":
+			continue
+		case lower == "self" || lower == "static" || lower == "$this":
+			return "static"
+		case strings.HasSuffix(part, "[]"):
+			return "array"
+		}
+
+		for _, builtin := range phpBuiltinTypes {
+			if lower == builtin {
+				return lower
+			}
+		}
+
+		return resolveNameString(part, namespace, uses)
+	}
+
+	return ""
+}
+
+// Get the name of a variable without the $.
+func variableName(variable *ast.ExprVariable) string {
+	if name, ok := variable.Name.(*ast.Identifier); ok {
+		return strings.TrimPrefix(string(name.Value), "$")
+	}
+
+	return ""
+}
+
+// The parser only supports php 7.4, so enums are found by their
+// declaration line instead of the syntax tree.
+var enumRegex = regexp.MustCompile(`(?m)^[ \t]*enum[ \t]+(\w+)(?:[ \t]*:[ \t]*\w+)?(?:[ \t]+implements[ \t]+([\w\\, \t]+?))?[ \t]*\{?[ \t]*$`)
+
+func parseEnums(src []byte, lines *LineOffsets, uses map[string]string) []*PhpClassDeclaration {
+	result := []*PhpClassDeclaration{}
+
+	for _, match := range enumRegex.FindAllSubmatchIndex(src, -1) {
+		namespace := namespaceAt(src, match[0])
+		declaration := &PhpClassDeclaration{
+			Position: sourcePosition(lines, match[2], match[3]),
+			Kind:     "enum",
+			Name:     string(src[match[2]:match[3]]),
+			Docblock: docblockBefore(src, match[0]),
+		}
+
+		if namespace != "" {
+			declaration.Name = namespace + "\\" + declaration.Name
+		}
+
+		if match[4] != -1 {
+			for _, item := range strings.Split(string(src[match[4]:match[5]]), ",") {
+				declaration.Interfaces = append(declaration.Interfaces, resolveNameString(strings.TrimSpace(item), namespace, uses))
+			}
+		}
+
+		result = append(result, declaration)
+	}
+
+	return result
+}
+
+// Get the parts of a name node, eg. [Drupal Core Url].
+func nameParts(n ast.Vertex) []string {
+	parts := []ast.Vertex{}
+
+	switch name := n.(type) {
+	case *ast.Name:
+		parts = name.Parts
+	case *ast.NameFullyQualified:
+		parts = name.Parts
+	case *ast.NameRelative:
+		parts = name.Parts
+	}
+
+	result := []string{}
+	for _, part := range parts {
+		if namePart, ok := part.(*ast.NamePart); ok {
+			result = append(result, string(namePart.Value))
+		}
+	}
+
+	return result
+}
+
+// Resolve a name node to a fully qualified name.
+func resolveName(n ast.Vertex, namespace string, uses map[string]string) string {
+	name := strings.Join(nameParts(n), "\\")
+	if name == "" {
+		return ""
+	}
+
+	switch n.(type) {
+	case *ast.NameFullyQualified:
+		return name
+	case *ast.NameRelative:
+		if namespace == "" {
+			return name
+		}
+		return namespace + "\\" + name
+	}
+
+	return resolveNameString(name, namespace, uses)
+}
+
+// Resolve a class name as written in the source to a fully qualified
+// name, using the imports of the file.
+func resolveNameString(name string, namespace string, uses map[string]string) string {
+	if strings.HasPrefix(name, "\\") {
+		return strings.TrimPrefix(name, "\\")
+	}
+
+	parts := strings.SplitN(name, "\\", 2)
+	if use, ok := uses[parts[0]]; ok {
+		parts[0] = use
+		return strings.Join(parts, "\\")
+	}
+
+	if namespace == "" {
+		return name
+	}
+
+	return namespace + "\\" + name
+}
+
+// Get the doc comment that precedes a token.
+func docComment(tkn *token.Token) string {
+	if item := docCommentToken(tkn); item != nil {
+		return string(item.Value)
+	}
+
+	return ""
+}
+
+// Get the token of the doc comment that precedes a token, if any.
+func docCommentToken(tkn *token.Token) *token.Token {
+	if tkn == nil {
+		return nil
+	}
+
+	var result *token.Token
+	for _, item := range tkn.FreeFloating {
+		if item.ID == token.T_DOC_COMMENT {
+			result = item
+		}
+	}
+
+	return result
+}
+
+// Strip the comment delimiters from a docblock.
+func DocblockText(docblock string) string {
+	lines := []string{}
+
+	for _, line := range strings.Split(docblock, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimPrefix(line, "/**")
+		line = strings.TrimSuffix(line, "*/")
+		line = strings.TrimPrefix(line, "*")
+		line = strings.TrimPrefix(line, " ")
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// Convert the parameters of a method, their types and default values are
+// kept as written.
+func parseParams(src []byte, params []ast.Vertex) []*PhpParameter {
+	result := []*PhpParameter{}
+
+	for _, item := range params {
+		param, ok := item.(*ast.Parameter)
+		if !ok {
+			continue
+		}
+
+		parameter := &PhpParameter{
+			Variadic: param.VariadicTkn != nil,
+		}
+
+		if variable, ok := param.Var.(*ast.ExprVariable); ok {
+			parameter.Name = variableName(variable)
+		}
+
+		if param.Type != nil {
+			if pos := param.Type.GetPosition(); pos != nil {
+				parameter.Type = string(src[pos.StartPos:pos.EndPos])
+			}
+		}
+
+		if param.DefaultValue != nil {
+			if pos := param.DefaultValue.GetPosition(); pos != nil {
+				parameter.Default = string(src[pos.StartPos:pos.EndPos])
+			}
+		}
+
+		result = append(result, parameter)
+	}
+
+	return result
+}
+
+// Every argument is returned so callers can rely on its index, but only
+// string literals get a name, the rest are left empty.
+func parseArgs(args []ast.Vertex) []*PhpClassArgument {
+	result := []*PhpClassArgument{}
+
+	for _, item := range args {
+		switch item.(type) {
+		case *ast.Argument:
+			arg := item.(*ast.Argument)
+			argument := &PhpClassArgument{
+				Position: arg.Expr.GetPosition(),
+			}
+
+			switch arg.Expr.(type) {
+			case *ast.ScalarString:
+				argument.Name = string(arg.Expr.(*ast.ScalarString).Value)
+			case *ast.ExprArray:
+				argument.Items = parseArrayItems(arg.Expr.(*ast.ExprArray).Items)
+			}
+
+			result = append(result, argument)
+		}
+	}
+
+	return result
+}
+
+// Convert the values of array items like arguments, the keys are ignored.
+func parseArrayItems(items []ast.Vertex) []*PhpClassArgument {
+	result := []*PhpClassArgument{}
+
+	for _, item := range items {
+		arrayItem, ok := item.(*ast.ExprArrayItem)
+		if !ok || arrayItem.Val == nil {
+			continue
+		}
+
+		argument := &PhpClassArgument{
+			Position: arrayItem.Val.GetPosition(),
+		}
+
+		if value, ok := arrayItem.Val.(*ast.ScalarString); ok {
+			argument.Name = string(value.Value)
+		}
+
+		result = append(result, argument)
+	}
+
+	return result
+}
+
+// Convert a byte offset in src to a zero based line and column. The
+// column is counted in UTF-16 code units, like the positions of the
+// language server protocol. Use LineOffsets to convert many offsets of the
+// same source.
+func LineColumn(src []byte, offset int) (int, int) {
+	if offset > len(src) {
+		offset = len(src)
+	}
+
+	start := bytes.LastIndexByte(src[:offset], '\n') + 1
+
+	return bytes.Count(src[:start], []byte("\n")), utf16Length(src[start:offset])
+}
+
+// LineOffsets holds where the lines of a source start, so its offsets are
+// converted without scanning the source again for each one.
+type LineOffsets struct {
+	src    []byte
+	starts []int
+}
+
+func NewLineOffsets(src []byte) *LineOffsets {
+	starts := []int{0}
+	for i, c := range src {
+		if c == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+
+	return &LineOffsets{
+		src:    src,
+		starts: starts,
+	}
+}
+
+// Convert a byte offset to a zero based line and column, like LineColumn.
+func (l *LineOffsets) LineColumn(offset int) (int, int) {
+	if offset > len(l.src) {
+		offset = len(l.src)
+	}
+
+	line := sort.SearchInts(l.starts, offset+1) - 1
+
+	return line, utf16Length(l.src[l.starts[line]:offset])
+}
+
+// Get the length of a text in UTF-16 code units.
+func utf16Length(text []byte) int {
+	length := 0
+	for _, r := range string(text) {
+		if n := utf16.RuneLen(r); n > 0 {
+			length += n
+		} else {
+			length++
+		}
+	}
+
+	return length
+}
@@ -1,6 +1,7 @@
 package php
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -36,3 +37,733 @@ func TestParse(t *testing.T) {
 		return
 	}
 }
+
+func TestParseMethodCalls(t *testing.T) {
+	// Test PHP file.
+	src := "<?php class Foo { public function build(): array { return $this->redirect($route, 'foo.page'); } } ?>"
+
+	// Parse.
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	if len(doc.MethodCalls) != 1 {
+		t.Errorf("Invalid number of method calls found")
+		return
+	}
+
+	methodCall := doc.MethodCalls[0]
+	if methodCall.Method.Name != "redirect" {
+		t.Errorf("Invalid method name")
+		return
+	}
+
+	if methodCall.Var != "this" || methodCall.Scope != "build" {
+		t.Errorf("Invalid method call context")
+		return
+	}
+
+	if len(methodCall.Args) != 2 || methodCall.Args[0].Name != "" || methodCall.Args[1].Name != "'foo.page'" {
+		t.Errorf("Invalid args found")
+		return
+	}
+
+	if len(doc.ClassMethods) != 1 || doc.ClassMethods[0].Name != "build" {
+		t.Errorf("Invalid class methods found")
+		return
+	}
+}
+
+func TestParseChainedMethodCalls(t *testing.T) {
+	// Test PHP file.
+	src := "<?php \\Drupal::entityTypeManager()->getStorage('node'); $this->entityTypeManager()->getStorage('user'); $this->storage->load(1); ?>"
+
+	// Parse.
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	calls := map[string]*PhpMethodCall{}
+	for _, call := range doc.MethodCalls {
+		if len(call.Args) > 0 {
+			calls[call.Args[0].Name] = call
+		}
+	}
+
+	if call := calls["'node'"]; call == nil || call.StaticCall == nil || call.StaticCall.Method.Name != "entityTypeManager" {
+		t.Errorf("Invalid static call receiver")
+		return
+	}
+
+	if call := calls["'user'"]; call == nil || call.MethodCall == nil || call.MethodCall.Method.Name != "entityTypeManager" || call.MethodCall.Var != "this" {
+		t.Errorf("Invalid method call receiver")
+		return
+	}
+
+	if call := calls[""]; call == nil || call.Var != "this" || call.Property != "storage" {
+		t.Errorf("Invalid property receiver")
+		return
+	}
+}
+
+func TestParseFunctions(t *testing.T) {
+	// Test PHP file.
+	src := "<?php\n/**\n * Perform alterations.\n */\nfunction hook_form_alter(&$form,\n  $form_id) {} ?>"
+
+	// Parse.
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	if len(doc.Functions) != 1 {
+		t.Errorf("Invalid number of functions found")
+		return
+	}
+
+	function := doc.Functions[0]
+	if function.Name != "hook_form_alter" {
+		t.Errorf("Invalid function name")
+		return
+	}
+
+	if function.Params != "&$form, $form_id" {
+		t.Errorf("Invalid function params %s", function.Params)
+		return
+	}
+
+	if DocblockText(function.Docblock) != "Perform alterations." {
+		t.Errorf("Invalid function docblock %s", function.Docblock)
+		return
+	}
+}
+
+func TestParseFunctionUses(t *testing.T) {
+	src := "<?php\nnamespace Drupal\\foo;\n\nuse Drupal\\Core\\Url;\nuse function Drupal\\bar\\bar_load as load;\nuse Drupal\\baz\\{Baz, function baz_view};\nuse const Drupal\\FOO;\n\nfunction foo_load() {}\n"
+
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	if len(doc.Uses) != 2 || doc.Uses["Url"] != "Drupal\\Core\\Url" || doc.Uses["Baz"] != "Drupal\\baz\\Baz" {
+		t.Errorf("Invalid class uses %v", doc.Uses)
+	}
+
+	if len(doc.FunctionUses) != 2 || doc.FunctionUses["load"] != "Drupal\\bar\\bar_load" || doc.FunctionUses["baz_view"] != "Drupal\\baz\\baz_view" {
+		t.Errorf("Invalid function uses %v", doc.FunctionUses)
+	}
+
+	if len(doc.Functions) != 1 || doc.Functions[0].Namespace != "Drupal\\foo" {
+		t.Errorf("Invalid function namespace %+v", doc.Functions)
+	}
+}
+
+func TestParseClasses(t *testing.T) {
+	// Test PHP file.
+	src := `<?php
+namespace Drupal\foo;
+
+use Drupal\Core\Controller\ControllerBase as Base;
+
+/**
+ * The foo controller.
+ */
+final class FooController extends Base implements \Countable, Bar\BazInterface {
+  use FooTrait, \Drupal\Core\StringTranslation\StringTranslationTrait;
+
+  protected $messenger, $logger;
+
+  private static ?Base $base = NULL;
+}
+
+interface FooInterface extends Base {}
+
+trait FooTrait {
+  var $count = 0;
+}
+
+enum Suit: string implements FooInterface {
+  case Hearts = 'H';
+}
+`
+
+	// Parse.
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	if len(doc.Classes) != 4 {
+		t.Errorf("Invalid number of classes found")
+		return
+	}
+
+	class := doc.Classes[0]
+	if class.Kind != "class" || class.Name != "Drupal\\foo\\FooController" {
+		t.Errorf("Invalid class %s", class.Name)
+		return
+	}
+
+	if class.Parent != "Drupal\\Core\\Controller\\ControllerBase" {
+		t.Errorf("Invalid class parent %s", class.Parent)
+		return
+	}
+
+	if len(class.Interfaces) != 2 || class.Interfaces[0] != "Countable" || class.Interfaces[1] != "Drupal\\foo\\Bar\\BazInterface" {
+		t.Errorf("Invalid class interfaces %v", class.Interfaces)
+		return
+	}
+
+	if DocblockText(class.Docblock) != "The foo controller." {
+		t.Errorf("Invalid class docblock %s", class.Docblock)
+		return
+	}
+
+	if strings.Join(class.Properties, ",") != "messenger,logger,base" {
+		t.Errorf("Invalid class properties %v", class.Properties)
+	}
+
+	if strings.Join(class.Traits, ",") != "Drupal\\foo\\FooTrait,Drupal\\Core\\StringTranslation\\StringTranslationTrait" {
+		t.Errorf("Invalid class traits %v", class.Traits)
+	}
+
+	if len(doc.Classes[2].Properties) != 1 || doc.Classes[2].Properties[0] != "count" {
+		t.Errorf("Invalid trait properties %v", doc.Classes[2].Properties)
+	}
+
+	if doc.Classes[1].Kind != "interface" || doc.Classes[2].Kind != "trait" {
+		t.Errorf("Invalid class kinds")
+		return
+	}
+
+	enum := doc.Classes[3]
+	if enum.Kind != "enum" || enum.Name != "Drupal\\foo\\Suit" || enum.Interfaces[0] != "Drupal\\foo\\FooInterface" {
+		t.Errorf("Invalid enum %v", enum)
+		return
+	}
+}
+
+func TestParsePhp8Classes(t *testing.T) {
+	// Test PHP file, the parser only supports php 7.4.
+	src := `<?php
+namespace Drupal\foo\Controller;
+
+use Drupal\Core\Controller\ControllerBase;
+use Drupal\Core\Entity\EntityTypeManagerInterface as Manager;
+
+/**
+ * The foo controller.
+ */
+final class FooController extends ControllerBase {
+
+  use FooTrait;
+
+  protected string $label = 'Foo';
+
+  public function __construct(
+    #[Autowire(service: 'entity_type.manager')]
+    protected readonly Manager $manager,
+    private array $options = ['a', 'b'],
+  ) {}
+
+  public static function create(ContainerInterface $container): static {
+    return new static($container->get('entity_type.manager'));
+  }
+
+  public function build(string $id) {
+    return match ($id) {
+      'foo' => [],
+      default => ['#markup' => $id],
+    };
+  }
+
+}
+`
+
+	// Parse.
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	if len(doc.Errors) == 0 || len(doc.Classes) != 1 {
+		t.Errorf("Invalid classes %v, errors %v", doc.Classes, doc.Errors)
+		return
+	}
+
+	class := doc.Classes[0]
+	if class.Name != "Drupal\\foo\\Controller\\FooController" || class.Parent != "Drupal\\Core\\Controller\\ControllerBase" || DocblockText(class.Docblock) != "The foo controller." {
+		t.Errorf("Invalid class %+v", class)
+		return
+	}
+
+	if len(class.Methods) != 3 {
+		t.Errorf("Invalid number of methods %d", len(class.Methods))
+		return
+	}
+
+	// Promoted parameters are properties.
+	if strings.Join(class.Properties, ",") != "label,manager,options" || strings.Join(class.Traits, ",") != "Drupal\\foo\\Controller\\FooTrait" {
+		t.Errorf("Invalid properties %v and traits %v", class.Properties, class.Traits)
+	}
+
+	constructor := class.Methods[0]
+	if constructor.Name != "__construct" || len(constructor.Parameters) != 2 {
+		t.Errorf("Invalid constructor %+v", constructor)
+		return
+	}
+
+	if parameter := constructor.Parameters[0]; parameter.Name != "manager" || parameter.Type != "Manager" {
+		t.Errorf("Invalid parameter %+v", parameter)
+	}
+
+	if parameter := constructor.Parameters[1]; parameter.Name != "options" || parameter.Type != "array" || parameter.Default != "['a', 'b']" {
+		t.Errorf("Invalid parameter %+v", parameter)
+	}
+
+	if create := class.Methods[1]; !create.Static || create.ReturnType != "static" || create.Parameters[0].Type != "ContainerInterface" {
+		t.Errorf("Invalid method %+v", create)
+	}
+
+	if src[class.Methods[2].Position.StartPos:class.Methods[2].Position.EndPos] != "build" {
+		t.Errorf("Invalid method position %v", class.Methods[2].Position)
+	}
+}
+
+func TestParseEnumNamespaces(t *testing.T) {
+	// Test PHP file.
+	src := `<?php
+namespace Drupal\foo {
+  enum Suit {
+    case Hearts;
+  }
+}
+
+namespace Drupal\bar {
+  enum Color: string {
+    case Red = 'red';
+  }
+}
+`
+
+	// Parse.
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	names := []string{}
+	for _, class := range doc.Classes {
+		names = append(names, class.Name)
+	}
+
+	if strings.Join(names, ",") != "Drupal\\foo\\Suit,Drupal\\bar\\Color" {
+		t.Errorf("Invalid enums %v", names)
+	}
+}
+
+func TestParseAnnotations(t *testing.T) {
+	// Test PHP file.
+	src := `<?php
+namespace Drupal\foo\Plugin\Block;
+
+use Drupal\Core\StringTranslation\TranslatableMarkup;
+use Drupal\foo\Form\FooForm;
+
+/**
+ * @Block(
+ *   id = "foo_block",
+ *   admin_label = @Translation("Foo"),
+ *   context_definitions = {
+ *     "node" = @ContextDefinition("entity:node"),
+ *   },
+ * )
+ */
+class FooBlock {}
+
+#[Block(
+  id: 'bar_block',
+  admin_label: new TranslatableMarkup('Bar'),
+  forms: ['settings' => FooForm::class],
+)]
+final class BarBlock {}
+`
+
+	// Parse.
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	if len(doc.Classes) != 2 || len(doc.Classes[0].Annotations) != 1 || len(doc.Classes[1].Annotations) != 1 {
+		t.Errorf("Invalid annotations found")
+		return
+	}
+
+	annotation := doc.Classes[0].Annotations[0]
+	if annotation.Name != "Block" || annotation.String("id") != "foo_block" || annotation.String("admin_label") != "Foo" {
+		t.Errorf("Invalid annotation %v", annotation.Values)
+		return
+	}
+
+	if annotation.Map("context_definitions")["node"] != "entity:node" {
+		t.Errorf("Invalid nested annotation %v", annotation.Values)
+		return
+	}
+
+	if pos := annotation.Positions["id"]; src[pos.StartPos:pos.EndPos] != `"foo_block"` {
+		t.Errorf("Invalid annotation position %v", pos)
+		return
+	}
+
+	attribute := doc.Classes[1].Annotations[0]
+	if attribute.Name != "Block" || attribute.String("id") != "bar_block" || attribute.String("admin_label") != "Bar" {
+		t.Errorf("Invalid attribute %v", attribute.Values)
+		return
+	}
+
+	if attribute.Map("forms")["settings"] != "Drupal\\foo\\Form\\FooForm" {
+		t.Errorf("Invalid attribute class constant %v", attribute.Values)
+		return
+	}
+}
+
+func TestParseAnnotationsOfClass(t *testing.T) {
+	// Test PHP file.
+	src := `<?php
+namespace Drupal\foo\Plugin\Block;
+
+/**
+ * @Block(id = "not_a_class")
+ */
+function foo() {
+  return '/** @Block(id = "in_a_string") */ class Bar {}';
+}
+
+/**
+ * Without annotations.
+ */
+#[Block(id: 'foo_block')]
+class FooBlock {}
+
+/**
+ * @Block(
+ *   id = "bar_block",
+ * )
+ */
+#[Other]
+class BarBlock {}
+`
+
+	// Parse.
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	ids := []string{}
+	for _, class := range doc.Classes {
+		for _, annotation := range class.Annotations {
+			ids = append(ids, class.Name[strings.LastIndex(class.Name, "\\")+1:]+":"+annotation.Name+":"+annotation.String("id"))
+		}
+	}
+
+	if strings.Join(ids, ",") != "FooBlock:Block:foo_block,BarBlock:Block:bar_block,BarBlock:Other:" {
+		t.Errorf("Invalid annotations %v", ids)
+	}
+
+	if DocblockText(doc.Classes[0].Docblock) != "Without annotations." {
+		t.Errorf("Invalid docblock %q", doc.Classes[0].Docblock)
+	}
+}
+
+func TestParseArray(t *testing.T) {
+	src := `return [
+    'foo_item' => [
+      'variables' => ['title' => NULL, 'items' => []],
+    ],
+    'foo_form' => array('render element' => 'form'),
+  ];`
+
+	values, end := ParseArray([]byte(src), strings.Index(src, "["))
+	if end != len(src)-1 {
+		t.Errorf("Invalid array end %d", end)
+		return
+	}
+
+	item, _ := values["foo_item"].(map[string]interface{})
+	variables, _ := item["variables"].(map[string]interface{})
+	if len(variables) != 2 || variables["title"] != "null" {
+		t.Errorf("Invalid nested array %v", values)
+		return
+	}
+
+	form, _ := values["foo_form"].(map[string]interface{})
+	if form["render element"] != "form" {
+		t.Errorf("Invalid long array syntax %v", values)
+		return
+	}
+}
+
+func TestParseResolveName(t *testing.T) {
+	// Test PHP file.
+	src := "<?php namespace Drupal\\foo; use Symfony\\Component\\HttpKernel\\KernelEvents; class Foo { public static function getSubscribedEvents() { return []; } } ?>"
+
+	// Parse.
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	if doc.ResolveName("KernelEvents") != "Symfony\\Component\\HttpKernel\\KernelEvents" {
+		t.Errorf("Invalid imported name")
+		return
+	}
+
+	if doc.ResolveName("Bar") != "Drupal\\foo\\Bar" {
+		t.Errorf("Invalid namespaced name")
+		return
+	}
+
+	if len(doc.ClassMethods) != 1 || doc.ClassMethods[0].Body == nil {
+		t.Errorf("Invalid method body")
+		return
+	}
+
+	body := src[doc.ClassMethods[0].Body.StartPos:doc.ClassMethods[0].Body.EndPos]
+	if strings.TrimSpace(body) != "return [];" {
+		t.Errorf("Invalid method body %q", body)
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	// Test PHP file.
+	src := "<?php class Foo { public function __construct(?Bar $bar, $baz = NULL, ...$rest) {} } ?>"
+
+	// Parse.
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	if len(doc.Classes) != 1 || len(doc.Classes[0].Methods) != 1 {
+		t.Errorf("Invalid class methods found")
+		return
+	}
+
+	parameters := doc.Classes[0].Methods[0].Parameters
+	if len(parameters) != 3 {
+		t.Errorf("Invalid number of parameters found")
+		return
+	}
+
+	if parameters[0].Name != "bar" || parameters[0].Type != "?Bar" || parameters[0].Default != "" {
+		t.Errorf("Invalid parameter %+v", parameters[0])
+	}
+
+	if parameters[1].Name != "baz" || parameters[1].Type != "" || parameters[1].Default != "NULL" {
+		t.Errorf("Invalid parameter %+v", parameters[1])
+	}
+
+	if parameters[2].Name != "rest" || !parameters[2].Variadic {
+		t.Errorf("Invalid parameter %+v", parameters[2])
+	}
+}
+
+func TestParseAssignments(t *testing.T) {
+	// Test PHP file.
+	src := "<?php namespace Drupal\\foo; use Drupal\\Core\\Url; function foo() { $messenger = \\Drupal::messenger(); $storage = \\Drupal::entityTypeManager()->getStorage('node'); $url = new Url('foo'); $count = 1; } ?>"
+
+	// Parse.
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	if len(doc.Assignments) != 3 {
+		t.Errorf("Invalid number of assignments found")
+		return
+	}
+
+	messenger := doc.Assignments[0]
+	if messenger.Var != "messenger" || messenger.Value.Kind != "static" || messenger.Value.Class != "Drupal" || messenger.Value.Name != "messenger" {
+		t.Errorf("Invalid assignment %+v", messenger.Value)
+	}
+
+	storage := doc.Assignments[1].Value
+	if storage.Kind != "method" || storage.Name != "getStorage" || storage.Argument != "node" || storage.Var == nil || storage.Var.Name != "entityTypeManager" {
+		t.Errorf("Invalid assignment %+v", storage)
+	}
+
+	url := doc.Assignments[2].Value
+	if url.Kind != "new" || url.Class != "Drupal\\Core\\Url" {
+		t.Errorf("Invalid assignment %+v", url)
+	}
+}
+
+func TestParseArrayElementAssignments(t *testing.T) {
+	src := "<?php use Drupal\\Core\\Field\\BaseFieldDefinition; $fields['title'] = BaseFieldDefinition::create('string')->setLabel('Title'); $fields[$key] = new Foo(); $fields[] = new Foo();"
+
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	// Only the elements with a string key are kept.
+	if len(doc.Assignments) != 1 {
+		t.Errorf("Invalid number of assignments found %d", len(doc.Assignments))
+		return
+	}
+
+	title := doc.Assignments[0]
+	if title.Var != "fields" || title.Key != "title" || src[title.KeyPosition.StartPos:title.KeyPosition.EndPos] != "title" {
+		t.Errorf("Invalid assignment %+v", title)
+	}
+
+	if title.Value.Kind != "method" || title.Value.Var == nil || title.Value.Var.Class != "Drupal\\Core\\Field\\BaseFieldDefinition" || title.Value.Var.Argument != "string" {
+		t.Errorf("Invalid assignment %+v", title.Value)
+	}
+}
+
+func TestParseExpression(t *testing.T) {
+	// Test PHP file.
+	src := "<?php namespace Drupal\\foo; use Drupal\\Core\\Url; ?>"
+
+	// Parse.
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	expression, err := doc.ParseExpression("Url::fromRoute('foo')->setOption('a', 1)")
+	if err != nil {
+		t.Errorf("ParseExpression() error = %v", err)
+		return
+	}
+
+	if expression.Kind != "method" || expression.Name != "setOption" || expression.Var == nil {
+		t.Errorf("Invalid expression %+v", expression)
+		return
+	}
+
+	if expression.Var.Kind != "static" || expression.Var.Class != "Drupal\\Core\\Url" || expression.Var.Argument != "foo" {
+		t.Errorf("Invalid expression %+v", expression.Var)
+	}
+
+	if expression, err := doc.ParseExpression("static::create()"); err != nil || expression.Class != "static" {
+		t.Errorf("Invalid expression %+v %v", expression, err)
+	}
+
+	if expression, err := doc.ParseExpression("t('Hello')"); err != nil || expression.Kind != "function" || expression.Name != "t" || expression.Argument != "Hello" {
+		t.Errorf("Invalid expression %+v %v", expression, err)
+	}
+
+	if _, err := doc.ParseExpression("$foo->"); err == nil {
+		t.Errorf("Invalid expression accepted")
+	}
+}
+
+func TestParseReturnTypes(t *testing.T) {
+	// Test PHP file.
+	src := `<?php
+namespace Drupal\foo;
+
+use Drupal\Core\Url;
+
+class Foo {
+  /**
+   * @return \Drupal\Core\Entity\EntityStorageInterface
+   */
+  public function getStorage($type) {}
+
+  protected function url(): ?Url {}
+
+  /**
+   * @return $this
+   */
+  private static function set() {}
+
+  public function names(): array {}
+}
+?>`
+
+	// Parse.
+	doc, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("Parse() error = %v", err)
+		return
+	}
+
+	expected := []struct {
+		returnType string
+		visibility string
+	}{
+		{"Drupal\\Core\\Entity\\EntityStorageInterface", "public"},
+		{"Drupal\\Core\\Url", "protected"},
+		{"static", "private"},
+		{"array", "public"},
+	}
+
+	if len(doc.ClassMethods) != len(expected) {
+		t.Errorf("Invalid number of methods found")
+		return
+	}
+
+	for i, method := range doc.ClassMethods {
+		if method.ReturnType != expected[i].returnType || method.Visibility != expected[i].visibility {
+			t.Errorf("Invalid method %s: %s %s", method.Name, method.ReturnType, method.Visibility)
+		}
+	}
+}
+
+func TestLineColumn(t *testing.T) {
+	src := []byte("<?php\n// 😀 é\n\n$foo = 'bar';")
+	lines := NewLineOffsets(src)
+
+	tests := []struct {
+		offset int
+		line   int
+		column int
+	}{
+		{0, 0, 0},
+		{5, 0, 5},
+		{6, 1, 0},
+		// The emoji is 2 UTF-16 code units.
+		{13, 1, 5},
+		{16, 1, 7},
+		{17, 2, 0},
+		{18, 3, 0},
+		{22, 3, 4},
+		// Offsets past the end are clamped.
+		{100, 3, 13},
+	}
+
+	for _, test := range tests {
+		line, column := LineColumn(src, test.offset)
+		if line != test.line || column != test.column {
+			t.Errorf("LineColumn(%d) = %d, %d, want %d, %d", test.offset, line, column, test.line, test.column)
+		}
+
+		line, column = lines.LineColumn(test.offset)
+		if line != test.line || column != test.column {
+			t.Errorf("LineOffsets.LineColumn(%d) = %d, %d, want %d, %d", test.offset, line, column, test.line, test.column)
+		}
+	}
+}
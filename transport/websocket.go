@@ -0,0 +1,258 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"go.lsp.dev/jsonrpc2"
+)
+
+// The key every websocket handshake is hashed with.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The largest message a client can send.
+const maxMessageSize = 64 << 20
+
+// Frame opcodes.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// WebSocketConn is a minimal server side websocket connection, as
+// described by RFC 6455. Each message holds one JSON-RPC message,
+// without the headers used by the stdio and tcp modes.
+type WebSocketConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mtx    sync.Mutex
+}
+
+// compile time check whether the connection implements Stream interface.
+var _ jsonrpc2.Stream = (*WebSocketConn)(nil)
+
+// Upgrade a http request to a websocket connection. Browsers send the
+// origin of the page that opens the connection, requests from pages that
+// aren't allowed are rejected so other sites can't use the server.
+func Upgrade(w http.ResponseWriter, r *http.Request, allowedOrigins []string) (*WebSocketConn, error) {
+	if origin := r.Header.Get("Origin"); origin != "" && !originAllowed(origin, allowedOrigins) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return nil, fmt.Errorf("Origin %s is not allowed", origin)
+	}
+
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a websocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("Not a websocket upgrade request")
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("Unsupported websocket version")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing websocket key", http.StatusBadRequest)
+		return nil, errors.New("Missing websocket key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("Connection can't be hijacked")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n\r\n"
+
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &WebSocketConn{
+		conn:   conn,
+		reader: rw.Reader,
+	}, nil
+}
+
+// Read the next text or binary message, answering pings on the way.
+func (c *WebSocketConn) Read(ctx context.Context) ([]byte, int64, error) {
+	message := []byte{}
+	var total int64
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, total, ctx.Err()
+		default:
+		}
+
+		fin, opcode, payload, err := c.readFrame()
+		total += int64(len(payload))
+		if err != nil {
+			return nil, total, err
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, total, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, payload)
+			return nil, total, io.EOF
+		case opText, opBinary, opContinuation:
+			message = append(message, payload...)
+			if len(message) > maxMessageSize {
+				return nil, total, errors.New("Websocket message is too large")
+			}
+		default:
+			return nil, total, fmt.Errorf("Unknown websocket opcode %d", opcode)
+		}
+
+		if fin {
+			return message, total, nil
+		}
+	}
+}
+
+// Write a message as a single text frame.
+func (c *WebSocketConn) Write(ctx context.Context, data []byte) (int64, error) {
+	if err := c.writeFrame(opText, data); err != nil {
+		return 0, err
+	}
+
+	return int64(len(data)), nil
+}
+
+func (c *WebSocketConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *WebSocketConn) readFrame() (bool, byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(c.reader, extended); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(c.reader, extended); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+
+	if length > maxMessageSize {
+		return false, 0, nil, errors.New("Websocket frame is too large")
+	}
+
+	// Clients must mask every frame.
+	if !masked {
+		return false, 0, nil, errors.New("Websocket frame is not masked")
+	}
+
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(c.reader, mask); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+func (c *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	header := []byte{0x80 | opcode}
+	length := len(payload)
+
+	switch {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xffff:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Check if an origin, eg. http://localhost:8080, is in the allowed list.
+func originAllowed(origin string, allowedOrigins []string) bool {
+	for _, item := range allowedOrigins {
+		if strings.EqualFold(strings.TrimRight(item, "/"), origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check if a comma separated header contains a value.
+func headerContains(header http.Header, name string, value string) bool {
+	for _, item := range header.Values(name) {
+		for _, part := range strings.Split(item, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,119 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebSocketConn(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade() error = %v", err)
+			return
+		}
+		defer conn.Close()
+
+		// Echo the messages back.
+		for {
+			data, _, err := conn.Read(context.Background())
+			if err != nil {
+				return
+			}
+			conn.Write(context.Background(), data)
+		}
+	}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("GET / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"))
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The example of RFC 6455.
+	if response.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Invalid accept header %q", response.Header.Get("Sec-WebSocket-Accept"))
+	}
+
+	// A message split in two masked frames.
+	mask := []byte{1, 2, 3, 4}
+	frame := func(fin bool, opcode byte, payload string) []byte {
+		first := opcode
+		if fin {
+			first |= 0x80
+		}
+		data := []byte{first, 0x80 | byte(len(payload))}
+		data = append(data, mask...)
+		for i := range payload {
+			data = append(data, payload[i]^mask[i%4])
+		}
+		return data
+	}
+	conn.Write(frame(false, opText, `{"jsonrpc":`))
+	conn.Write(frame(true, opContinuation, `"2.0"}`))
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if header[0] != 0x80|opText || string(payload) != `{"jsonrpc":"2.0"}` {
+		t.Errorf("Invalid message %x %q", header[0], payload)
+	}
+}
+
+func TestUpgradeOrigin(t *testing.T) {
+	allowed := []string{"http://localhost:8080"}
+
+	tests := []struct {
+		origin string
+		status int
+	}{
+		// The recorder can't be hijacked, so allowed requests fail later.
+		{"", http.StatusInternalServerError},
+		{"http://localhost:8080", http.StatusInternalServerError},
+		{"http://example.com", http.StatusForbidden},
+		{"http://localhost:8080.example.com", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+
+		w := httptest.NewRecorder()
+		if _, err := Upgrade(w, r, allowed); err == nil || w.Code != test.status {
+			t.Errorf("Upgrade() with origin %q = %d, want %d", test.origin, w.Code, test.status)
+		}
+	}
+}